@@ -34,6 +34,9 @@ var (
 	master     = env.GetEnv("MASTER", "")
 	kubeconfig = env.GetEnv("KUBECONFIG", "")
 	namespace  = env.GetEnv("NAMESPACE", consts.FerryNamespace)
+
+	mcsAutoImport = env.GetEnvBool("MCS_AUTO_IMPORT", false)
+	tenancy       = env.GetEnvBool("TENANCY", false)
 )
 
 func main() {
@@ -57,9 +60,11 @@ func main() {
 	}
 
 	control := controllers.NewController(&controllers.ControllerConfig{
-		Logger:    log.WithName("controller"),
-		Clientset: clientset,
-		Namespace: namespace,
+		Logger:        log.WithName("controller"),
+		Clientset:     clientset,
+		Namespace:     namespace,
+		MCSAutoImport: mcsAutoImport,
+		Tenancy:       tenancy,
 	})
 
 	stopCh := signals.SetupNotifySignalHandler()
@@ -37,6 +37,7 @@ var (
 	portManagerServiceURL = env.GetEnv("PORT_MANAGER_SERVICE_URL", "")
 	master                = env.GetEnv("MASTER", "")
 	kubeconfig            = env.GetEnv("KUBECONFIG", "")
+	requireApproval       = env.GetEnvBool("REQUIRE_APPROVAL", false)
 )
 
 func main() {
@@ -64,7 +65,7 @@ func main() {
 	mux := http.NewServeMux()
 
 	cli := portsclient.NewClient(portManagerServiceURL)
-	err = server.Serve(mux, log, config, tunnelAddress, cli.Get)
+	err = server.Serve(mux, log, config, tunnelAddress, requireApproval, cli.Get)
 	if err != nil {
 		log.Error(err, "failed to create service router")
 		os.Exit(1)
@@ -18,20 +18,27 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/ferryproxy/ferry/pkg/client"
 	"github.com/ferryproxy/ferry/pkg/consts"
 	healthserver "github.com/ferryproxy/ferry/pkg/services/health/server"
 	portsserver "github.com/ferryproxy/ferry/pkg/services/ports/server"
+	"github.com/ferryproxy/ferry/pkg/tunnel/accesslog"
 	"github.com/ferryproxy/ferry/pkg/tunnel/controllers"
+	"github.com/ferryproxy/ferry/pkg/tunnel/metrics"
+	"github.com/ferryproxy/ferry/pkg/tunnel/worker"
 	"github.com/ferryproxy/ferry/pkg/utils/env"
 	"github.com/ferryproxy/ferry/pkg/utils/signals"
 	"github.com/go-logr/zapr"
 	"github.com/gorilla/handlers"
 	"go.uber.org/zap"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
@@ -41,6 +48,13 @@ var (
 	namespace      = env.GetEnv("NAMESPACE", consts.FerryTunnelNamespace)
 	master         = env.GetEnv("MASTER", "")
 	kubeconfig     = env.GetEnv("KUBECONFIG", "")
+	endpointSlice  = env.GetEnvBool("ENDPOINT_SLICE", false)
+	drainTimeout   = env.GetEnvDuration("DRAIN_TIMEOUT", 30*time.Second)
+	hubBandwidth   = env.GetEnv("HUB_BANDWIDTH", "")
+	hubBurst       = env.GetEnv("HUB_BANDWIDTH_BURST", "")
+	hubMaxConns    = env.GetEnv("HUB_MAX_CONNECTIONS", "")
+	accessLogPath  = env.GetEnv("ACCESS_LOG", "")
+	accessSample   = env.GetEnv("ACCESS_LOG_SAMPLE", "1")
 )
 
 func main() {
@@ -77,13 +91,15 @@ func main() {
 			Logger:        log.WithName("discovery-controller"),
 			Namespace:     namespace,
 			LabelSelector: consts.TunnelConfigKey + "=" + consts.TunnelConfigDiscoverValue,
+			EndpointSlice: endpointSlice,
 		})
 
 		epWatcher := controllers.NewEndpointWatcher(&controllers.EndpointWatcherConfig{
-			Clientset: clientset,
-			Name:      serviceName,
-			Namespace: namespace,
-			SyncFunc:  svcSyncer.UpdateIPs,
+			Clientset:     clientset,
+			Name:          serviceName,
+			Namespace:     namespace,
+			EndpointSlice: endpointSlice,
+			SyncFunc:      svcSyncer.UpdateEndpoints,
 		})
 
 		authorizedController := controllers.NewAuthorizedController(&controllers.AuthorizedControllerConfig{
@@ -129,6 +145,28 @@ func main() {
 		}()
 	}
 
+	hubLimit, err := buildHubLimit()
+	if err != nil {
+		log.Error(err, "failed to parse the hub limit")
+		os.Exit(1)
+	}
+
+	accessLog, err := buildAccessLog()
+	if err != nil {
+		log.Error(err, "failed to open the access log")
+		os.Exit(1)
+	}
+
+	ctr := controllers.NewRuntimeController(&controllers.RuntimeControllerConfig{
+		Namespace:     namespace,
+		LabelSelector: consts.TunnelConfigKey + "=" + consts.TunnelConfigRulesValue,
+		Clientset:     clientset,
+		Logger:        log.WithName("runtime-controller"),
+		DrainTimeout:  drainTimeout,
+		HubLimit:      hubLimit,
+		AccessLog:     accessLog,
+	})
+
 	if serviceAddress != "" {
 		go func() {
 			mux := http.NewServeMux()
@@ -139,11 +177,13 @@ func main() {
 				os.Exit(1)
 			}
 
-			err = healthserver.Serve(mux, log)
+			err = healthserver.Serve(mux, log, ctr.Health)
 			if err != nil {
 				log.Error(err, "failed to create health serve")
 				os.Exit(1)
 			}
+
+			mux.Handle("/metrics", metrics.Handler())
 			server := http.Server{
 				BaseContext: func(listener net.Listener) context.Context {
 					return ctx
@@ -159,15 +199,58 @@ func main() {
 		}()
 	}
 
-	ctr := controllers.NewRuntimeController(&controllers.RuntimeControllerConfig{
-		Namespace:     namespace,
-		LabelSelector: consts.TunnelConfigKey + "=" + consts.TunnelConfigRulesValue,
-		Clientset:     clientset,
-		Logger:        log.WithName("runtime-controller"),
-	})
-
 	err = ctr.Run(ctx)
 	if err != nil {
 		log.Error(err, "failed to run runtime controller")
 	}
 }
+
+// buildHubLimit returns the limit shared by all routes of the hub,
+// it limits the chains served by the runtime only, the forwarding of the ssh server in ferry-tunnel is not limited,
+// so the routes whose peer hubs dial through this hub are bounded by their own route limits or the peer hub limits.
+func buildHubLimit() (*worker.Limit, error) {
+	if hubBandwidth == "" && hubMaxConns == "" {
+		return nil, nil
+	}
+	limit := &worker.Limit{
+		Name: "hub",
+	}
+	if hubBandwidth != "" {
+		q, err := resource.ParseQuantity(hubBandwidth)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HUB_BANDWIDTH %q: %w", hubBandwidth, err)
+		}
+		limit.BytesPerSecond = q.Value()
+	}
+	if hubBurst != "" {
+		q, err := resource.ParseQuantity(hubBurst)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HUB_BANDWIDTH_BURST %q: %w", hubBurst, err)
+		}
+		limit.Burst = q.Value()
+	}
+	if hubMaxConns != "" {
+		n, err := strconv.ParseInt(hubMaxConns, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HUB_MAX_CONNECTIONS %q: %w", hubMaxConns, err)
+		}
+		limit.MaxConnections = n
+	}
+	return limit, nil
+}
+
+// buildAccessLog returns the logger of the connections, it is disabled if ACCESS_LOG is empty
+func buildAccessLog() (*accesslog.Logger, error) {
+	if accessLogPath == "" {
+		return nil, nil
+	}
+	sample, err := strconv.ParseFloat(accessSample, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ACCESS_LOG_SAMPLE %q: %w", accessSample, err)
+	}
+	w, err := accesslog.Open(accessLogPath)
+	if err != nil {
+		return nil, err
+	}
+	return accesslog.NewLogger(w, sample), nil
+}
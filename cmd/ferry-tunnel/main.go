@@ -19,7 +19,9 @@ package main
 import (
 	"context"
 	"os"
+	"time"
 
+	"github.com/ferryproxy/ferry/pkg/tunnel/accesslog"
 	"github.com/ferryproxy/ferry/pkg/tunnel/worker"
 	"github.com/ferryproxy/ferry/pkg/utils/signals"
 	"github.com/go-logr/zapr"
@@ -29,13 +31,21 @@ import (
 )
 
 var (
-	configs []string
-	dump    bool
+	configs      []string
+	dump         bool
+	drainTimeout = 30 * time.Second
+	statusPath   string
+	accessLog    string
+	accessSample = 1.0
 )
 
 func init() {
 	flag.StringSliceVarP(&configs, "config", "c", nil, "load from config and ignore --bind and --proxy")
 	flag.BoolVarP(&dump, "debug", "d", dump, "Output the communication data.")
+	flag.DurationVar(&drainTimeout, "drain-timeout", drainTimeout, "The time to wait for the connections of the removed chains to finish on reload.")
+	flag.StringVar(&statusPath, "status", statusPath, "Write the outcome of each reload to the file.")
+	flag.StringVar(&accessLog, "access-log", accessLog, "Write the access log of each connection to the file, \"-\" is the stdout.")
+	flag.Float64Var(&accessSample, "access-log-sample", accessSample, "The ratio of the connections to write to the access log.")
 	flag.Parse()
 
 	logConfig := zap.NewDevelopmentConfig()
@@ -56,6 +66,20 @@ func main() {
 		cancel()
 	}()
 
-	worker.RunWithReload(ctx, logger.Std, configs, dump)
+	conf := &worker.RuntimeConfig{
+		Logger:       logger.Std,
+		Dump:         dump,
+		DrainTimeout: drainTimeout,
+	}
+	if accessLog != "" {
+		w, err := accesslog.Open(accessLog)
+		if err != nil {
+			logger.Std.Error(err, "failed to open the access log")
+			os.Exit(1)
+		}
+		conf.AccessLog = accesslog.NewLogger(w, accessSample)
+	}
+
+	worker.RunWithReload(ctx, conf, configs, statusPath)
 	return
 }
@@ -25,8 +25,10 @@ import (
 	"github.com/ferryproxy/ferry/pkg/utils/objref"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	mcsv1alpha1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 	mcsversioned "sigs.k8s.io/mcs-api/pkg/client/clientset/versioned"
 )
 
@@ -88,12 +90,16 @@ func Apply(ctx context.Context, logger logr.Logger, c Interface, obj objref.KMet
 		return service{o}.Apply(ctx, logger, c)
 	case *corev1.Endpoints:
 		return endpoints{o}.Apply(ctx, logger, c)
+	case *discoveryv1.EndpointSlice:
+		return endpointSlice{o}.Apply(ctx, logger, c)
 	case *trafficv1alpha2.Hub:
 		return hub{o}.Apply(ctx, logger, c)
 	case *trafficv1alpha2.RoutePolicy:
 		return routePolicy{o}.Apply(ctx, logger, c)
 	case *trafficv1alpha2.Route:
 		return route{o}.Apply(ctx, logger, c)
+	case *mcsv1alpha1.ServiceImport:
+		return serviceImport{o}.Apply(ctx, logger, c)
 	default:
 		return fmt.Errorf("unsupport type")
 	}
@@ -109,12 +115,16 @@ func Delete(ctx context.Context, logger logr.Logger, c Interface, obj objref.KMe
 		return service{o}.Delete(ctx, logger, c)
 	case *corev1.Endpoints:
 		return endpoints{o}.Delete(ctx, logger, c)
+	case *discoveryv1.EndpointSlice:
+		return endpointSlice{o}.Delete(ctx, logger, c)
 	case *trafficv1alpha2.Hub:
 		return hub{o}.Delete(ctx, logger, c)
 	case *trafficv1alpha2.RoutePolicy:
 		return routePolicy{o}.Delete(ctx, logger, c)
 	case *trafficv1alpha2.Route:
 		return route{o}.Delete(ctx, logger, c)
+	case *mcsv1alpha1.ServiceImport:
+		return serviceImport{o}.Delete(ctx, logger, c)
 	default:
 		return fmt.Errorf("unsupport type")
 	}
@@ -26,8 +26,10 @@ import (
 	"github.com/ferryproxy/ferry/pkg/utils/objref"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	mcsv1alpha1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 )
 
 type hub struct {
@@ -201,7 +203,8 @@ func (r route) Apply(ctx context.Context, logger logr.Logger, clientset Interfac
 			return fmt.Errorf("create route %s: %w", objref.KObj(r), err)
 		}
 	} else {
-		if reflect.DeepEqual(ori.Spec, r.Spec) {
+		if reflect.DeepEqual(ori.Spec, r.Spec) &&
+			reflect.DeepEqual(ori.Annotations, r.Annotations) {
 			logger.Info("No update",
 				"route", objref.KObj(r),
 			)
@@ -212,6 +215,7 @@ func (r route) Apply(ctx context.Context, logger logr.Logger, clientset Interfac
 			"route", objref.KObj(r),
 		)
 		ori.Spec = r.Spec
+		ori.Annotations = r.Annotations
 		_, err = clientset.
 			Ferry().
 			TrafficV1alpha2().
@@ -278,6 +282,31 @@ func (s service) Apply(ctx context.Context, logger logr.Logger, clientset Interf
 			)
 			return fmt.Errorf("service %s is not managed by ferry", objref.KObj(s))
 		}
+		if (ori.Spec.ClusterIP == corev1.ClusterIPNone) != (s.Spec.ClusterIP == corev1.ClusterIPNone) {
+			// The cluster IP is immutable, so recreate it when switching to or from headless
+			logger.Info("Recreating",
+				"service", objref.KObj(s),
+			)
+			err = clientset.
+				Kubernetes().
+				CoreV1().
+				Services(s.Namespace).
+				Delete(ctx, s.Name, metav1.DeleteOptions{})
+			if err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("delete service %s: %w", objref.KObj(s), err)
+			}
+			_, err = clientset.
+				Kubernetes().
+				CoreV1().
+				Services(s.Namespace).
+				Create(ctx, s.Service, metav1.CreateOptions{
+					FieldManager: consts.LabelFerryManagedByValue,
+				})
+			if err != nil {
+				return fmt.Errorf("create service %s: %w", objref.KObj(s), err)
+			}
+			return nil
+		}
 		if reflect.DeepEqual(ori.Spec.Ports, s.Spec.Ports) {
 			logger.Info("No update",
 				"service", objref.KObj(s),
@@ -421,6 +450,97 @@ func (s endpoints) Delete(ctx context.Context, logger logr.Logger, clientset Int
 	return nil
 }
 
+type endpointSlice struct {
+	*discoveryv1.EndpointSlice
+}
+
+func (s endpointSlice) Apply(ctx context.Context, logger logr.Logger, clientset Interface) (err error) {
+
+	ori, err := clientset.
+		Kubernetes().
+		DiscoveryV1().
+		EndpointSlices(s.Namespace).
+		Get(ctx, s.Name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("get endpointSlice %s: %w", objref.KObj(s), err)
+		}
+		logger.Info("Creating",
+			"endpointSlice", objref.KObj(s),
+		)
+		_, err = clientset.
+			Kubernetes().
+			DiscoveryV1().
+			EndpointSlices(s.Namespace).
+			Create(ctx, s.EndpointSlice, metav1.CreateOptions{
+				FieldManager: consts.LabelFerryManagedByValue,
+			})
+		if err != nil {
+			return fmt.Errorf("create endpointSlice %s: %w", objref.KObj(s), err)
+		}
+	} else {
+		if ori.Labels[consts.LabelGeneratedKey] == "" {
+			logger.Info("Refuse update",
+				"endpointSlice", objref.KObj(s),
+			)
+			return fmt.Errorf("endpointSlice %s is not managed by ferry", objref.KObj(s))
+		}
+		if reflect.DeepEqual(ori.Endpoints, s.Endpoints) &&
+			reflect.DeepEqual(ori.Ports, s.Ports) {
+			logger.Info("No update",
+				"endpointSlice", objref.KObj(s),
+			)
+			return nil
+		}
+
+		logger.Info("Updating",
+			"endpointSlice", objref.KObj(s),
+		)
+		ori.Endpoints = s.Endpoints
+		ori.Ports = s.Ports
+		_, err = clientset.
+			Kubernetes().
+			DiscoveryV1().
+			EndpointSlices(s.Namespace).
+			Update(ctx, ori, metav1.UpdateOptions{
+				FieldManager: consts.LabelFerryManagedByValue,
+			})
+		if err != nil {
+			return fmt.Errorf("update endpointSlice %s: %w", objref.KObj(s), err)
+		}
+	}
+	return nil
+}
+
+func (s endpointSlice) Delete(ctx context.Context, logger logr.Logger, clientset Interface) (err error) {
+
+	logger.Info("Deleting",
+		"endpointSlice", objref.KObj(s),
+	)
+
+	ori, err := clientset.
+		Kubernetes().
+		DiscoveryV1().
+		EndpointSlices(s.Namespace).
+		Get(ctx, s.Name, metav1.GetOptions{})
+	if err != nil && errors.IsNotFound(err) {
+		return nil
+	}
+	if ori != nil && (len(ori.Labels) == 0 || ori.Labels[consts.LabelGeneratedKey] == "") {
+		return fmt.Errorf("endpointSlice %s is not managed by ferry", objref.KObj(s))
+	}
+
+	err = clientset.
+		Kubernetes().
+		DiscoveryV1().
+		EndpointSlices(s.Namespace).
+		Delete(ctx, s.Name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("delete endpointSlice %s: %w", objref.KObj(s), err)
+	}
+	return nil
+}
+
 type configMap struct {
 	*corev1.ConfigMap
 }
@@ -565,3 +685,75 @@ func (s secret) Delete(ctx context.Context, logger logr.Logger, clientset Interf
 
 	return nil
 }
+
+type serviceImport struct {
+	*mcsv1alpha1.ServiceImport
+}
+
+func (s serviceImport) Apply(ctx context.Context, logger logr.Logger, clientset Interface) (err error) {
+
+	ori, err := clientset.
+		MCS().
+		MulticlusterV1alpha1().
+		ServiceImports(s.Namespace).
+		Get(ctx, s.Name, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("get serviceImport %s: %w", objref.KObj(s), err)
+		}
+		logger.Info("Creating",
+			"serviceImport", objref.KObj(s),
+		)
+		_, err = clientset.
+			MCS().
+			MulticlusterV1alpha1().
+			ServiceImports(s.Namespace).
+			Create(ctx, s.ServiceImport, metav1.CreateOptions{
+				FieldManager: consts.LabelFerryManagedByValue,
+			})
+		if err != nil {
+			return fmt.Errorf("create serviceImport %s: %w", objref.KObj(s), err)
+		}
+	} else {
+		if ori.Labels[consts.LabelGeneratedKey] == "" {
+			logger.Info("Refuse update",
+				"serviceImport", objref.KObj(s),
+			)
+			return fmt.Errorf("serviceImport %s is not managed by ferry", objref.KObj(s))
+		}
+		// The ports and IPs are maintained from the discovery service after created
+		logger.Info("No update",
+			"serviceImport", objref.KObj(s),
+		)
+	}
+	return nil
+}
+
+func (s serviceImport) Delete(ctx context.Context, logger logr.Logger, clientset Interface) (err error) {
+
+	logger.Info("Deleting",
+		"serviceImport", objref.KObj(s),
+	)
+
+	ori, err := clientset.
+		MCS().
+		MulticlusterV1alpha1().
+		ServiceImports(s.Namespace).
+		Get(ctx, s.Name, metav1.GetOptions{})
+	if err != nil && errors.IsNotFound(err) {
+		return nil
+	}
+	if ori != nil && (len(ori.Labels) == 0 || ori.Labels[consts.LabelGeneratedKey] == "") {
+		return fmt.Errorf("serviceImport %s is not managed by ferry", objref.KObj(s))
+	}
+
+	err = clientset.
+		MCS().
+		MulticlusterV1alpha1().
+		ServiceImports(s.Namespace).
+		Delete(ctx, s.Name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("delete serviceImport %s: %w", objref.KObj(s), err)
+	}
+	return nil
+}
@@ -41,6 +41,73 @@ const (
 	LabelMCSMarkHubKey   = "mcs.traffic.ferryproxy.io/service"
 	LabelMCSMarkHubValue = "enabled"
 
+	AnnotationMCSImportHubsKey = "mcs.traffic.ferryproxy.io/import-hubs"
+
+	// AnnotationPortsKey selects and remaps the ports of the route, e.g. "8080:80/http,9090"
+	AnnotationPortsKey = LabelPrefix + "ports"
+
+	// AnnotationBandwidthKey limits the bytes per second of the route, e.g. "10Mi"
+	AnnotationBandwidthKey = LabelPrefix + "bandwidth"
+	// AnnotationBandwidthBurstKey is the bytes that the route can transfer at once, same as the bandwidth by default
+	AnnotationBandwidthBurstKey = LabelPrefix + "bandwidth-burst"
+	// AnnotationMaxConnectionsKey limits the concurrent connections of the route in each hub
+	AnnotationMaxConnectionsKey = LabelPrefix + "max-connections"
+
+	// AnnotationMaxPendingDialsKey fails the connections of the route fast while the dials in progress reach it
+	AnnotationMaxPendingDialsKey = LabelPrefix + "max-pending-dials"
+	// AnnotationConsecutiveFailuresKey opens the circuit breaker of the route after the consecutive dial failures
+	AnnotationConsecutiveFailuresKey = LabelPrefix + "consecutive-failures"
+	// AnnotationEjectionTimeKey is how long the circuit breaker of the route stays open, e.g. "30s"
+	AnnotationEjectionTimeKey = LabelPrefix + "ejection-time"
+
+	// AnnotationMirrorKey duplicates the connections of the route to the shadow import, e.g. "namespace/name",
+	// the shadow import is a service in the import hub, the ports of the route are used and the responses are discarded
+	AnnotationMirrorKey = LabelPrefix + "mirror"
+	// AnnotationMirrorPercentKey is the percentage of the connections to mirror, 100 by default
+	AnnotationMirrorPercentKey = LabelPrefix + "mirror-percent"
+
+	// AnnotationTrafficSplitKey splits the connections of the import between the export hubs by the weights, e.g. "hub-a=90,hub-b=10",
+	// it is set on the policy or the routes that import the same service, and the export hubs not in it get no connections,
+	// unless none of the hubs in it has ready endpoints, then the ready hubs are split evenly
+	AnnotationTrafficSplitKey = LabelPrefix + "traffic-split"
+
+	// AnnotationHTTPRulesKey routes the HTTP requests of the import to the export hubs or services by the host, path and headers,
+	// the value is the JSON list of the rules, and the requests that match no rule go to the exports that no rule selects,
+	// the rules only apply to the ports named "http" or "http-*" or with the appProtocol "http" or "kubernetes.io/ws"
+	AnnotationHTTPRulesKey = LabelPrefix + "http-rules"
+
+	// AnnotationTLSOriginationKey encrypts the connections of the route with TLS when the export hub dials the service,
+	// the value is the server name to verify, or "true" to verify the host of the service
+	AnnotationTLSOriginationKey = LabelPrefix + "tls-origination"
+	// AnnotationTLSInsecureSkipVerifyKey does not verify the certificate of the service when it is "true"
+	AnnotationTLSInsecureSkipVerifyKey = LabelPrefix + "tls-insecure-skip-verify"
+	// AnnotationTLSCAKey verifies the certificate of the service with the CA bundle of the "ca.crt" in the secret or config map,
+	// e.g. "secret/my-ca" or "configmap/my-ca", which is in the namespace of the export service in the export hub
+	AnnotationTLSCAKey = LabelPrefix + "tls-ca"
+	// AnnotationSNIKey routes the TLS connections of the import to the route by the server names, e.g. "api.example.com,*.example.org",
+	// the routes that import the same service share the port, and the connections that match no route go to the routes without it
+	AnnotationSNIKey = LabelPrefix + "sni"
+
+	// AnnotationFallbackKey imports the service as the fallback of the local service of the same name in the import hub,
+	// the value is the export hubs in priority order, e.g. "hub-a,hub-b", and the export hubs not in it come last
+	AnnotationFallbackKey = LabelPrefix + "fallback"
+
+	// AnnotationAllowedImportHubsKey restricts the hubs that the service can be imported into, e.g. "cluster-1,cluster-2",
+	// it is set on the service of the export hub, and all hubs are allowed if it is empty
+	AnnotationAllowedImportHubsKey = LabelPrefix + "allowed-import-hubs"
+
+	// LabelTenantKey marks the config map in the namespace of ferry that defines the tenant,
+	// the value is the namespace of the tenant, and the rules are in the TenantRulesKey of the data
+	LabelTenantKey = LabelPrefix + "tenant"
+	TenantRulesKey = "tenant"
+
+	LabelRegistrationKey           = LabelPrefix + "registration"
+	LabelRegistrationPendingValue  = "pending"
+	LabelRegistrationApprovedValue = "approved"
+	LabelRegistrationDeniedValue   = "denied"
+
+	RegistrationSecretSuffix = "-registration"
+
 	TunnelRulesKey      = "tunnel"
 	TunnelRulesAllowKey = "allows"
 	TunnelUserKey       = "user"
@@ -59,4 +126,9 @@ const (
 	TunnelPermissionsName   = "permissions.json"
 	TunnelAuthorizedKeyName = "authorized_keys"
 	TunnelIdentityKeyName   = "identity"
+
+	TunnelTLSDir        = "/var/ferry/tls/"
+	TunnelTLSSecretName = FerryTunnelName + "-tls"
+	TunnelTLSALPN       = "ferry"
+	TLSCASecretName     = FerryName + "-tls-ca"
 )
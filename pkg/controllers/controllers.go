@@ -26,6 +26,7 @@ import (
 	"github.com/ferryproxy/ferry/pkg/controllers/mcs"
 	"github.com/ferryproxy/ferry/pkg/controllers/route"
 	"github.com/ferryproxy/ferry/pkg/controllers/route_policy"
+	"github.com/ferryproxy/ferry/pkg/controllers/tenant"
 	"github.com/ferryproxy/ferry/pkg/utils/trybuffer"
 	"github.com/go-logr/logr"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -37,6 +38,8 @@ type Controller struct {
 	logger                logr.Logger
 	clientset             client.Interface
 	namespace             string
+	mcsAutoImport         bool
+	tenancy               bool
 	hubController         *hub.HubController
 	routeController       *route.RouteController
 	routePolicyController *route_policy.RoutePolicyController
@@ -45,16 +48,21 @@ type Controller struct {
 }
 
 type ControllerConfig struct {
-	Clientset client.Interface
-	Logger    logr.Logger
-	Namespace string
+	Clientset     client.Interface
+	Logger        logr.Logger
+	Namespace     string
+	MCSAutoImport bool
+	// Tenancy allows the routes and the policies in the namespaces of the tenants
+	Tenancy bool
 }
 
 func NewController(conf *ControllerConfig) *Controller {
 	return &Controller{
-		logger:    conf.Logger,
-		clientset: conf.Clientset,
-		namespace: conf.Namespace,
+		logger:        conf.Logger,
+		clientset:     conf.Clientset,
+		namespace:     conf.Namespace,
+		mcsAutoImport: conf.MCSAutoImport,
+		tenancy:       conf.Tenancy,
 	}
 }
 
@@ -71,21 +79,46 @@ func (c *Controller) Run(ctx context.Context) error {
 	})
 	c.hubController = hubController
 
+	var (
+		routeTenant       route.TenantInterface
+		routePolicyTenant route_policy.TenantInterface
+	)
+	if c.tenancy {
+		tenantController := tenant.NewTenantController(&tenant.TenantControllerConfig{
+			Clientset: c.clientset,
+			Namespace: c.namespace,
+			Logger:    c.logger.WithName("tenant"),
+			SyncFunc:  c.try.Try,
+		})
+		routeTenant = tenantController
+		routePolicyTenant = tenantController
+
+		go func() {
+			err := tenantController.Run(c.ctx)
+			if err != nil {
+				c.logger.Error(err, "Run TenantController")
+			}
+			cancel()
+		}()
+	}
+
 	routeController := route.NewRouteController(&route.RouteControllerConfig{
-		Clientset:    c.clientset,
-		Namespace:    c.namespace,
-		HubInterface: hubController,
-		Logger:       c.logger.WithName("route"),
-		SyncFunc:     c.try.Try,
+		Clientset:       c.clientset,
+		Namespace:       c.namespace,
+		HubInterface:    hubController,
+		TenantInterface: routeTenant,
+		Logger:          c.logger.WithName("route"),
+		SyncFunc:        c.try.Try,
 	})
 	c.routeController = routeController
 
 	routePolicyController := route_policy.NewRoutePolicyController(route_policy.RoutePolicyControllerConfig{
-		Clientset:    c.clientset,
-		Namespace:    c.namespace,
-		HubInterface: hubController,
-		Logger:       c.logger.WithName("route-policy"),
-		SyncFunc:     c.try.Try,
+		Clientset:       c.clientset,
+		Namespace:       c.namespace,
+		HubInterface:    hubController,
+		TenantInterface: routePolicyTenant,
+		Logger:          c.logger.WithName("route-policy"),
+		SyncFunc:        c.try.Try,
 	})
 	c.routePolicyController = routePolicyController
 
@@ -102,6 +135,7 @@ func (c *Controller) Run(ctx context.Context) error {
 		Namespace:    c.namespace,
 		HubInterface: hubController,
 		Logger:       c.logger.WithName("mcs"),
+		AutoImport:   c.mcsAutoImport,
 	})
 	c.mcsController = mcsController
 	err := mcsController.Start(ctx)
@@ -0,0 +1,168 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hub
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/ferryproxy/ferry/pkg/client"
+	"github.com/ferryproxy/ferry/pkg/utils/objref"
+	"github.com/ferryproxy/ferry/pkg/utils/trybuffer"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+type clusterEndpointsCache struct {
+	parentCtx context.Context
+	ctx       context.Context
+	cancel    context.CancelFunc
+
+	clientset client.Interface
+	cache     map[objref.ObjectRef]*corev1.Endpoints
+	syncFunc  func()
+
+	logger logr.Logger
+	try    *trybuffer.TryBuffer
+
+	informer cache.SharedIndexInformer
+
+	mut sync.RWMutex
+}
+
+type clusterEndpointsCacheConfig struct {
+	Clientset client.Interface
+	Logger    logr.Logger
+	SyncFunc  func()
+}
+
+func newClusterEndpointsCache(conf clusterEndpointsCacheConfig) *clusterEndpointsCache {
+	c := &clusterEndpointsCache{
+		clientset: conf.Clientset,
+		logger:    conf.Logger,
+		cache:     map[objref.ObjectRef]*corev1.Endpoints{},
+		syncFunc:  conf.SyncFunc,
+	}
+	return c
+}
+
+func (c *clusterEndpointsCache) ResetClientset(clientset client.Interface) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.cache = map[objref.ObjectRef]*corev1.Endpoints{}
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.ctx, c.cancel = context.WithCancel(c.parentCtx)
+	c.clientset = clientset
+	informerFactory := informers.NewSharedInformerFactoryWithOptions(c.clientset.Kubernetes(), 0)
+	informer := informerFactory.
+		Core().
+		V1().
+		Endpoints().
+		Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.onAdd,
+		UpdateFunc: c.onUpdate,
+		DeleteFunc: c.onDelete,
+	})
+	c.informer = informer
+
+	go informer.Run(c.ctx.Done())
+	return nil
+}
+
+func (c *clusterEndpointsCache) Start(ctx context.Context) error {
+	c.parentCtx = ctx
+	c.try = trybuffer.NewTryBuffer(c.sync, time.Second/10)
+	err := c.ResetClientset(c.clientset)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *clusterEndpointsCache) Close() {
+	c.try.Close()
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+func (c *clusterEndpointsCache) Get(namespace, name string) (*corev1.Endpoints, bool) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	ep, ok := c.cache[objref.KRef(namespace, name)]
+	return ep, ok
+}
+
+func (c *clusterEndpointsCache) sync() {
+	c.syncFunc()
+}
+
+func (c *clusterEndpointsCache) onAdd(obj interface{}) {
+	ep := obj.(*corev1.Endpoints)
+	c.logger.Info("onAdd",
+		"endpoints", objref.KObj(ep),
+	)
+	ep = ep.DeepCopy()
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.cache[objref.KObj(ep)] = ep
+	c.try.Try()
+}
+
+func (c *clusterEndpointsCache) onUpdate(oldObj, newObj interface{}) {
+	ep := newObj.(*corev1.Endpoints)
+	ep = ep.DeepCopy()
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	old := c.cache[objref.KObj(ep)]
+	if old != nil && reflect.DeepEqual(ep.Subsets, old.Subsets) {
+		c.cache[objref.KObj(ep)] = ep
+		return
+	}
+	c.logger.Info("onUpdate",
+		"endpoints", objref.KObj(ep),
+	)
+	c.cache[objref.KObj(ep)] = ep
+
+	c.try.Try()
+}
+
+func (c *clusterEndpointsCache) onDelete(obj interface{}) {
+	ep := obj.(*corev1.Endpoints)
+	c.logger.Info("onDelete",
+		"endpoints", objref.KObj(ep),
+	)
+	ep = ep.DeepCopy()
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	delete(c.cache, objref.KObj(ep))
+	c.try.Try()
+}
@@ -21,6 +21,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"sort"
 	"sync"
 	"time"
@@ -32,6 +33,7 @@ import (
 	"github.com/ferryproxy/ferry/pkg/consts"
 	healthclient "github.com/ferryproxy/ferry/pkg/services/health/client"
 	portsclient "github.com/ferryproxy/ferry/pkg/services/ports/client"
+	"github.com/ferryproxy/ferry/pkg/tunnel/status"
 	"github.com/ferryproxy/ferry/pkg/utils/objref"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
@@ -57,11 +59,13 @@ type HubController struct {
 	cacheHub           map[string]*trafficv1alpha2.Hub
 	cacheClientset     map[string]client.Interface
 	cacheService       map[string]*clusterServiceCache
+	cacheEndpoints     map[string]*clusterEndpointsCache
 	cacheServiceExport map[string]*clusterServiceExportCache
 	cacheServiceImport map[string]*clusterServiceImportCache
 	cacheTunnelPorts   map[string]*tunnelPorts
 	cacheAuthorized    map[string]string
 	cacheKubeconfig    map[string][]byte
+	cacheOpenCircuits  map[string][]string
 	syncFunc           func()
 	namespace          string
 	conditionsManager  *conditions.ConditionsManager
@@ -76,11 +80,13 @@ func NewHubController(conf HubControllerConfig) *HubController {
 		cacheHub:           map[string]*trafficv1alpha2.Hub{},
 		cacheClientset:     map[string]client.Interface{},
 		cacheService:       map[string]*clusterServiceCache{},
+		cacheEndpoints:     map[string]*clusterEndpointsCache{},
 		cacheServiceExport: map[string]*clusterServiceExportCache{},
 		cacheServiceImport: map[string]*clusterServiceImportCache{},
 		cacheTunnelPorts:   map[string]*tunnelPorts{},
 		cacheAuthorized:    map[string]string{},
 		cacheKubeconfig:    map[string][]byte{},
+		cacheOpenCircuits:  map[string][]string{},
 		conditionsManager:  conditions.NewConditionsManager(),
 	}
 }
@@ -103,6 +109,8 @@ func (c *HubController) Run(ctx context.Context) error {
 		DeleteFunc: c.onDelete,
 	})
 
+	go c.pollCircuits(ctx)
+
 	informer.Run(ctx.Done())
 	return nil
 }
@@ -169,7 +177,7 @@ func (c *HubController) UpdateHubConditions(name string, conditions []metav1.Con
 	_, err = c.clientset.
 		Ferry().
 		TrafficV1alpha2().
-		Hubs(consts.FerryNamespace).
+		Hubs(c.namespace).
 		Patch(c.ctx, name, types.MergePatchType, data, metav1.PatchOptions{}, "status")
 	if err != nil {
 		retErr = err
@@ -253,6 +261,16 @@ func (c *HubController) GetService(hubName string, namespace, name string) (*cor
 	return cache.Get(namespace, name)
 }
 
+func (c *HubController) GetEndpoints(hubName string, namespace, name string) (*corev1.Endpoints, bool) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	cache := c.cacheEndpoints[hubName]
+	if cache == nil {
+		return nil, false
+	}
+	return cache.Get(namespace, name)
+}
+
 func (c *HubController) ListServices(hubName string) []*corev1.Service {
 	c.mut.RLock()
 	defer c.mut.RUnlock()
@@ -279,6 +297,43 @@ func (c *HubController) GetAuthorized(name string) string {
 	return c.cacheAuthorized[name]
 }
 
+// GetCABundle returns the "ca.crt" of the secret or config map in the hub, the kind is "secret" or "configmap"
+func (c *HubController) GetCABundle(hubName string, namespace, kind, name string) ([]byte, error) {
+	clientset, err := c.Clientset(hubName)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	switch kind {
+	case "secret":
+		secret, err := clientset.
+			Kubernetes().
+			CoreV1().
+			Secrets(namespace).
+			Get(c.ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		data = secret.Data[corev1.ServiceAccountRootCAKey]
+	case "configmap":
+		configMap, err := clientset.
+			Kubernetes().
+			CoreV1().
+			ConfigMaps(namespace).
+			Get(c.ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		data = []byte(configMap.Data[corev1.ServiceAccountRootCAKey])
+	default:
+		return nil, fmt.Errorf("unsupported kind %q", kind)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("no %s in %s %s/%s", corev1.ServiceAccountRootCAKey, kind, namespace, name)
+	}
+	return data, nil
+}
+
 func (c *HubController) LoadPortPeer(importHubName string, cluster, namespace, name string, port, bindPort int32) error {
 	c.mut.RLock()
 	defer c.mut.RUnlock()
@@ -432,7 +487,8 @@ func (c *HubController) onUpdate(oldObj, newObj interface{}) {
 func (c *HubController) checkHealth(hubName string) {
 	host := c.GetTunnelAddressInControlPlane(hubName)
 	route := healthclient.NewClient("http://" + host)
-	err := route.Get(c.ctx)
+	s := status.Status{}
+	err := route.Get(c.ctx, &s)
 	if err != nil {
 		c.logger.Error(err, "health",
 			"hub", objref.KRef(consts.FerryNamespace, hubName),
@@ -453,9 +509,69 @@ func (c *HubController) checkHealth(hubName string) {
 				Reason: "Health",
 			},
 		})
+		c.updateOpenCircuits(hubName, s.OpenCircuits)
+	}
+}
+
+// circuitsInterval is the interval of polling the open circuit breakers of the tunnels
+const circuitsInterval = 10 * time.Second
+
+// pollCircuits polls the open circuit breakers of the healthy tunnels,
+// it is not a part of the Sync so that the slow tunnels do not hold the lock of the sync
+func (c *HubController) pollCircuits(ctx context.Context) {
+	ticker := time.NewTicker(circuitsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, hub := range c.ListHubs() {
+			if !c.conditionsManager.IsTrue(hub.Name, trafficv1alpha2.TunnelHealthCondition) {
+				continue
+			}
+			c.checkCircuits(ctx, hub.Name)
+		}
 	}
 }
 
+// checkCircuits updates the open circuit breakers of the healthy tunnel
+func (c *HubController) checkCircuits(ctx context.Context, hubName string) {
+	ctx, cancel := context.WithTimeout(ctx, circuitsInterval/2)
+	defer cancel()
+	host := c.GetTunnelAddressInControlPlane(hubName)
+	route := healthclient.NewClient("http://" + host)
+	s := status.Status{}
+	err := route.Get(ctx, &s)
+	if err != nil {
+		return
+	}
+	c.updateOpenCircuits(hubName, s.OpenCircuits)
+}
+
+func (c *HubController) updateOpenCircuits(hubName string, circuits []string) {
+	c.mut.Lock()
+	if reflect.DeepEqual(c.cacheOpenCircuits[hubName], circuits) {
+		c.mut.Unlock()
+		return
+	}
+	if len(circuits) == 0 {
+		delete(c.cacheOpenCircuits, hubName)
+	} else {
+		c.cacheOpenCircuits[hubName] = circuits
+	}
+	c.mut.Unlock()
+	c.syncFunc()
+}
+
+// OpenCircuits returns the chains of the tunnel in the hub whose circuit breaker is open
+func (c *HubController) OpenCircuits(hubName string) []string {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	return c.cacheOpenCircuits[hubName]
+}
+
 func (c *HubController) enablePorts(hubName string) {
 	if c.cacheTunnelPorts[hubName] == nil {
 		host := c.GetTunnelAddressInControlPlane(hubName)
@@ -491,6 +607,24 @@ func (c *HubController) enableCache(hubName string, clientset client.Interface)
 			c.logger.Error(err, "reset clientset")
 		}
 	}
+
+	if c.cacheEndpoints[hubName] == nil {
+		clusterEndpoints := newClusterEndpointsCache(clusterEndpointsCacheConfig{
+			Clientset: clientset,
+			Logger:    c.logger.WithName(hubName).WithName("endpoints"),
+			SyncFunc:  c.syncFunc,
+		})
+		c.cacheEndpoints[hubName] = clusterEndpoints
+		err := clusterEndpoints.Start(c.ctx)
+		if err != nil {
+			c.logger.Error(err, "failed start cluster endpoints cache")
+		}
+	} else {
+		err := c.cacheEndpoints[hubName].ResetClientset(clientset)
+		if err != nil {
+			c.logger.Error(err, "reset clientset")
+		}
+	}
 }
 
 func (c *HubController) enableMCS(f *trafficv1alpha2.Hub, clientset client.Interface) {
@@ -592,6 +726,10 @@ func (c *HubController) onDelete(obj interface{}) {
 		c.cacheService[f.Name].Close()
 	}
 	delete(c.cacheService, f.Name)
+	if c.cacheEndpoints[f.Name] != nil {
+		c.cacheEndpoints[f.Name].Close()
+	}
+	delete(c.cacheEndpoints, f.Name)
 	delete(c.cacheAuthorized, f.Name)
 	c.disableMCS(f)
 
@@ -646,5 +784,19 @@ func (c *HubController) Sync(ctx context.Context) {
 			time.Since(tunnelHealthCondition.LastTransitionTime.Time) > 10*time.Second) {
 			c.ResetClientset(hub.Name)
 		}
+
+		err := c.refreshToken(ctx, hub.Name)
+		if err != nil {
+			c.logger.Error(err, "refreshToken",
+				"hub", objref.KRef(c.namespace, hub.Name),
+			)
+		}
+
+		err = c.ensureTunnelCert(ctx, hub.Name)
+		if err != nil {
+			c.logger.Error(err, "ensureTunnelCert",
+				"hub", objref.KRef(c.namespace, hub.Name),
+			)
+		}
 	}
 }
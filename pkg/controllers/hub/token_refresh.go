@@ -0,0 +1,161 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hub
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ferryproxy/ferry/pkg/consts"
+	"github.com/ferryproxy/ferry/pkg/utils/objref"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const (
+	tokenServiceAccountName = "ferry-control"
+	defaultTokenLifetime    = time.Hour
+)
+
+// refreshToken mints a new token for the hub over its current connection before the old one expires,
+// and writes it back to the kubeconfig secret so the next connect picks it up.
+func (c *HubController) refreshToken(ctx context.Context, hubName string) error {
+	c.mut.RLock()
+	kubeconfig := c.cacheKubeconfig[hubName]
+	clientset := c.cacheClientset[hubName]
+	c.mut.RUnlock()
+
+	if len(kubeconfig) == 0 {
+		return nil
+	}
+
+	config, err := clientcmd.Load(kubeconfig)
+	if err != nil {
+		return err
+	}
+	authInfo := currentAuthInfo(config)
+	if authInfo == nil || authInfo.Token == "" {
+		return nil
+	}
+
+	issuedAt, expiresAt, ok := tokenLifetime(authInfo.Token)
+	if !ok {
+		// Tokens without expiration, e.g. the legacy service account token
+		return nil
+	}
+	lifetime := defaultTokenLifetime
+	if !issuedAt.IsZero() && expiresAt.After(issuedAt) {
+		lifetime = expiresAt.Sub(issuedAt)
+	}
+	if time.Until(expiresAt) > lifetime/3 {
+		return nil
+	}
+
+	if clientset == nil {
+		return fmt.Errorf("hub %q is disconnected, unable to refresh token expired at %s", hubName, expiresAt)
+	}
+
+	c.logger.Info("Refreshing token",
+		"hub", objref.KRef(c.namespace, hubName),
+		"expiresAt", expiresAt,
+	)
+
+	expirationSeconds := int64(lifetime / time.Second)
+	tokenRequest, err := clientset.
+		Kubernetes().
+		CoreV1().
+		ServiceAccounts(consts.FerryTunnelNamespace).
+		CreateToken(ctx, tokenServiceAccountName, &authenticationv1.TokenRequest{
+			Spec: authenticationv1.TokenRequestSpec{
+				ExpirationSeconds: &expirationSeconds,
+			},
+		}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("create token: %w", err)
+	}
+
+	authInfo.Token = tokenRequest.Status.Token
+	data, err := clientcmd.Write(*config)
+	if err != nil {
+		return err
+	}
+
+	secret, err := c.clientset.
+		Kubernetes().
+		CoreV1().
+		Secrets(c.namespace).
+		Get(ctx, hubName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	secret.Data["kubeconfig"] = data
+	_, err = c.clientset.
+		Kubernetes().
+		CoreV1().
+		Secrets(c.namespace).
+		Update(ctx, secret, metav1.UpdateOptions{
+			FieldManager: consts.LabelFerryManagedByValue,
+		})
+	if err != nil {
+		return fmt.Errorf("update secret %s: %w", objref.KObj(secret), err)
+	}
+
+	_, err = c.UpdateClientset(hubName)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func currentAuthInfo(config *clientcmdapi.Config) *clientcmdapi.AuthInfo {
+	kubeContext := config.Contexts[config.CurrentContext]
+	if kubeContext == nil {
+		return nil
+	}
+	return config.AuthInfos[kubeContext.AuthInfo]
+}
+
+// tokenLifetime returns the issued and expiration time of the JWT,
+// the signature is not verified, this is only used to decide when to refresh.
+func tokenLifetime(token string) (issuedAt, expiresAt time.Time, ok bool) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return issuedAt, expiresAt, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return issuedAt, expiresAt, false
+	}
+	claims := struct {
+		IssuedAt  int64 `json:"iat"`
+		ExpiresAt int64 `json:"exp"`
+	}{}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil || claims.ExpiresAt == 0 {
+		return issuedAt, expiresAt, false
+	}
+	if claims.IssuedAt != 0 {
+		issuedAt = time.Unix(claims.IssuedAt, 0)
+	}
+	return issuedAt, time.Unix(claims.ExpiresAt, 0), true
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hub
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func Test_tokenLifetime(t *testing.T) {
+	jwt := func(payload string) string {
+		return "header." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + ".signature"
+	}
+	tests := []struct {
+		name          string
+		token         string
+		wantIssuedAt  time.Time
+		wantExpiresAt time.Time
+		wantOk        bool
+	}{
+		{
+			name:          "bound token",
+			token:         jwt(`{"iat":1000,"exp":4600}`),
+			wantIssuedAt:  time.Unix(1000, 0),
+			wantExpiresAt: time.Unix(4600, 0),
+			wantOk:        true,
+		},
+		{
+			name:          "without iat",
+			token:         jwt(`{"exp":4600}`),
+			wantExpiresAt: time.Unix(4600, 0),
+			wantOk:        true,
+		},
+		{
+			name:   "legacy token without exp",
+			token:  jwt(`{"sub":"system:serviceaccount:ferry-tunnel-system:ferry-control"}`),
+			wantOk: false,
+		},
+		{
+			name:   "not jwt",
+			token:  "token",
+			wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issuedAt, expiresAt, ok := tokenLifetime(tt.token)
+			if ok != tt.wantOk {
+				t.Fatalf("tokenLifetime() ok = %v, want %v", ok, tt.wantOk)
+			}
+			if !issuedAt.Equal(tt.wantIssuedAt) {
+				t.Errorf("tokenLifetime() issuedAt = %v, want %v", issuedAt, tt.wantIssuedAt)
+			}
+			if !expiresAt.Equal(tt.wantExpiresAt) {
+				t.Errorf("tokenLifetime() expiresAt = %v, want %v", expiresAt, tt.wantExpiresAt)
+			}
+		})
+	}
+}
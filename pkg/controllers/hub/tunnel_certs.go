@@ -0,0 +1,148 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hub
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ferryproxy/ferry/pkg/client"
+	"github.com/ferryproxy/ferry/pkg/consts"
+	"github.com/ferryproxy/ferry/pkg/utils/certs"
+	"github.com/ferryproxy/ferry/pkg/utils/objref"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	caLifetime         = 10 * 365 * 24 * time.Hour
+	tunnelCertLifetime = 365 * 24 * time.Hour
+)
+
+// ensureTunnelCert issues the certificate of the hub for the mTLS between hubs,
+// and renews it before it expires or when the CA changes.
+func (c *HubController) ensureTunnelCert(ctx context.Context, hubName string) error {
+	c.mut.RLock()
+	clientset := c.cacheClientset[hubName]
+	c.mut.RUnlock()
+
+	if clientset == nil {
+		return nil
+	}
+
+	caCert, caKey, err := c.getCA(ctx)
+	if err != nil {
+		return err
+	}
+
+	secret, err := clientset.
+		Kubernetes().
+		CoreV1().
+		Secrets(consts.FerryTunnelNamespace).
+		Get(ctx, consts.TunnelTLSSecretName, metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+	} else if !shouldRenewCert(secret.Data, caCert, time.Now()) {
+		return nil
+	}
+
+	c.logger.Info("Issuing tunnel certificate",
+		"hub", objref.KRef(c.namespace, hubName),
+	)
+	cert, key, err := certs.IssueCert(caCert, caKey, hubName, tunnelCertLifetime)
+	if err != nil {
+		return err
+	}
+	return client.Apply(ctx, c.logger, clientset, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      consts.TunnelTLSSecretName,
+			Namespace: consts.FerryTunnelNamespace,
+			Labels: map[string]string{
+				consts.LabelGeneratedKey: consts.LabelGeneratedValue,
+			},
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.ServiceAccountRootCAKey: caCert,
+			corev1.TLSCertKey:              cert,
+			corev1.TLSPrivateKeyKey:        key,
+		},
+	})
+}
+
+// getCA returns the CA of the control plane, it is created if not exists
+func (c *HubController) getCA(ctx context.Context) (caCert, caKey []byte, err error) {
+	secret, err := c.clientset.
+		Kubernetes().
+		CoreV1().
+		Secrets(c.namespace).
+		Get(ctx, consts.TLSCASecretName, metav1.GetOptions{})
+	if err == nil {
+		caCert, caKey = secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey]
+		if len(caCert) == 0 || len(caKey) == 0 {
+			return nil, nil, fmt.Errorf("secret %s is not a valid CA", objref.KObj(secret))
+		}
+		return caCert, caKey, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, nil, err
+	}
+
+	caCert, caKey, err = certs.NewCA(consts.FerryName, caLifetime)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = c.clientset.
+		Kubernetes().
+		CoreV1().
+		Secrets(c.namespace).
+		Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      consts.TLSCASecretName,
+				Namespace: c.namespace,
+			},
+			Type: corev1.SecretTypeTLS,
+			Data: map[string][]byte{
+				corev1.TLSCertKey:       caCert,
+				corev1.TLSPrivateKeyKey: caKey,
+			},
+		}, metav1.CreateOptions{
+			FieldManager: consts.LabelFerryManagedByValue,
+		})
+	if err != nil {
+		return nil, nil, err
+	}
+	return caCert, caKey, nil
+}
+
+// shouldRenewCert returns true if the certificate is invalid, is not signed by the CA, or has less than a third of its lifetime left
+func shouldRenewCert(data map[string][]byte, caCert []byte, now time.Time) bool {
+	if !bytes.Equal(data[corev1.ServiceAccountRootCAKey], caCert) ||
+		len(data[corev1.TLSPrivateKeyKey]) == 0 {
+		return true
+	}
+	notBefore, notAfter, err := certs.Validity(data[corev1.TLSCertKey])
+	if err != nil {
+		return true
+	}
+	return notAfter.Sub(now) < notAfter.Sub(notBefore)/3
+}
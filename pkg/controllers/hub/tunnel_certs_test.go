@@ -0,0 +1,89 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ferryproxy/ferry/pkg/utils/certs"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func Test_shouldRenewCert(t *testing.T) {
+	caCert, caKey, err := certs.NewCA("ferry", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCACert, _, err := certs.NewCA("ferry", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key, err := certs.IssueCert(caCert, caKey, "cluster-1", 3*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string][]byte{
+		corev1.ServiceAccountRootCAKey: caCert,
+		corev1.TLSCertKey:              cert,
+		corev1.TLSPrivateKeyKey:        key,
+	}
+	now := time.Now()
+	tests := []struct {
+		name   string
+		data   map[string][]byte
+		caCert []byte
+		now    time.Time
+		want   bool
+	}{
+		{
+			name:   "valid",
+			data:   data,
+			caCert: caCert,
+			now:    now,
+			want:   false,
+		},
+		{
+			name:   "about to expire",
+			data:   data,
+			caCert: caCert,
+			now:    now.Add(2*time.Hour + 30*time.Minute),
+			want:   true,
+		},
+		{
+			name:   "ca changed",
+			data:   data,
+			caCert: otherCACert,
+			now:    now,
+			want:   true,
+		},
+		{
+			name:   "empty",
+			data:   map[string][]byte{},
+			caCert: caCert,
+			now:    now,
+			want:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shouldRenewCert(tt.data, tt.caCert, tt.now); got != tt.want {
+				t.Errorf("shouldRenewCert() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
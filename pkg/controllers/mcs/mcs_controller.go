@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"sort"
+	"strings"
 	"sync"
 
 	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
@@ -28,6 +29,7 @@ import (
 	"github.com/ferryproxy/ferry/pkg/utils/diffobjs"
 	"github.com/ferryproxy/ferry/pkg/utils/objref"
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	mcsv1alpha1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
@@ -35,6 +37,9 @@ import (
 
 type HubInterface interface {
 	ListMCS(namespace string) (map[string][]*mcsv1alpha1.ServiceImport, map[string][]*mcsv1alpha1.ServiceExport)
+	GetService(hubName string, namespace, name string) (*corev1.Service, bool)
+	Clientset(hubName string) (client.Interface, error)
+	ListHubs() []*trafficv1alpha2.Hub
 }
 
 type MCSControllerConfig struct {
@@ -42,6 +47,8 @@ type MCSControllerConfig struct {
 	Clientset    client.Interface
 	HubInterface HubInterface
 	Namespace    string
+	// AutoImport creates the ServiceImport in MCS-enabled hubs for each ServiceExport
+	AutoImport bool
 }
 
 type MCSController struct {
@@ -51,6 +58,7 @@ type MCSController struct {
 	namespace          string
 	mut                sync.RWMutex
 	hubInterface       HubInterface
+	autoImport         bool
 	cacheRoutePolicies []*trafficv1alpha2.RoutePolicy
 }
 
@@ -60,6 +68,7 @@ func NewMCSController(conf *MCSControllerConfig) *MCSController {
 		namespace:    conf.Namespace,
 		hubInterface: conf.HubInterface,
 		logger:       conf.Logger,
+		autoImport:   conf.AutoImport,
 	}
 }
 
@@ -87,7 +96,11 @@ func (m *MCSController) Sync(ctx context.Context) {
 
 	importMap, exportMap := m.hubInterface.ListMCS("")
 
-	updated := mcsToRoutePolicies(importMap, exportMap)
+	if m.autoImport {
+		m.syncServiceImports(ctx, importMap, exportMap)
+	}
+
+	updated := mcsToRoutePolicies(m.namespace, importMap, exportMap)
 
 	m.logger.Info("Update routePolicy with mcs",
 		"size", len(updated),
@@ -112,9 +125,12 @@ func (m *MCSController) Sync(ctx context.Context) {
 			m.logger.Error(err, "failed to delete routePolicy")
 		}
 	}
+
+	m.updateServiceExportStatus(ctx, exportMap)
+	m.updateServiceImport(ctx, importMap, exportMap)
 }
 
-func mcsToRoutePolicies(importMap map[string][]*mcsv1alpha1.ServiceImport, exportMap map[string][]*mcsv1alpha1.ServiceExport) []*trafficv1alpha2.RoutePolicy {
+func mcsToRoutePolicies(namespace string, importMap map[string][]*mcsv1alpha1.ServiceImport, exportMap map[string][]*mcsv1alpha1.ServiceExport) []*trafficv1alpha2.RoutePolicy {
 	rulesImport := map[objref.ObjectRef][]string{}
 	for name, imports := range importMap {
 		for _, i := range imports {
@@ -179,7 +195,7 @@ func mcsToRoutePolicies(importMap map[string][]*mcsv1alpha1.ServiceImport, expor
 		policy := trafficv1alpha2.RoutePolicy{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      fmt.Sprintf("mcs-%s-%s", n.Namespace, n.Name),
-				Namespace: consts.FerryNamespace,
+				Namespace: namespace,
 				Labels:    labelsForRoutePolicy,
 			},
 			Spec: trafficv1alpha2.RoutePolicySpec{
@@ -195,6 +211,140 @@ func mcsToRoutePolicies(importMap map[string][]*mcsv1alpha1.ServiceImport, expor
 	return policies
 }
 
+// syncServiceImports creates the derived ServiceImports for the ServiceExports,
+// and deletes the ones that are no longer exported.
+func (m *MCSController) syncServiceImports(ctx context.Context, importMap map[string][]*mcsv1alpha1.ServiceImport, exportMap map[string][]*mcsv1alpha1.ServiceExport) {
+	mcsHubs := []string{}
+	for _, hub := range m.hubInterface.ListHubs() {
+		if hub.Labels[consts.LabelMCSMarkHubKey] == consts.LabelMCSMarkHubValue {
+			mcsHubs = append(mcsHubs, hub.Name)
+		}
+	}
+
+	updated := mcsToServiceImports(mcsHubs, exportMap, m.hubInterface.GetService)
+
+	for _, hubName := range mcsHubs {
+		cli, err := m.hubInterface.Clientset(hubName)
+		if err != nil {
+			m.logger.Error(err, "get clientset",
+				"hub", hubName,
+			)
+			continue
+		}
+
+		for _, i := range updated[hubName] {
+			err := client.Apply(ctx, m.logger, cli, i)
+			if err != nil {
+				m.logger.Error(err, "failed to update serviceImport")
+			}
+		}
+
+		existing := []*mcsv1alpha1.ServiceImport{}
+		for _, i := range importMap[hubName] {
+			if i.Labels[consts.LabelGeneratedKey] == consts.LabelGeneratedValue {
+				existing = append(existing, i)
+			}
+		}
+		deleted := diffobjs.ShouldDeleted(existing, updated[hubName])
+		for _, i := range deleted {
+			err := client.Delete(ctx, m.logger, cli, i)
+			if err != nil {
+				m.logger.Error(err, "failed to delete serviceImport")
+			}
+		}
+	}
+}
+
+// mcsToServiceImports returns the derived ServiceImports by hub, the import hubs of a ServiceExport
+// can be scoped by the annotation, the hub that exports the service will not import it.
+func mcsToServiceImports(mcsHubs []string, exportMap map[string][]*mcsv1alpha1.ServiceExport, getService func(hubName string, namespace, name string) (*corev1.Service, bool)) map[string][]*mcsv1alpha1.ServiceImport {
+	type exported struct {
+		ports    []mcsv1alpha1.ServicePort
+		headless bool
+		hubs     map[string]struct{}
+		scoped   map[string]struct{}
+	}
+
+	exports := map[objref.ObjectRef]*exported{}
+	for hubName, list := range exportMap {
+		for _, e := range list {
+			svc, ok := getService(hubName, e.Namespace, e.Name)
+			if !ok {
+				continue
+			}
+			r := objref.KObj(e)
+			ex := exports[r]
+			if ex == nil {
+				ex = &exported{
+					hubs: map[string]struct{}{},
+				}
+				exports[r] = ex
+			}
+			ex.hubs[hubName] = struct{}{}
+			if ex.ports == nil {
+				for _, port := range svc.Spec.Ports {
+					ex.ports = append(ex.ports, mcsv1alpha1.ServicePort{
+						Name:     port.Name,
+						Protocol: port.Protocol,
+						Port:     port.Port,
+					})
+				}
+				ex.headless = svc.Spec.ClusterIP == corev1.ClusterIPNone
+			}
+			if hubs := e.Annotations[consts.AnnotationMCSImportHubsKey]; hubs != "" {
+				if ex.scoped == nil {
+					ex.scoped = map[string]struct{}{}
+				}
+				for _, h := range strings.Split(hubs, ",") {
+					ex.scoped[strings.TrimSpace(h)] = struct{}{}
+				}
+			}
+		}
+	}
+
+	out := map[string][]*mcsv1alpha1.ServiceImport{}
+	for r, ex := range exports {
+		if len(ex.ports) == 0 {
+			continue
+		}
+		typ := mcsv1alpha1.ClusterSetIP
+		if ex.headless {
+			typ = mcsv1alpha1.Headless
+		}
+		for _, hubName := range mcsHubs {
+			if _, ok := ex.hubs[hubName]; ok {
+				continue
+			}
+			if ex.scoped != nil {
+				if _, ok := ex.scoped[hubName]; !ok {
+					continue
+				}
+			}
+			out[hubName] = append(out[hubName], &mcsv1alpha1.ServiceImport{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      r.Name,
+					Namespace: r.Namespace,
+					Labels:    labelsForServiceImport,
+				},
+				Spec: mcsv1alpha1.ServiceImportSpec{
+					Type:  typ,
+					Ports: ex.ports,
+				},
+			})
+		}
+	}
+	for _, list := range out {
+		sort.Slice(list, func(i, j int) bool {
+			return objref.KObj(list[i]).String() < objref.KObj(list[j]).String()
+		})
+	}
+	return out
+}
+
+var labelsForServiceImport = map[string]string{
+	consts.LabelGeneratedKey: consts.LabelGeneratedValue,
+}
+
 var labelsForRoutePolicy = map[string]string{
 	consts.LabelGeneratedKey: consts.LabelGeneratedValue,
 }
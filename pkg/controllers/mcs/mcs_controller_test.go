@@ -20,7 +20,9 @@ import (
 	"testing"
 
 	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
+	"github.com/ferryproxy/ferry/pkg/consts"
 	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	mcsv1alpha1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
 )
@@ -304,7 +306,7 @@ func Test_mcsToRoutePolicies(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := mcsToRoutePolicies(tt.args.importMap, tt.args.exportMap)
+			got := mcsToRoutePolicies(consts.FerryNamespace, tt.args.importMap, tt.args.exportMap)
 
 			if diff := cmp.Diff(got, tt.want); diff != "" {
 				t.Errorf("mcsToRoutePolicies(): got - want + \n%s", diff)
@@ -312,3 +314,77 @@ func Test_mcsToRoutePolicies(t *testing.T) {
 		})
 	}
 }
+
+func Test_mcsToServiceImports(t *testing.T) {
+	svc := &corev1.Service{
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80},
+			},
+		},
+	}
+	imported := &mcsv1alpha1.ServiceImport{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "svc-1",
+			Labels:    labelsForServiceImport,
+		},
+		Spec: mcsv1alpha1.ServiceImportSpec{
+			Type: mcsv1alpha1.ClusterSetIP,
+			Ports: []mcsv1alpha1.ServicePort{
+				{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80},
+			},
+		},
+	}
+	tests := []struct {
+		name      string
+		mcsHubs   []string
+		exportMap map[string][]*mcsv1alpha1.ServiceExport
+		want      map[string][]*mcsv1alpha1.ServiceImport
+	}{
+		{
+			name:    "all mcs hubs",
+			mcsHubs: []string{"cluster-1", "cluster-2", "cluster-3"},
+			exportMap: map[string][]*mcsv1alpha1.ServiceExport{
+				"cluster-1": {
+					{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "svc-1"}},
+				},
+			},
+			want: map[string][]*mcsv1alpha1.ServiceImport{
+				"cluster-2": {imported},
+				"cluster-3": {imported},
+			},
+		},
+		{
+			name:    "scoped hubs",
+			mcsHubs: []string{"cluster-1", "cluster-2", "cluster-3"},
+			exportMap: map[string][]*mcsv1alpha1.ServiceExport{
+				"cluster-1": {
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Namespace: "default",
+							Name:      "svc-1",
+							Annotations: map[string]string{
+								consts.AnnotationMCSImportHubsKey: "cluster-3",
+							},
+						},
+					},
+				},
+			},
+			want: map[string][]*mcsv1alpha1.ServiceImport{
+				"cluster-3": {imported},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mcsToServiceImports(tt.mcsHubs, tt.exportMap, func(hubName string, namespace, name string) (*corev1.Service, bool) {
+				return svc, true
+			})
+
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Errorf("mcsToServiceImports(): got - want + \n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mcs
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/ferryproxy/ferry/pkg/consts"
+	"github.com/ferryproxy/ferry/pkg/utils/objref"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	mcsv1alpha1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+// updateServiceExportStatus sets the Valid and Conflict conditions of the ServiceExports
+func (m *MCSController) updateServiceExportStatus(ctx context.Context, exportMap map[string][]*mcsv1alpha1.ServiceExport) {
+	conditions := serviceExportConditions(exportMap, m.hubInterface.GetService)
+
+	for hubName, exports := range exportMap {
+		for _, export := range exports {
+			want := conditions[hubName][objref.KObj(export)]
+			updated, changed := mergeServiceExportConditions(export.Status.Conditions, want)
+			if !changed {
+				continue
+			}
+
+			cli, err := m.hubInterface.Clientset(hubName)
+			if err != nil {
+				m.logger.Error(err, "get clientset",
+					"hub", hubName,
+				)
+				continue
+			}
+
+			export = export.DeepCopy()
+			export.Status.Conditions = updated
+			_, err = cli.
+				MCS().
+				MulticlusterV1alpha1().
+				ServiceExports(export.Namespace).
+				UpdateStatus(ctx, export, metav1.UpdateOptions{
+					FieldManager: consts.LabelFerryManagedByValue,
+				})
+			if err != nil {
+				m.logger.Error(err, "failed to update serviceExport status",
+					"hub", hubName,
+					"serviceExport", objref.KObj(export),
+				)
+			}
+		}
+	}
+}
+
+// updateServiceImport fills the ports and IPs of the ServiceImports from the Service created by ferry-tunnel,
+// the IPs make the service resolvable as <service>.<namespace>.svc.clusterset.local by the multicluster DNS plugin
+func (m *MCSController) updateServiceImport(ctx context.Context, importMap map[string][]*mcsv1alpha1.ServiceImport, exportMap map[string][]*mcsv1alpha1.ServiceExport) {
+	clusters := map[objref.ObjectRef][]mcsv1alpha1.ClusterStatus{}
+	for hubName, exports := range exportMap {
+		for _, export := range exports {
+			ref := objref.KObj(export)
+			clusters[ref] = append(clusters[ref], mcsv1alpha1.ClusterStatus{
+				Cluster: hubName,
+			})
+		}
+	}
+	for _, c := range clusters {
+		sort.Slice(c, func(i, j int) bool {
+			return c[i].Cluster < c[j].Cluster
+		})
+	}
+
+	for hubName, imports := range importMap {
+		for _, imp := range imports {
+			svc, ok := m.hubInterface.GetService(hubName, imp.Namespace, imp.Name)
+			if !ok || svc.Labels[consts.LabelGeneratedKey] != consts.LabelGeneratedTunnelValue {
+				continue
+			}
+
+			cli, err := m.hubInterface.Clientset(hubName)
+			if err != nil {
+				m.logger.Error(err, "get clientset",
+					"hub", hubName,
+				)
+				continue
+			}
+
+			spec := serviceImportSpecFromService(svc)
+			if !reflect.DeepEqual(imp.Spec, spec) {
+				imp = imp.DeepCopy()
+				imp.Spec = spec
+				imp, err = cli.
+					MCS().
+					MulticlusterV1alpha1().
+					ServiceImports(imp.Namespace).
+					Update(ctx, imp, metav1.UpdateOptions{
+						FieldManager: consts.LabelFerryManagedByValue,
+					})
+				if err != nil {
+					m.logger.Error(err, "failed to update serviceImport",
+						"hub", hubName,
+						"serviceImport", objref.KRef(svc.Namespace, svc.Name),
+					)
+					continue
+				}
+			}
+
+			status := mcsv1alpha1.ServiceImportStatus{
+				Clusters: clusters[objref.KObj(imp)],
+			}
+			if !reflect.DeepEqual(imp.Status, status) {
+				imp = imp.DeepCopy()
+				imp.Status = status
+				_, err = cli.
+					MCS().
+					MulticlusterV1alpha1().
+					ServiceImports(imp.Namespace).
+					UpdateStatus(ctx, imp, metav1.UpdateOptions{
+						FieldManager: consts.LabelFerryManagedByValue,
+					})
+				if err != nil {
+					m.logger.Error(err, "failed to update serviceImport status",
+						"hub", hubName,
+						"serviceImport", objref.KObj(imp),
+					)
+				}
+			}
+		}
+	}
+}
+
+func serviceImportSpecFromService(svc *corev1.Service) mcsv1alpha1.ServiceImportSpec {
+	spec := mcsv1alpha1.ServiceImportSpec{
+		Type:            mcsv1alpha1.ClusterSetIP,
+		SessionAffinity: svc.Spec.SessionAffinity,
+	}
+	if svc.Spec.SessionAffinityConfig != nil {
+		spec.SessionAffinityConfig = svc.Spec.SessionAffinityConfig.DeepCopy()
+	}
+	for _, port := range svc.Spec.Ports {
+		spec.Ports = append(spec.Ports, mcsv1alpha1.ServicePort{
+			Name:        port.Name,
+			Protocol:    port.Protocol,
+			AppProtocol: port.AppProtocol,
+			Port:        port.Port,
+		})
+	}
+	if svc.Spec.ClusterIP == corev1.ClusterIPNone {
+		spec.Type = mcsv1alpha1.Headless
+		return spec
+	}
+	if len(svc.Spec.ClusterIPs) != 0 {
+		spec.IPs = append(spec.IPs, svc.Spec.ClusterIPs...)
+	} else if svc.Spec.ClusterIP != "" {
+		spec.IPs = append(spec.IPs, svc.Spec.ClusterIP)
+	}
+	return spec
+}
+
+// serviceExportConditions returns the conditions of each ServiceExport by hub,
+// the oldest ServiceExport of the same name is the reference for the conflict detection.
+func serviceExportConditions(exportMap map[string][]*mcsv1alpha1.ServiceExport, getService func(hubName string, namespace, name string) (*corev1.Service, bool)) map[string]map[objref.ObjectRef][]mcsv1alpha1.ServiceExportCondition {
+	type exported struct {
+		hubName string
+		export  *mcsv1alpha1.ServiceExport
+		svc     *corev1.Service
+	}
+
+	groups := map[objref.ObjectRef][]exported{}
+	for hubName, exports := range exportMap {
+		for _, export := range exports {
+			svc, _ := getService(hubName, export.Namespace, export.Name)
+			ref := objref.KObj(export)
+			groups[ref] = append(groups[ref], exported{
+				hubName: hubName,
+				export:  export,
+				svc:     svc,
+			})
+		}
+	}
+
+	out := map[string]map[objref.ObjectRef][]mcsv1alpha1.ServiceExportCondition{}
+	for ref, group := range groups {
+		sort.Slice(group, func(i, j int) bool {
+			ti, tj := group[i].export.CreationTimestamp, group[j].export.CreationTimestamp
+			if !ti.Equal(&tj) {
+				return ti.Before(&tj)
+			}
+			return group[i].hubName < group[j].hubName
+		})
+
+		var oldest *corev1.Service
+		for _, e := range group {
+			if e.svc != nil {
+				oldest = e.svc
+				break
+			}
+		}
+
+		for _, e := range group {
+			if out[e.hubName] == nil {
+				out[e.hubName] = map[objref.ObjectRef][]mcsv1alpha1.ServiceExportCondition{}
+			}
+
+			if e.svc == nil {
+				out[e.hubName][ref] = []mcsv1alpha1.ServiceExportCondition{
+					newServiceExportCondition(mcsv1alpha1.ServiceExportValid, corev1.ConditionFalse,
+						"ServiceNotFound", fmt.Sprintf("service %s is not found in hub %s", ref, e.hubName)),
+				}
+				continue
+			}
+
+			conditions := []mcsv1alpha1.ServiceExportCondition{
+				newServiceExportCondition(mcsv1alpha1.ServiceExportValid, corev1.ConditionTrue,
+					"ServiceFound", "service is valid for export"),
+			}
+			if reason, message := serviceConflict(oldest, e.svc); reason != "" {
+				conditions = append(conditions,
+					newServiceExportCondition(mcsv1alpha1.ServiceExportConflict, corev1.ConditionTrue, reason, message))
+			} else {
+				conditions = append(conditions,
+					newServiceExportCondition(mcsv1alpha1.ServiceExportConflict, corev1.ConditionFalse, "NoConflict", "no conflict with other exports"))
+			}
+			out[e.hubName][ref] = conditions
+		}
+	}
+	return out
+}
+
+// serviceConflict compares the exported service with the reference, it returns an empty reason if there is no conflict
+func serviceConflict(ref, svc *corev1.Service) (reason, message string) {
+	if ref == nil || ref == svc {
+		return "", ""
+	}
+	if (ref.Spec.ClusterIP == corev1.ClusterIPNone) != (svc.Spec.ClusterIP == corev1.ClusterIPNone) {
+		return "TypeConflict", "headless and non-headless services are exported with the same name"
+	}
+
+	type portKey struct {
+		Name     string
+		Protocol corev1.Protocol
+	}
+	ports := map[portKey]int32{}
+	for _, port := range ref.Spec.Ports {
+		ports[portKey{port.Name, port.Protocol}] = port.Port
+	}
+	for _, port := range svc.Spec.Ports {
+		p, ok := ports[portKey{port.Name, port.Protocol}]
+		if ok && p != port.Port {
+			return "PortConflict", fmt.Sprintf("port %q is %d, conflicts with %d of the oldest export", port.Name, port.Port, p)
+		}
+	}
+	return "", ""
+}
+
+func newServiceExportCondition(typ mcsv1alpha1.ServiceExportConditionType, status corev1.ConditionStatus, reason, message string) mcsv1alpha1.ServiceExportCondition {
+	return mcsv1alpha1.ServiceExportCondition{
+		Type:    typ,
+		Status:  status,
+		Reason:  &reason,
+		Message: &message,
+	}
+}
+
+// mergeServiceExportConditions merges the want conditions into the existing ones,
+// the transition time is only changed if the status changes.
+func mergeServiceExportConditions(existing, want []mcsv1alpha1.ServiceExportCondition) ([]mcsv1alpha1.ServiceExportCondition, bool) {
+	changed := false
+	out := make([]mcsv1alpha1.ServiceExportCondition, 0, len(existing)+len(want))
+	out = append(out, existing...)
+	for _, w := range want {
+		found := false
+		for i, e := range out {
+			if e.Type != w.Type {
+				continue
+			}
+			found = true
+			if e.Status != w.Status {
+				now := metav1.Now()
+				w.LastTransitionTime = &now
+			} else {
+				w.LastTransitionTime = e.LastTransitionTime
+			}
+			if !reflect.DeepEqual(e, w) {
+				out[i] = w
+				changed = true
+			}
+			break
+		}
+		if !found {
+			now := metav1.Now()
+			w.LastTransitionTime = &now
+			out = append(out, w)
+			changed = true
+		}
+	}
+	return out, changed
+}
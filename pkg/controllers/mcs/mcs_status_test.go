@@ -0,0 +1,183 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mcs
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	mcsv1alpha1 "sigs.k8s.io/mcs-api/pkg/apis/v1alpha1"
+)
+
+func Test_serviceImportSpecFromService(t *testing.T) {
+	tests := []struct {
+		name string
+		svc  *corev1.Service
+		want mcsv1alpha1.ServiceImportSpec
+	}{
+		{
+			name: "cluster ip",
+			svc: &corev1.Service{
+				Spec: corev1.ServiceSpec{
+					ClusterIP:  "10.0.0.1",
+					ClusterIPs: []string{"10.0.0.1"},
+					Ports: []corev1.ServicePort{
+						{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80},
+					},
+				},
+			},
+			want: mcsv1alpha1.ServiceImportSpec{
+				Type: mcsv1alpha1.ClusterSetIP,
+				IPs:  []string{"10.0.0.1"},
+				Ports: []mcsv1alpha1.ServicePort{
+					{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80},
+				},
+			},
+		},
+		{
+			name: "headless",
+			svc: &corev1.Service{
+				Spec: corev1.ServiceSpec{
+					ClusterIP: corev1.ClusterIPNone,
+					Ports: []corev1.ServicePort{
+						{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80},
+					},
+				},
+			},
+			want: mcsv1alpha1.ServiceImportSpec{
+				Type: mcsv1alpha1.Headless,
+				Ports: []mcsv1alpha1.ServicePort{
+					{Name: "http", Protocol: corev1.ProtocolTCP, Port: 80},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := serviceImportSpecFromService(tt.svc)
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Errorf("serviceImportSpecFromService(): got - want + \n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_serviceExportConditions(t *testing.T) {
+	svc := func(port int32) *corev1.Service {
+		return &corev1.Service{
+			Spec: corev1.ServiceSpec{
+				Ports: []corev1.ServicePort{
+					{Name: "http", Protocol: corev1.ProtocolTCP, Port: port},
+				},
+			},
+		}
+	}
+	export := func(sec int64) *mcsv1alpha1.ServiceExport {
+		return &mcsv1alpha1.ServiceExport{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace:         "default",
+				Name:              "svc-1",
+				CreationTimestamp: metav1.Unix(sec, 0),
+			},
+		}
+	}
+	tests := []struct {
+		name      string
+		exportMap map[string][]*mcsv1alpha1.ServiceExport
+		services  map[string]*corev1.Service
+		want      map[string]map[corev1.ConditionStatus][]mcsv1alpha1.ServiceExportConditionType
+	}{
+		{
+			name: "no conflict",
+			exportMap: map[string][]*mcsv1alpha1.ServiceExport{
+				"cluster-1": {export(1)},
+				"cluster-2": {export(2)},
+			},
+			services: map[string]*corev1.Service{
+				"cluster-1": svc(80),
+				"cluster-2": svc(80),
+			},
+			want: map[string]map[corev1.ConditionStatus][]mcsv1alpha1.ServiceExportConditionType{
+				"cluster-1": {
+					corev1.ConditionTrue:  {mcsv1alpha1.ServiceExportValid},
+					corev1.ConditionFalse: {mcsv1alpha1.ServiceExportConflict},
+				},
+				"cluster-2": {
+					corev1.ConditionTrue:  {mcsv1alpha1.ServiceExportValid},
+					corev1.ConditionFalse: {mcsv1alpha1.ServiceExportConflict},
+				},
+			},
+		},
+		{
+			name: "port conflict with the oldest",
+			exportMap: map[string][]*mcsv1alpha1.ServiceExport{
+				"cluster-1": {export(1)},
+				"cluster-2": {export(2)},
+			},
+			services: map[string]*corev1.Service{
+				"cluster-1": svc(80),
+				"cluster-2": svc(8080),
+			},
+			want: map[string]map[corev1.ConditionStatus][]mcsv1alpha1.ServiceExportConditionType{
+				"cluster-1": {
+					corev1.ConditionTrue:  {mcsv1alpha1.ServiceExportValid},
+					corev1.ConditionFalse: {mcsv1alpha1.ServiceExportConflict},
+				},
+				"cluster-2": {
+					corev1.ConditionTrue: {mcsv1alpha1.ServiceExportValid, mcsv1alpha1.ServiceExportConflict},
+				},
+			},
+		},
+		{
+			name: "service not found",
+			exportMap: map[string][]*mcsv1alpha1.ServiceExport{
+				"cluster-1": {export(1)},
+			},
+			services: map[string]*corev1.Service{},
+			want: map[string]map[corev1.ConditionStatus][]mcsv1alpha1.ServiceExportConditionType{
+				"cluster-1": {
+					corev1.ConditionFalse: {mcsv1alpha1.ServiceExportValid},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := serviceExportConditions(tt.exportMap, func(hubName string, namespace, name string) (*corev1.Service, bool) {
+				svc, ok := tt.services[hubName]
+				return svc, ok
+			})
+
+			status := map[string]map[corev1.ConditionStatus][]mcsv1alpha1.ServiceExportConditionType{}
+			for hubName, refs := range got {
+				for _, conditions := range refs {
+					for _, condition := range conditions {
+						if status[hubName] == nil {
+							status[hubName] = map[corev1.ConditionStatus][]mcsv1alpha1.ServiceExportConditionType{}
+						}
+						status[hubName][condition.Status] = append(status[hubName][condition.Status], condition.Type)
+					}
+				}
+			}
+			if diff := cmp.Diff(status, tt.want); diff != "" {
+				t.Errorf("serviceExportConditions(): got - want + \n%s", diff)
+			}
+		})
+	}
+}
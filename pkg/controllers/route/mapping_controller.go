@@ -40,6 +40,8 @@ import (
 
 type HubInterface interface {
 	GetService(hubName string, namespace, name string) (*corev1.Service, bool)
+	GetEndpoints(hubName string, namespace, name string) (*corev1.Endpoints, bool)
+	GetCABundle(hubName string, namespace, kind, name string) ([]byte, error)
 	ListServices(name string) []*corev1.Service
 	GetHub(name string) *trafficv1alpha2.Hub
 	GetHubGateway(hubName string, forHub string) trafficv1alpha2.HubSpecGateway
@@ -50,14 +52,26 @@ type HubInterface interface {
 	GetPortPeer(importHubName string, cluster, namespace, name string, port int32) (int32, error)
 	DeletePortPeer(importHubName string, cluster, namespace, name string, port int32) (int32, error)
 	HubReady(hubName string) bool
+	OpenCircuits(hubName string) []string
 }
 
+const (
+	// ExportEndpointsReadyCondition is false if the export service has no ready endpoints
+	ExportEndpointsReadyCondition = "ExportEndpointsReady"
+	// CircuitClosedCondition is false if the circuit breaker of some tunnels of the route is open
+	CircuitClosedCondition = "CircuitClosed"
+	// AnnotationsValidCondition is false if the route is skipped because of its invalid annotations
+	AnnotationsValidCondition = "AnnotationsValid"
+)
+
 type RouteInterface interface {
-	UpdateRouteCondition(name string, conditions []metav1.Condition)
+	UpdateRouteCondition(ref objref.ObjectRef, conditions []metav1.Condition)
 }
 
 type MappingControllerConfig struct {
-	Namespace      string
+	Namespace string
+	// RouteNamespace is the namespace of the routes of ferry, the resources of the routes in other namespaces are prefixed with their namespace
+	RouteNamespace string
 	ExportHubName  string
 	ImportHubName  string
 	HubInterface   HubInterface
@@ -68,6 +82,7 @@ type MappingControllerConfig struct {
 func NewMappingController(conf MappingControllerConfig) *MappingController {
 	return &MappingController{
 		namespace:      conf.Namespace,
+		routeNamespace: conf.RouteNamespace,
 		importHubName:  conf.ImportHubName,
 		exportHubName:  conf.ExportHubName,
 		logger:         conf.Logger,
@@ -81,8 +96,9 @@ type MappingController struct {
 	mut sync.Mutex
 	ctx context.Context
 
-	namespace string
-	labels    map[string]string
+	namespace      string
+	routeNamespace string
+	labels         map[string]string
 
 	exportHubName string
 	importHubName string
@@ -133,6 +149,7 @@ func (m *MappingController) Start(ctx context.Context) error {
 	}
 	m.router = router.NewRouter(router.RouterConfig{
 		Labels:        m.getLabel(),
+		Namespace:     m.routeNamespace,
 		ExportHubName: m.exportHubName,
 		ImportHubName: m.importHubName,
 		HubInterface:  m.hubInterface,
@@ -183,11 +200,36 @@ func (m *MappingController) loadPorts(importHubName string, cm *corev1.ConfigMap
 		return
 	}
 	for _, port := range data.Ports {
-		err = m.hubInterface.LoadPortPeer(importHubName, data.ExportHubName, data.ExportServiceNamespace, data.ExportServiceName, port.Port, port.TargetPort)
+		if data.Headless {
+			continue
+		}
+		err = m.hubInterface.LoadPortPeer(importHubName, data.ExportHubName, data.ExportServiceNamespace, data.ExportServiceName, exportPort(port), port.TargetPort)
 		if err != nil {
 			m.logger.Error(err, "LoadPortPeer")
 		}
+		if port.SplitPort != 0 {
+			err = m.hubInterface.LoadPortPeer(importHubName, router.SplitCluster, data.ImportServiceNamespace, data.ImportServiceName, port.Port, port.SplitPort)
+			if err != nil {
+				m.logger.Error(err, "LoadPortPeer")
+			}
+		}
+	}
+	for _, pod := range data.Pods {
+		name := pod.Hostname + "." + data.ExportServiceName
+		for _, port := range pod.Ports {
+			err = m.hubInterface.LoadPortPeer(importHubName, data.ExportHubName, data.ExportServiceNamespace, name, exportPort(port), port.TargetPort)
+			if err != nil {
+				m.logger.Error(err, "LoadPortPeer")
+			}
+		}
+	}
+}
+
+func exportPort(port discovery.MappingPort) int32 {
+	if port.ExportPort != 0 {
+		return port.ExportPort
 	}
+	return port.Port
 }
 
 func (m *MappingController) getLabel() map[string]string {
@@ -211,13 +253,14 @@ func (m *MappingController) sync() {
 	}
 	ctx := m.ctx
 
-	condsExcept := map[string][]metav1.Condition{}
+	condsExcept := map[objref.ObjectRef][]metav1.Condition{}
 	conds := []metav1.Condition{}
 
 	defer func() {
 		if len(conds) != 0 {
 			for _, route := range m.routes {
-				m.routeInterface.UpdateRouteCondition(route.Name, append(condsExcept[route.Name], conds...))
+				ref := objref.KObj(route)
+				m.routeInterface.UpdateRouteCondition(ref, append(condsExcept[ref], conds...))
 			}
 		}
 	}()
@@ -240,7 +283,7 @@ func (m *MappingController) sync() {
 					Reason: trafficv1alpha2.PortsAllocatedCondition,
 				})
 			}
-			condsExcept[route.Name] = conds
+			condsExcept[objref.KObj(route)] = conds
 		}
 		deleted := diffobjs.ShouldDeleted(m.routes, m.nextRoutes)
 		for _, route := range deleted {
@@ -391,9 +434,79 @@ loop:
 		})
 	}
 
+	for _, route := range m.routes {
+		ref := objref.KObj(route)
+		condsExcept[ref] = append(condsExcept[ref], m.annotationsValidCondition(ref), m.exportEndpointsReadyCondition(route), m.circuitClosedCondition(route))
+	}
 	return
 }
 
+func (m *MappingController) annotationsValidCondition(ref objref.ObjectRef) metav1.Condition {
+	if err := m.router.InvalidRoutes()[ref]; err != nil {
+		return metav1.Condition{
+			Type:    AnnotationsValidCondition,
+			Status:  metav1.ConditionFalse,
+			Reason:  "InvalidAnnotations",
+			Message: err.Error(),
+		}
+	}
+	return metav1.Condition{
+		Type:   AnnotationsValidCondition,
+		Status: metav1.ConditionTrue,
+		Reason: AnnotationsValidCondition,
+	}
+}
+
+func (m *MappingController) circuitClosedCondition(route *trafficv1alpha2.Route) metav1.Condition {
+	prefix := m.router.TunnelPrefix(route)
+	open := []string{}
+	for _, hubName := range m.way {
+		for _, name := range m.hubInterface.OpenCircuits(hubName) {
+			if strings.HasPrefix(name, prefix) {
+				open = append(open, hubName+"/"+name)
+			}
+		}
+	}
+	if len(open) != 0 {
+		return metav1.Condition{
+			Type:    CircuitClosedCondition,
+			Status:  metav1.ConditionFalse,
+			Reason:  "CircuitOpen",
+			Message: strings.Join(open, ", "),
+		}
+	}
+	return metav1.Condition{
+		Type:   CircuitClosedCondition,
+		Status: metav1.ConditionTrue,
+		Reason: CircuitClosedCondition,
+	}
+}
+
+func (m *MappingController) exportEndpointsReadyCondition(route *trafficv1alpha2.Route) metav1.Condition {
+	export := route.Spec.Export.Service
+	svc, ok := m.hubInterface.GetService(m.exportHubName, export.Namespace, export.Name)
+	if !ok {
+		return metav1.Condition{
+			Type:   ExportEndpointsReadyCondition,
+			Status: metav1.ConditionFalse,
+			Reason: "ExportServiceNotFound",
+		}
+	}
+	ep, ok := m.hubInterface.GetEndpoints(m.exportHubName, export.Namespace, export.Name)
+	if !router.EndpointsReady(svc, ep, ok) {
+		return metav1.Condition{
+			Type:   ExportEndpointsReadyCondition,
+			Status: metav1.ConditionFalse,
+			Reason: "NoReadyEndpoints",
+		}
+	}
+	return metav1.Condition{
+		Type:   ExportEndpointsReadyCondition,
+		Status: metav1.ConditionTrue,
+		Reason: ExportEndpointsReadyCondition,
+	}
+}
+
 func (m *MappingController) Close() {
 	m.mut.Lock()
 	defer m.mut.Unlock()
@@ -431,7 +544,11 @@ func (m *MappingController) updatePort(f *trafficv1alpha2.Route) error {
 		return fmt.Errorf("not found export service")
 	}
 
-	for _, port := range svc.Spec.Ports {
+	ports, err := router.RoutePorts(f, svc)
+	if err != nil {
+		return err
+	}
+	for _, port := range ports {
 		if port.Protocol != corev1.ProtocolTCP {
 			continue
 		}
@@ -449,14 +566,33 @@ func (m *MappingController) deletePort(f *trafficv1alpha2.Route) error {
 	if !ok {
 		return fmt.Errorf("not found export service")
 	}
-	for _, port := range svc.Spec.Ports {
-		if port.Protocol != corev1.ProtocolTCP {
-			continue
+	names := []string{f.Spec.Export.Service.Name}
+	if svc.Spec.ClusterIP == corev1.ClusterIPNone {
+		ep, ok := m.hubInterface.GetEndpoints(f.Spec.Export.HubName, f.Spec.Export.Service.Namespace, f.Spec.Export.Service.Name)
+		if ok {
+			for _, hostname := range router.ReadyHostnames(ep) {
+				names = append(names, hostname+"."+f.Spec.Export.Service.Name)
+			}
 		}
-		_, err := m.hubInterface.DeletePortPeer(f.Spec.Import.HubName,
-			f.Spec.Export.HubName, f.Spec.Export.Service.Namespace, f.Spec.Export.Service.Name, port.Port)
-		if err == nil {
-			continue
+	}
+	ports, err := router.RoutePorts(f, svc)
+	if err != nil {
+		m.logger.Error(err, "route ports")
+		ports = nil
+		for _, port := range svc.Spec.Ports {
+			ports = append(ports, router.RoutePort{ServicePort: port})
+		}
+	}
+	for _, name := range names {
+		for _, port := range ports {
+			if port.Protocol != corev1.ProtocolTCP {
+				continue
+			}
+			_, err := m.hubInterface.DeletePortPeer(f.Spec.Import.HubName,
+				f.Spec.Export.HubName, f.Spec.Export.Service.Namespace, name, port.Port)
+			if err == nil {
+				continue
+			}
 		}
 	}
 	return nil
@@ -36,12 +36,21 @@ import (
 	"k8s.io/client-go/tools/cache"
 )
 
+// TenantInterface checks the routes that are not in the namespace of ferry
+type TenantInterface interface {
+	Allow(namespace string, spec trafficv1alpha2.RouteSpec) error
+	// HasSynced returns true if the tenants are loaded
+	HasSynced() bool
+}
+
 type RouteControllerConfig struct {
 	Logger       logr.Logger
 	Clientset    client.Interface
 	HubInterface HubInterface
-	Namespace    string
-	SyncFunc     func()
+	// TenantInterface watches the routes of all namespaces if not nil
+	TenantInterface TenantInterface
+	Namespace       string
+	SyncFunc        func()
 }
 
 type RouteController struct {
@@ -49,7 +58,8 @@ type RouteController struct {
 	mut                    sync.RWMutex
 	clientset              client.Interface
 	hubInterface           HubInterface
-	cache                  map[string]*trafficv1alpha2.Route
+	tenantInterface        TenantInterface
+	cache                  map[objref.ObjectRef]*trafficv1alpha2.Route
 	cacheMappingController map[clusterPair]*MappingController
 	cacheRoutes            map[clusterPair][]*trafficv1alpha2.Route
 	namespace              string
@@ -63,9 +73,10 @@ func NewRouteController(conf *RouteControllerConfig) *RouteController {
 		clientset:              conf.Clientset,
 		namespace:              conf.Namespace,
 		hubInterface:           conf.HubInterface,
+		tenantInterface:        conf.TenantInterface,
 		logger:                 conf.Logger,
 		syncFunc:               conf.SyncFunc,
-		cache:                  map[string]*trafficv1alpha2.Route{},
+		cache:                  map[objref.ObjectRef]*trafficv1alpha2.Route{},
 		cacheMappingController: map[clusterPair]*MappingController{},
 		cacheRoutes:            map[clusterPair][]*trafficv1alpha2.Route{},
 		conditionsManager:      conditions.NewConditionsManager(),
@@ -74,11 +85,7 @@ func NewRouteController(conf *RouteControllerConfig) *RouteController {
 
 func (c *RouteController) list() []*trafficv1alpha2.Route {
 	var list []*trafficv1alpha2.Route
-	for _, v := range c.cache {
-		item := c.cache[v.Name]
-		if item == nil {
-			continue
-		}
+	for _, item := range c.cache {
 		list = append(list, item)
 	}
 	sort.Slice(list, func(i, j int) bool {
@@ -92,8 +99,12 @@ func (c *RouteController) Run(ctx context.Context) error {
 	defer c.logger.Info("Route controller stopped")
 
 	c.ctx = ctx
+	namespace := c.namespace
+	if c.tenantInterface != nil {
+		namespace = metav1.NamespaceAll
+	}
 	informerFactory := externalversions.NewSharedInformerFactoryWithOptions(c.clientset.Ferry(), 0,
-		externalversions.WithNamespace(c.namespace))
+		externalversions.WithNamespace(namespace))
 	informer := informerFactory.
 		Traffic().
 		V1alpha2().
@@ -109,13 +120,13 @@ func (c *RouteController) Run(ctx context.Context) error {
 	return nil
 }
 
-func (c *RouteController) UpdateRouteCondition(name string, conditions []metav1.Condition) {
+func (c *RouteController) UpdateRouteCondition(ref objref.ObjectRef, conditions []metav1.Condition) {
 	c.mut.Lock()
 	defer c.mut.Unlock()
-	c.updateRouteCondition(name, conditions)
+	c.updateRouteCondition(ref, conditions)
 }
 
-func (c *RouteController) updateRouteCondition(name string, conditions []metav1.Condition) {
+func (c *RouteController) updateRouteCondition(ref objref.ObjectRef, conditions []metav1.Condition) {
 	var retErr error
 	defer func() {
 		if retErr != nil {
@@ -123,11 +134,12 @@ func (c *RouteController) updateRouteCondition(name string, conditions []metav1.
 		}
 	}()
 
-	fp := c.cache[name]
+	fp := c.cache[ref]
 	if fp == nil {
-		retErr = fmt.Errorf("not found route %s", name)
+		retErr = fmt.Errorf("not found route %s", ref)
 		return
 	}
+	name := ref.String()
 
 	status := fp.Status.DeepCopy()
 
@@ -141,6 +153,9 @@ func (c *RouteController) updateRouteCondition(name string, conditions []metav1.
 		trafficv1alpha2.ExportHubReadyCondition,
 		trafficv1alpha2.ImportHubReadyCondition,
 		trafficv1alpha2.PathReachableCondition,
+		ExportEndpointsReadyCondition,
+		CircuitClosedCondition,
+		AnnotationsValidCondition,
 	)
 	if ready {
 		c.conditionsManager.Set(name, metav1.Condition{
@@ -201,7 +216,7 @@ func (c *RouteController) onAdd(obj interface{}) {
 	c.mut.Lock()
 	defer c.mut.Unlock()
 
-	c.cache[f.Name] = f
+	c.cache[objref.KObj(f)] = f
 
 	c.syncFunc()
 }
@@ -216,12 +231,14 @@ func (c *RouteController) onUpdate(oldObj, newObj interface{}) {
 	c.mut.Lock()
 	defer c.mut.Unlock()
 
-	if reflect.DeepEqual(c.cache[f.Name].Spec, f.Spec) {
-		c.cache[f.Name] = f
+	ref := objref.KObj(f)
+	if reflect.DeepEqual(c.cache[ref].Spec, f.Spec) &&
+		reflect.DeepEqual(c.cache[ref].Annotations, f.Annotations) {
+		c.cache[ref] = f
 		return
 	}
 
-	c.cache[f.Name] = f
+	c.cache[ref] = f
 
 	c.syncFunc()
 }
@@ -235,9 +252,10 @@ func (c *RouteController) onDelete(obj interface{}) {
 	c.mut.Lock()
 	defer c.mut.Unlock()
 
-	delete(c.cache, f.Name)
+	ref := objref.KObj(f)
+	delete(c.cache, ref)
 
-	c.conditionsManager.Delete(f.Name)
+	c.conditionsManager.Delete(ref.String())
 	c.syncFunc()
 }
 
@@ -245,7 +263,16 @@ func (c *RouteController) Sync(ctx context.Context) {
 	c.mut.Lock()
 	defer c.mut.Unlock()
 
+	// The routes are synced again once the tenants are loaded
+	if c.tenantInterface != nil && !c.tenantInterface.HasSynced() {
+		c.logger.Info("Waiting for the tenants to sync")
+		return
+	}
+
 	routes := c.list()
+	if c.tenantInterface != nil {
+		routes = c.allowedRoutes(routes)
+	}
 
 	newerRoutes := groupRoutes(routes)
 	defer func() {
@@ -277,14 +304,14 @@ func (c *RouteController) Sync(ctx context.Context) {
 				},
 			}
 			for _, route := range routes {
-				c.updateRouteCondition(route.Name, conds)
+				c.updateRouteCondition(objref.KObj(route), conds)
 			}
 			continue
 		}
 		if len(failedConds) != 0 {
 			routes := newerRoutes[key]
 			for _, route := range routes {
-				c.updateRouteCondition(route.Name, failedConds)
+				c.updateRouteCondition(objref.KObj(route), failedConds)
 			}
 			continue
 		}
@@ -296,6 +323,28 @@ func (c *RouteController) Sync(ctx context.Context) {
 	return
 }
 
+// allowedRoutes returns the routes that are allowed by their tenant, the others are marked as forbidden
+func (c *RouteController) allowedRoutes(routes []*trafficv1alpha2.Route) []*trafficv1alpha2.Route {
+	allowed := make([]*trafficv1alpha2.Route, 0, len(routes))
+	for _, route := range routes {
+		err := c.tenantInterface.Allow(route.Namespace, route.Spec)
+		if err != nil {
+			c.logger.Info("Forbidden route", "route", objref.KObj(route), "err", err)
+			c.updateRouteCondition(objref.KObj(route), []metav1.Condition{
+				{
+					Type:    trafficv1alpha2.RouteSyncedCondition,
+					Status:  metav1.ConditionFalse,
+					Reason:  "Forbidden",
+					Message: err.Error(),
+				},
+			})
+			continue
+		}
+		allowed = append(allowed, route)
+	}
+	return allowed
+}
+
 func (c *RouteController) cleanupMappingController(key clusterPair) {
 	mc := c.cacheMappingController[key]
 	if mc != nil {
@@ -340,6 +389,7 @@ func (c *RouteController) startMappingController(ctx context.Context, key cluste
 
 	mc = NewMappingController(MappingControllerConfig{
 		Namespace:      consts.FerryTunnelNamespace,
+		RouteNamespace: c.namespace,
 		HubInterface:   c.hubInterface,
 		RouteInterface: c,
 		ImportHubName:  key.Import,
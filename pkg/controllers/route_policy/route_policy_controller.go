@@ -22,8 +22,10 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"path"
 	"reflect"
 	"sort"
+	"strings"
 	"sync"
 
 	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
@@ -31,6 +33,7 @@ import (
 	"github.com/ferryproxy/ferry/pkg/client"
 	"github.com/ferryproxy/ferry/pkg/conditions"
 	"github.com/ferryproxy/ferry/pkg/consts"
+	"github.com/ferryproxy/ferry/pkg/router"
 	"github.com/ferryproxy/ferry/pkg/utils/diffobjs"
 	"github.com/ferryproxy/ferry/pkg/utils/maps"
 	"github.com/ferryproxy/ferry/pkg/utils/objref"
@@ -45,14 +48,25 @@ import (
 type HubInterface interface {
 	ListHubs() []*trafficv1alpha2.Hub
 	ListServices(hubName string) []*corev1.Service
+	GetService(hubName string, namespace, name string) (*corev1.Service, bool)
+	GetEndpoints(hubName string, namespace, name string) (*corev1.Endpoints, bool)
+}
+
+// TenantInterface checks the routes that are not in the namespace of ferry
+type TenantInterface interface {
+	Allow(namespace string, spec trafficv1alpha2.RouteSpec) error
+	// HasSynced returns true if the tenants are loaded
+	HasSynced() bool
 }
 
 type RoutePolicyControllerConfig struct {
 	Logger       logr.Logger
 	Clientset    client.Interface
 	HubInterface HubInterface
-	Namespace    string
-	SyncFunc     func()
+	// TenantInterface watches the policies of all namespaces if not nil
+	TenantInterface TenantInterface
+	Namespace       string
+	SyncFunc        func()
 }
 
 type RoutePolicyController struct {
@@ -61,7 +75,8 @@ type RoutePolicyController struct {
 	mutStatus              sync.Mutex
 	clientset              client.Interface
 	hubInterface           HubInterface
-	cache                  map[string]*trafficv1alpha2.RoutePolicy
+	tenantInterface        TenantInterface
+	cache                  map[objref.ObjectRef]*trafficv1alpha2.RoutePolicy
 	namespace              string
 	logger                 logr.Logger
 	cacheRoutePolicyRoutes []*trafficv1alpha2.Route
@@ -75,29 +90,29 @@ func NewRoutePolicyController(conf RoutePolicyControllerConfig) *RoutePolicyCont
 		namespace:         conf.Namespace,
 		logger:            conf.Logger,
 		hubInterface:      conf.HubInterface,
+		tenantInterface:   conf.TenantInterface,
 		syncFunc:          conf.SyncFunc,
-		cache:             map[string]*trafficv1alpha2.RoutePolicy{},
+		cache:             map[objref.ObjectRef]*trafficv1alpha2.RoutePolicy{},
 		conditionsManager: conditions.NewConditionsManager(),
 	}
 }
 
 func (c *RoutePolicyController) list() []*trafficv1alpha2.RoutePolicy {
 	var list []*trafficv1alpha2.RoutePolicy
-	for _, v := range c.cache {
-		item := c.cache[v.Name]
-		if item == nil {
-			continue
-		}
+	for _, item := range c.cache {
 		list = append(list, item)
 	}
 	sort.Slice(list, func(i, j int) bool {
+		if list[i].Namespace != list[j].Namespace {
+			return list[i].Namespace < list[j].Namespace
+		}
 		return list[i].Name < list[j].Name
 	})
 	return list
 }
 
-func (c *RoutePolicyController) get(name string) *trafficv1alpha2.RoutePolicy {
-	return c.cache[name]
+func (c *RoutePolicyController) get(ref objref.ObjectRef) *trafficv1alpha2.RoutePolicy {
+	return c.cache[ref]
 }
 
 func (c *RoutePolicyController) Run(ctx context.Context) error {
@@ -106,10 +121,15 @@ func (c *RoutePolicyController) Run(ctx context.Context) error {
 
 	c.ctx = ctx
 
+	namespace := c.namespace
+	if c.tenantInterface != nil {
+		namespace = metav1.NamespaceAll
+	}
+
 	list, err := c.clientset.
 		Ferry().
 		TrafficV1alpha2().
-		Routes(c.namespace).
+		Routes(namespace).
 		List(ctx, metav1.ListOptions{
 			LabelSelector: labels.FormatLabels(labelsForRoute),
 		})
@@ -121,7 +141,7 @@ func (c *RoutePolicyController) Run(ctx context.Context) error {
 	}
 
 	informerFactory := externalversions.NewSharedInformerFactoryWithOptions(c.clientset.Ferry(), 0,
-		externalversions.WithNamespace(c.namespace))
+		externalversions.WithNamespace(namespace))
 	informer := informerFactory.
 		Traffic().
 		V1alpha2().
@@ -137,7 +157,9 @@ func (c *RoutePolicyController) Run(ctx context.Context) error {
 	return nil
 }
 
-func (c *RoutePolicyController) UpdateRoutePolicyCondition(name string, routeCount int) {
+// UpdateRoutePolicyCondition updates the status of the policy, routes is the routes of the policy, denied is the imports
+// that are not allowed by the exported services and forbidden is the routes that are not allowed by the tenant
+func (c *RoutePolicyController) UpdateRoutePolicyCondition(ref objref.ObjectRef, routes []*trafficv1alpha2.Route, denied, forbidden []string) {
 	c.mutStatus.Lock()
 	defer c.mutStatus.Unlock()
 
@@ -148,8 +170,10 @@ func (c *RoutePolicyController) UpdateRoutePolicyCondition(name string, routeCou
 		}
 	}()
 
+	name := ref.String()
 	status := trafficv1alpha2.RoutePolicyStatus{}
 
+	routeCount := len(routes)
 	if routeCount > 0 {
 		c.conditionsManager.Set(name, metav1.Condition{
 			Type:   trafficv1alpha2.RoutePolicyReady,
@@ -166,6 +190,39 @@ func (c *RoutePolicyController) UpdateRoutePolicyCondition(name string, routeCou
 		status.Phase = "NotReady"
 	}
 
+	if len(denied) != 0 {
+		c.conditionsManager.Set(name, metav1.Condition{
+			Type:    ImportAllowedCondition,
+			Status:  metav1.ConditionFalse,
+			Reason:  "ImportDenied",
+			Message: strings.Join(denied, ", "),
+		})
+	} else {
+		c.conditionsManager.Set(name, metav1.Condition{
+			Type:   ImportAllowedCondition,
+			Status: metav1.ConditionTrue,
+			Reason: ImportAllowedCondition,
+		})
+	}
+
+	if len(forbidden) != 0 {
+		c.conditionsManager.Set(name, metav1.Condition{
+			Type:    TenantAllowedCondition,
+			Status:  metav1.ConditionFalse,
+			Reason:  "Forbidden",
+			Message: strings.Join(forbidden, ", "),
+		})
+	} else {
+		c.conditionsManager.Set(name, metav1.Condition{
+			Type:   TenantAllowedCondition,
+			Status: metav1.ConditionTrue,
+			Reason: TenantAllowedCondition,
+		})
+	}
+
+	c.conditionsManager.Set(name, trafficSplitCondition(c.hubInterface, c.get(ref), routes))
+	c.conditionsManager.Set(name, httpRulesCondition(c.get(ref)))
+
 	status.LastSynchronizationTimestamp = metav1.Now()
 	status.RouteCount = routeCount
 	status.Conditions = c.conditionsManager.Get(name)
@@ -180,8 +237,8 @@ func (c *RoutePolicyController) UpdateRoutePolicyCondition(name string, routeCou
 	_, err = c.clientset.
 		Ferry().
 		TrafficV1alpha2().
-		RoutePolicies(consts.FerryNamespace).
-		Patch(c.ctx, name, types.MergePatchType, data, metav1.PatchOptions{}, "status")
+		RoutePolicies(ref.Namespace).
+		Patch(c.ctx, ref.Name, types.MergePatchType, data, metav1.PatchOptions{}, "status")
 	if err != nil {
 		retErr = err
 		return
@@ -198,11 +255,12 @@ func (c *RoutePolicyController) onAdd(obj interface{}) {
 	c.mut.Lock()
 	defer c.mut.Unlock()
 
-	c.cache[f.Name] = f
+	ref := objref.KObj(f)
+	c.cache[ref] = f
 
 	c.syncFunc()
 
-	c.UpdateRoutePolicyCondition(f.Name, 0)
+	c.UpdateRoutePolicyCondition(ref, nil, nil, nil)
 }
 
 func (c *RoutePolicyController) onUpdate(oldObj, newObj interface{}) {
@@ -215,12 +273,14 @@ func (c *RoutePolicyController) onUpdate(oldObj, newObj interface{}) {
 	c.mut.Lock()
 	defer c.mut.Unlock()
 
-	if reflect.DeepEqual(c.cache[f.Name].Spec, f.Spec) {
-		c.cache[f.Name] = f
+	ref := objref.KObj(f)
+	if reflect.DeepEqual(c.cache[ref].Spec, f.Spec) &&
+		reflect.DeepEqual(c.cache[ref].Annotations, f.Annotations) {
+		c.cache[ref] = f
 		return
 	}
 
-	c.cache[f.Name] = f
+	c.cache[ref] = f
 
 	c.syncFunc()
 }
@@ -234,9 +294,10 @@ func (c *RoutePolicyController) onDelete(obj interface{}) {
 	c.mut.Lock()
 	defer c.mut.Unlock()
 
-	delete(c.cache, f.Name)
+	ref := objref.KObj(f)
+	delete(c.cache, ref)
 
-	c.conditionsManager.Delete(f.Name)
+	c.conditionsManager.Delete(ref.String())
 	c.syncFunc()
 }
 
@@ -244,9 +305,29 @@ func (c *RoutePolicyController) Sync(ctx context.Context) {
 	c.mut.Lock()
 	defer c.mut.Unlock()
 
+	// The policies are synced again once the tenants are loaded
+	if c.tenantInterface != nil && !c.tenantInterface.HasSynced() {
+		c.logger.Info("Waiting for the tenants to sync")
+		return
+	}
+
 	ferryPolicies := c.list()
 
-	updated := policiesToRoutes(c.hubInterface, ferryPolicies)
+	updated, denied := policiesToRoutes(c.hubInterface, ferryPolicies)
+	forbidden := map[types.UID][]string{}
+	if c.tenantInterface != nil {
+		allowed := make([]*trafficv1alpha2.Route, 0, len(updated))
+		for _, r := range updated {
+			err := c.tenantInterface.Allow(r.Namespace, r.Spec)
+			if err != nil {
+				uid := r.OwnerReferences[0].UID
+				forbidden[uid] = append(forbidden[uid], err.Error())
+				continue
+			}
+			allowed = append(allowed, r)
+		}
+		updated = allowed
+	}
 
 	hubs := c.hubInterface.ListHubs()
 
@@ -274,19 +355,20 @@ func (c *RoutePolicyController) Sync(ctx context.Context) {
 	}
 
 	for _, policy := range ferryPolicies {
-		count := 0
-
+		var routes []*trafficv1alpha2.Route
 		for _, r := range updated {
 			if policy.UID == r.OwnerReferences[0].UID {
-				count++
+				routes = append(routes, r)
 			}
 		}
-		c.UpdateRoutePolicyCondition(policy.Name, count)
+		c.UpdateRoutePolicyCondition(objref.KObj(policy), routes, denied[objref.KObj(policy)], forbidden[policy.UID])
 	}
 }
 
-func policiesToRoutes(hubInterface HubInterface, policies []*trafficv1alpha2.RoutePolicy) []*trafficv1alpha2.Route {
+// policiesToRoutes returns the routes of the policies, and the imports that are denied by the exported services for each policy
+func policiesToRoutes(hubInterface HubInterface, policies []*trafficv1alpha2.RoutePolicy) ([]*trafficv1alpha2.Route, map[objref.ObjectRef][]string) {
 	out := []*trafficv1alpha2.Route{}
+	denied := map[objref.ObjectRef][]string{}
 	rules := groupFerryPolicies(policies)
 	controller := true
 	for exportHubName, rule := range rules {
@@ -365,6 +447,13 @@ func policiesToRoutes(hubInterface HubInterface, policies []*trafficv1alpha2.Rou
 
 					policy := match.Policy
 
+					if !router.ImportAllowed(svc, importHubName) {
+						ref := objref.KObj(policy)
+						denied[ref] = append(denied[ref],
+							fmt.Sprintf("%s/%s/%s to %s", exportHubName, exportNamespace, exportName, importHubName))
+						continue
+					}
+
 					suffix := hash(fmt.Sprintf("%s|%s|%s|%s|%s|%s",
 						exportHubName, exportNamespace, exportName,
 						importHubName, importNamespace, importName))
@@ -373,6 +462,8 @@ func policiesToRoutes(hubInterface HubInterface, policies []*trafficv1alpha2.Rou
 							Name:      fmt.Sprintf("%s-%s", policy.Name, suffix),
 							Namespace: policy.Namespace,
 							Labels:    maps.Merge(policy.Labels, labelsForRoute),
+							// Only the annotations of ferry are inherited, e.g. the ports of the route
+							Annotations: maps.FilterPrefix(policy.Annotations, consts.LabelPrefix),
 							OwnerReferences: []metav1.OwnerReference{
 								{
 									APIVersion: trafficv1alpha2.GroupVersion.String(),
@@ -407,7 +498,10 @@ func policiesToRoutes(hubInterface HubInterface, policies []*trafficv1alpha2.Rou
 	sort.Slice(out, func(i, j int) bool {
 		return out[i].Name < out[j].Name
 	})
-	return out
+	for _, d := range denied {
+		sort.Strings(d)
+	}
+	return out, denied
 }
 
 func groupFerryPolicies(policies []*trafficv1alpha2.RoutePolicy) map[string]map[string][]groupRoutePolicy {
@@ -447,6 +541,82 @@ type groupRoutePolicy struct {
 	Import trafficv1alpha2.RoutePolicySpecRuleService
 }
 
+const (
+	// ImportAllowedCondition is false if some imports of the policy are denied by the exported services
+	ImportAllowedCondition = "ImportAllowed"
+	// TenantAllowedCondition is false if some routes of the policy are not allowed by the tenant of its namespace
+	TenantAllowedCondition = "TenantAllowed"
+	// TrafficSplitCondition is true if the imports of the policy are split between the export hubs,
+	// the message is the effective weights of the export hubs for each import
+	TrafficSplitCondition = "TrafficSplit"
+	// HTTPRulesCondition is true if the imports of the policy route the HTTP requests by the rules
+	HTTPRulesCondition = "HTTPRules"
+)
+
+// trafficSplitCondition reports the effective weights of the export hubs for each import of the policy,
+// which leave out the hubs without ready endpoints, see router.EffectiveSplit
+func trafficSplitCondition(hubInterface HubInterface, policy *trafficv1alpha2.RoutePolicy, routes []*trafficv1alpha2.Route) metav1.Condition {
+	var v string
+	if policy != nil {
+		v = policy.Annotations[consts.AnnotationTrafficSplitKey]
+	}
+	if v == "" {
+		return metav1.Condition{
+			Type:   TrafficSplitCondition,
+			Status: metav1.ConditionFalse,
+			Reason: "NotSplit",
+		}
+	}
+	split, err := router.ParseTrafficSplit(v)
+	if err != nil {
+		return metav1.Condition{
+			Type:    TrafficSplitCondition,
+			Status:  metav1.ConditionFalse,
+			Reason:  "InvalidTrafficSplit",
+			Message: err.Error(),
+		}
+	}
+	if len(routes) == 0 {
+		return metav1.Condition{
+			Type:    TrafficSplitCondition,
+			Status:  metav1.ConditionTrue,
+			Reason:  TrafficSplitCondition,
+			Message: split.String(),
+		}
+	}
+
+	// The readiness of the export hubs of each import
+	imports := map[string]map[string]bool{}
+	for _, r := range routes {
+		imp := path.Join(r.Spec.Import.HubName, r.Spec.Import.Service.Namespace, r.Spec.Import.Service.Name)
+		if imports[imp] == nil {
+			imports[imp] = map[string]bool{}
+		}
+		export := r.Spec.Export
+		ready := false
+		if svc, ok := hubInterface.GetService(export.HubName, export.Service.Namespace, export.Service.Name); ok {
+			ep, ok := hubInterface.GetEndpoints(export.HubName, export.Service.Namespace, export.Service.Name)
+			ready = router.EndpointsReady(svc, ep, ok)
+		}
+		imports[imp][export.HubName] = imports[imp][export.HubName] || ready
+	}
+	keys := make([]string, 0, len(imports))
+	for imp := range imports {
+		keys = append(keys, imp)
+	}
+	sort.Strings(keys)
+	messages := make([]string, 0, len(keys))
+	for _, imp := range keys {
+		messages = append(messages, fmt.Sprintf("%s: %s", imp, router.EffectiveSplit(split, imports[imp])))
+	}
+	return metav1.Condition{
+		Type:    TrafficSplitCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  TrafficSplitCondition,
+		Message: strings.Join(messages, "; "),
+	}
+}
+
 var labelsForRoute = map[string]string{
 	consts.LabelGeneratedKey: consts.LabelGeneratedValue,
 }
@@ -455,3 +625,32 @@ func hash(s string) string {
 	d := sha256.Sum256([]byte(s))
 	return hex.EncodeToString(d[:6])
 }
+
+func httpRulesCondition(policy *trafficv1alpha2.RoutePolicy) metav1.Condition {
+	var v string
+	if policy != nil {
+		v = policy.Annotations[consts.AnnotationHTTPRulesKey]
+	}
+	if v == "" {
+		return metav1.Condition{
+			Type:   HTTPRulesCondition,
+			Status: metav1.ConditionFalse,
+			Reason: "NotHTTP",
+		}
+	}
+	rules, err := router.ParseHTTPRules(v)
+	if err != nil {
+		return metav1.Condition{
+			Type:    HTTPRulesCondition,
+			Status:  metav1.ConditionFalse,
+			Reason:  "InvalidHTTPRules",
+			Message: err.Error(),
+		}
+	}
+	return metav1.Condition{
+		Type:    HTTPRulesCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  HTTPRulesCondition,
+		Message: fmt.Sprintf("%d rules", len(rules)),
+	}
+}
@@ -20,6 +20,8 @@ import (
 	"testing"
 
 	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
+	"github.com/ferryproxy/ferry/pkg/consts"
+	"github.com/ferryproxy/ferry/pkg/utils/objref"
 	"github.com/google/go-cmp/cmp"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -779,7 +781,7 @@ func Test_policiesToRoutes(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := policiesToRoutes(src, tt.policies)
+			got, _ := policiesToRoutes(src, tt.policies)
 			if diff := cmp.Diff(got, tt.want); diff != "" {
 				t.Errorf("policiesToRoutes(): got - want + \n%s", diff)
 			}
@@ -787,8 +789,63 @@ func Test_policiesToRoutes(t *testing.T) {
 	}
 }
 
+func Test_policiesToRoutesDenied(t *testing.T) {
+	src := &fakeDataSource{
+		svcs: map[string][]*corev1.Service{
+			"export-1": {
+				{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "app-1",
+						Namespace: "default",
+						Annotations: map[string]string{
+							consts.AnnotationAllowedImportHubsKey: "import-1",
+						},
+					},
+				},
+			},
+		},
+	}
+	policies := []*trafficv1alpha2.RoutePolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "test",
+			},
+			Spec: trafficv1alpha2.RoutePolicySpec{
+				Exports: []trafficv1alpha2.RoutePolicySpecRule{
+					{
+						HubName: "export-1",
+						Service: trafficv1alpha2.RoutePolicySpecRuleService{
+							Namespace: "default",
+						},
+					},
+				},
+				Imports: []trafficv1alpha2.RoutePolicySpecRule{
+					{
+						HubName: "import-1",
+					},
+					{
+						HubName: "import-2",
+					},
+				},
+			},
+		},
+	}
+
+	got, denied := policiesToRoutes(src, policies)
+	if len(got) != 1 || got[0].Spec.Import.HubName != "import-1" {
+		t.Errorf("policiesToRoutes(): want only the route to import-1, got %d routes", len(got))
+	}
+	want := map[objref.ObjectRef][]string{
+		{Name: "test"}: {"export-1/default/app-1 to import-2"},
+	}
+	if diff := cmp.Diff(denied, want); diff != "" {
+		t.Errorf("policiesToRoutes(): denied got - want + \n%s", diff)
+	}
+}
+
 type fakeDataSource struct {
 	svcs map[string][]*corev1.Service
+	eps  map[string][]*corev1.Endpoints
 }
 
 func (f *fakeDataSource) ListServices(name string) []*corev1.Service {
@@ -798,3 +855,118 @@ func (f *fakeDataSource) ListServices(name string) []*corev1.Service {
 func (f *fakeDataSource) ListHubs() []*trafficv1alpha2.Hub {
 	return nil
 }
+
+func (f *fakeDataSource) GetService(hubName string, namespace, name string) (*corev1.Service, bool) {
+	for _, svc := range f.svcs[hubName] {
+		if svc.Namespace == namespace && svc.Name == name {
+			return svc, true
+		}
+	}
+	return nil, false
+}
+
+func (f *fakeDataSource) GetEndpoints(hubName string, namespace, name string) (*corev1.Endpoints, bool) {
+	for _, ep := range f.eps[hubName] {
+		if ep.Namespace == namespace && ep.Name == name {
+			return ep, true
+		}
+	}
+	return nil, false
+}
+
+func Test_trafficSplitCondition(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "app-1",
+			Namespace: "default",
+		},
+	}
+	endpoints := func(ready bool) *corev1.Endpoints {
+		ep := &corev1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "app-1",
+				Namespace: "default",
+			},
+		}
+		if ready {
+			ep.Subsets = []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}}}
+		}
+		return ep
+	}
+	src := &fakeDataSource{
+		svcs: map[string][]*corev1.Service{
+			"export-1": {svc},
+			"export-2": {svc},
+			"export-3": {svc},
+		},
+		eps: map[string][]*corev1.Endpoints{
+			"export-1": {endpoints(true)},
+			"export-2": {endpoints(false)},
+			"export-3": {endpoints(true)},
+		},
+	}
+	route := func(export string) *trafficv1alpha2.Route {
+		return &trafficv1alpha2.Route{
+			Spec: trafficv1alpha2.RouteSpec{
+				Import: trafficv1alpha2.RouteSpecRule{
+					HubName: "import-1",
+					Service: trafficv1alpha2.RouteSpecRuleService{Name: "app-1", Namespace: "default"},
+				},
+				Export: trafficv1alpha2.RouteSpecRule{
+					HubName: export,
+					Service: trafficv1alpha2.RouteSpecRuleService{Name: "app-1", Namespace: "default"},
+				},
+			},
+		}
+	}
+	policy := func(split string) *trafficv1alpha2.RoutePolicy {
+		return &trafficv1alpha2.RoutePolicy{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{
+					consts.AnnotationTrafficSplitKey: split,
+				},
+			},
+		}
+	}
+	tests := []struct {
+		name        string
+		policy      *trafficv1alpha2.RoutePolicy
+		routes      []*trafficv1alpha2.Route
+		wantStatus  metav1.ConditionStatus
+		wantMessage string
+	}{
+		{
+			name:       "not split",
+			policy:     &trafficv1alpha2.RoutePolicy{},
+			wantStatus: metav1.ConditionFalse,
+		},
+		{
+			name:        "without routes",
+			policy:      policy("export-1=90,export-2=10"),
+			wantStatus:  metav1.ConditionTrue,
+			wantMessage: "export-1=90%,export-2=10%",
+		},
+		{
+			name:        "not ready",
+			policy:      policy("export-1=60,export-2=20,export-3=20"),
+			routes:      []*trafficv1alpha2.Route{route("export-1"), route("export-2"), route("export-3")},
+			wantStatus:  metav1.ConditionTrue,
+			wantMessage: "import-1/default/app-1: export-1=75%,export-2=0%,export-3=25%",
+		},
+		{
+			name:        "weighted hubs are down",
+			policy:      policy("export-2=100"),
+			routes:      []*trafficv1alpha2.Route{route("export-1"), route("export-2"), route("export-3")},
+			wantStatus:  metav1.ConditionTrue,
+			wantMessage: "import-1/default/app-1: export-1=50%,export-2=0%,export-3=50%",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := trafficSplitCondition(src, tt.policy, tt.routes)
+			if got.Status != tt.wantStatus || got.Message != tt.wantMessage {
+				t.Errorf("trafficSplitCondition() = %s %q, want %s %q", got.Status, got.Message, tt.wantStatus, tt.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
+	"github.com/ferryproxy/ferry/pkg/client"
+	"github.com/ferryproxy/ferry/pkg/consts"
+	"github.com/ferryproxy/ferry/pkg/utils/objref"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Tenant is the hubs and the namespaces of the hubs that a namespace of the control plane may route
+type Tenant struct {
+	Exports []Rule `json:"exports,omitempty"`
+	Imports []Rule `json:"imports,omitempty"`
+}
+
+// Rule allows the namespaces of a hub
+type Rule struct {
+	HubName string `json:"hubName"`
+	// Namespaces are the namespaces of the hub, the namespace of the tenant by default, "*" is any namespace
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+func (r Rule) allow(tenant string, hubName, namespace string) bool {
+	if r.HubName != hubName {
+		return false
+	}
+	if len(r.Namespaces) == 0 {
+		return namespace == tenant
+	}
+	for _, ns := range r.Namespaces {
+		if ns == "*" || ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// Allow returns an error if the route of the tenant exports or imports what is not allowed
+func (t *Tenant) Allow(tenant string, spec trafficv1alpha2.RouteSpec) error {
+	if !allow(t.Exports, tenant, spec.Export) {
+		return fmt.Errorf("tenant %s is not allowed to export from %s/%s", tenant, spec.Export.HubName, spec.Export.Service.Namespace)
+	}
+	if !allow(t.Imports, tenant, spec.Import) {
+		return fmt.Errorf("tenant %s is not allowed to import into %s/%s", tenant, spec.Import.HubName, spec.Import.Service.Namespace)
+	}
+	return nil
+}
+
+func allow(rules []Rule, tenant string, rule trafficv1alpha2.RouteSpecRule) bool {
+	for _, r := range rules {
+		if r.allow(tenant, rule.HubName, rule.Service.Namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+type TenantControllerConfig struct {
+	Namespace string
+	Logger    logr.Logger
+	Clientset client.Interface
+	SyncFunc  func()
+}
+
+// TenantController watches the tenants that are defined by the admin in the namespace of ferry,
+// the routes in the namespace of ferry are always allowed, the others are only allowed by their tenant.
+type TenantController struct {
+	mut       sync.RWMutex
+	namespace string
+	cache     map[string]map[string]*Tenant
+	synced    bool
+	clientset client.Interface
+	logger    logr.Logger
+	syncFunc  func()
+}
+
+func NewTenantController(conf *TenantControllerConfig) *TenantController {
+	return &TenantController{
+		namespace: conf.Namespace,
+		cache:     map[string]map[string]*Tenant{},
+		clientset: conf.Clientset,
+		logger:    conf.Logger,
+		syncFunc:  conf.SyncFunc,
+	}
+}
+
+func (c *TenantController) Run(ctx context.Context) error {
+	c.logger.Info("Tenant controller started")
+	defer c.logger.Info("Tenant controller stopped")
+
+	informer := informers.NewSharedInformerFactoryWithOptions(c.clientset.Kubernetes(), 0,
+		informers.WithNamespace(c.namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = consts.LabelTenantKey
+		}),
+	).Core().V1().ConfigMaps().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.onAdd,
+		UpdateFunc: c.onUpdate,
+		DeleteFunc: c.onDelete,
+	})
+	go informer.Run(ctx.Done())
+	if cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		c.mut.Lock()
+		c.synced = true
+		c.mut.Unlock()
+		c.syncFunc()
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// HasSynced returns true if the tenants are loaded, the routes are not checked before it
+// so that they are not forbidden by the tenants that are not loaded yet
+func (c *TenantController) HasSynced() bool {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	return c.synced
+}
+
+// Allow returns an error if the route in the namespace is not allowed by its tenant
+func (c *TenantController) Allow(namespace string, spec trafficv1alpha2.RouteSpec) error {
+	if namespace == c.namespace {
+		return nil
+	}
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	tenants := c.cache[namespace]
+	if len(tenants) == 0 {
+		return fmt.Errorf("namespace %s is not a tenant", namespace)
+	}
+	var err error
+	for _, t := range tenants {
+		err = t.Allow(namespace, spec)
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (c *TenantController) onAdd(obj interface{}) {
+	cm := obj.(*corev1.ConfigMap)
+	c.logger.Info("onAdd",
+		"configMap", objref.KObj(cm),
+	)
+	c.add(cm)
+}
+
+func (c *TenantController) onUpdate(oldObj, newObj interface{}) {
+	cm := newObj.(*corev1.ConfigMap)
+	c.logger.Info("onUpdate",
+		"configMap", objref.KObj(cm),
+	)
+	c.del(oldObj.(*corev1.ConfigMap))
+	c.add(cm)
+}
+
+func (c *TenantController) onDelete(obj interface{}) {
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		return
+	}
+	c.logger.Info("onDelete",
+		"configMap", objref.KObj(cm),
+	)
+	c.del(cm)
+}
+
+func (c *TenantController) add(cm *corev1.ConfigMap) {
+	namespace := cm.Labels[consts.LabelTenantKey]
+	if namespace == "" {
+		return
+	}
+	tenant := &Tenant{}
+	content := cm.Data[consts.TenantRulesKey]
+	err := json.Unmarshal([]byte(content), tenant)
+	if err != nil {
+		c.logger.Error(err, "unmarshal tenant failed",
+			"configMap", objref.KObj(cm),
+			"content", content,
+		)
+		return
+	}
+
+	c.mut.Lock()
+	if c.cache[namespace] == nil {
+		c.cache[namespace] = map[string]*Tenant{}
+	}
+	c.cache[namespace][cm.Name] = tenant
+	c.mut.Unlock()
+
+	c.syncFunc()
+}
+
+func (c *TenantController) del(cm *corev1.ConfigMap) {
+	namespace := cm.Labels[consts.LabelTenantKey]
+	if namespace == "" {
+		return
+	}
+
+	c.mut.Lock()
+	delete(c.cache[namespace], cm.Name)
+	if len(c.cache[namespace]) == 0 {
+		delete(c.cache, namespace)
+	}
+	c.mut.Unlock()
+
+	c.syncFunc()
+}
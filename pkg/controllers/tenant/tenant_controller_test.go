@@ -0,0 +1,194 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tenant
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
+	ferryversioned "github.com/ferryproxy/client-go/generated/clientset/versioned"
+	"github.com/ferryproxy/ferry/pkg/consts"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	mcsversioned "sigs.k8s.io/mcs-api/pkg/client/clientset/versioned"
+)
+
+type fakeClientset struct {
+	kube *fake.Clientset
+}
+
+func (f *fakeClientset) Kubernetes() kubernetes.Interface {
+	return f.kube
+}
+
+func (f *fakeClientset) Ferry() ferryversioned.Interface {
+	return nil
+}
+
+func (f *fakeClientset) MCS() mcsversioned.Interface {
+	return nil
+}
+
+func TestTenantControllerAllow(t *testing.T) {
+	c := NewTenantController(&TenantControllerConfig{
+		Namespace: "ferry-system",
+		Logger:    logr.Discard(),
+	})
+	c.cache["team-a"] = map[string]*Tenant{
+		"team-a": {
+			Exports: []Rule{
+				{HubName: "cluster-1"},
+			},
+			Imports: []Rule{
+				{HubName: "cluster-2", Namespaces: []string{"shared"}},
+				{HubName: "cluster-3", Namespaces: []string{"*"}},
+			},
+		},
+	}
+
+	route := func(exportHub, exportNamespace, importHub, importNamespace string) trafficv1alpha2.RouteSpec {
+		return trafficv1alpha2.RouteSpec{
+			Export: trafficv1alpha2.RouteSpecRule{
+				HubName: exportHub,
+				Service: trafficv1alpha2.RouteSpecRuleService{Namespace: exportNamespace, Name: "svc"},
+			},
+			Import: trafficv1alpha2.RouteSpecRule{
+				HubName: importHub,
+				Service: trafficv1alpha2.RouteSpecRuleService{Namespace: importNamespace, Name: "svc"},
+			},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		namespace string
+		spec      trafficv1alpha2.RouteSpec
+		wantErr   bool
+	}{
+		{
+			name:      "admin",
+			namespace: "ferry-system",
+			spec:      route("cluster-9", "any", "cluster-8", "any"),
+		},
+		{
+			name:      "not a tenant",
+			namespace: "team-b",
+			spec:      route("cluster-1", "team-b", "cluster-2", "shared"),
+			wantErr:   true,
+		},
+		{
+			name:      "allowed",
+			namespace: "team-a",
+			spec:      route("cluster-1", "team-a", "cluster-2", "shared"),
+		},
+		{
+			name:      "any namespace",
+			namespace: "team-a",
+			spec:      route("cluster-1", "team-a", "cluster-3", "other"),
+		},
+		{
+			name:      "export from other namespace",
+			namespace: "team-a",
+			spec:      route("cluster-1", "team-b", "cluster-2", "shared"),
+			wantErr:   true,
+		},
+		{
+			name:      "import into other namespace",
+			namespace: "team-a",
+			spec:      route("cluster-1", "team-a", "cluster-2", "team-a"),
+			wantErr:   true,
+		},
+		{
+			name:      "import into other hub",
+			namespace: "team-a",
+			spec:      route("cluster-1", "team-a", "cluster-4", "team-a"),
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := c.Allow(tt.namespace, tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Allow() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTenantControllerRun(t *testing.T) {
+	kube := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "team-a",
+			Namespace: "ferry-system",
+			Labels: map[string]string{
+				consts.LabelTenantKey: "team-a",
+			},
+		},
+		Data: map[string]string{
+			consts.TenantRulesKey: `{"exports":[{"hubName":"cluster-1"}],"imports":[{"hubName":"cluster-2"}]}`,
+		},
+	})
+	spec := trafficv1alpha2.RouteSpec{
+		Export: trafficv1alpha2.RouteSpecRule{
+			HubName: "cluster-1",
+			Service: trafficv1alpha2.RouteSpecRuleService{Namespace: "team-a", Name: "svc"},
+		},
+		Import: trafficv1alpha2.RouteSpecRule{
+			HubName: "cluster-2",
+			Service: trafficv1alpha2.RouteSpecRuleService{Namespace: "team-a", Name: "svc"},
+		},
+	}
+
+	var c *TenantController
+	var once sync.Once
+	synced := make(chan error, 1)
+	c = NewTenantController(&TenantControllerConfig{
+		Namespace: "ferry-system",
+		Logger:    logr.Discard(),
+		Clientset: &fakeClientset{kube: kube},
+		SyncFunc: func() {
+			// The first sync after the tenants are loaded must allow the routes of the tenants
+			if c.HasSynced() {
+				once.Do(func() {
+					synced <- c.Allow("team-a", spec)
+				})
+			}
+		},
+	})
+	if c.HasSynced() {
+		t.Fatal("the tenants should not be synced before running")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	select {
+	case err := <-synced:
+		if err != nil {
+			t.Errorf("route should be allowed after the tenants are synced: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the tenants are not synced")
+	}
+}
@@ -0,0 +1,45 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package approve
+
+import (
+	"github.com/ferryproxy/ferry/pkg/consts"
+	"github.com/ferryproxy/ferry/pkg/ferryctl/kubectl"
+	"github.com/ferryproxy/ferry/pkg/ferryctl/log"
+	"github.com/spf13/cobra"
+)
+
+func NewCommand(logger log.Logger) *cobra.Command {
+
+	cmd := &cobra.Command{
+		Args: cobra.ExactArgs(1),
+		Use:  "approve <data-plane-hub-name>",
+		Aliases: []string{
+			"a",
+		},
+		Short: "Control plane approve commands",
+		Long:  `Control plane approve commands is used to approve a hub pending registration`,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			dataPlaneName := args[0]
+
+			kctl := kubectl.NewKubectl()
+			return kctl.Wrap(cmd.Context(), "label", "secret", "-n", consts.FerryNamespace, dataPlaneName+consts.RegistrationSecretSuffix,
+				consts.LabelRegistrationKey+"="+consts.LabelRegistrationApprovedValue, "--overwrite")
+		},
+	}
+	return cmd
+}
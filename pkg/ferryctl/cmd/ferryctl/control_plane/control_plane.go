@@ -19,6 +19,8 @@ package control_plane
 import (
 	"fmt"
 
+	"github.com/ferryproxy/ferry/pkg/ferryctl/cmd/ferryctl/control_plane/approve"
+	"github.com/ferryproxy/ferry/pkg/ferryctl/cmd/ferryctl/control_plane/deny"
 	initcmd "github.com/ferryproxy/ferry/pkg/ferryctl/cmd/ferryctl/control_plane/init"
 	"github.com/ferryproxy/ferry/pkg/ferryctl/cmd/ferryctl/control_plane/join"
 	"github.com/ferryproxy/ferry/pkg/ferryctl/cmd/ferryctl/control_plane/remove"
@@ -46,6 +48,8 @@ func NewCommand(logger log.Logger) *cobra.Command {
 		join.NewCommand(logger),
 		unjoin.NewCommand(logger),
 		remove.NewCommand(logger),
+		approve.NewCommand(logger),
+		deny.NewCommand(logger),
 	)
 	return cmd
 }
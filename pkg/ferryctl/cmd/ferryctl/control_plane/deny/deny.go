@@ -0,0 +1,45 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package deny
+
+import (
+	"github.com/ferryproxy/ferry/pkg/consts"
+	"github.com/ferryproxy/ferry/pkg/ferryctl/kubectl"
+	"github.com/ferryproxy/ferry/pkg/ferryctl/log"
+	"github.com/spf13/cobra"
+)
+
+func NewCommand(logger log.Logger) *cobra.Command {
+
+	cmd := &cobra.Command{
+		Args: cobra.ExactArgs(1),
+		Use:  "deny <data-plane-hub-name>",
+		Aliases: []string{
+			"d",
+		},
+		Short: "Control plane deny commands",
+		Long:  `Control plane deny commands is used to deny a hub pending registration`,
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			dataPlaneName := args[0]
+
+			kctl := kubectl.NewKubectl()
+			return kctl.Wrap(cmd.Context(), "label", "secret", "-n", consts.FerryNamespace, dataPlaneName+consts.RegistrationSecretSuffix,
+				consts.LabelRegistrationKey+"="+consts.LabelRegistrationDeniedValue, "--overwrite")
+		},
+	}
+	return cmd
+}
@@ -34,6 +34,7 @@ func NewCommand(logger log.Logger) *cobra.Command {
 		controlPlaneReachable     = true
 		tunnelServiceType         = "NodePort"
 		enableRegister            = false
+		registerRequireApproval   = false
 	)
 
 	cmd := &cobra.Command{
@@ -81,9 +82,10 @@ func NewCommand(logger log.Logger) *cobra.Command {
 			if enableRegister {
 				kctl := kubectl.NewKubectl()
 				data, err := register.BuildInitRegister(register.BuildInitRegisterConfig{
-					Image:         vars.FerryRegisterImage,
-					ServiceType:   tunnelServiceType,
-					TunnelAddress: controlPlaneTunnelAddress,
+					Image:           vars.FerryRegisterImage,
+					ServiceType:     tunnelServiceType,
+					TunnelAddress:   controlPlaneTunnelAddress,
+					RequireApproval: registerRequireApproval,
 				})
 
 				err = kctl.ApplyWithReader(cmd.Context(), strings.NewReader(data))
@@ -99,5 +101,6 @@ func NewCommand(logger log.Logger) *cobra.Command {
 	flags.BoolVar(&controlPlaneReachable, "control-plane-reachable", controlPlaneReachable, "Whether the control plane is reachable")
 	flags.StringVar(&tunnelServiceType, "tunnel-service-type", tunnelServiceType, "Tunnel service type (LoadBalancer or NodePort)")
 	flags.BoolVar(&enableRegister, "enable-register", enableRegister, "Enable register")
+	flags.BoolVar(&registerRequireApproval, "register-require-approval", registerRequireApproval, "Hubs joined by the register stay pending until approved by the administrator")
 	return cmd
 }
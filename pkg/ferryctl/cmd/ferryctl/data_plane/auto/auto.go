@@ -17,7 +17,9 @@ limitations under the License.
 package auto
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/ferryproxy/ferry/pkg/ferryctl/data_plane"
 	"github.com/ferryproxy/ferry/pkg/ferryctl/log"
@@ -26,6 +28,8 @@ import (
 	"github.com/spf13/cobra"
 )
 
+const approvalPollInterval = 10 * time.Second
+
 func NewCommand(logger log.Logger) *cobra.Command {
 	var (
 		tunnelServiceType = "NodePort"
@@ -57,11 +61,18 @@ func NewCommand(logger log.Logger) *cobra.Command {
 			if isExist {
 				return fmt.Errorf("the name %s is already taken", name)
 			}
-			err = cli.Create(cmd.Context(), name)
-			if err != nil {
-				return err
+			for {
+				err = cli.Create(cmd.Context(), name)
+				if !errors.Is(err, client.ErrPendingApproval) {
+					return err
+				}
+				logger.Printf("Waiting for the administrator to approve hub %s with: ferryctl control-plane approve %s", name, name)
+				select {
+				case <-cmd.Context().Done():
+					return cmd.Context().Err()
+				case <-time.After(approvalPollInterval):
+				}
 			}
-			return nil
 		},
 	}
 	flags := cmd.Flags()
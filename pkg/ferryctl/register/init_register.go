@@ -23,9 +23,10 @@ import (
 )
 
 type BuildInitRegisterConfig struct {
-	Image         string
-	TunnelAddress string
-	ServiceType   string // LoadBalancer or NodePort
+	Image           string
+	TunnelAddress   string
+	ServiceType     string // LoadBalancer or NodePort
+	RequireApproval bool
 }
 
 func BuildInitRegister(conf BuildInitRegisterConfig) (string, error) {
@@ -0,0 +1,54 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"strings"
+
+	"github.com/ferryproxy/ferry/pkg/consts"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// AllowedImportHubs returns the hubs that the service can be imported into, nil if all hubs are allowed
+func AllowedImportHubs(svc *corev1.Service) []string {
+	v := svc.Annotations[consts.AnnotationAllowedImportHubsKey]
+	if v == "" {
+		return nil
+	}
+	hubs := []string{}
+	for _, hub := range strings.Split(v, ",") {
+		hub = strings.TrimSpace(hub)
+		if hub != "" {
+			hubs = append(hubs, hub)
+		}
+	}
+	return hubs
+}
+
+// ImportAllowed returns true if the service of the export hub can be imported into the hub
+func ImportAllowed(svc *corev1.Service, importHubName string) bool {
+	hubs := AllowedImportHubs(svc)
+	if hubs == nil {
+		return true
+	}
+	for _, hub := range hubs {
+		if hub == importHubName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"testing"
+
+	"github.com/ferryproxy/ferry/pkg/consts"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestImportAllowed(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		hub         string
+		want        bool
+	}{
+		{
+			name: "no annotation",
+			hub:  "cluster-1",
+			want: true,
+		},
+		{
+			name: "allowed",
+			annotations: map[string]string{
+				consts.AnnotationAllowedImportHubsKey: "cluster-1, cluster-2",
+			},
+			hub:  "cluster-2",
+			want: true,
+		},
+		{
+			name: "denied",
+			annotations: map[string]string{
+				consts.AnnotationAllowedImportHubsKey: "cluster-1,cluster-2",
+			},
+			hub:  "cluster-3",
+			want: false,
+		},
+		{
+			name: "deny all",
+			annotations: map[string]string{
+				consts.AnnotationAllowedImportHubsKey: ",",
+			},
+			hub:  "cluster-1",
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: tt.annotations,
+				},
+			}
+			if got := ImportAllowed(svc, tt.hub); got != tt.want {
+				t.Errorf("ImportAllowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
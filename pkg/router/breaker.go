@@ -0,0 +1,70 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
+	"github.com/ferryproxy/ferry/pkg/consts"
+)
+
+// CircuitBreaker fails the connections of the route fast at the import side, it is enforced by the tunnel
+type CircuitBreaker struct {
+	MaxPendingDials     int64  `json:"maxPendingDials,omitempty"`
+	ConsecutiveFailures int64  `json:"consecutiveFailures,omitempty"`
+	EjectionTime        string `json:"ejectionTime,omitempty"`
+}
+
+// RouteCircuitBreaker returns the circuit breaker declared by the annotations of the route, nil if there is no breaker
+func RouteCircuitBreaker(route *trafficv1alpha2.Route) (*CircuitBreaker, error) {
+	breaker := &CircuitBreaker{}
+	var err error
+	breaker.MaxPendingDials, err = parseCount(route.Annotations, consts.AnnotationMaxPendingDialsKey)
+	if err != nil {
+		return nil, err
+	}
+	breaker.ConsecutiveFailures, err = parseCount(route.Annotations, consts.AnnotationConsecutiveFailuresKey)
+	if err != nil {
+		return nil, err
+	}
+	if v := route.Annotations[consts.AnnotationEjectionTimeKey]; v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid %s %q", consts.AnnotationEjectionTimeKey, v)
+		}
+		breaker.EjectionTime = v
+	}
+	if breaker.MaxPendingDials == 0 && breaker.ConsecutiveFailures == 0 {
+		return nil, nil
+	}
+	return breaker, nil
+}
+
+func parseCount(annotations map[string]string, key string) (int64, error) {
+	v := annotations[key]
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", key, v)
+	}
+	return n, nil
+}
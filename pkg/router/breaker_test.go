@@ -0,0 +1,98 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"reflect"
+	"testing"
+
+	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
+	"github.com/ferryproxy/ferry/pkg/consts"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRouteCircuitBreaker(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        *CircuitBreaker
+		wantErr     bool
+	}{
+		{
+			name: "no breaker",
+		},
+		{
+			name: "ejection time only",
+			annotations: map[string]string{
+				consts.AnnotationEjectionTimeKey: "10s",
+			},
+		},
+		{
+			name: "consecutive failures",
+			annotations: map[string]string{
+				consts.AnnotationConsecutiveFailuresKey: "5",
+				consts.AnnotationEjectionTimeKey:        "1m",
+			},
+			want: &CircuitBreaker{
+				ConsecutiveFailures: 5,
+				EjectionTime:        "1m",
+			},
+		},
+		{
+			name: "max pending dials",
+			annotations: map[string]string{
+				consts.AnnotationMaxPendingDialsKey: "100",
+			},
+			want: &CircuitBreaker{
+				MaxPendingDials: 100,
+			},
+		},
+		{
+			name: "invalid ejection time",
+			annotations: map[string]string{
+				consts.AnnotationConsecutiveFailuresKey: "5",
+				consts.AnnotationEjectionTimeKey:        "soon",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid max pending dials",
+			annotations: map[string]string{
+				consts.AnnotationMaxPendingDialsKey: "-1",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := &trafficv1alpha2.Route{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "route",
+					Namespace:   "ferry-system",
+					Annotations: tt.annotations,
+				},
+			}
+			got, err := RouteCircuitBreaker(route)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RouteCircuitBreaker() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RouteCircuitBreaker() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"net"
+	"sort"
+
+	"github.com/ferryproxy/ferry/pkg/consts"
+	"github.com/ferryproxy/ferry/pkg/utils/maps"
+	"github.com/ferryproxy/ferry/pkg/utils/objref"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	endpointSliceManagedByValue  = "ferry-tunnel.ferryproxy.io"
+	annotationTopologyAwareHints = "service.kubernetes.io/topology-aware-hints"
+)
+
+// Endpoint is the address of the tunnel
+type Endpoint struct {
+	IP       string
+	NodeName string
+	Zone     string
+}
+
+// BuildServiceDiscoveryWithEndpointSlices the Egress Discovery resource with EndpointSlices instead of Endpoints,
+// there is one EndpointSlice for each export hub that labelled with the hub name, and the endpoints of the hubs in notReady are not ready.
+func BuildServiceDiscoveryWithEndpointSlices(om metav1.ObjectMeta, endpoints []Endpoint, exportPorts map[string][]MappingPort, notReady map[string]bool) []objref.KMetadata {
+	svc := corev1.Service{
+		ObjectMeta: om,
+	}
+
+	hints := topologyHints(endpoints)
+	if hints {
+		svc.Annotations = maps.Merge(svc.Annotations, map[string]string{
+			annotationTopologyAwareHints: "auto",
+		})
+	}
+
+	type pair struct {
+		Name     string
+		Protocol string
+	}
+	uniq := map[pair]struct{}{}
+	resources := []objref.KMetadata{&svc}
+	for _, hub := range sortedHubs(exportPorts) {
+		ports := exportPorts[hub]
+		if len(ports) == 0 {
+			continue
+		}
+		resources = append(resources, buildEndpointSlices(om, endpoints, hub, ports, !notReady[hub], hints)...)
+
+		for _, port := range ports {
+			key := pair{
+				Name:     port.Name,
+				Protocol: port.Protocol,
+			}
+			if _, ok := uniq[key]; ok {
+				continue
+			}
+			uniq[key] = struct{}{}
+			svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{
+				Name:     port.Name,
+				Protocol: corev1.Protocol(port.Protocol),
+				Port:     port.Port,
+			})
+		}
+	}
+	return resources
+}
+
+// BuildFallbackEndpointSlices the EndpointSlices of the tunnel that are added to the local service,
+// the local service itself is not managed by ferry.
+func BuildFallbackEndpointSlices(om metav1.ObjectMeta, endpoints []Endpoint, exportPorts map[string][]MappingPort) []objref.KMetadata {
+	hints := topologyHints(endpoints)
+	resources := []objref.KMetadata{}
+	for _, hub := range sortedHubs(exportPorts) {
+		ports := exportPorts[hub]
+		if len(ports) == 0 {
+			continue
+		}
+		resources = append(resources, buildEndpointSlices(om, endpoints, hub, ports, true, hints)...)
+	}
+	return resources
+}
+
+// topologyHints returns true if all endpoints have a zone, since the topology hints are only useful then
+func topologyHints(endpoints []Endpoint) bool {
+	if len(endpoints) == 0 {
+		return false
+	}
+	for _, ep := range endpoints {
+		if ep.Zone == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedHubs(exportPorts map[string][]MappingPort) []string {
+	hubs := make([]string, 0, len(exportPorts))
+	for hub := range exportPorts {
+		hubs = append(hubs, hub)
+	}
+	sort.Strings(hubs)
+	return hubs
+}
+
+// buildEndpointSlices returns the EndpointSlices of the export hub for each address type of the endpoints
+func buildEndpointSlices(om metav1.ObjectMeta, endpoints []Endpoint, hub string, ports []MappingPort, ready bool, hints bool) []objref.KMetadata {
+	type pair struct {
+		Name     string
+		Protocol string
+	}
+	slices := map[discoveryv1.AddressType]*discoveryv1.EndpointSlice{}
+	for _, ep := range endpoints {
+		addressType := addressTypeOf(ep.IP)
+		slice := slices[addressType]
+		if slice == nil {
+			slice = &discoveryv1.EndpointSlice{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      endpointSliceName(om.Name, hub, addressType),
+					Namespace: om.Namespace,
+					Labels: maps.Merge(om.Labels, map[string]string{
+						discoveryv1.LabelServiceName:     om.Name,
+						discoveryv1.LabelManagedBy:       endpointSliceManagedByValue,
+						consts.LabelFerryExportedFromKey: hub,
+					}),
+				},
+				AddressType: addressType,
+			}
+			slicePorts := map[pair]struct{}{}
+			for _, port := range ports {
+				key := pair{
+					Name:     port.Name,
+					Protocol: port.Protocol,
+				}
+				if _, ok := slicePorts[key]; ok {
+					continue
+				}
+				slicePorts[key] = struct{}{}
+				port := port
+				protocol := corev1.Protocol(port.Protocol)
+				slice.Ports = append(slice.Ports, discoveryv1.EndpointPort{
+					Name:     &port.Name,
+					Protocol: &protocol,
+					Port:     &port.TargetPort,
+				})
+			}
+			slices[addressType] = slice
+		}
+
+		ready := ready
+		endpoint := discoveryv1.Endpoint{
+			Addresses: []string{ep.IP},
+			Conditions: discoveryv1.EndpointConditions{
+				Ready: &ready,
+			},
+		}
+		if ep.NodeName != "" {
+			nodeName := ep.NodeName
+			endpoint.NodeName = &nodeName
+		}
+		if ep.Zone != "" {
+			zone := ep.Zone
+			endpoint.Zone = &zone
+			if hints {
+				endpoint.Hints = &discoveryv1.EndpointHints{
+					ForZones: []discoveryv1.ForZone{
+						{Name: zone},
+					},
+				}
+			}
+		}
+		slice.Endpoints = append(slice.Endpoints, endpoint)
+	}
+
+	resources := []objref.KMetadata{}
+	for _, addressType := range []discoveryv1.AddressType{discoveryv1.AddressTypeIPv4, discoveryv1.AddressTypeIPv6} {
+		if slice := slices[addressType]; slice != nil {
+			resources = append(resources, slice)
+		}
+	}
+	return resources
+}
+
+func endpointSliceName(name, hub string, addressType discoveryv1.AddressType) string {
+	if addressType == discoveryv1.AddressTypeIPv6 {
+		return name + "-" + hub + "-ipv6"
+	}
+	return name + "-" + hub
+}
+
+func addressTypeOf(ip string) discoveryv1.AddressType {
+	p := net.ParseIP(ip)
+	if p != nil && p.To4() == nil {
+		return discoveryv1.AddressTypeIPv6
+	}
+	return discoveryv1.AddressTypeIPv4
+}
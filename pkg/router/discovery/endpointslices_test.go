@@ -0,0 +1,165 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"testing"
+
+	"github.com/ferryproxy/ferry/pkg/consts"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuildServiceDiscoveryWithEndpointSlices(t *testing.T) {
+	om := metav1.ObjectMeta{
+		Name:      "svc",
+		Namespace: "default",
+	}
+	ports := []MappingPort{
+		{Name: "http", Protocol: "TCP", Port: 80, TargetPort: 10001},
+	}
+	tests := []struct {
+		name       string
+		endpoints  []Endpoint
+		notReady   map[string]bool
+		wantSlices map[string]string
+		wantHints  bool
+	}{
+		{
+			name: "with zones",
+			endpoints: []Endpoint{
+				{IP: "10.0.0.1", Zone: "zone-a"},
+				{IP: "10.0.0.2", Zone: "zone-b"},
+			},
+			wantSlices: map[string]string{
+				"svc-cluster-1": "cluster-1",
+				"svc-cluster-2": "cluster-2",
+			},
+			wantHints: true,
+		},
+		{
+			name: "without zones",
+			endpoints: []Endpoint{
+				{IP: "10.0.0.1", Zone: "zone-a"},
+				{IP: "fd00::1"},
+			},
+			wantSlices: map[string]string{
+				"svc-cluster-1":      "cluster-1",
+				"svc-cluster-1-ipv6": "cluster-1",
+				"svc-cluster-2":      "cluster-2",
+				"svc-cluster-2-ipv6": "cluster-2",
+			},
+			wantHints: false,
+		},
+		{
+			name: "not ready hub",
+			endpoints: []Endpoint{
+				{IP: "10.0.0.1"},
+			},
+			notReady: map[string]bool{
+				"cluster-2": true,
+			},
+			wantSlices: map[string]string{
+				"svc-cluster-1": "cluster-1",
+				"svc-cluster-2": "cluster-2",
+			},
+			wantHints: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BuildServiceDiscoveryWithEndpointSlices(om, tt.endpoints, map[string][]MappingPort{
+				"cluster-1": ports,
+				"cluster-2": ports,
+			}, tt.notReady)
+
+			svc, ok := got[0].(*corev1.Service)
+			if !ok {
+				t.Fatalf("first resource is not service: %T", got[0])
+			}
+			if len(svc.Spec.Ports) != 1 {
+				t.Errorf("service ports = %v, want 1 port", svc.Spec.Ports)
+			}
+			if hints := svc.Annotations[annotationTopologyAwareHints] != ""; hints != tt.wantHints {
+				t.Errorf("service hints = %v, want %v", hints, tt.wantHints)
+			}
+
+			slices := map[string]string{}
+			for _, r := range got[1:] {
+				slice, ok := r.(*discoveryv1.EndpointSlice)
+				if !ok {
+					t.Fatalf("resource is not endpointSlice: %T", r)
+				}
+				slices[slice.Name] = slice.Labels[consts.LabelFerryExportedFromKey]
+				hub := slice.Labels[consts.LabelFerryExportedFromKey]
+				for _, ep := range slice.Endpoints {
+					if ready := ep.Conditions.Ready != nil && *ep.Conditions.Ready; ready == tt.notReady[hub] {
+						t.Errorf("endpoint %v ready = %v, want %v", ep.Addresses, ready, !tt.notReady[hub])
+					}
+					if (ep.Hints != nil) != tt.wantHints {
+						t.Errorf("endpoint %v hints = %v, want %v", ep.Addresses, ep.Hints, tt.wantHints)
+					}
+				}
+			}
+			if len(slices) != len(tt.wantSlices) {
+				t.Fatalf("slices = %v, want %v", slices, tt.wantSlices)
+			}
+			for name, hub := range tt.wantSlices {
+				if slices[name] != hub {
+					t.Errorf("slice %s hub = %q, want %q", name, slices[name], hub)
+				}
+			}
+		})
+	}
+}
+
+func TestBuildFallbackEndpointSlices(t *testing.T) {
+	om := metav1.ObjectMeta{
+		Name:      "svc",
+		Namespace: "default",
+	}
+	ports := []MappingPort{
+		{Name: "http", Protocol: "TCP", Port: 80, TargetPort: 10001},
+	}
+	got := BuildFallbackEndpointSlices(om, []Endpoint{{IP: "10.0.0.1"}, {IP: "fd00::1"}}, map[string][]MappingPort{
+		"cluster-1": ports,
+		"cluster-2": nil,
+	})
+	want := []string{"svc-cluster-1", "svc-cluster-1-ipv6"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d resources, want %d", len(got), len(want))
+	}
+	for i, r := range got {
+		slice, ok := r.(*discoveryv1.EndpointSlice)
+		if !ok {
+			t.Fatalf("resource is not endpointSlice: %T", r)
+		}
+		if slice.Name != want[i] {
+			t.Errorf("slice %d name = %q, want %q", i, slice.Name, want[i])
+		}
+		for _, ep := range slice.Endpoints {
+			if ep.Conditions.Ready == nil || !*ep.Conditions.Ready {
+				t.Errorf("endpoint %v is not ready", ep.Addresses)
+			}
+		}
+	}
+
+	if got := BuildFallbackEndpointSlices(om, []Endpoint{{IP: "10.0.0.1"}}, nil); len(got) != 0 {
+		t.Errorf("got %d resources without exports, want 0", len(got))
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"sort"
+
+	"github.com/ferryproxy/ferry/pkg/utils/maps"
+	"github.com/ferryproxy/ferry/pkg/utils/objref"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PodAddress is the address of a pod of the headless service,
+// the IP is the cluster IP of the service that proxies to the pod through the tunnel
+type PodAddress struct {
+	Hostname string
+	IP       string
+	// NotReady is true if the export hub of the pod has no ready endpoints
+	NotReady bool
+}
+
+// BuildHeadlessServiceDiscovery the Egress Discovery resource for the headless service,
+// each pod is resolvable by its hostname, the pods that are not ready are listed as not ready
+func BuildHeadlessServiceDiscovery(om metav1.ObjectMeta, pods []PodAddress, ports []MappingPort, endpointSlice bool) []objref.KMetadata {
+	svc := corev1.Service{
+		ObjectMeta: om,
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+		},
+	}
+
+	pods = append([]PodAddress{}, pods...)
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].Hostname < pods[j].Hostname
+	})
+
+	for _, port := range ports {
+		svc.Spec.Ports = append(svc.Spec.Ports, corev1.ServicePort{
+			Name:     port.Name,
+			Protocol: corev1.Protocol(port.Protocol),
+			Port:     port.Port,
+		})
+	}
+
+	if endpointSlice {
+		slice := discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      om.Name,
+				Namespace: om.Namespace,
+				Labels: maps.Merge(om.Labels, map[string]string{
+					discoveryv1.LabelServiceName: om.Name,
+					discoveryv1.LabelManagedBy:   endpointSliceManagedByValue,
+				}),
+			},
+			AddressType: discoveryv1.AddressTypeIPv4,
+		}
+		for _, port := range ports {
+			port := port
+			protocol := corev1.Protocol(port.Protocol)
+			slice.Ports = append(slice.Ports, discoveryv1.EndpointPort{
+				Name:     &port.Name,
+				Protocol: &protocol,
+				Port:     &port.Port,
+			})
+		}
+		for _, pod := range pods {
+			pod := pod
+			ready := !pod.NotReady
+			slice.Endpoints = append(slice.Endpoints, discoveryv1.Endpoint{
+				Addresses: []string{pod.IP},
+				Hostname:  &pod.Hostname,
+				Conditions: discoveryv1.EndpointConditions{
+					Ready: &ready,
+				},
+			})
+		}
+		if len(pods) != 0 {
+			slice.AddressType = addressTypeOf(pods[0].IP)
+		}
+		return []objref.KMetadata{&svc, &slice}
+	}
+
+	ep := corev1.Endpoints{
+		ObjectMeta: om,
+	}
+	if len(pods) != 0 {
+		es := corev1.EndpointSubset{}
+		for _, pod := range pods {
+			address := corev1.EndpointAddress{
+				IP:       pod.IP,
+				Hostname: pod.Hostname,
+			}
+			if pod.NotReady {
+				es.NotReadyAddresses = append(es.NotReadyAddresses, address)
+			} else {
+				es.Addresses = append(es.Addresses, address)
+			}
+		}
+		for _, port := range ports {
+			es.Ports = append(es.Ports, corev1.EndpointPort{
+				Name:     port.Name,
+				Protocol: corev1.Protocol(port.Protocol),
+				Port:     port.Port,
+			})
+		}
+		ep.Subsets = append(ep.Subsets, es)
+	}
+	return []objref.KMetadata{&svc, &ep}
+}
+
+// PodServiceName is the name of the service that proxies to the pod of the headless service
+func PodServiceName(name, hostname string) string {
+	return name + "-" + hostname
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestBuildHeadlessServiceDiscovery(t *testing.T) {
+	om := metav1.ObjectMeta{
+		Name:      "kafka",
+		Namespace: "default",
+	}
+	pods := []PodAddress{
+		{Hostname: "kafka-1", IP: "10.96.0.11"},
+		{Hostname: "kafka-0", IP: "10.96.0.10"},
+		{Hostname: "kafka-2", IP: "10.96.0.12", NotReady: true},
+	}
+	ports := []MappingPort{
+		{Name: "broker", Protocol: "TCP", Port: 9092, TargetPort: 9092},
+	}
+	tests := []struct {
+		name          string
+		endpointSlice bool
+	}{
+		{
+			name: "endpoints",
+		},
+		{
+			name:          "endpoint slice",
+			endpointSlice: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := BuildHeadlessServiceDiscovery(om, pods, ports, tt.endpointSlice)
+			if len(got) != 2 {
+				t.Fatalf("BuildHeadlessServiceDiscovery() got %d resources, want 2", len(got))
+			}
+			svc := got[0].(*corev1.Service)
+			if svc.Spec.ClusterIP != corev1.ClusterIPNone {
+				t.Errorf("service cluster IP = %q, want None", svc.Spec.ClusterIP)
+			}
+
+			var hostnames, ips, notReady []string
+			switch ep := got[1].(type) {
+			case *corev1.Endpoints:
+				for _, address := range ep.Subsets[0].Addresses {
+					hostnames = append(hostnames, address.Hostname)
+					ips = append(ips, address.IP)
+				}
+				for _, address := range ep.Subsets[0].NotReadyAddresses {
+					notReady = append(notReady, address.Hostname)
+				}
+			case *discoveryv1.EndpointSlice:
+				for _, endpoint := range ep.Endpoints {
+					if !*endpoint.Conditions.Ready {
+						notReady = append(notReady, *endpoint.Hostname)
+						continue
+					}
+					hostnames = append(hostnames, *endpoint.Hostname)
+					ips = append(ips, endpoint.Addresses...)
+				}
+			}
+			if len(hostnames) != 2 || hostnames[0] != "kafka-0" || hostnames[1] != "kafka-1" {
+				t.Errorf("hostnames = %v, want [kafka-0 kafka-1]", hostnames)
+			}
+			if len(ips) != 2 || ips[0] != "10.96.0.10" || ips[1] != "10.96.0.11" {
+				t.Errorf("ips = %v, want [10.96.0.10 10.96.0.11]", ips)
+			}
+			if len(notReady) != 1 || notReady[0] != "kafka-2" {
+				t.Errorf("not ready hostnames = %v, want [kafka-2]", notReady)
+			}
+		})
+	}
+}
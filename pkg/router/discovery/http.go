@@ -0,0 +1,41 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+// HTTPRule routes the HTTP requests that match the host, path prefix and headers to the selected exports
+type HTTPRule struct {
+	// Name is the name of the rule in the metrics, the index of the rule if empty
+	Name       string            `json:"name,omitempty"`
+	Host       string            `json:"host,omitempty"`
+	PathPrefix string            `json:"pathPrefix,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	// HubName selects the exports of the hub, any hub if empty
+	HubName string `json:"hubName,omitempty"`
+	// Service selects the exports of the service, "namespace/name", any service if empty
+	Service string `json:"service,omitempty"`
+}
+
+// Selects returns true if the rule selects the export
+func (r HTTPRule) Selects(s Service) bool {
+	if r.HubName != "" && r.HubName != s.ExportHubName {
+		return false
+	}
+	if r.Service != "" && r.Service != s.ExportServiceNamespace+"/"+s.ExportServiceName {
+		return false
+	}
+	return true
+}
@@ -18,14 +18,17 @@ package discovery
 
 import (
 	"encoding/json"
+	"strconv"
+	"strings"
 
 	"github.com/ferryproxy/ferry/pkg/utils/objref"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-// BuildServiceDiscovery the Egress Discovery resource, perhaps Service or DNS
-func BuildServiceDiscovery(om metav1.ObjectMeta, ips []string, mappingPorts map[string][]MappingPort) []objref.KMetadata {
+// BuildServiceDiscovery the Egress Discovery resource, perhaps Service or DNS,
+// the addresses of the exports in notReady are not ready.
+func BuildServiceDiscovery(om metav1.ObjectMeta, ips []string, mappingPorts map[string][]MappingPort, notReady map[string]bool) []objref.KMetadata {
 	svc := corev1.Service{
 		ObjectMeta: om,
 	}
@@ -39,9 +42,12 @@ func BuildServiceDiscovery(om metav1.ObjectMeta, ips []string, mappingPorts map[
 	}
 	uniq := map[pair]struct{}{}
 	addresses := buildIPToEndpointAddress(ips)
-	for _, ports := range mappingPorts {
-		es := corev1.EndpointSubset{
-			Addresses: addresses,
+	for export, ports := range mappingPorts {
+		es := corev1.EndpointSubset{}
+		if notReady[export] {
+			es.NotReadyAddresses = addresses
+		} else {
+			es.Addresses = addresses
 		}
 		for _, port := range ports {
 			es.Ports = append(es.Ports, corev1.EndpointPort{
@@ -84,10 +90,32 @@ func buildIPToEndpointAddress(ips []string) []corev1.EndpointAddress {
 }
 
 type MappingPort struct {
-	Name       string `json:"name,omitempty"`
-	Protocol   string `json:"protocol,omitempty"`
-	Port       int32  `json:"port,omitempty"`
-	TargetPort int32  `json:"targetPort,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Protocol    string `json:"protocol,omitempty"`
+	AppProtocol string `json:"appProtocol,omitempty"`
+	Port        int32  `json:"port,omitempty"`
+	TargetPort  int32  `json:"targetPort,omitempty"`
+	// ExportPort is the port of the export service, only set if it differs from the Port
+	ExportPort int32 `json:"exportPort,omitempty"`
+	// SplitPort is the port of the import hub that splits the import between the export hubs by the weight or the HTTP rules
+	SplitPort int32 `json:"splitPort,omitempty"`
+}
+
+// IsHTTP returns true if the port serves HTTP/1.x, by the name "http" or "http-*" or the appProtocol "http" or "kubernetes.io/ws"
+func (p MappingPort) IsHTTP() bool {
+	switch strings.ToLower(p.AppProtocol) {
+	case "http", "kubernetes.io/ws":
+		return true
+	case "":
+		return p.Name == "http" || strings.HasPrefix(p.Name, "http-")
+	}
+	return false
+}
+
+// MappingPod is the ports of a pod of the headless service
+type MappingPod struct {
+	Hostname string        `json:"hostname,omitempty"`
+	Ports    []MappingPort `json:"ports,omitempty"`
 }
 
 type Service struct {
@@ -97,6 +125,21 @@ type Service struct {
 	ImportServiceName      string
 	ImportServiceNamespace string
 	Ports                  []MappingPort
+	// Headless is true if the export service is headless, and the Pods is the ready pods of it
+	Headless bool
+	Pods     []MappingPod
+	// Weight is the weight of the export hub in the traffic split, only used if the ports have the SplitPort
+	Weight int
+	// Fallback is true if the import is the fallback of the local service of the same name,
+	// the export with the lowest Priority is used only if the local service has no ready endpoints
+	Fallback bool
+	Priority int
+	// HTTPRules routes the HTTP requests of the import, only used if the ports have the SplitPort
+	HTTPRules []HTTPRule
+	// ServerNames routes the TLS connections of the import by SNI, only used if the ports have the SplitPort
+	ServerNames []string
+	// NotReady is true if the export service has no ready endpoints, so the import is not ready either
+	NotReady bool
 }
 
 func ServiceFrom(m map[string]string) (Service, error) {
@@ -105,6 +148,36 @@ func ServiceFrom(m map[string]string) (Service, error) {
 	if err != nil {
 		return s, err
 	}
+	if pods := m["pods"]; pods != "" {
+		err = json.Unmarshal([]byte(pods), &s.Pods)
+		if err != nil {
+			return s, err
+		}
+	}
+	s.Headless = m["headless"] == "true"
+	if rules := m["http_rules"]; rules != "" {
+		err = json.Unmarshal([]byte(rules), &s.HTTPRules)
+		if err != nil {
+			return s, err
+		}
+	}
+	if names := m["server_names"]; names != "" {
+		s.ServerNames = strings.Split(names, ",")
+	}
+	s.NotReady = m["not_ready"] == "true"
+	s.Fallback = m["fallback"] == "true"
+	if priority := m["priority"]; priority != "" {
+		s.Priority, err = strconv.Atoi(priority)
+		if err != nil {
+			return s, err
+		}
+	}
+	if weight := m["weight"]; weight != "" {
+		s.Weight, err = strconv.Atoi(weight)
+		if err != nil {
+			return s, err
+		}
+	}
 	s.ExportHubName = m["export_hub_name"]
 	s.ExportServiceName = m["export_service_name"]
 	s.ExportServiceNamespace = m["export_service_namespace"]
@@ -125,5 +198,35 @@ func (s Service) ToMap() (map[string]string, error) {
 		"import_service_namespace": s.ImportServiceNamespace,
 		"ports":                    string(portData),
 	}
+	if s.Headless {
+		out["headless"] = "true"
+	}
+	if s.Weight != 0 {
+		out["weight"] = strconv.Itoa(s.Weight)
+	}
+	if len(s.HTTPRules) != 0 {
+		rulesData, err := json.Marshal(s.HTTPRules)
+		if err != nil {
+			return nil, err
+		}
+		out["http_rules"] = string(rulesData)
+	}
+	if len(s.ServerNames) != 0 {
+		out["server_names"] = strings.Join(s.ServerNames, ",")
+	}
+	if s.NotReady {
+		out["not_ready"] = "true"
+	}
+	if s.Fallback {
+		out["fallback"] = "true"
+		out["priority"] = strconv.Itoa(s.Priority)
+	}
+	if len(s.Pods) != 0 {
+		podData, err := json.Marshal(s.Pods)
+		if err != nil {
+			return nil, err
+		}
+		out["pods"] = string(podData)
+	}
 	return out, nil
 }
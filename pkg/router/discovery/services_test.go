@@ -0,0 +1,42 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package discovery
+
+import (
+	"testing"
+)
+
+func TestMappingPortIsHTTP(t *testing.T) {
+	tests := []struct {
+		port MappingPort
+		want bool
+	}{
+		{port: MappingPort{Name: "http"}, want: true},
+		{port: MappingPort{Name: "http-web"}, want: true},
+		{port: MappingPort{Name: "https"}, want: false},
+		{port: MappingPort{Name: "grpc"}, want: false},
+		{port: MappingPort{Name: "web", AppProtocol: "http"}, want: true},
+		{port: MappingPort{Name: "web", AppProtocol: "kubernetes.io/ws"}, want: true},
+		{port: MappingPort{Name: "http", AppProtocol: "kubernetes.io/h2c"}, want: false},
+		{port: MappingPort{}, want: false},
+	}
+	for _, tt := range tests {
+		if got := tt.port.IsHTTP(); got != tt.want {
+			t.Errorf("IsHTTP(%+v) = %v, want %v", tt.port, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"strings"
+
+	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
+	"github.com/ferryproxy/ferry/pkg/consts"
+)
+
+// RouteFallback returns true if the route is the fallback of the local service,
+// and the priority of its export hub, lower is preferred
+func RouteFallback(route *trafficv1alpha2.Route) (fallback bool, priority int) {
+	v := route.Annotations[consts.AnnotationFallbackKey]
+	if v == "" {
+		return false, 0
+	}
+	hubs := strings.Split(v, ",")
+	for i, hub := range hubs {
+		if strings.TrimSpace(hub) == route.Spec.Export.HubName {
+			return true, i
+		}
+	}
+	return true, len(hubs)
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"testing"
+
+	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
+	"github.com/ferryproxy/ferry/pkg/consts"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRouteFallback(t *testing.T) {
+	tests := []struct {
+		name         string
+		annotations  map[string]string
+		wantFallback bool
+		wantPriority int
+	}{
+		{
+			name: "no fallback",
+		},
+		{
+			name: "first",
+			annotations: map[string]string{
+				consts.AnnotationFallbackKey: "hub-a,hub-b",
+			},
+			wantFallback: true,
+			wantPriority: 0,
+		},
+		{
+			name: "second",
+			annotations: map[string]string{
+				consts.AnnotationFallbackKey: "hub-b, hub-a",
+			},
+			wantFallback: true,
+			wantPriority: 1,
+		},
+		{
+			name: "not listed",
+			annotations: map[string]string{
+				consts.AnnotationFallbackKey: "hub-b,hub-c",
+			},
+			wantFallback: true,
+			wantPriority: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := &trafficv1alpha2.Route{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: tt.annotations,
+				},
+				Spec: trafficv1alpha2.RouteSpec{
+					Export: trafficv1alpha2.RouteSpecRule{
+						HubName: "hub-a",
+					},
+				},
+			}
+			fallback, priority := RouteFallback(route)
+			if fallback != tt.wantFallback || priority != tt.wantPriority {
+				t.Errorf("RouteFallback() = %v, %v, want %v, %v", fallback, priority, tt.wantFallback, tt.wantPriority)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// EndpointsReady returns false if the export service is known to have no ready endpoints,
+// the service without the endpoints is considered ready since the endpoints may not be synced yet,
+// and the ExternalName service has no endpoints at all.
+func EndpointsReady(svc *corev1.Service, ep *corev1.Endpoints, ok bool) bool {
+	if svc.Spec.Type == corev1.ServiceTypeExternalName || !ok {
+		return true
+	}
+	for _, subset := range ep.Subsets {
+		if len(subset.Addresses) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// exportReady returns false if the export service has no ready endpoints
+func (d *Router) exportReady(svc *corev1.Service) bool {
+	ep, ok := d.hubInterface.GetEndpoints(d.exportHubName, svc.Namespace, svc.Name)
+	return EndpointsReady(svc, ep, ok)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestEndpointsReady(t *testing.T) {
+	tests := []struct {
+		name string
+		svc  *corev1.Service
+		ep   *corev1.Endpoints
+		want bool
+	}{
+		{
+			name: "no endpoints",
+			svc:  &corev1.Service{},
+			want: true,
+		},
+		{
+			name: "external name",
+			svc: &corev1.Service{
+				Spec: corev1.ServiceSpec{
+					Type: corev1.ServiceTypeExternalName,
+				},
+			},
+			ep:   &corev1.Endpoints{},
+			want: true,
+		},
+		{
+			name: "ready",
+			svc:  &corev1.Service{},
+			ep: &corev1.Endpoints{
+				Subsets: []corev1.EndpointSubset{
+					{
+						Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}},
+					},
+				},
+			},
+			want: true,
+		},
+		{
+			name: "not ready",
+			svc:  &corev1.Service{},
+			ep: &corev1.Endpoints{
+				Subsets: []corev1.EndpointSubset{
+					{
+						NotReadyAddresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}},
+					},
+				},
+			},
+			want: false,
+		},
+		{
+			name: "empty",
+			svc:  &corev1.Service{},
+			ep:   &corev1.Endpoints{},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EndpointsReady(tt.svc, tt.ep, tt.ep != nil); got != tt.want {
+				t.Errorf("EndpointsReady() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
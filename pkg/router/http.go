@@ -0,0 +1,74 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
+	"github.com/ferryproxy/ferry/pkg/consts"
+	"github.com/ferryproxy/ferry/pkg/router/discovery"
+)
+
+// HTTPRoute routes the HTTP requests of the chain to the addresses of the first proxy by the rules
+type HTTPRoute struct {
+	Rules []HTTPRouteRule `json:"rules,omitempty"`
+	// Default is the indexes of the addresses for the requests that match no rule
+	Default []int `json:"default,omitempty"`
+}
+
+// HTTPRouteRule is the rule of the HTTPRoute
+type HTTPRouteRule struct {
+	Name       string            `json:"name"`
+	Host       string            `json:"host,omitempty"`
+	PathPrefix string            `json:"pathPrefix,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	// Backends is the indexes of the addresses that the requests are routed to
+	Backends []int `json:"backends"`
+}
+
+// RouteHTTPRules returns the HTTP rules declared by the annotations of the route, nil if the route is not HTTP
+func RouteHTTPRules(route *trafficv1alpha2.Route) ([]discovery.HTTPRule, error) {
+	v := route.Annotations[consts.AnnotationHTTPRulesKey]
+	if v == "" {
+		return nil, nil
+	}
+	return ParseHTTPRules(v)
+}
+
+// ParseHTTPRules parses the HTTP rules in JSON, e.g. `[{"pathPrefix":"/api/v2","hubName":"cluster-new"}]`
+func ParseHTTPRules(v string) ([]discovery.HTTPRule, error) {
+	var rules []discovery.HTTPRule
+	err := json.Unmarshal([]byte(v), &rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", consts.AnnotationHTTPRulesKey, err)
+	}
+	for i, rule := range rules {
+		if rule.Host == "" && rule.PathPrefix == "" && len(rule.Headers) == 0 {
+			return nil, fmt.Errorf("invalid %s: rule %d matches nothing", consts.AnnotationHTTPRulesKey, i)
+		}
+		if rule.PathPrefix != "" && !strings.HasPrefix(rule.PathPrefix, "/") {
+			return nil, fmt.Errorf("invalid %s: path prefix %q of rule %d is not absolute", consts.AnnotationHTTPRulesKey, rule.PathPrefix, i)
+		}
+		if rule.Service != "" && strings.Count(rule.Service, "/") != 1 {
+			return nil, fmt.Errorf("invalid %s: service %q of rule %d is not namespace/name", consts.AnnotationHTTPRulesKey, rule.Service, i)
+		}
+	}
+	return rules, nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"testing"
+)
+
+func TestParseHTTPRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		v       string
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "path prefix",
+			v:    `[{"pathPrefix":"/api/v2","hubName":"hub-b"}]`,
+			want: 1,
+		},
+		{
+			name: "host and headers",
+			v:    `[{"host":"example.com","service":"test/svc1"},{"headers":{"X-Canary":"true"}}]`,
+			want: 2,
+		},
+		{
+			name:    "not json",
+			v:       `/api/v2=hub-b`,
+			wantErr: true,
+		},
+		{
+			name:    "matches nothing",
+			v:       `[{"hubName":"hub-b"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "relative path",
+			v:       `[{"pathPrefix":"api"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "service without namespace",
+			v:       `[{"pathPrefix":"/api","service":"svc1"}]`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseHTTPRules(tt.v)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseHTTPRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("ParseHTTPRules() = %v, want %d rules", got, tt.want)
+			}
+		})
+	}
+}
@@ -19,6 +19,7 @@ package router
 import (
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/url"
 	"path"
 	"sort"
@@ -49,7 +50,12 @@ type HubsChain struct {
 }
 
 func (h *HubsChain) Build(name string, origin, destination objref.ObjectRef, originPort, peerPort int32, ways []string) (map[string]*Bound, error) {
-	hubsChains, err := h.buildRaw(name, origin, destination, originPort, peerPort, ways)
+	return h.BuildWithAddress(name, ServiceAddress(origin, originPort), destination, peerPort, ways)
+}
+
+// BuildWithAddress is like Build, but the origin is the address that the export hub proxies to
+func (h *HubsChain) BuildWithAddress(name string, originAddress string, destination objref.ObjectRef, peerPort int32, ways []string) (map[string]*Bound, error) {
+	hubsChains, err := h.buildRaw(name, originAddress, destination, peerPort, ways)
 	if err != nil {
 		return nil, err
 	}
@@ -129,12 +135,48 @@ func mergeContinuousReachableImport(m map[string][]*Chain, hubNames []string) {
 
 var identityFile = path.Join(consts.TunnelSshDir, consts.TunnelIdentityKeyName)
 
+const (
+	sshPrefix  = "ssh://"
+	unixPrefix = "unix://"
+	wsPrefix   = "ws://"
+	wssPrefix  = "wss://"
+	h2Prefix   = "h2://"
+	h2cPrefix  = "h2c://"
+	mtlsPrefix = "mtls://"
+	quicPrefix = "quic://"
+)
+
+// isHop returns true if the address is the hop to the gateway of a hub
+func isHop(address string) bool {
+	return strings.HasPrefix(address, sshPrefix) ||
+		strings.HasPrefix(address, wsPrefix) ||
+		strings.HasPrefix(address, wssPrefix) ||
+		strings.HasPrefix(address, h2Prefix) ||
+		strings.HasPrefix(address, h2cPrefix) ||
+		strings.HasPrefix(address, mtlsPrefix) ||
+		strings.HasPrefix(address, quicPrefix)
+}
+
+// gatewayHop returns the hop with the identity of the hub and the target hub of the hop,
+// the ssh is authenticated by the identity file, the mtls and the quic are authenticated by the certificate of the hub.
+func gatewayHop(name, hop string) (string, string, bool) {
+	switch {
+	case strings.HasPrefix(hop, sshPrefix):
+		uri, _ := url.Parse(hop)
+		query := uri.Query()
+		query.Set("identity_file", identityFile)
+		uri.RawQuery = query.Encode()
+		uri.User = url.User(name)
+		return strings.Replace(uri.String(), "%2F", "/", -1), query.Get("target_hub"), true
+	case strings.HasPrefix(hop, mtlsPrefix), strings.HasPrefix(hop, quicPrefix):
+		uri, _ := url.Parse(hop)
+		return hop, uri.Query().Get("server_name"), true
+	}
+	return "", "", false
+}
+
 func (h *HubsChain) modifyAuth(m map[string][]*Chain) map[string]*Bound {
 	bound := map[string]*Bound{}
-	const (
-		sshPrefix  = "ssh://"
-		unixPrefix = "unix://"
-	)
 	for name, chains := range m {
 		if bound[name] == nil {
 			bound[name] = &Bound{}
@@ -143,75 +185,114 @@ func (h *HubsChain) modifyAuth(m map[string][]*Chain) map[string]*Bound {
 
 		for _, chain := range chains {
 			for i, p := range chain.Proxy[1:] {
-				if strings.HasPrefix(p, sshPrefix) {
-					uri, _ := url.Parse(p)
-					query := uri.Query()
-
-					query.Set("identity_file", identityFile)
-					uri.RawQuery = query.Encode()
-					uri.User = url.User(name)
-					chain.Proxy[i+1] = strings.Replace(uri.String(), "%2F", "/", -1)
-
-					targetHub := query.Get("target_hub")
-					if bound[targetHub] == nil {
-						bound[targetHub] = &Bound{}
-					}
+				hop, targetHub, ok := gatewayHop(name, p)
+				if !ok {
+					continue
+				}
+				chain.Proxy[i+1] = hop
+				if bound[targetHub] == nil {
+					bound[targetHub] = &Bound{}
+				}
 
-					allowList := &AllowList{}
-					if i == 0 {
-						if !strings.Contains(chain.Proxy[i], "/") {
-							allowList.DirectTcpip.Allows = []string{chain.Proxy[i]}
-						} else if strings.HasPrefix(chain.Proxy[i], unixPrefix) {
-							next, _ := url.Parse(chain.Proxy[i])
-							allowList.DirectStreamlocal.Allows = []string{next.Path}
-						}
-					} else if strings.HasPrefix(chain.Proxy[i], sshPrefix) {
+				allowList := &AllowList{}
+				if i == 0 {
+					if !strings.Contains(chain.Proxy[i], "/") {
+						allowList.DirectTcpip.Allows = []string{chain.Proxy[i]}
+					} else if strings.HasPrefix(chain.Proxy[i], unixPrefix) {
 						next, _ := url.Parse(chain.Proxy[i])
-						allowList.DirectTcpip.Allows = []string{next.Host}
+						allowList.DirectStreamlocal.Allows = []string{next.Path}
 					}
-					if bound[targetHub].Inbound == nil {
-						bound[targetHub].Inbound = map[string]*AllowList{}
-					}
-					bound[targetHub].Inbound[name] = bound[targetHub].Inbound[name].Merge(allowList)
+				} else if isHop(chain.Proxy[i]) {
+					next, _ := url.Parse(chain.Proxy[i])
+					allowList.DirectTcpip.Allows = []string{next.Host}
+				}
+				if bound[targetHub].Inbound == nil {
+					bound[targetHub].Inbound = map[string]*AllowList{}
 				}
+				bound[targetHub].Inbound[name] = bound[targetHub].Inbound[name].Merge(allowList)
 			}
 			for i, b := range chain.Bind[1:] {
-				if strings.HasPrefix(b, sshPrefix) {
-					uri, _ := url.Parse(b)
-					query := uri.Query()
-					query.Set("identity_file", identityFile)
-					uri.RawQuery = query.Encode()
-					uri.User = url.User(name)
-					chain.Bind[i+1] = strings.Replace(uri.String(), "%2F", "/", -1)
-
-					targetHub := query.Get("target_hub")
-					if bound[targetHub] == nil {
-						bound[targetHub] = &Bound{}
-					}
+				hop, targetHub, ok := gatewayHop(name, b)
+				if !ok {
+					continue
+				}
+				chain.Bind[i+1] = hop
+				if bound[targetHub] == nil {
+					bound[targetHub] = &Bound{}
+				}
 
-					allowList := &AllowList{}
-					if i == 0 {
-						if !strings.Contains(chain.Bind[i], "/") {
-							allowList.TcpipForward.Allows = []string{chain.Bind[i]}
-						} else if strings.HasPrefix(chain.Bind[i], unixPrefix) {
-							next, _ := url.Parse(chain.Bind[i])
-							allowList.StreamlocalForward.Allows = []string{next.Path}
-						}
-					} else if strings.HasPrefix(chain.Bind[i], sshPrefix) {
+				allowList := &AllowList{}
+				if i == 0 {
+					if !strings.Contains(chain.Bind[i], "/") {
+						allowList.TcpipForward.Allows = []string{chain.Bind[i]}
+					} else if strings.HasPrefix(chain.Bind[i], unixPrefix) {
 						next, _ := url.Parse(chain.Bind[i])
-						allowList.DirectTcpip.Allows = []string{next.Host}
-					}
-					if bound[targetHub].Inbound == nil {
-						bound[targetHub].Inbound = map[string]*AllowList{}
+						allowList.StreamlocalForward.Allows = []string{next.Path}
 					}
-					bound[targetHub].Inbound[name] = bound[targetHub].Inbound[name].Merge(allowList)
+				} else if isHop(chain.Bind[i]) {
+					next, _ := url.Parse(chain.Bind[i])
+					allowList.DirectTcpip.Allows = []string{next.Host}
 				}
+				if bound[targetHub].Inbound == nil {
+					bound[targetHub].Inbound = map[string]*AllowList{}
+				}
+				bound[targetHub].Inbound[name] = bound[targetHub].Inbound[name].Merge(allowList)
 			}
 		}
 	}
 	return bound
 }
 
+func setMirror(bound map[string]*Bound, importBind string, mirror *Mirror) {
+	for name, b := range bound {
+		for _, chain := range b.Outbound {
+			if len(chain.Bind) == 0 || chain.Bind[0] != importBind {
+				continue
+			}
+			chain.Mirror = mirror
+			// The mirror is dialed through the first gateway of the bind, whatever its transport
+			for _, hop := range chain.Bind[1:] {
+				_, targetHub, ok := gatewayHop(name, hop)
+				if !ok {
+					continue
+				}
+				if bound[targetHub] == nil {
+					bound[targetHub] = &Bound{}
+				}
+				if bound[targetHub].Inbound == nil {
+					bound[targetHub].Inbound = map[string]*AllowList{}
+				}
+				allowList := &AllowList{}
+				allowList.DirectTcpip.Allows = []string{mirror.Address}
+				bound[targetHub].Inbound[name] = bound[targetHub].Inbound[name].Merge(allowList)
+				break
+			}
+		}
+	}
+}
+
+// setCircuitBreaker sets the circuit breaker on the chain that binds the import, so that it fails fast at the import side
+func setCircuitBreaker(bound map[string]*Bound, importBind string, breaker *CircuitBreaker) {
+	for _, b := range bound {
+		for _, chain := range b.Outbound {
+			if len(chain.Bind) != 0 && chain.Bind[0] == importBind {
+				chain.CircuitBreaker = breaker
+			}
+		}
+	}
+}
+
+// setTLSOrigination sets the TLS origination on the chain that dials the origin, which is in the export hub unless it is merged
+func setTLSOrigination(bound map[string]*Bound, originAddress string, tls *TLSOrigination) {
+	for _, b := range bound {
+		for _, chain := range b.Outbound {
+			if len(chain.Proxy) != 0 && chain.Proxy[0] == originAddress {
+				chain.TLS = tls
+			}
+		}
+	}
+}
+
 func mergeStrings(a, b []string) []string {
 	out := make([]string, 0, len(a)+len(b))
 	out = append(out, a...)
@@ -219,13 +300,13 @@ func mergeStrings(a, b []string) []string {
 	return out
 }
 
-func (h *HubsChain) buildRaw(name string, origin, destination objref.ObjectRef, originPort, peerPort int32, ways []string) (hubsChains map[string][]*Chain, err error) {
+func (h *HubsChain) buildRaw(name string, originAddress string, destination objref.ObjectRef, peerPort int32, ways []string) (hubsChains map[string][]*Chain, err error) {
 	hubsChains = map[string][]*Chain{}
 
 	if len(ways) == 1 {
 		hubName := ways[0]
 		hubChain, err := h.buildSelf(
-			name, origin, destination, originPort, peerPort,
+			name, originAddress, destination, peerPort,
 		)
 		if err != nil {
 			return nil, err
@@ -247,7 +328,7 @@ func (h *HubsChain) buildRaw(name string, origin, destination objref.ObjectRef,
 		importGateway := h.getHubGateway(importHubName, exportHubName)
 
 		exportHubChain, importHubChain, err := h.buildPeer(
-			name, origin, destination, originPort, peerPort,
+			name, originAddress, destination, peerPort,
 			exportHubName, exportRepeater, exportGateway,
 			importHubName, importRepeater, importGateway,
 		)
@@ -268,7 +349,7 @@ func (h *HubsChain) buildRaw(name string, origin, destination objref.ObjectRef,
 }
 
 func (h *HubsChain) buildSelf(
-	name string, origin, destination objref.ObjectRef, originPort, peerPort int32,
+	name string, originAddress string, destination objref.ObjectRef, peerPort int32,
 ) (hubChain *Chain, err error) {
 	chain := &Chain{
 		Bind:  []string{},
@@ -278,14 +359,13 @@ func (h *HubsChain) buildSelf(
 	destinationAddress := fmt.Sprintf(":%d", peerPort)
 	chain.Bind = append(chain.Bind, destinationAddress)
 
-	originSvc := fmt.Sprintf("%s.%s.svc:%d", origin.Name, origin.Namespace, originPort)
-	chain.Proxy = append(chain.Proxy, originSvc)
+	chain.Proxy = append(chain.Proxy, originAddress)
 
 	return chain, nil
 }
 
 func (h *HubsChain) buildPeer(
-	name string, origin, destination objref.ObjectRef, originPort, peerPort int32,
+	name string, originAddress string, destination objref.ObjectRef, peerPort int32,
 	exportHubName string, exportRepeater bool, exportGateway trafficv1alpha2.HubSpecGateway,
 	importHubName string, importRepeater bool, importGateway trafficv1alpha2.HubSpecGateway,
 ) (exportHubChain *Chain, importHubChain *Chain, err error) {
@@ -307,8 +387,7 @@ func (h *HubsChain) buildPeer(
 		unixSocks := unixSocksPath(name)
 		chain.Proxy = append(chain.Proxy, unixSocks)
 	} else {
-		originSvc := fmt.Sprintf("%s.%s.svc:%d", origin.Name, origin.Namespace, originPort)
-		chain.Proxy = append(chain.Proxy, originSvc)
+		chain.Proxy = append(chain.Proxy, originAddress)
 	}
 
 	if exportGateway.Reachable {
@@ -338,8 +417,7 @@ func (h *HubsChain) proxies(a []string, prev string, proxies []trafficv1alpha2.H
 	for _, r := range proxies {
 		if r.HubName != "" {
 			gw := h.getHubGateway(r.HubName, prev)
-			hubURI := sshURI(gw.Address, r.HubName)
-			a = append(a, hubURI)
+			a = append(a, gatewayURIs(gw.Address, r.HubName)...)
 			prev = r.HubName
 		} else if r.Proxy != "" {
 			a = append(a, r.Proxy)
@@ -348,6 +426,11 @@ func (h *HubsChain) proxies(a []string, prev string, proxies []trafficv1alpha2.H
 	return a
 }
 
+// ServiceAddress is the in-cluster DNS address of the service
+func ServiceAddress(origin objref.ObjectRef, port int32) string {
+	return fmt.Sprintf("%s.%s.svc:%d", origin.Name, origin.Namespace, port)
+}
+
 func unixSocksPath(name string) string {
 	return fmt.Sprintf("unix:///dev/shm/%s.socks", name)
 }
@@ -356,6 +439,43 @@ func sshURI(address string, target string) string {
 	return fmt.Sprintf("ssh://%s?target_hub=%s", address, target)
 }
 
+// gatewayURIs returns the hops to the gateway of the target hub, the scheme of the address selects the transport,
+// e.g. wss://example.com:443/ferry carries the ssh over the WebSocket, which verifies the hub by the CA of the control plane
+// if it has the dir of the certificates, e.g. wss://example.com:443/ferry?dir=/var/ferry/tls/, h2://example.com:443 carries the ssh
+// over the HTTP/2 CONNECT to the same gateway as the WebSocket, mtls://example.com:31444 and quic://example.com:31445
+// are the hops themselves which carry each connection over its own TLS connection or QUIC stream without the ssh,
+// the quic only works when the gateway is dialed directly.
+func gatewayURIs(address string, target string) []string {
+	uri, err := url.Parse(address)
+	if err != nil || uri.Host == "" {
+		return []string{sshURI(address, target)}
+	}
+	switch uri.Scheme {
+	case "ws", "wss", "h2", "h2c":
+		host := uri.Host
+		if uri.Port() == "" {
+			if uri.Scheme == "wss" || uri.Scheme == "h2" {
+				host = net.JoinHostPort(uri.Hostname(), "443")
+			} else {
+				host = net.JoinHostPort(uri.Hostname(), "80")
+			}
+		}
+		query := uri.Query()
+		if (uri.Scheme == "wss" || uri.Scheme == "h2") && query.Has("dir") && !query.Has("server_name") {
+			query.Set("server_name", target)
+			uri.RawQuery = query.Encode()
+			address = strings.Replace(uri.String(), "%2F", "/", -1)
+		}
+		return []string{sshURI(host, target), address}
+	case "mtls", "quic":
+		query := uri.Query()
+		query.Set("server_name", target)
+		uri.RawQuery = query.Encode()
+		return []string{uri.String()}
+	}
+	return []string{sshURI(address, target)}
+}
+
 func ConvertInboundToResourcers(name, namespace string, labels map[string]string, cs map[string]*Bound) (map[string][]objref.KMetadata, error) {
 	out := map[string][]objref.KMetadata{}
 
@@ -468,8 +588,23 @@ func convertOutboundToResourcer(name, namespace string, labels map[string]string
 }
 
 type Chain struct {
+	// Name is the name of the tunnel that the chain belongs to
+	Name  string   `json:"name,omitempty"`
 	Bind  []string `json:"bind"`
 	Proxy []string `json:"proxy"`
+	Limit *Limit   `json:"limit,omitempty"`
+	// Mirror is only set on the chain that binds the import
+	Mirror *Mirror `json:"mirror,omitempty"`
+	// CircuitBreaker is only set on the chain that binds the import
+	CircuitBreaker *CircuitBreaker `json:"circuitBreaker,omitempty"`
+	// Weights is only set on the chain that splits the import between the export hubs
+	Weights []int `json:"weights,omitempty"`
+	// HTTP is only set on the chain that routes the HTTP requests of the import
+	HTTP *HTTPRoute `json:"http,omitempty"`
+	// SNI is only set on the chain that routes the TLS connections of the import by the server names
+	SNI *SNIRoute `json:"sni,omitempty"`
+	// TLS is only set on the chain that dials the origin
+	TLS *TLSOrigination `json:"tls,omitempty"`
 }
 
 type AllowList struct {
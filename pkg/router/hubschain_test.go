@@ -1197,6 +1197,317 @@ func TestHubsChain_Build(t *testing.T) {
 
 		// 2 hubs with proxy (export, import)
 		// 0b0
+		{
+			name: "2 hubs 0b0 with websocket",
+			hubs: map[string]*trafficv1alpha2.Hub{
+				"export": {
+					Spec: trafficv1alpha2.HubSpec{
+						Gateway: trafficv1alpha2.HubSpecGateway{
+							Reachable: true,
+							Address:   "wss://export/ferry",
+						},
+					},
+				},
+				"import": {
+					Spec: trafficv1alpha2.HubSpec{
+						Gateway: trafficv1alpha2.HubSpecGateway{
+							NavigationProxy: []trafficv1alpha2.HubSpecGatewayProxy{
+								{
+									Proxy: "http://import-navigation:3128",
+								},
+							},
+						},
+					},
+				},
+			},
+			ways: []string{
+				"export",
+				"import",
+			},
+			wantBound: map[string]*Bound{
+				"export": {
+					Inbound: map[string]*AllowList{
+						"import": {
+							DirectTcpip: permissions.Permission{
+								Allows: []string{
+									"oname.ons.svc:80",
+								},
+							},
+						},
+					},
+				},
+				"import": {
+					Outbound: []*Chain{
+						{
+							Bind: []string{
+								":10000",
+							},
+							Proxy: []string{
+								"oname.ons.svc:80",
+								"ssh://import@export:443?identity_file=/var/ferry/ssh/identity&target_hub=export",
+								"wss://export/ferry",
+								"http://import-navigation:3128",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "2 hubs 0b0 with websocket verified by the ca",
+			hubs: map[string]*trafficv1alpha2.Hub{
+				"export": {
+					Spec: trafficv1alpha2.HubSpec{
+						Gateway: trafficv1alpha2.HubSpecGateway{
+							Reachable: true,
+							Address:   "wss://export/ferry?dir=/var/ferry/tls/",
+						},
+					},
+				},
+				"import": {
+					Spec: trafficv1alpha2.HubSpec{
+						Gateway: trafficv1alpha2.HubSpecGateway{
+							NavigationProxy: []trafficv1alpha2.HubSpecGatewayProxy{
+								{
+									Proxy: "http://import-navigation:3128",
+								},
+							},
+						},
+					},
+				},
+			},
+			ways: []string{
+				"export",
+				"import",
+			},
+			wantBound: map[string]*Bound{
+				"export": {
+					Inbound: map[string]*AllowList{
+						"import": {
+							DirectTcpip: permissions.Permission{
+								Allows: []string{
+									"oname.ons.svc:80",
+								},
+							},
+						},
+					},
+				},
+				"import": {
+					Outbound: []*Chain{
+						{
+							Bind: []string{
+								":10000",
+							},
+							Proxy: []string{
+								"oname.ons.svc:80",
+								"ssh://import@export:443?identity_file=/var/ferry/ssh/identity&target_hub=export",
+								"wss://export/ferry?dir=/var/ferry/tls/&server_name=export",
+								"http://import-navigation:3128",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "2 hubs 0b0 with http2 verified by the ca",
+			hubs: map[string]*trafficv1alpha2.Hub{
+				"export": {
+					Spec: trafficv1alpha2.HubSpec{
+						Gateway: trafficv1alpha2.HubSpecGateway{
+							Reachable: true,
+							Address:   "h2://export?dir=/var/ferry/tls/",
+						},
+					},
+				},
+				"import": {
+					Spec: trafficv1alpha2.HubSpec{
+						Gateway: trafficv1alpha2.HubSpecGateway{
+							NavigationProxy: []trafficv1alpha2.HubSpecGatewayProxy{
+								{
+									Proxy: "http://import-navigation:3128",
+								},
+							},
+						},
+					},
+				},
+			},
+			ways: []string{
+				"export",
+				"import",
+			},
+			wantBound: map[string]*Bound{
+				"export": {
+					Inbound: map[string]*AllowList{
+						"import": {
+							DirectTcpip: permissions.Permission{
+								Allows: []string{
+									"oname.ons.svc:80",
+								},
+							},
+						},
+					},
+				},
+				"import": {
+					Outbound: []*Chain{
+						{
+							Bind: []string{
+								":10000",
+							},
+							Proxy: []string{
+								"oname.ons.svc:80",
+								"ssh://import@export:443?identity_file=/var/ferry/ssh/identity&target_hub=export",
+								"h2://export?dir=/var/ferry/tls/&server_name=export",
+								"http://import-navigation:3128",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "2 hubs 0b0 with mtls",
+			hubs: map[string]*trafficv1alpha2.Hub{
+				"export": {
+					Spec: trafficv1alpha2.HubSpec{
+						Gateway: trafficv1alpha2.HubSpecGateway{
+							Reachable: true,
+							Address:   "mtls://export:31444",
+						},
+					},
+				},
+				"import": {
+					Spec: trafficv1alpha2.HubSpec{
+						Gateway: trafficv1alpha2.HubSpecGateway{
+							NavigationProxy: []trafficv1alpha2.HubSpecGatewayProxy{
+								{
+									Proxy: "http://import-navigation:3128",
+								},
+							},
+						},
+					},
+				},
+			},
+			ways: []string{
+				"export",
+				"import",
+			},
+			wantBound: map[string]*Bound{
+				"export": {
+					Inbound: map[string]*AllowList{
+						"import": {
+							DirectTcpip: permissions.Permission{
+								Allows: []string{
+									"oname.ons.svc:80",
+								},
+							},
+						},
+					},
+				},
+				"import": {
+					Outbound: []*Chain{
+						{
+							Bind: []string{
+								":10000",
+							},
+							Proxy: []string{
+								"oname.ons.svc:80",
+								"mtls://export:31444?server_name=export",
+								"http://import-navigation:3128",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "2 hubs 0b1 with mtls",
+			hubs: map[string]*trafficv1alpha2.Hub{
+				"export": {},
+				"import": {
+					Spec: trafficv1alpha2.HubSpec{
+						Gateway: trafficv1alpha2.HubSpecGateway{
+							Reachable: true,
+							Address:   "mtls://import:31444",
+						},
+					},
+				},
+			},
+			ways: []string{
+				"export",
+				"import",
+			},
+			wantBound: map[string]*Bound{
+				"export": {
+					Outbound: []*Chain{
+						{
+							Bind: []string{
+								":10000",
+								"mtls://import:31444?server_name=import",
+							},
+							Proxy: []string{
+								"oname.ons.svc:80",
+							},
+						},
+					},
+				},
+				"import": {
+					Inbound: map[string]*AllowList{
+						"export": {
+							TcpipForward: permissions.Permission{
+								Allows: []string{
+									":10000",
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "2 hubs 0b0 with quic",
+			hubs: map[string]*trafficv1alpha2.Hub{
+				"export": {
+					Spec: trafficv1alpha2.HubSpec{
+						Gateway: trafficv1alpha2.HubSpecGateway{
+							Reachable: true,
+							Address:   "quic://export:31445",
+						},
+					},
+				},
+				"import": {},
+			},
+			ways: []string{
+				"export",
+				"import",
+			},
+			wantBound: map[string]*Bound{
+				"export": {
+					Inbound: map[string]*AllowList{
+						"import": {
+							DirectTcpip: permissions.Permission{
+								Allows: []string{
+									"oname.ons.svc:80",
+								},
+							},
+						},
+					},
+				},
+				"import": {
+					Outbound: []*Chain{
+						{
+							Bind: []string{
+								":10000",
+							},
+							Proxy: []string{
+								"oname.ons.svc:80",
+								"quic://export:31445?server_name=export",
+							},
+						},
+					},
+				},
+			},
+		},
 		{
 			name: "2 hubs 0b0 with proxy",
 			hubs: map[string]*trafficv1alpha2.Hub{
@@ -0,0 +1,72 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"fmt"
+	"strconv"
+
+	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
+	"github.com/ferryproxy/ferry/pkg/consts"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// Limit is the limit of the bandwidth and the connections of the route, it is enforced by the tunnel
+type Limit struct {
+	Name           string `json:"name"`
+	BytesPerSecond int64  `json:"bytesPerSecond,omitempty"`
+	Burst          int64  `json:"burst,omitempty"`
+	MaxConnections int64  `json:"maxConnections,omitempty"`
+}
+
+// RouteLimit returns the limit declared by the annotations of the route, nil if there is no limit
+func RouteLimit(route *trafficv1alpha2.Route) (*Limit, error) {
+	limit := &Limit{
+		Name: route.Namespace + "/" + route.Name,
+	}
+	var err error
+	limit.BytesPerSecond, err = parseBytes(route.Annotations, consts.AnnotationBandwidthKey)
+	if err != nil {
+		return nil, err
+	}
+	limit.Burst, err = parseBytes(route.Annotations, consts.AnnotationBandwidthBurstKey)
+	if err != nil {
+		return nil, err
+	}
+	if v := route.Annotations[consts.AnnotationMaxConnectionsKey]; v != "" {
+		limit.MaxConnections, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || limit.MaxConnections < 0 {
+			return nil, fmt.Errorf("invalid %s %q", consts.AnnotationMaxConnectionsKey, v)
+		}
+	}
+	if limit.BytesPerSecond == 0 && limit.MaxConnections == 0 {
+		return nil, nil
+	}
+	return limit, nil
+}
+
+func parseBytes(annotations map[string]string, key string) (int64, error) {
+	v := annotations[key]
+	if v == "" {
+		return 0, nil
+	}
+	q, err := resource.ParseQuantity(v)
+	if err != nil || q.Sign() < 0 {
+		return 0, fmt.Errorf("invalid %s %q", key, v)
+	}
+	return q.Value(), nil
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"reflect"
+	"testing"
+
+	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
+	"github.com/ferryproxy/ferry/pkg/consts"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRouteLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        *Limit
+		wantErr     bool
+	}{
+		{
+			name: "no limit",
+		},
+		{
+			name: "bandwidth",
+			annotations: map[string]string{
+				consts.AnnotationBandwidthKey:      "1Mi",
+				consts.AnnotationBandwidthBurstKey: "64Ki",
+			},
+			want: &Limit{
+				Name:           "ferry-system/route",
+				BytesPerSecond: 1 << 20,
+				Burst:          64 << 10,
+			},
+		},
+		{
+			name: "max connections",
+			annotations: map[string]string{
+				consts.AnnotationMaxConnectionsKey: "100",
+			},
+			want: &Limit{
+				Name:           "ferry-system/route",
+				MaxConnections: 100,
+			},
+		},
+		{
+			name: "invalid bandwidth",
+			annotations: map[string]string{
+				consts.AnnotationBandwidthKey: "fast",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid max connections",
+			annotations: map[string]string{
+				consts.AnnotationMaxConnectionsKey: "-1",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := &trafficv1alpha2.Route{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "route",
+					Namespace:   "ferry-system",
+					Annotations: tt.annotations,
+				},
+			}
+			got, err := RouteLimit(route)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RouteLimit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RouteLimit() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
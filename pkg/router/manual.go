@@ -17,6 +17,8 @@ limitations under the License.
 package router
 
 import (
+	"fmt"
+
 	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
 	"github.com/ferryproxy/ferry/pkg/utils/objref"
 	corev1 "k8s.io/api/core/v1"
@@ -109,6 +111,14 @@ func (f *dateSource) ListServices(name string) []*corev1.Service {
 		svc,
 	}
 }
+func (f *dateSource) GetEndpoints(hubName string, namespace, name string) (*corev1.Endpoints, bool) {
+	return nil, false
+}
+
+func (f *dateSource) GetCABundle(hubName string, namespace, kind, name string) ([]byte, error) {
+	return nil, fmt.Errorf("%s %s/%s not found", kind, namespace, name)
+}
+
 func (f *dateSource) GetHubGateway(hubName string, forHub string) trafficv1alpha2.HubSpecGateway {
 	if hubName == f.importHubName {
 		return f.importGateway
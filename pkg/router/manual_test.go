@@ -125,6 +125,7 @@ func TestManualBuildResource(t *testing.T) {
 							consts.TunnelRulesKey: toJson(
 								[]Chain{
 									{
+										Name: "manual-tunnel-80-10000",
 										Bind: []string{
 											":10000",
 										},
@@ -176,6 +177,7 @@ func TestManualBuildResource(t *testing.T) {
 							consts.TunnelRulesKey: toJson(
 								[]Chain{
 									{
+										Name: "manual-tunnel-80-10000",
 										Bind: []string{
 											":10000",
 											"ssh://export-hub@import-address?identity_file=/var/ferry/ssh/identity&target_hub=import-hub",
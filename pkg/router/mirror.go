@@ -0,0 +1,65 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
+	"github.com/ferryproxy/ferry/pkg/consts"
+	"github.com/ferryproxy/ferry/pkg/utils/objref"
+)
+
+// Mirror duplicates the connections of the chain to the address, the responses of the mirror are discarded
+type Mirror struct {
+	Address string `json:"address"`
+	Percent int    `json:"percent"`
+}
+
+// RouteMirror returns the shadow import declared by the annotations of the route, nil if there is no mirror
+func RouteMirror(route *trafficv1alpha2.Route) (*objref.ObjectRef, int, error) {
+	v := route.Annotations[consts.AnnotationMirrorKey]
+	if v == "" {
+		return nil, 0, nil
+	}
+	shadow := objref.ObjectRef{
+		Name:      v,
+		Namespace: route.Spec.Import.Service.Namespace,
+	}
+	if i := strings.Index(v, "/"); i != -1 {
+		shadow.Namespace = v[:i]
+		shadow.Name = v[i+1:]
+	}
+	if shadow.Name == "" || shadow.Namespace == "" || strings.Contains(shadow.Name, "/") {
+		return nil, 0, fmt.Errorf("invalid %s %q", consts.AnnotationMirrorKey, v)
+	}
+	if shadow.Name == route.Spec.Import.Service.Name && shadow.Namespace == route.Spec.Import.Service.Namespace {
+		return nil, 0, fmt.Errorf("invalid %s %q: the mirror is the import itself", consts.AnnotationMirrorKey, v)
+	}
+
+	percent := 100
+	if p := route.Annotations[consts.AnnotationMirrorPercentKey]; p != "" {
+		var err error
+		percent, err = strconv.Atoi(strings.TrimSuffix(p, "%"))
+		if err != nil || percent < 0 || percent > 100 {
+			return nil, 0, fmt.Errorf("invalid %s %q", consts.AnnotationMirrorPercentKey, p)
+		}
+	}
+	return &shadow, percent, nil
+}
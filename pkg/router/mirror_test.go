@@ -0,0 +1,155 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"testing"
+
+	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
+	"github.com/ferryproxy/ferry/pkg/consts"
+	"github.com/ferryproxy/ferry/pkg/utils/objref"
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRouteMirror(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        *objref.ObjectRef
+		wantPercent int
+		wantErr     bool
+	}{
+		{
+			name: "no mirror",
+		},
+		{
+			name: "same namespace",
+			annotations: map[string]string{
+				consts.AnnotationMirrorKey: "svc1-shadow",
+			},
+			want:        &objref.ObjectRef{Name: "svc1-shadow", Namespace: "test"},
+			wantPercent: 100,
+		},
+		{
+			name: "other namespace with percent",
+			annotations: map[string]string{
+				consts.AnnotationMirrorKey:        "shadow/svc1",
+				consts.AnnotationMirrorPercentKey: "25%",
+			},
+			want:        &objref.ObjectRef{Name: "svc1", Namespace: "shadow"},
+			wantPercent: 25,
+		},
+		{
+			name: "itself",
+			annotations: map[string]string{
+				consts.AnnotationMirrorKey: "test/svc1",
+			},
+			wantErr: true,
+		},
+		{
+			name: "nested name",
+			annotations: map[string]string{
+				consts.AnnotationMirrorKey: "a/b/c",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid percent",
+			annotations: map[string]string{
+				consts.AnnotationMirrorKey:        "svc1-shadow",
+				consts.AnnotationMirrorPercentKey: "101",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := &trafficv1alpha2.Route{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: tt.annotations,
+				},
+				Spec: trafficv1alpha2.RouteSpec{
+					Import: trafficv1alpha2.RouteSpecRule{
+						Service: trafficv1alpha2.RouteSpecRuleService{Name: "svc1", Namespace: "test"},
+					},
+				},
+			}
+			got, percent, err := RouteMirror(route)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RouteMirror() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Errorf("RouteMirror(): got - want + \n%s", diff)
+			}
+			if percent != tt.wantPercent {
+				t.Errorf("RouteMirror() percent = %d, want %d", percent, tt.wantPercent)
+			}
+		})
+	}
+}
+
+func TestSetMirror(t *testing.T) {
+	tests := []struct {
+		name string
+		bind []string
+	}{
+		{
+			name: "ssh",
+			bind: []string{":10001", "ssh://export@10.0.0.2:31087?identity_file=/var/ferry/ssh/identity&target_hub=import"},
+		},
+		{
+			name: "websocket",
+			bind: []string{":10001", "ssh://export@10.0.0.2:80?identity_file=/var/ferry/ssh/identity&target_hub=import", "ws://10.0.0.2/ferry"},
+		},
+		{
+			name: "mtls",
+			bind: []string{":10001", "mtls://10.0.0.2:31444?server_name=import"},
+		},
+		{
+			name: "quic",
+			bind: []string{":10001", "quic://10.0.0.2:31445?server_name=import"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bound := map[string]*Bound{
+				"export": {
+					Outbound: []*Chain{
+						{
+							Bind:  tt.bind,
+							Proxy: []string{"svc1.test.svc:80"},
+						},
+					},
+				},
+			}
+			mirror := &Mirror{Address: "svc1-shadow.test.svc:80", Percent: 100}
+			setMirror(bound, ":10001", mirror)
+
+			if bound["export"].Outbound[0].Mirror != mirror {
+				t.Errorf("the mirror is not set on the chain that binds the import")
+			}
+			if bound["import"] == nil {
+				t.Fatalf("the mirror is not allowed in the import hub")
+			}
+			allow := bound["import"].Inbound["export"]
+			if allow == nil || !cmp.Equal(allow.DirectTcpip.Allows, []string{mirror.Address}) {
+				t.Errorf("the mirror is not allowed in the import hub: %+v", allow)
+			}
+		})
+	}
+}
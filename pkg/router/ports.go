@@ -0,0 +1,129 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
+	"github.com/ferryproxy/ferry/pkg/consts"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PortMapping is a port of the export service and how it is imported
+type PortMapping struct {
+	// Export is the port number or name of the export service
+	Export string
+	// Import is the port number of the import service, same as the export if zero
+	Import int32
+	// Name is the port name of the import service, same as the export if empty
+	Name string
+}
+
+// ParsePortMappings parses the comma separated port mappings in the format of "<export>[:<import>][/<name>]"
+func ParsePortMappings(s string) ([]PortMapping, error) {
+	var mappings []PortMapping
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		mapping := PortMapping{}
+		if i := strings.Index(item, "/"); i != -1 {
+			mapping.Name = item[i+1:]
+			item = item[:i]
+		}
+		if i := strings.Index(item, ":"); i != -1 {
+			port, err := strconv.ParseUint(item[i+1:], 10, 16)
+			if err != nil || port == 0 {
+				return nil, fmt.Errorf("invalid import port %q", item[i+1:])
+			}
+			mapping.Import = int32(port)
+			item = item[:i]
+		}
+		if item == "" {
+			return nil, fmt.Errorf("missing export port in %q", s)
+		}
+		mapping.Export = item
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
+}
+
+// RoutePort is a port of the export service and the port of the import service
+type RoutePort struct {
+	corev1.ServicePort
+	ImportName string
+	ImportPort int32
+}
+
+// RoutePorts returns the ports of the service that the route exports,
+// all ports are exported as is without the ports annotation.
+func RoutePorts(route *trafficv1alpha2.Route, svc *corev1.Service) ([]RoutePort, error) {
+	annotation := route.Annotations[consts.AnnotationPortsKey]
+	if annotation == "" {
+		ports := make([]RoutePort, 0, len(svc.Spec.Ports))
+		for _, port := range svc.Spec.Ports {
+			ports = append(ports, RoutePort{
+				ServicePort: port,
+				ImportName:  port.Name,
+				ImportPort:  port.Port,
+			})
+		}
+		return ports, nil
+	}
+
+	mappings, err := ParsePortMappings(annotation)
+	if err != nil {
+		return nil, fmt.Errorf("route %s annotation %s: %w", route.Name, consts.AnnotationPortsKey, err)
+	}
+	ports := make([]RoutePort, 0, len(mappings))
+	for _, mapping := range mappings {
+		port, ok := findServicePort(svc.Spec.Ports, mapping.Export)
+		if !ok {
+			return nil, fmt.Errorf("route %s: not found port %q in service %s/%s", route.Name, mapping.Export, svc.Namespace, svc.Name)
+		}
+		routePort := RoutePort{
+			ServicePort: port,
+			ImportName:  port.Name,
+			ImportPort:  port.Port,
+		}
+		if mapping.Name != "" {
+			routePort.ImportName = mapping.Name
+		}
+		if mapping.Import != 0 {
+			routePort.ImportPort = mapping.Import
+		}
+		ports = append(ports, routePort)
+	}
+	return ports, nil
+}
+
+func findServicePort(ports []corev1.ServicePort, export string) (corev1.ServicePort, bool) {
+	number, err := strconv.ParseInt(export, 10, 32)
+	for _, port := range ports {
+		if err == nil && port.Port == int32(number) {
+			return port, true
+		}
+		if err != nil && port.Name == export {
+			return port, true
+		}
+	}
+	return corev1.ServicePort{}, false
+}
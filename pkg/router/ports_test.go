@@ -0,0 +1,102 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"reflect"
+	"testing"
+
+	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
+	"github.com/ferryproxy/ferry/pkg/consts"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRoutePorts(t *testing.T) {
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "svc1",
+			Namespace: "test",
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: []corev1.ServicePort{
+				{Name: "http", Port: 8080, Protocol: corev1.ProtocolTCP},
+				{Name: "metrics", Port: 9090, Protocol: corev1.ProtocolTCP},
+			},
+		},
+	}
+	tests := []struct {
+		name       string
+		annotation string
+		want       []RoutePort
+		wantErr    bool
+	}{
+		{
+			name: "all ports",
+			want: []RoutePort{
+				{ServicePort: svc.Spec.Ports[0], ImportName: "http", ImportPort: 8080},
+				{ServicePort: svc.Spec.Ports[1], ImportName: "metrics", ImportPort: 9090},
+			},
+		},
+		{
+			name:       "remap port",
+			annotation: "8080:80",
+			want: []RoutePort{
+				{ServicePort: svc.Spec.Ports[0], ImportName: "http", ImportPort: 80},
+			},
+		},
+		{
+			name:       "select by name and rename",
+			annotation: "metrics/prom, http:80/web",
+			want: []RoutePort{
+				{ServicePort: svc.Spec.Ports[1], ImportName: "prom", ImportPort: 9090},
+				{ServicePort: svc.Spec.Ports[0], ImportName: "web", ImportPort: 80},
+			},
+		},
+		{
+			name:       "unknown port",
+			annotation: "8081",
+			wantErr:    true,
+		},
+		{
+			name:       "invalid import port",
+			annotation: "8080:http",
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := &trafficv1alpha2.Route{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "svc1",
+				},
+			}
+			if tt.annotation != "" {
+				route.Annotations = map[string]string{
+					consts.AnnotationPortsKey: tt.annotation,
+				}
+			}
+			got, err := RoutePorts(route, svc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RoutePorts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RoutePorts() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
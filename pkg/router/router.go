@@ -17,7 +17,13 @@ limitations under the License.
 package router
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
 
 	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
 	"github.com/ferryproxy/ferry/pkg/consts"
@@ -27,6 +33,7 @@ import (
 	"github.com/ferryproxy/ferry/pkg/utils/objref"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 type HubInterface interface {
@@ -34,10 +41,15 @@ type HubInterface interface {
 	GetHubGateway(hubName string, forHub string) trafficv1alpha2.HubSpecGateway
 	GetAuthorized(name string) string
 	GetPortPeer(importHubName string, cluster, namespace, name string, port int32) (int32, error)
+	GetEndpoints(hubName string, namespace, name string) (*corev1.Endpoints, bool)
+	// GetCABundle returns the "ca.crt" of the secret or config map, the kind is "secret" or "configmap"
+	GetCABundle(hubName string, namespace, kind, name string) ([]byte, error)
 }
 
 type RouterConfig struct {
-	Labels        map[string]string
+	Labels map[string]string
+	// Namespace is the namespace of the routes of ferry, the resources of the routes in other namespaces are prefixed with their namespace
+	Namespace     string
 	ExportHubName string
 	ImportHubName string
 	HubInterface  HubInterface
@@ -46,6 +58,7 @@ type RouterConfig struct {
 func NewRouter(conf RouterConfig) *Router {
 	return &Router{
 		labels:        conf.Labels,
+		namespace:     conf.Namespace,
 		importHubName: conf.ImportHubName,
 		exportHubName: conf.ExportHubName,
 		hubInterface:  conf.HubInterface,
@@ -56,7 +69,8 @@ func NewRouter(conf RouterConfig) *Router {
 }
 
 type Router struct {
-	labels map[string]string
+	labels    map[string]string
+	namespace string
 
 	exportHubName string
 	importHubName string
@@ -64,6 +78,15 @@ type Router struct {
 	hubInterface HubInterface
 
 	hubsChain *HubsChain
+
+	// invalid is the routes skipped by the last BuildResource because of their annotations
+	invalid map[objref.ObjectRef]error
+}
+
+// InvalidRoutes returns the routes that the last BuildResource skipped and why,
+// the other routes between the hubs are built as usual.
+func (d *Router) InvalidRoutes() map[objref.ObjectRef]error {
+	return d.invalid
 }
 
 func (d *Router) BuildResource(rules []*trafficv1alpha2.Route, ways []string) (out map[string][]objref.KMetadata, err error) {
@@ -75,71 +98,164 @@ func (d *Router) BuildResource(rules []*trafficv1alpha2.Route, ways []string) (o
 	}
 
 	out = map[string][]objref.KMetadata{}
+	d.invalid = map[objref.ObjectRef]error{}
 	svcs := d.hubInterface.ListServices(d.exportHubName)
 
-	labelsForRules := maps.Merge(d.labels, map[string]string{
-		consts.TunnelConfigKey: consts.TunnelConfigRulesValue,
-	})
-	labelsForAllow := maps.Merge(d.labels, map[string]string{
-		consts.TunnelConfigKey: consts.TunnelConfigAllowValue,
-	})
-	labelsForAuth := maps.Merge(d.labels, map[string]string{
-		consts.TunnelConfigKey: consts.TunnelConfigAuthorizedValue,
-	})
 	labelsForDiscover := maps.Merge(d.labels, map[string]string{
 		consts.TunnelConfigKey: consts.TunnelConfigDiscoverValue,
 	})
 
 	for _, svc := range svcs {
 		origin := objref.KObj(svc)
-		for _, rule := range mappings[origin] {
+		rules := mappings[origin]
+		if len(rules) == 0 {
+			continue
+		}
+		// The service is not exported to the hub that is not allowed, so that the tunnels do not allow it either
+		if !ImportAllowed(svc, d.importHubName) {
+			continue
+		}
+		for _, rule := range rules {
+			ruleName := d.resourceName(rule)
 			destination := objref.ObjectRef{Name: rule.Spec.Import.Service.Name, Namespace: rule.Spec.Import.Service.Namespace}
 
-			peerPortMapping := map[int32]int32{}
+			// The route with the invalid annotations is skipped, so that it does not break the other routes
+			routePorts, err := RoutePorts(rule, svc)
+			if err != nil {
+				d.invalid[objref.KObj(rule)] = err
+				continue
+			}
 
-			for _, port := range svc.Spec.Ports {
+			limit, err := RouteLimit(rule)
+			if err != nil {
+				d.invalid[objref.KObj(rule)] = err
+				continue
+			}
 
-				peerPort, err := d.hubInterface.GetPortPeer(d.importHubName, d.exportHubName, origin.Namespace, origin.Name, port.Port)
-				if err != nil {
-					return nil, err
-				}
-				peerPortMapping[port.Port] = peerPort
+			breaker, err := RouteCircuitBreaker(rule)
+			if err != nil {
+				d.invalid[objref.KObj(rule)] = err
+				continue
+			}
 
-				tunnelName := fmt.Sprintf("%s-tunnel-%d-%d", rule.Name, port.Port, peerPort)
-				hubsBound, err := d.hubsChain.Build(tunnelName, origin, destination, port.Port, peerPort, ways)
-				if err != nil {
-					return nil, err
-				}
-				resources, err := ConvertOutboundToResourcers(tunnelName, consts.FerryTunnelNamespace, labelsForRules, hubsBound)
-				if err != nil {
-					return nil, err
-				}
-				for k, res := range resources {
-					out[k] = append(out[k], res...)
-				}
+			shadow, mirrorPercent, err := RouteMirror(rule)
+			if err != nil {
+				d.invalid[objref.KObj(rule)] = err
+				continue
+			}
+
+			split, err := RouteTrafficSplit(rule)
+			if err != nil {
+				d.invalid[objref.KObj(rule)] = err
+				continue
+			}
+			weight := 0
+
+			httpRules, err := RouteHTTPRules(rule)
+			if err != nil {
+				d.invalid[objref.KObj(rule)] = err
+				continue
+			}
+
+			serverNames, err := RouteSNI(rule)
+			if err != nil {
+				d.invalid[objref.KObj(rule)] = err
+				continue
+			}
+
+			tlsOrigination, err := RouteTLSOrigination(rule)
+			if err != nil {
+				d.invalid[objref.KObj(rule)] = err
+				continue
+			}
+			err = d.resolveTLSCA(tlsOrigination, origin.Namespace)
+			if err != nil {
+				d.invalid[objref.KObj(rule)] = err
+				continue
+			}
+
+			fallback, priority := RouteFallback(rule)
+
+			var ports []discovery.MappingPort
+			var pods []discovery.MappingPod
+			if svc.Spec.ClusterIP == corev1.ClusterIPNone {
+				// Headless service, one tunnel for each pod that is addressed by the hostname
+				ep, _ := d.hubInterface.GetEndpoints(d.exportHubName, origin.Namespace, origin.Name)
+				for _, hostname := range ReadyHostnames(ep) {
+					podOrigin := objref.ObjectRef{Name: hostname + "." + origin.Name, Namespace: origin.Namespace}
+					peerPortMapping := map[int32]int32{}
+					for _, port := range routePorts {
+						peerPort, err := d.hubInterface.GetPortPeer(d.importHubName, d.exportHubName, podOrigin.Namespace, podOrigin.Name, port.Port)
+						if err != nil {
+							return nil, err
+						}
+						peerPortMapping[port.Port] = peerPort
 
-				allowName := fmt.Sprintf("%s-allows-%d-%d", rule.Name, port.Port, peerPort)
-				resources, err = ConvertInboundToResourcers(allowName, consts.FerryTunnelNamespace, labelsForAllow, hubsBound)
-				if err != nil {
-					return nil, err
+						suffix := fmt.Sprintf("%s-%d-%d", hostname, port.Port, peerPort)
+						podAddress := podOriginAddress(ep, podOrigin, hostname, podPort(ep, hostname, port.ServicePort))
+						err = d.buildTunnel(out, ruleName, suffix, podAddress, destination, peerPort, ways, limit, breaker, nil, tlsOrigination.forOrigin(podAddress))
+						if err != nil {
+							return nil, err
+						}
+					}
+					pods = append(pods, discovery.MappingPod{
+						Hostname: hostname,
+						Ports:    buildPorts(peerPortMapping, routePorts),
+					})
 				}
-				for k, res := range resources {
-					out[k] = append(out[k], res...)
+
+				// The ports of the headless service are the same as the pods
+				identityPortMapping := map[int32]int32{}
+				for _, port := range routePorts {
+					identityPortMapping[port.Port] = port.ImportPort
 				}
+				ports = buildPorts(identityPortMapping, routePorts)
+			} else {
+				peerPortMapping := map[int32]int32{}
+				for _, port := range routePorts {
+					peerPort, err := d.hubInterface.GetPortPeer(d.importHubName, d.exportHubName, origin.Namespace, origin.Name, port.Port)
+					if err != nil {
+						return nil, err
+					}
+					peerPortMapping[port.Port] = peerPort
+
+					originAddress, err := d.originAddress(svc, origin, port.ServicePort)
+					if err != nil {
+						return nil, err
+					}
 
-				authNameSuffix := "authorized"
-				resources, err = ConvertInboundAuthorizedToResourcers(authNameSuffix, consts.FerryTunnelNamespace, labelsForAuth, hubsBound, d.hubInterface.GetAuthorized)
-				if err != nil {
-					return nil, err
+					// The shadow import is mirrored with the same port as the import
+					var mirror *Mirror
+					if shadow != nil && mirrorPercent != 0 {
+						mirror = &Mirror{
+							Address: ServiceAddress(*shadow, port.ImportPort),
+							Percent: mirrorPercent,
+						}
+					}
+
+					suffix := fmt.Sprintf("%d-%d", port.Port, peerPort)
+					err = d.buildTunnel(out, ruleName, suffix, originAddress, destination, peerPort, ways, limit, breaker, mirror, tlsOrigination.forOrigin(originAddress))
+					if err != nil {
+						return nil, err
+					}
 				}
-				for k, res := range resources {
-					out[k] = append(out[k], res...)
+				ports = buildPorts(peerPortMapping, routePorts)
+
+				// The split port is shared by all the export hubs of the import service,
+				// the tunnel of the import hub splits the connections to the peer ports by the weights, the HTTP rules or the server names
+				if split != nil || len(httpRules) != 0 || len(serverNames) != 0 {
+					weight = split[d.exportHubName]
+					for i, port := range ports {
+						splitPort, err := d.hubInterface.GetPortPeer(d.importHubName, SplitCluster, destination.Namespace, destination.Name, port.Port)
+						if err != nil {
+							return nil, err
+						}
+						ports[i].SplitPort = splitPort
+					}
 				}
 			}
 
-			serviceName := fmt.Sprintf("%s-service", rule.Name)
-
-			ports := buildPorts(peerPortMapping, &svc.Spec)
+			serviceName := fmt.Sprintf("%s-service", ruleName)
 
 			svcConfig := discovery.Service{
 				ExportHubName:          d.exportHubName,
@@ -148,6 +264,14 @@ func (d *Router) BuildResource(rules []*trafficv1alpha2.Route, ways []string) (o
 				ImportServiceNamespace: destination.Namespace,
 				ImportServiceName:      destination.Name,
 				Ports:                  ports,
+				Headless:               svc.Spec.ClusterIP == corev1.ClusterIPNone,
+				Pods:                   pods,
+				Weight:                 weight,
+				HTTPRules:              httpRules,
+				ServerNames:            serverNames,
+				Fallback:               fallback,
+				Priority:               priority,
+				NotReady:               !d.exportReady(svc),
 			}
 			data, err := svcConfig.ToMap()
 			if err != nil {
@@ -171,19 +295,192 @@ func (d *Router) BuildResource(rules []*trafficv1alpha2.Route, ways []string) (o
 	return out, nil
 }
 
-func buildPorts(peerPortMapping map[int32]int32, spec *corev1.ServiceSpec) []discovery.MappingPort {
+// TunnelPrefix returns the prefix of the names of the tunnels of the route
+func (d *Router) TunnelPrefix(rule *trafficv1alpha2.Route) string {
+	return d.resourceName(rule) + "-tunnel-"
+}
+
+// resourceName returns the prefix of the resources of the route, which is unique across the namespaces of the tenants,
+// the routes of the tenants are suffixed with the hash of their namespace and name, since joining them is ambiguous,
+// e.g. "team-a/web" and "team/a-web".
+func (d *Router) resourceName(rule *trafficv1alpha2.Route) string {
+	if d.namespace == "" || rule.Namespace == "" || rule.Namespace == d.namespace {
+		return rule.Name
+	}
+	sum := sha256.Sum256([]byte(rule.Namespace + "/" + rule.Name))
+	return rule.Name + "-" + hex.EncodeToString(sum[:6])
+}
+
+func (d *Router) buildTunnel(out map[string][]objref.KMetadata, ruleName, suffix string, originAddress string, destination objref.ObjectRef, peerPort int32, ways []string, limit *Limit, breaker *CircuitBreaker, mirror *Mirror, tls *TLSOrigination) error {
+	labelsForRules := maps.Merge(d.labels, map[string]string{
+		consts.TunnelConfigKey: consts.TunnelConfigRulesValue,
+	})
+	labelsForAllow := maps.Merge(d.labels, map[string]string{
+		consts.TunnelConfigKey: consts.TunnelConfigAllowValue,
+	})
+	labelsForAuth := maps.Merge(d.labels, map[string]string{
+		consts.TunnelConfigKey: consts.TunnelConfigAuthorizedValue,
+	})
+
+	tunnelName := fmt.Sprintf("%s-tunnel-%s", ruleName, suffix)
+	hubsBound, err := d.hubsChain.BuildWithAddress(tunnelName, originAddress, destination, peerPort, ways)
+	if err != nil {
+		return err
+	}
+	for _, bound := range hubsBound {
+		for _, chain := range bound.Outbound {
+			chain.Name = tunnelName
+			chain.Limit = limit
+		}
+	}
+	if mirror != nil {
+		setMirror(hubsBound, fmt.Sprintf(":%d", peerPort), mirror)
+	}
+	if breaker != nil {
+		setCircuitBreaker(hubsBound, fmt.Sprintf(":%d", peerPort), breaker)
+	}
+	if tls != nil {
+		setTLSOrigination(hubsBound, originAddress, tls)
+	}
+	resources, err := ConvertOutboundToResourcers(tunnelName, consts.FerryTunnelNamespace, labelsForRules, hubsBound)
+	if err != nil {
+		return err
+	}
+	for k, res := range resources {
+		out[k] = append(out[k], res...)
+	}
+
+	allowName := fmt.Sprintf("%s-allows-%s", ruleName, suffix)
+	resources, err = ConvertInboundToResourcers(allowName, consts.FerryTunnelNamespace, labelsForAllow, hubsBound)
+	if err != nil {
+		return err
+	}
+	for k, res := range resources {
+		out[k] = append(out[k], res...)
+	}
+
+	authNameSuffix := "authorized"
+	resources, err = ConvertInboundAuthorizedToResourcers(authNameSuffix, consts.FerryTunnelNamespace, labelsForAuth, hubsBound, d.hubInterface.GetAuthorized)
+	if err != nil {
+		return err
+	}
+	for k, res := range resources {
+		out[k] = append(out[k], res...)
+	}
+	return nil
+}
+
+// originAddress returns the address that the export hub proxies to for the port of the service,
+// the service without selector is proxied by its cluster address as well,
+// so that its manually managed endpoints are still balanced and followed by the kube-proxy
+func (d *Router) originAddress(svc *corev1.Service, origin objref.ObjectRef, port corev1.ServicePort) (string, error) {
+	if svc.Spec.Type == corev1.ServiceTypeExternalName {
+		// ExternalName service is only a CNAME, so proxy to the external name directly
+		if svc.Spec.ExternalName == "" {
+			return "", fmt.Errorf("service %s has no external name", origin)
+		}
+		return net.JoinHostPort(svc.Spec.ExternalName, strconv.Itoa(int(port.Port))), nil
+	}
+	return ServiceAddress(origin, port.Port), nil
+}
+
+// podPort returns the port of the pod for the port of the service,
+// it is the target port resolved in the subset of the pod, so the named target port works
+// even if the pods resolve it differently
+func podPort(ep *corev1.Endpoints, hostname string, port corev1.ServicePort) int32 {
+	if ep != nil {
+		for _, subset := range ep.Subsets {
+			if !hasHostname(subset.Addresses, hostname) {
+				continue
+			}
+			for _, epPort := range subset.Ports {
+				if epPort.Name == port.Name && (epPort.Protocol == "" || epPort.Protocol == port.Protocol) {
+					return epPort.Port
+				}
+			}
+		}
+	}
+	if port.TargetPort.Type == intstr.Int && port.TargetPort.IntVal != 0 {
+		return port.TargetPort.IntVal
+	}
+	return port.Port
+}
+
+func hasHostname(addresses []corev1.EndpointAddress, hostname string) bool {
+	for _, address := range addresses {
+		if addressHostname(address) == hostname {
+			return true
+		}
+	}
+	return false
+}
+
+// podOriginAddress returns the address of the pod, the pod with the hostname is addressed by the DNS of the headless service,
+// the others have no DNS name so they are addressed by the IP
+func podOriginAddress(ep *corev1.Endpoints, podOrigin objref.ObjectRef, hostname string, port int32) string {
+	if ep != nil {
+		for _, subset := range ep.Subsets {
+			for _, address := range subset.Addresses {
+				if address.Hostname == "" && addressHostname(address) == hostname {
+					return net.JoinHostPort(address.IP, strconv.Itoa(int(port)))
+				}
+			}
+		}
+	}
+	return ServiceAddress(podOrigin, port)
+}
+
+// addressHostname returns the hostname of the address, the pod without the hostname, e.g. of a Deployment,
+// is named by the name of the pod, or by the IP with the dashes if it is not a pod
+func addressHostname(address corev1.EndpointAddress) string {
+	if address.Hostname != "" {
+		return address.Hostname
+	}
+	if address.TargetRef != nil && address.TargetRef.Kind == "Pod" && address.TargetRef.Name != "" {
+		return address.TargetRef.Name
+	}
+	return strings.NewReplacer(".", "-", ":", "-").Replace(address.IP)
+}
+
+// ReadyHostnames returns the sorted hostnames of the ready addresses
+func ReadyHostnames(ep *corev1.Endpoints) []string {
+	if ep == nil {
+		return nil
+	}
+	uniq := map[string]struct{}{}
+	for _, subset := range ep.Subsets {
+		for _, address := range subset.Addresses {
+			uniq[addressHostname(address)] = struct{}{}
+		}
+	}
+	hostnames := make([]string, 0, len(uniq))
+	for hostname := range uniq {
+		hostnames = append(hostnames, hostname)
+	}
+	sort.Strings(hostnames)
+	return hostnames
+}
+
+func buildPorts(peerPortMapping map[int32]int32, routePorts []RoutePort) []discovery.MappingPort {
 	ports := []discovery.MappingPort{}
-	for _, port := range spec.Ports {
+	for _, port := range routePorts {
 		if port.Protocol != corev1.ProtocolTCP {
 			continue
 		}
 		svcPort := peerPortMapping[port.Port]
-		ports = append(ports, discovery.MappingPort{
-			Name:       port.Name,
-			Port:       port.Port,
+		mappingPort := discovery.MappingPort{
+			Name:       port.ImportName,
+			Port:       port.ImportPort,
 			Protocol:   string(port.Protocol),
 			TargetPort: svcPort,
-		})
+		}
+		if port.AppProtocol != nil {
+			mappingPort.AppProtocol = *port.AppProtocol
+		}
+		if port.ImportPort != port.Port {
+			mappingPort.ExportPort = port.Port
+		}
+		ports = append(ports, mappingPort)
 	}
 	return ports
 }
@@ -28,13 +28,15 @@ import (
 	"github.com/wzshiming/sshproxy/permissions"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 func TestRouter(t *testing.T) {
 	tests := []struct {
-		name string
-		args fakeRouter
-		want map[string][]objref.KMetadata
+		name    string
+		args    fakeRouter
+		want    map[string][]objref.KMetadata
+		invalid []objref.ObjectRef
 	}{
 		{
 			name: "self",
@@ -126,6 +128,2309 @@ func TestRouter(t *testing.T) {
 							consts.TunnelRulesKey: toJson(
 								[]Chain{
 									{
+										Name: "svc1-tunnel-80-10001",
+										Bind: []string{
+											":10001",
+										},
+										Proxy: []string{
+											"svc1.test.svc:80",
+										},
+									},
+								},
+							),
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "self denied",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+							Annotations: map[string]string{
+								consts.AnnotationAllowedImportHubsKey: "other",
+							},
+						},
+						Spec: corev1.ServiceSpec{
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{},
+		},
+		{
+			name: "self with invalid ports",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc2",
+							Namespace: "test",
+							Annotations: map[string]string{
+								consts.AnnotationPortsKey: "8080:unknown",
+							},
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc2-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{
+				"self": {
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-service",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "service",
+							},
+						},
+						Data: map[string]string{
+							"export_hub_name":          "self",
+							"export_service_name":      "svc1",
+							"export_service_namespace": "test",
+							"import_service_name":      "svc1-new",
+							"import_service_namespace": "test",
+							"ports":                    `[{"name":"http","protocol":"TCP","port":80,"targetPort":10001}]`,
+						},
+					},
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-tunnel-80-10001",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "rules",
+							},
+						},
+						Data: map[string]string{
+							consts.TunnelRulesKey: toJson(
+								[]Chain{
+									{
+										Name: "svc1-tunnel-80-10001",
+										Bind: []string{
+											":10001",
+										},
+										Proxy: []string{
+											"svc1.test.svc:80",
+										},
+									},
+								},
+							),
+						},
+					},
+				},
+			},
+			invalid: []objref.ObjectRef{
+				{Name: "svc2", Namespace: "test"},
+			},
+		},
+		{
+			name: "self with limit",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+							Annotations: map[string]string{
+								consts.AnnotationBandwidthKey:      "1Mi",
+								consts.AnnotationMaxConnectionsKey: "10",
+							},
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{
+				"self": {
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-service",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "service",
+							},
+						},
+						Data: map[string]string{
+							"export_hub_name":          "self",
+							"export_service_name":      "svc1",
+							"export_service_namespace": "test",
+							"import_service_name":      "svc1-new",
+							"import_service_namespace": "test",
+							"ports":                    `[{"name":"http","protocol":"TCP","port":80,"targetPort":10001}]`,
+						},
+					},
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-tunnel-80-10001",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "rules",
+							},
+						},
+						Data: map[string]string{
+							consts.TunnelRulesKey: toJson(
+								[]Chain{
+									{
+										Name: "svc1-tunnel-80-10001",
+										Bind: []string{
+											":10001",
+										},
+										Proxy: []string{
+											"svc1.test.svc:80",
+										},
+										Limit: &Limit{
+											Name:           "test/svc1",
+											BytesPerSecond: 1 << 20,
+											MaxConnections: 10,
+										},
+									},
+								},
+							),
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "self with invalid limit",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+							Annotations: map[string]string{
+								consts.AnnotationBandwidthKey: "fast",
+							},
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{},
+			invalid: []objref.ObjectRef{
+				{Name: "svc1", Namespace: "test"},
+			},
+		},
+		{
+			name: "self with mirror",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+							Annotations: map[string]string{
+								consts.AnnotationMirrorKey:        "svc1-shadow",
+								consts.AnnotationMirrorPercentKey: "10",
+							},
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{
+				"self": {
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-service",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "service",
+							},
+						},
+						Data: map[string]string{
+							"export_hub_name":          "self",
+							"export_service_name":      "svc1",
+							"export_service_namespace": "test",
+							"import_service_name":      "svc1-new",
+							"import_service_namespace": "test",
+							"ports":                    `[{"name":"http","protocol":"TCP","port":80,"targetPort":10001}]`,
+						},
+					},
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-tunnel-80-10001",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "rules",
+							},
+						},
+						Data: map[string]string{
+							consts.TunnelRulesKey: toJson(
+								[]Chain{
+									{
+										Name: "svc1-tunnel-80-10001",
+										Bind: []string{
+											":10001",
+										},
+										Proxy: []string{
+											"svc1.test.svc:80",
+										},
+										Mirror: &Mirror{
+											Address: "svc1-shadow.test.svc:80",
+											Percent: 10,
+										},
+									},
+								},
+							),
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "self with invalid mirror",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+							Annotations: map[string]string{
+								consts.AnnotationMirrorKey: "a/b/c",
+							},
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{},
+			invalid: []objref.ObjectRef{
+				{Name: "svc1", Namespace: "test"},
+			},
+		},
+		{
+			name: "self with circuit breaker",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+							Annotations: map[string]string{
+								consts.AnnotationConsecutiveFailuresKey: "5",
+							},
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{
+				"self": {
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-service",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "service",
+							},
+						},
+						Data: map[string]string{
+							"export_hub_name":          "self",
+							"export_service_name":      "svc1",
+							"export_service_namespace": "test",
+							"import_service_name":      "svc1-new",
+							"import_service_namespace": "test",
+							"ports":                    `[{"name":"http","protocol":"TCP","port":80,"targetPort":10001}]`,
+						},
+					},
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-tunnel-80-10001",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "rules",
+							},
+						},
+						Data: map[string]string{
+							consts.TunnelRulesKey: toJson(
+								[]Chain{
+									{
+										Name: "svc1-tunnel-80-10001",
+										Bind: []string{
+											":10001",
+										},
+										Proxy: []string{
+											"svc1.test.svc:80",
+										},
+										CircuitBreaker: &CircuitBreaker{
+											ConsecutiveFailures: 5,
+										},
+									},
+								},
+							),
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "self with invalid circuit breaker",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+							Annotations: map[string]string{
+								consts.AnnotationConsecutiveFailuresKey: "many",
+							},
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{},
+			invalid: []objref.ObjectRef{
+				{Name: "svc1", Namespace: "test"},
+			},
+		},
+		{
+			name: "self with traffic split",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+							Annotations: map[string]string{
+								consts.AnnotationTrafficSplitKey: "self=90,other=10",
+							},
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{
+				"self": {
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-service",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "service",
+							},
+						},
+						Data: map[string]string{
+							"export_hub_name":          "self",
+							"export_service_name":      "svc1",
+							"export_service_namespace": "test",
+							"import_service_name":      "svc1-new",
+							"import_service_namespace": "test",
+							"ports":                    `[{"name":"http","protocol":"TCP","port":80,"targetPort":10001,"splitPort":10002}]`,
+							"weight":                   "90",
+						},
+					},
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-tunnel-80-10001",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "rules",
+							},
+						},
+						Data: map[string]string{
+							consts.TunnelRulesKey: toJson(
+								[]Chain{
+									{
+										Name: "svc1-tunnel-80-10001",
+										Bind: []string{
+											":10001",
+										},
+										Proxy: []string{
+											"svc1.test.svc:80",
+										},
+									},
+								},
+							),
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "self with invalid traffic split",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+							Annotations: map[string]string{
+								consts.AnnotationTrafficSplitKey: "self",
+							},
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{},
+			invalid: []objref.ObjectRef{
+				{Name: "svc1", Namespace: "test"},
+			},
+		},
+		{
+			name: "self with tls origination",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+							Annotations: map[string]string{
+								consts.AnnotationTLSOriginationKey: "true",
+							},
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{
+				"self": {
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-service",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "service",
+							},
+						},
+						Data: map[string]string{
+							"export_hub_name":          "self",
+							"export_service_name":      "svc1",
+							"export_service_namespace": "test",
+							"import_service_name":      "svc1-new",
+							"import_service_namespace": "test",
+							"ports":                    `[{"name":"http","protocol":"TCP","port":80,"targetPort":10001}]`,
+						},
+					},
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-tunnel-80-10001",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "rules",
+							},
+						},
+						Data: map[string]string{
+							consts.TunnelRulesKey: toJson(
+								[]Chain{
+									{
+										Name: "svc1-tunnel-80-10001",
+										Bind: []string{
+											":10001",
+										},
+										Proxy: []string{
+											"svc1.test.svc:80",
+										},
+										TLS: &TLSOrigination{
+											ServerName: "svc1.test.svc",
+										},
+									},
+								},
+							),
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "self with tls origination with ca",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+							Annotations: map[string]string{
+								consts.AnnotationTLSOriginationKey: "true",
+								consts.AnnotationTLSCAKey:          "secret/ca",
+							},
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{
+				"self": {
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-service",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "service",
+							},
+						},
+						Data: map[string]string{
+							"export_hub_name":          "self",
+							"export_service_name":      "svc1",
+							"export_service_namespace": "test",
+							"import_service_name":      "svc1-new",
+							"import_service_namespace": "test",
+							"ports":                    `[{"name":"http","protocol":"TCP","port":80,"targetPort":10001}]`,
+						},
+					},
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-tunnel-80-10001",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "rules",
+							},
+						},
+						Data: map[string]string{
+							consts.TunnelRulesKey: toJson(
+								[]Chain{
+									{
+										Name: "svc1-tunnel-80-10001",
+										Bind: []string{
+											":10001",
+										},
+										Proxy: []string{
+											"svc1.test.svc:80",
+										},
+										TLS: &TLSOrigination{
+											ServerName: "svc1.test.svc",
+											CA:         string(testCABundle),
+										},
+									},
+								},
+							),
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "self with invalid tls origination",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+							Annotations: map[string]string{
+								consts.AnnotationTLSOriginationKey: "api_example",
+							},
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{},
+			invalid: []objref.ObjectRef{
+				{Name: "svc1", Namespace: "test"},
+			},
+		},
+		{
+			name: "self with tls ca not found",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+							Annotations: map[string]string{
+								consts.AnnotationTLSOriginationKey: "true",
+								consts.AnnotationTLSCAKey:          "configmap/ca",
+							},
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{},
+			invalid: []objref.ObjectRef{
+				{Name: "svc1", Namespace: "test"},
+			},
+		},
+		{
+			name: "self with invalid sni",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+							Annotations: map[string]string{
+								consts.AnnotationSNIKey: ",",
+							},
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{},
+			invalid: []objref.ObjectRef{
+				{Name: "svc1", Namespace: "test"},
+			},
+		},
+		{
+			name: "self with http rules",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+							Annotations: map[string]string{
+								consts.AnnotationHTTPRulesKey: `[{"name":"v2","pathPrefix":"/api/v2","hubName":"self"}]`,
+							},
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{
+				"self": {
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-service",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "service",
+							},
+						},
+						Data: map[string]string{
+							"export_hub_name":          "self",
+							"export_service_name":      "svc1",
+							"export_service_namespace": "test",
+							"import_service_name":      "svc1-new",
+							"import_service_namespace": "test",
+							"ports":                    `[{"name":"http","protocol":"TCP","port":80,"targetPort":10001,"splitPort":10002}]`,
+							"http_rules":               `[{"name":"v2","pathPrefix":"/api/v2","hubName":"self"}]`,
+						},
+					},
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-tunnel-80-10001",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "rules",
+							},
+						},
+						Data: map[string]string{
+							consts.TunnelRulesKey: toJson(
+								[]Chain{
+									{
+										Name: "svc1-tunnel-80-10001",
+										Bind: []string{
+											":10001",
+										},
+										Proxy: []string{
+											"svc1.test.svc:80",
+										},
+									},
+								},
+							),
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "self with invalid http rules",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+							Annotations: map[string]string{
+								consts.AnnotationHTTPRulesKey: "/api",
+							},
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{},
+			invalid: []objref.ObjectRef{
+				{Name: "svc1", Namespace: "test"},
+			},
+		},
+		{
+			name: "self without ready endpoints",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Endpoints: []*corev1.Endpoints{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Subsets: []corev1.EndpointSubset{
+							{
+								NotReadyAddresses: []corev1.EndpointAddress{
+									{IP: "10.1.0.1"},
+								},
+								Ports: []corev1.EndpointPort{
+									{Name: "http", Port: 8080, Protocol: corev1.ProtocolTCP},
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{
+				"self": {
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-service",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "service",
+							},
+						},
+						Data: map[string]string{
+							"export_hub_name":          "self",
+							"export_service_name":      "svc1",
+							"export_service_namespace": "test",
+							"import_service_name":      "svc1-new",
+							"import_service_namespace": "test",
+							"ports":                    `[{"name":"http","protocol":"TCP","port":80,"targetPort":10001}]`,
+							"not_ready":                "true",
+						},
+					},
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-tunnel-80-10001",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "rules",
+							},
+						},
+						Data: map[string]string{
+							consts.TunnelRulesKey: toJson(
+								[]Chain{
+									{
+										Name: "svc1-tunnel-80-10001",
+										Bind: []string{
+											":10001",
+										},
+										Proxy: []string{
+											"svc1.test.svc:80",
+										},
+									},
+								},
+							),
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "self headless",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							ClusterIP: corev1.ClusterIPNone,
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Endpoints: []*corev1.Endpoints{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Subsets: []corev1.EndpointSubset{
+							{
+								Addresses: []corev1.EndpointAddress{
+									{IP: "10.1.0.1", Hostname: "svc1-0"},
+								},
+								NotReadyAddresses: []corev1.EndpointAddress{
+									{IP: "10.1.0.2", Hostname: "svc1-1"},
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{
+				"self": {
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-service",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "service",
+							},
+						},
+						Data: map[string]string{
+							"export_hub_name":          "self",
+							"export_service_name":      "svc1",
+							"export_service_namespace": "test",
+							"headless":                 "true",
+							"import_service_name":      "svc1-new",
+							"import_service_namespace": "test",
+							"ports":                    `[{"name":"http","protocol":"TCP","port":80,"targetPort":80}]`,
+							"pods":                     `[{"hostname":"svc1-0","ports":[{"name":"http","protocol":"TCP","port":80,"targetPort":10001}]}]`,
+						},
+					},
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-tunnel-svc1-0-80-10001",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "rules",
+							},
+						},
+						Data: map[string]string{
+							consts.TunnelRulesKey: toJson(
+								[]Chain{
+									{
+										Name: "svc1-tunnel-svc1-0-80-10001",
+										Bind: []string{
+											":10001",
+										},
+										Proxy: []string{
+											"svc1-0.svc1.test.svc:80",
+										},
+									},
+								},
+							),
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "self headless without hostname",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							ClusterIP: corev1.ClusterIPNone,
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Endpoints: []*corev1.Endpoints{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Subsets: []corev1.EndpointSubset{
+							{
+								Addresses: []corev1.EndpointAddress{
+									{IP: "10.1.0.1", TargetRef: &corev1.ObjectReference{Kind: "Pod", Name: "svc1-5d8f9"}},
+									{IP: "10.1.0.2"},
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{
+				"self": {
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-service",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "service",
+							},
+						},
+						Data: map[string]string{
+							"export_hub_name":          "self",
+							"export_service_name":      "svc1",
+							"export_service_namespace": "test",
+							"headless":                 "true",
+							"import_service_name":      "svc1-new",
+							"import_service_namespace": "test",
+							"ports":                    `[{"name":"http","protocol":"TCP","port":80,"targetPort":80}]`,
+							"pods":                     `[{"hostname":"10-1-0-2","ports":[{"name":"http","protocol":"TCP","port":80,"targetPort":10001}]},{"hostname":"svc1-5d8f9","ports":[{"name":"http","protocol":"TCP","port":80,"targetPort":10002}]}]`,
+						},
+					},
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-tunnel-10-1-0-2-80-10001",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "rules",
+							},
+						},
+						Data: map[string]string{
+							consts.TunnelRulesKey: toJson(
+								[]Chain{
+									{
+										Name: "svc1-tunnel-10-1-0-2-80-10001",
+										Bind: []string{
+											":10001",
+										},
+										Proxy: []string{
+											"10.1.0.2:80",
+										},
+									},
+								},
+							),
+						},
+					},
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-tunnel-svc1-5d8f9-80-10002",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "rules",
+							},
+						},
+						Data: map[string]string{
+							consts.TunnelRulesKey: toJson(
+								[]Chain{
+									{
+										Name: "svc1-tunnel-svc1-5d8f9-80-10002",
+										Bind: []string{
+											":10002",
+										},
+										Proxy: []string{
+											"10.1.0.1:80",
+										},
+									},
+								},
+							),
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "self headless with named target port",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							ClusterIP: corev1.ClusterIPNone,
+							Ports: []corev1.ServicePort{
+								{
+									Name:       "http",
+									Port:       80,
+									Protocol:   corev1.ProtocolTCP,
+									TargetPort: intstr.FromString("web"),
+								},
+							},
+						},
+					},
+				},
+				Endpoints: []*corev1.Endpoints{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Subsets: []corev1.EndpointSubset{
+							{
+								Addresses: []corev1.EndpointAddress{
+									{IP: "10.1.0.1", Hostname: "svc1-0"},
+								},
+								Ports: []corev1.EndpointPort{
+									{Name: "http", Port: 8080, Protocol: corev1.ProtocolTCP},
+								},
+							},
+							{
+								Addresses: []corev1.EndpointAddress{
+									{IP: "10.1.0.2", Hostname: "svc1-1"},
+								},
+								Ports: []corev1.EndpointPort{
+									{Name: "http", Port: 9090, Protocol: corev1.ProtocolTCP},
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{
+				"self": {
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-service",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "service",
+							},
+						},
+						Data: map[string]string{
+							"export_hub_name":          "self",
+							"export_service_name":      "svc1",
+							"export_service_namespace": "test",
+							"headless":                 "true",
+							"import_service_name":      "svc1-new",
+							"import_service_namespace": "test",
+							"ports":                    `[{"name":"http","protocol":"TCP","port":80,"targetPort":80}]`,
+							"pods":                     `[{"hostname":"svc1-0","ports":[{"name":"http","protocol":"TCP","port":80,"targetPort":10001}]},{"hostname":"svc1-1","ports":[{"name":"http","protocol":"TCP","port":80,"targetPort":10002}]}]`,
+						},
+					},
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-tunnel-svc1-0-80-10001",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "rules",
+							},
+						},
+						Data: map[string]string{
+							consts.TunnelRulesKey: toJson(
+								[]Chain{
+									{
+										Name: "svc1-tunnel-svc1-0-80-10001",
+										Bind: []string{
+											":10001",
+										},
+										Proxy: []string{
+											"svc1-0.svc1.test.svc:8080",
+										},
+									},
+								},
+							),
+						},
+					},
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-tunnel-svc1-1-80-10002",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "rules",
+							},
+						},
+						Data: map[string]string{
+							consts.TunnelRulesKey: toJson(
+								[]Chain{
+									{
+										Name: "svc1-tunnel-svc1-1-80-10002",
+										Bind: []string{
+											":10002",
+										},
+										Proxy: []string{
+											"svc1-1.svc1.test.svc:9090",
+										},
+									},
+								},
+							),
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "self external name",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							Type:         corev1.ServiceTypeExternalName,
+							ExternalName: "db.example.com",
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{
+				"self": {
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-service",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "service",
+							},
+						},
+						Data: map[string]string{
+							"export_hub_name":          "self",
+							"export_service_name":      "svc1",
+							"export_service_namespace": "test",
+							"import_service_name":      "svc1-new",
+							"import_service_namespace": "test",
+							"ports":                    `[{"name":"http","protocol":"TCP","port":80,"targetPort":10001}]`,
+						},
+					},
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-tunnel-80-10001",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "rules",
+							},
+						},
+						Data: map[string]string{
+							consts.TunnelRulesKey: toJson(
+								[]Chain{
+									{
+										Name: "svc1-tunnel-80-10001",
+										Bind: []string{
+											":10001",
+										},
+										Proxy: []string{
+											"db.example.com:80",
+										},
+									},
+								},
+							),
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "self without selector",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Endpoints: []*corev1.Endpoints{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Subsets: []corev1.EndpointSubset{
+							{
+								Addresses: []corev1.EndpointAddress{
+									{IP: "192.168.0.2"},
+									{IP: "192.168.0.1"},
+								},
+								Ports: []corev1.EndpointPort{
+									{Name: "http", Port: 8080, Protocol: corev1.ProtocolTCP},
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "self",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1-new",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "self",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+
+			want: map[string][]objref.KMetadata{
+				"self": {
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-service",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "service",
+							},
+						},
+						Data: map[string]string{
+							"export_hub_name":          "self",
+							"export_service_name":      "svc1",
+							"export_service_namespace": "test",
+							"import_service_name":      "svc1-new",
+							"import_service_namespace": "test",
+							"ports":                    `[{"name":"http","protocol":"TCP","port":80,"targetPort":10001}]`,
+						},
+					},
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-tunnel-80-10001",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "rules",
+							},
+						},
+						Data: map[string]string{
+							consts.TunnelRulesKey: toJson(
+								[]Chain{
+									{
+										Name: "svc1-tunnel-80-10001",
 										Bind: []string{
 											":10001",
 										},
@@ -294,6 +2599,7 @@ func TestRouter(t *testing.T) {
 							consts.TunnelRulesKey: toJson(
 								[]Chain{
 									{
+										Name: "svc1-tunnel-80-10001",
 										Bind: []string{
 											":10001",
 										},
@@ -464,6 +2770,7 @@ func TestRouter(t *testing.T) {
 							consts.TunnelRulesKey: toJson(
 								[]Chain{
 									{
+										Name: "svc1-tunnel-80-10001",
 										Bind: []string{
 											":10001",
 											"ssh://export@10.0.0.2:8080?identity_file=/var/ferry/ssh/identity&target_hub=import",
@@ -481,6 +2788,171 @@ func TestRouter(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "import reachable with mirror over mtls",
+			args: fakeRouter{
+				Services: []*corev1.Service{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+						},
+						Spec: corev1.ServiceSpec{
+							Ports: []corev1.ServicePort{
+								{
+									Name:     "http",
+									Port:     80,
+									Protocol: corev1.ProtocolTCP,
+								},
+							},
+						},
+					},
+				},
+				Hubs: []*trafficv1alpha2.Hub{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "export",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: false,
+								Address:   "10.0.0.1:8080",
+							},
+						},
+					},
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "import",
+						},
+						Spec: trafficv1alpha2.HubSpec{
+							Gateway: trafficv1alpha2.HubSpecGateway{
+								Reachable: true,
+								Address:   "mtls://10.0.0.2:31444",
+							},
+						},
+					},
+				},
+				Routes: []*trafficv1alpha2.Route{
+					{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1",
+							Namespace: "test",
+							Annotations: map[string]string{
+								consts.AnnotationMirrorKey: "svc1-shadow",
+							},
+						},
+						Spec: trafficv1alpha2.RouteSpec{
+							Import: trafficv1alpha2.RouteSpecRule{
+								HubName: "import",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+							Export: trafficv1alpha2.RouteSpecRule{
+								HubName: "export",
+								Service: trafficv1alpha2.RouteSpecRuleService{
+									Name:      "svc1",
+									Namespace: "test",
+								},
+							},
+						},
+					},
+				},
+			},
+			want: map[string][]objref.KMetadata{
+				"import": {
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "export-authorized",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "authorized",
+							},
+						},
+						Data: map[string]string{
+							"authorized_keys": "export-authorized export@ferryproxy.io",
+							"user":            "export",
+						},
+					},
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-allows-80-10001",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "allows",
+							},
+						},
+						Data: map[string]string{
+							consts.TunnelRulesAllowKey: toJson(
+								map[string]AllowList{
+									"export": {
+										DirectTcpip: permissions.Permission{
+											Allows: []string{
+												"svc1-shadow.test.svc:80",
+											},
+										},
+										TcpipForward: permissions.Permission{
+											Allows: []string{
+												":10001",
+											},
+										},
+									},
+								},
+							),
+						},
+					},
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-service",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "service",
+							},
+						},
+						Data: map[string]string{
+							"export_hub_name":          "export",
+							"export_service_name":      "svc1",
+							"export_service_namespace": "test",
+							"import_service_name":      "svc1",
+							"import_service_namespace": "test",
+							"ports":                    `[{"name":"http","protocol":"TCP","port":80,"targetPort":10001}]`,
+						},
+					},
+				},
+				"export": {
+					&corev1.ConfigMap{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "svc1-tunnel-80-10001",
+							Namespace: "ferry-tunnel-system",
+							Labels: map[string]string{
+								"tunnel.ferryproxy.io/config": "rules",
+							},
+						},
+						Data: map[string]string{
+							consts.TunnelRulesKey: toJson(
+								[]Chain{
+									{
+										Name: "svc1-tunnel-80-10001",
+										Bind: []string{
+											":10001",
+											"mtls://10.0.0.2:31444?server_name=import",
+										},
+										Proxy: []string{
+											"svc1.test.svc:80",
+										},
+										Mirror: &Mirror{
+											Address: "svc1-shadow.test.svc:80",
+											Percent: 100,
+										},
+									},
+								},
+							),
+						},
+					},
+				},
+			},
+		},
 		{
 			name: "proxy reachable",
 			args: fakeRouter{
@@ -583,6 +3055,7 @@ func TestRouter(t *testing.T) {
 							consts.TunnelRulesKey: toJson(
 								[]Chain{
 									{
+										Name: "svc1-tunnel-80-10001",
 										Bind: []string{
 											"unix:///dev/shm/svc1-tunnel-80-10001.socks",
 											"ssh://export@10.0.0.3:8080?identity_file=/var/ferry/ssh/identity&target_hub=proxy",
@@ -626,6 +3099,7 @@ func TestRouter(t *testing.T) {
 							consts.TunnelRulesKey: toJson(
 								[]Chain{
 									{
+										Name: "svc1-tunnel-80-10001",
 										Bind: []string{
 											":10001",
 										},
@@ -701,7 +3175,7 @@ func TestRouter(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := tt.args.BuildResource()
+			router, got, err := tt.args.build()
 			if err != nil {
 				t.Errorf("BuildResource() error = %v", err)
 				return
@@ -710,7 +3184,40 @@ func TestRouter(t *testing.T) {
 				t.Errorf("BuildResource(): got - want + \n%s", diff)
 			}
 
+			var invalid []objref.ObjectRef
+			for ref := range router.InvalidRoutes() {
+				invalid = append(invalid, ref)
+			}
+			if diff := cmp.Diff(invalid, tt.invalid); diff != "" {
+				t.Errorf("InvalidRoutes(): got - want + \n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRouterResourceName(t *testing.T) {
+	router := &Router{namespace: "ferry-system"}
+	routes := []objref.ObjectRef{
+		{Namespace: "ferry-system", Name: "team-a-web"},
+		{Namespace: "team-a", Name: "web"},
+		{Namespace: "team", Name: "a-web"},
+		{Namespace: "team-a-web", Name: "web"},
+	}
+	names := map[string]objref.ObjectRef{}
+	for _, ref := range routes {
+		name := router.resourceName(&trafficv1alpha2.Route{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ref.Name,
+				Namespace: ref.Namespace,
+			},
 		})
+		if other, ok := names[name]; ok {
+			t.Errorf("resourceName() of %s and %s are both %q", ref, other, name)
+		}
+		names[name] = ref
+	}
+	if _, ok := names["team-a-web"]; !ok {
+		t.Errorf("resourceName() of the route in the namespace of ferry should be its name, got %v", names)
 	}
 }
 
@@ -720,12 +3227,18 @@ func toJson(c interface{}) string {
 }
 
 type fakeRouter struct {
-	Services []*corev1.Service
-	Hubs     []*trafficv1alpha2.Hub
-	Routes   []*trafficv1alpha2.Route
+	Services  []*corev1.Service
+	Endpoints []*corev1.Endpoints
+	Hubs      []*trafficv1alpha2.Hub
+	Routes    []*trafficv1alpha2.Route
 }
 
 func (f *fakeRouter) BuildResource() (out map[string][]objref.KMetadata, err error) {
+	_, out, err = f.build()
+	return out, err
+}
+
+func (f *fakeRouter) build() (*Router, map[string][]objref.KMetadata, error) {
 	hubs := map[string]*trafficv1alpha2.Hub{}
 	for _, hub := range f.Hubs {
 		hubs[hub.Name] = hub
@@ -733,6 +3246,7 @@ func (f *fakeRouter) BuildResource() (out map[string][]objref.KMetadata, err err
 
 	fake := &fakeHubInterface{
 		services:  f.Services,
+		endpoints: f.Endpoints,
 		hubs:      hubs,
 		port:      10000,
 		portCache: map[string]int{},
@@ -746,7 +3260,7 @@ func (f *fakeRouter) BuildResource() (out map[string][]objref.KMetadata, err err
 
 	ways, err := solution.CalculateWays(route.Spec.Export.HubName, route.Spec.Import.HubName)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	router := NewRouter(RouterConfig{
@@ -756,11 +3270,13 @@ func (f *fakeRouter) BuildResource() (out map[string][]objref.KMetadata, err err
 		HubInterface:  fake,
 	})
 
-	return router.BuildResource(f.Routes, ways)
+	out, err := router.BuildResource(f.Routes, ways)
+	return router, out, err
 }
 
 type fakeHubInterface struct {
 	services  []*corev1.Service
+	endpoints []*corev1.Endpoints
 	hubs      map[string]*trafficv1alpha2.Hub
 	portCache map[string]int
 	port      int
@@ -770,6 +3286,15 @@ func (f *fakeHubInterface) ListServices(name string) []*corev1.Service {
 	return f.services
 }
 
+func (f *fakeHubInterface) GetEndpoints(hubName string, namespace, name string) (*corev1.Endpoints, bool) {
+	for _, ep := range f.endpoints {
+		if ep.Namespace == namespace && ep.Name == name {
+			return ep, true
+		}
+	}
+	return nil, false
+}
+
 func (f *fakeHubInterface) GetHub(name string) *trafficv1alpha2.Hub {
 	return f.hubs[name]
 }
@@ -788,6 +3313,13 @@ func (f *fakeHubInterface) GetHubGateway(hubName string, forHub string) trafficv
 	return trafficv1alpha2.HubSpecGateway{}
 }
 
+func (f *fakeHubInterface) GetCABundle(hubName string, namespace, kind, name string) ([]byte, error) {
+	if kind == "secret" && name == "ca" {
+		return testCABundle, nil
+	}
+	return nil, fmt.Errorf("%s %s/%s not found", kind, namespace, name)
+}
+
 func (f fakeHubInterface) GetAuthorized(name string) string {
 	return fmt.Sprintf("%s-%s", name, "authorized")
 }
@@ -0,0 +1,117 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
+	"github.com/ferryproxy/ferry/pkg/consts"
+)
+
+// SplitCluster is the cluster of the ports that split the import between the export hubs,
+// the port is shared by the routes of the import service since no hub is named empty.
+const SplitCluster = ""
+
+// TrafficSplit is the weights of the export hubs
+type TrafficSplit map[string]int
+
+// ParseTrafficSplit parses the weights of the export hubs, e.g. "hub-a=90,hub-b=10"
+func ParseTrafficSplit(v string) (TrafficSplit, error) {
+	split := TrafficSplit{}
+	total := 0
+	for _, item := range strings.Split(v, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		hub, weight, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid traffic split %q", item)
+		}
+		w, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(weight), "%"))
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid weight of traffic split %q", item)
+		}
+		split[strings.TrimSpace(hub)] = w
+		total += w
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("invalid traffic split %q: no weight", v)
+	}
+	return split, nil
+}
+
+// EffectiveSplit returns the weights that the connections are split by between the export hubs of the import,
+// the ready is the readiness of the export hubs, and the hubs are split evenly if the split is nil.
+// The hubs without ready endpoints get no connections, and the ready hubs are split evenly if all of their weights are zero,
+// so the import keeps working when the weighted hubs are down. All the weights are zero if no hub is ready.
+func EffectiveSplit(split TrafficSplit, ready map[string]bool) TrafficSplit {
+	out := make(TrafficSplit, len(ready))
+	total := 0
+	for hub, ok := range ready {
+		weight := 1
+		if split != nil {
+			weight = split[hub]
+		}
+		if !ok {
+			weight = 0
+		}
+		out[hub] = weight
+		total += weight
+	}
+	if total == 0 {
+		for hub, ok := range ready {
+			if ok {
+				out[hub] = 1
+			}
+		}
+	}
+	return out
+}
+
+// String returns the percentages of the export hubs, which are all 0% if there is no weight
+func (t TrafficSplit) String() string {
+	total := 0
+	hubs := make([]string, 0, len(t))
+	for hub, weight := range t {
+		hubs = append(hubs, hub)
+		total += weight
+	}
+	sort.Strings(hubs)
+	out := make([]string, 0, len(hubs))
+	for _, hub := range hubs {
+		percent := 0
+		if total != 0 {
+			percent = t[hub] * 100 / total
+		}
+		out = append(out, fmt.Sprintf("%s=%d%%", hub, percent))
+	}
+	return strings.Join(out, ",")
+}
+
+// RouteTrafficSplit returns the traffic split declared by the annotations of the route, nil if there is no split
+func RouteTrafficSplit(route *trafficv1alpha2.Route) (TrafficSplit, error) {
+	v := route.Annotations[consts.AnnotationTrafficSplitKey]
+	if v == "" {
+		return nil, nil
+	}
+	return ParseTrafficSplit(v)
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseTrafficSplit(t *testing.T) {
+	tests := []struct {
+		name       string
+		value      string
+		want       TrafficSplit
+		wantString string
+		wantErr    bool
+	}{
+		{
+			name:       "two hubs",
+			value:      "hub-a=90,hub-b=10",
+			want:       TrafficSplit{"hub-a": 90, "hub-b": 10},
+			wantString: "hub-a=90%,hub-b=10%",
+		},
+		{
+			name:       "percent and spaces",
+			value:      " hub-b = 1%, hub-a=3 ",
+			want:       TrafficSplit{"hub-a": 3, "hub-b": 1},
+			wantString: "hub-a=75%,hub-b=25%",
+		},
+		{
+			name:    "missing weight",
+			value:   "hub-a",
+			wantErr: true,
+		},
+		{
+			name:    "negative weight",
+			value:   "hub-a=-1,hub-b=2",
+			wantErr: true,
+		},
+		{
+			name:    "no weight",
+			value:   "hub-a=0",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTrafficSplit(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTrafficSplit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("ParseTrafficSplit() mismatch (-want +got):\n%s", diff)
+			}
+			if err == nil && got.String() != tt.wantString {
+				t.Errorf("String() = %q, want %q", got.String(), tt.wantString)
+			}
+		})
+	}
+}
+
+func TestEffectiveSplit(t *testing.T) {
+	tests := []struct {
+		name       string
+		split      TrafficSplit
+		ready      map[string]bool
+		wantString string
+	}{
+		{
+			name:       "weighted",
+			split:      TrafficSplit{"hub-a": 90, "hub-b": 10},
+			ready:      map[string]bool{"hub-a": true, "hub-b": true},
+			wantString: "hub-a=90%,hub-b=10%",
+		},
+		{
+			name:       "not in the split",
+			split:      TrafficSplit{"hub-a": 90, "hub-b": 10},
+			ready:      map[string]bool{"hub-a": true, "hub-c": true},
+			wantString: "hub-a=100%,hub-c=0%",
+		},
+		{
+			name:       "not ready",
+			split:      TrafficSplit{"hub-a": 90, "hub-b": 10},
+			ready:      map[string]bool{"hub-a": false, "hub-b": true},
+			wantString: "hub-a=0%,hub-b=100%",
+		},
+		{
+			name:       "even without split",
+			ready:      map[string]bool{"hub-a": true, "hub-b": true},
+			wantString: "hub-a=50%,hub-b=50%",
+		},
+		{
+			name:       "weighted hubs are down",
+			split:      TrafficSplit{"hub-a": 100},
+			ready:      map[string]bool{"hub-a": false, "hub-b": true, "hub-c": true},
+			wantString: "hub-a=0%,hub-b=50%,hub-c=50%",
+		},
+		{
+			name:       "no ready hub",
+			split:      TrafficSplit{"hub-a": 100},
+			ready:      map[string]bool{"hub-a": false, "hub-b": false},
+			wantString: "hub-a=0%,hub-b=0%",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := EffectiveSplit(tt.split, tt.ready).String()
+			if got != tt.wantString {
+				t.Errorf("EffectiveSplit() = %q, want %q", got, tt.wantString)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+
+	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
+	"github.com/ferryproxy/ferry/pkg/consts"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// TLSOrigination encrypts the connections to the first proxy of the chain with TLS
+type TLSOrigination struct {
+	ServerName         string `json:"serverName,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+	// CA is the PEM bundle that verifies the certificate instead of the system roots
+	CA string `json:"ca,omitempty"`
+
+	// caKind and caName is the secret or config map of the CA, which is read into the CA by the router
+	caKind string
+	caName string
+}
+
+// RouteTLSOrigination returns the TLS origination declared by the annotations of the route, nil if the route is not originated with TLS,
+// the server name is empty if it is the host of the origin.
+func RouteTLSOrigination(route *trafficv1alpha2.Route) (*TLSOrigination, error) {
+	v := route.Annotations[consts.AnnotationTLSOriginationKey]
+	if v == "" || v == "false" {
+		return nil, nil
+	}
+	tls := &TLSOrigination{}
+	if v != "true" {
+		if errs := validation.IsDNS1123Subdomain(v); len(errs) != 0 {
+			return nil, fmt.Errorf("invalid %s %q: %s", consts.AnnotationTLSOriginationKey, v, strings.Join(errs, ", "))
+		}
+		tls.ServerName = v
+	}
+	switch skip := route.Annotations[consts.AnnotationTLSInsecureSkipVerifyKey]; skip {
+	case "", "false":
+	case "true":
+		tls.InsecureSkipVerify = true
+	default:
+		return nil, fmt.Errorf("invalid %s %q", consts.AnnotationTLSInsecureSkipVerifyKey, skip)
+	}
+	if ca := route.Annotations[consts.AnnotationTLSCAKey]; ca != "" {
+		kind, name, _ := strings.Cut(ca, "/")
+		if kind != "secret" && kind != "configmap" {
+			return nil, fmt.Errorf("invalid %s %q: not secret/<name> or configmap/<name>", consts.AnnotationTLSCAKey, ca)
+		}
+		if errs := validation.IsDNS1123Subdomain(name); len(errs) != 0 {
+			return nil, fmt.Errorf("invalid %s %q: %s", consts.AnnotationTLSCAKey, ca, strings.Join(errs, ", "))
+		}
+		tls.caKind = kind
+		tls.caName = name
+	}
+	return tls, nil
+}
+
+// resolveTLSCA reads the CA bundle that the TLS origination refers to from the export hub
+func (d *Router) resolveTLSCA(tls *TLSOrigination, namespace string) error {
+	if tls == nil || tls.caName == "" {
+		return nil
+	}
+	data, err := d.hubInterface.GetCABundle(d.exportHubName, namespace, tls.caKind, tls.caName)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", consts.AnnotationTLSCAKey, err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(data) {
+		return fmt.Errorf("invalid %s: no certificate in %s %s/%s", consts.AnnotationTLSCAKey, tls.caKind, namespace, tls.caName)
+	}
+	tls.CA = string(data)
+	return nil
+}
+
+// forOrigin returns the TLS origination for the origin address, the server name is the host of it if it is not declared
+func (t *TLSOrigination) forOrigin(originAddress string) *TLSOrigination {
+	if t == nil || t.ServerName != "" {
+		return t
+	}
+	tls := *t
+	tls.ServerName = originAddress
+	if host, _, err := net.SplitHostPort(originAddress); err == nil {
+		tls.ServerName = host
+	}
+	return &tls
+}
+
+// RouteSNI returns the server names declared by the annotations of the route, nil if the route is not routed by SNI
+func RouteSNI(route *trafficv1alpha2.Route) ([]string, error) {
+	v := route.Annotations[consts.AnnotationSNIKey]
+	if v == "" {
+		return nil, nil
+	}
+	return ParseServerNames(v)
+}
+
+// ParseServerNames parses the server names, e.g. "api.example.com,*.example.org"
+func ParseServerNames(v string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(v, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		var errs []string
+		if strings.HasPrefix(name, "*.") {
+			errs = validation.IsWildcardDNS1123Subdomain(name)
+		} else {
+			errs = validation.IsDNS1123Subdomain(name)
+		}
+		if len(errs) != 0 {
+			return nil, fmt.Errorf("invalid server name %q: %s", name, strings.Join(errs, ", "))
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("invalid %s %q: no server name", consts.AnnotationSNIKey, v)
+	}
+	return names, nil
+}
+
+// SNIRoute routes the TLS connections of the chain to the addresses of the first proxy by the server names
+type SNIRoute struct {
+	Rules []SNIRouteRule `json:"rules,omitempty"`
+	// Default is the indexes of the addresses for the connections that match no rule
+	Default []int `json:"default,omitempty"`
+}
+
+// SNIRouteRule is the rule of the SNIRoute
+type SNIRouteRule struct {
+	// ServerName is the server name, or the wildcard of the subdomains, e.g. "*.example.com"
+	ServerName string `json:"serverName"`
+	// Backends is the indexes of the addresses that the connections are routed to
+	Backends []int `json:"backends"`
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package router
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
+	"github.com/ferryproxy/ferry/pkg/consts"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRouteTLSOrigination(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		want        *TLSOrigination
+		wantErr     bool
+	}{
+		{
+			name: "no tls",
+		},
+		{
+			name: "host of the origin",
+			annotations: map[string]string{
+				consts.AnnotationTLSOriginationKey: "true",
+			},
+			want: &TLSOrigination{},
+		},
+		{
+			name: "server name",
+			annotations: map[string]string{
+				consts.AnnotationTLSOriginationKey:        "api.example.com",
+				consts.AnnotationTLSInsecureSkipVerifyKey: "true",
+			},
+			want: &TLSOrigination{ServerName: "api.example.com", InsecureSkipVerify: true},
+		},
+		{
+			name: "invalid server name",
+			annotations: map[string]string{
+				consts.AnnotationTLSOriginationKey: "api_example",
+			},
+			wantErr: true,
+		},
+		{
+			name: "ca",
+			annotations: map[string]string{
+				consts.AnnotationTLSOriginationKey: "true",
+				consts.AnnotationTLSCAKey:          "configmap/my-ca",
+			},
+			want: &TLSOrigination{caKind: "configmap", caName: "my-ca"},
+		},
+		{
+			name: "invalid ca kind",
+			annotations: map[string]string{
+				consts.AnnotationTLSOriginationKey: "true",
+				consts.AnnotationTLSCAKey:          "vault/my-ca",
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid skip verify",
+			annotations: map[string]string{
+				consts.AnnotationTLSOriginationKey:        "true",
+				consts.AnnotationTLSInsecureSkipVerifyKey: "yes",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := &trafficv1alpha2.Route{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: tt.annotations,
+				},
+			}
+			got, err := RouteTLSOrigination(route)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RouteTLSOrigination() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RouteTLSOrigination() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseServerNames(t *testing.T) {
+	tests := []struct {
+		v       string
+		want    []string
+		wantErr bool
+	}{
+		{v: "api.example.com", want: []string{"api.example.com"}},
+		{v: "API.example.com, *.example.org", want: []string{"api.example.com", "*.example.org"}},
+		{v: ",", wantErr: true},
+		{v: "api.*.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.v, func(t *testing.T) {
+			got, err := ParseServerNames(tt.v)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseServerNames() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseServerNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testCABundle is the PEM of a self-signed certificate
+var testCABundle = func() []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		panic(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}()
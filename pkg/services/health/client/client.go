@@ -18,33 +18,50 @@ package client
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 )
 
+// timeout is the timeout of the request, so that an unreachable tunnel does not block the caller
+const timeout = 5 * time.Second
+
 type Client struct {
 	baseURL string
 	client  http.Client
 }
 
 func NewClient(baseUrl string) *Client {
-	return &Client{baseURL: baseUrl}
+	return &Client{
+		baseURL: baseUrl,
+		client: http.Client{
+			Timeout: timeout,
+		},
+	}
 }
 
-func (c *Client) Get(ctx context.Context) error {
-	resp, err := c.client.Get(c.baseURL + "/health")
+// Get returns an error if it is unhealthy, and decodes the status of the health into out if it is not nil
+func (c *Client) Get(ctx context.Context, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/health", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
 	if resp.StatusCode != http.StatusOK {
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return err
-		}
 		return fmt.Errorf("response %s:\n%s", http.StatusText(resp.StatusCode), string(body))
 	}
-
-	return nil
+	if out == nil || len(body) == 0 {
+		return nil
+	}
+	return json.Unmarshal(body, out)
 }
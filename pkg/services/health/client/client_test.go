@@ -0,0 +1,67 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetCanceled(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := NewClient(server.URL).Get(ctx, nil)
+	if err == nil {
+		t.Fatal("want error of the canceled request")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the request should be canceled with the ctx, took %s", elapsed)
+	}
+}
+
+func TestGet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+		rw.Write([]byte(`{"live":1}`))
+	}))
+	defer server.Close()
+
+	var out struct {
+		Live int `json:"live"`
+	}
+	err := NewClient(server.URL).Get(context.Background(), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Live != 1 {
+		t.Errorf("got %d, want 1", out.Live)
+	}
+}
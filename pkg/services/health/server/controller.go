@@ -17,6 +17,7 @@ limitations under the License.
 package server
 
 import (
+	"encoding/json"
 	"net/http"
 	"sync"
 
@@ -26,6 +27,7 @@ import (
 type Controller struct {
 	mut    sync.Mutex
 	logger logr.Logger
+	check  func() (interface{}, error)
 }
 
 func (c *Controller) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
@@ -42,4 +44,19 @@ func (c *Controller) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 
 // Get GET /health
 func (c *Controller) Get(rw http.ResponseWriter, r *http.Request) {
+	if c.check == nil {
+		return
+	}
+	s, err := c.check()
+	rw.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if s == nil {
+		return
+	}
+	err = json.NewEncoder(rw).Encode(s)
+	if err != nil {
+		c.logger.Error(err, "failed to encode health")
+	}
 }
@@ -22,9 +22,11 @@ import (
 	"github.com/go-logr/logr"
 )
 
-func Serve(mux *http.ServeMux, logger logr.Logger) error {
+// Serve serves the health, the check returns the status to show and an error if it is unhealthy
+func Serve(mux *http.ServeMux, logger logr.Logger, check func() (interface{}, error)) error {
 	c := &Controller{
 		logger: logger,
+		check:  check,
 	}
 	mux.Handle("/health", c)
 	return nil
@@ -21,6 +21,7 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -29,6 +30,11 @@ import (
 	"github.com/ferryproxy/ferry/pkg/services/registry/models"
 )
 
+var (
+	ErrPendingApproval = errors.New("registration is pending approval")
+	ErrDenied          = errors.New("registration is denied")
+)
+
 type Client struct {
 	baseURL string
 	client  http.Client
@@ -71,6 +77,12 @@ func (c *Client) Create(ctx context.Context, hubName string) error {
 		return err
 	}
 	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusAccepted:
+		return ErrPendingApproval
+	case http.StatusForbidden:
+		return ErrDenied
+	}
 	if resp.StatusCode != http.StatusOK {
 		body, err = io.ReadAll(resp.Body)
 		if err != nil {
@@ -95,9 +107,12 @@ func (c *Client) IsExist(ctx context.Context, hubName string) (bool, error) {
 		return false, err
 	}
 	defer resp.Body.Close()
-	if resp.StatusCode == http.StatusNotFound {
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusAccepted {
 		return false, nil
 	}
+	if resp.StatusCode == http.StatusForbidden {
+		return false, ErrDenied
+	}
 	if resp.StatusCode == http.StatusCreated {
 		return true, nil
 	}
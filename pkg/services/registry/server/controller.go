@@ -22,6 +22,8 @@ import (
 	"io"
 	"net/http"
 	"path"
+	"sort"
+	"strings"
 	"sync"
 
 	trafficv1alpha2 "github.com/ferryproxy/api/apis/traffic/v1alpha2"
@@ -38,11 +40,12 @@ import (
 )
 
 type Controller struct {
-	mut           sync.Mutex
-	GetBindPort   func(ctx context.Context) (int32, error)
-	TunnelAddress string
-	Clientset     client.Interface
-	Logger        logr.Logger
+	mut             sync.Mutex
+	GetBindPort     func(ctx context.Context) (int32, error)
+	TunnelAddress   string
+	RequireApproval bool
+	Clientset       client.Interface
+	Logger          logr.Logger
 }
 
 func (c *Controller) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
@@ -63,7 +66,8 @@ func (c *Controller) Head(rw http.ResponseWriter, r *http.Request) {
 	c.mut.Lock()
 	defer c.mut.Unlock()
 
-	ok, err := c.isExistHub(r.Context(), c.getHubName(r))
+	hubName := c.getHubName(r)
+	ok, err := c.isExistHub(r.Context(), hubName)
 	if err != nil {
 		c.Logger.Error(err, "get hub")
 		http.Error(rw, err.Error(), http.StatusInternalServerError)
@@ -71,9 +75,29 @@ func (c *Controller) Head(rw http.ResponseWriter, r *http.Request) {
 	}
 	if ok {
 		rw.WriteHeader(http.StatusCreated)
-	} else {
-		rw.WriteHeader(http.StatusNotFound)
+		return
 	}
+
+	if c.RequireApproval {
+		registration, err := c.getRegistration(r.Context(), hubName)
+		if err != nil {
+			c.Logger.Error(err, "get registration")
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if registration != nil {
+			switch registration.Labels[consts.LabelRegistrationKey] {
+			case consts.LabelRegistrationDeniedValue:
+				rw.WriteHeader(http.StatusForbidden)
+				return
+			case consts.LabelRegistrationApprovedValue:
+			default:
+				rw.WriteHeader(http.StatusAccepted)
+				return
+			}
+		}
+	}
+	rw.WriteHeader(http.StatusNotFound)
 }
 
 // Create POST /hubs/{hub_name}
@@ -107,6 +131,73 @@ func (c *Controller) Create(rw http.ResponseWriter, r *http.Request) {
 	c.mut.Lock()
 	defer c.mut.Unlock()
 
+	ok, err := c.isExistHub(r.Context(), joinHub.HubName)
+	if err != nil {
+		c.Logger.Error(err, "get hub")
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if ok {
+		c.Logger.Info("hub existing", "hub", joinHub.HubName)
+		http.Error(rw, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+	}
+
+	var registration *corev1.Secret
+	if c.RequireApproval {
+		registration, err = c.getRegistration(r.Context(), joinHub.HubName)
+		if err != nil {
+			c.Logger.Error(err, "get registration")
+			http.Error(rw, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		// The denied requester keeps being denied, even after its registration is replaced by another requester
+		deniedKeys := registrationDeniedKeys(registration)
+		if _, ok := deniedKeys[joinHub.AuthorizedKey]; ok {
+			c.Logger.Info("registration denied", "hub", joinHub.HubName)
+			http.Error(rw, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		// A denied registration is replaced by another requester, so the hub name is not blocked forever
+		if registration != nil && registration.Labels[consts.LabelRegistrationKey] == consts.LabelRegistrationDeniedValue {
+			err = client.Delete(r.Context(), c.Logger, c.Clientset, registration)
+			if err != nil {
+				c.Logger.Error(err, "delete registration")
+				http.Error(rw, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			registration = nil
+		}
+
+		if registration == nil {
+			err = c.createRegistration(r.Context(), joinHub, deniedKeys)
+			if err != nil {
+				c.Logger.Error(err, "create registration")
+				http.Error(rw, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			c.Logger.Info("registration pending approval", "hub", joinHub.HubName)
+			rw.WriteHeader(http.StatusAccepted)
+			return
+		}
+
+		// Only the requester that created the registration can complete it
+		if string(registration.Data[registrationAuthorizedKey]) != joinHub.AuthorizedKey {
+			c.Logger.Info("registration is held by another requester", "hub", joinHub.HubName)
+			http.Error(rw, http.StatusText(http.StatusConflict), http.StatusConflict)
+			return
+		}
+
+		switch registration.Labels[consts.LabelRegistrationKey] {
+		case consts.LabelRegistrationApprovedValue:
+		default:
+			rw.WriteHeader(http.StatusAccepted)
+			return
+		}
+	}
+
 	importHubName := consts.ControlPlaneName
 	exportHubName := joinHub.HubName
 
@@ -204,17 +295,6 @@ func (c *Controller) Create(rw http.ResponseWriter, r *http.Request) {
 		},
 	}
 
-	ok, err := c.isExistHub(r.Context(), joinHub.HubName)
-	if err != nil {
-		c.Logger.Error(err, "get hub")
-		http.Error(rw, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if ok {
-		c.Logger.Error(err, "hub existing", "hub", joinHub.HubName)
-		http.Error(rw, http.StatusText(http.StatusConflict), http.StatusConflict)
-	}
-
 	defer func() {
 		if err != nil {
 			ctx := context.Background()
@@ -245,6 +325,13 @@ func (c *Controller) Create(rw http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if registration != nil {
+		err := client.Delete(r.Context(), c.Logger, c.Clientset, registration)
+		if err != nil {
+			c.Logger.Error(err, "Delete registration")
+		}
+	}
+
 	rw.Header().Set("Content-Type", "application/json")
 	rw.Write(repo)
 }
@@ -267,3 +354,67 @@ func (c *Controller) isExistHub(ctx context.Context, hubName string) (bool, erro
 	}
 	return true, nil
 }
+
+const (
+	registrationType                = "traffic.ferryproxy.io/registration"
+	registrationAuthorizedKey       = "authorized_key"
+	registrationDeniedAuthorizedKey = "denied_authorized_keys"
+)
+
+// registrationDeniedKeys returns the keys denied for the hub name, the key of the denied registration
+// and the keys of the registrations it replaced, which are kept one per line
+func registrationDeniedKeys(registration *corev1.Secret) map[string]struct{} {
+	keys := map[string]struct{}{}
+	if registration == nil {
+		return keys
+	}
+	for _, key := range strings.Split(string(registration.Data[registrationDeniedAuthorizedKey]), "\n") {
+		if key != "" {
+			keys[key] = struct{}{}
+		}
+	}
+	if registration.Labels[consts.LabelRegistrationKey] == consts.LabelRegistrationDeniedValue {
+		keys[string(registration.Data[registrationAuthorizedKey])] = struct{}{}
+	}
+	return keys
+}
+
+func (c *Controller) getRegistration(ctx context.Context, hubName string) (*corev1.Secret, error) {
+	secret, err := c.Clientset.
+		Kubernetes().
+		CoreV1().
+		Secrets(consts.FerryNamespace).
+		Get(ctx, hubName+consts.RegistrationSecretSuffix, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return secret, nil
+}
+
+func (c *Controller) createRegistration(ctx context.Context, joinHub models.JoinHub, deniedKeys map[string]struct{}) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      joinHub.HubName + consts.RegistrationSecretSuffix,
+			Namespace: consts.FerryNamespace,
+			Labels: map[string]string{
+				consts.LabelRegistrationKey: consts.LabelRegistrationPendingValue,
+			},
+		},
+		Type: registrationType,
+		Data: map[string][]byte{
+			registrationAuthorizedKey: []byte(joinHub.AuthorizedKey),
+		},
+	}
+	if len(deniedKeys) != 0 {
+		keys := make([]string, 0, len(deniedKeys))
+		for key := range deniedKeys {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		secret.Data[registrationDeniedAuthorizedKey] = []byte(strings.Join(keys, "\n"))
+	}
+	return client.Apply(ctx, c.Logger, c.Clientset, secret)
+}
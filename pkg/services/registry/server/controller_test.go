@@ -0,0 +1,230 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ferryversioned "github.com/ferryproxy/client-go/generated/clientset/versioned"
+	ferryfake "github.com/ferryproxy/client-go/generated/clientset/versioned/fake"
+	"github.com/ferryproxy/ferry/pkg/consts"
+	"github.com/ferryproxy/ferry/pkg/services/registry/models"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	mcsversioned "sigs.k8s.io/mcs-api/pkg/client/clientset/versioned"
+)
+
+type fakeClientset struct {
+	kube  *fake.Clientset
+	ferry *ferryfake.Clientset
+}
+
+func (f *fakeClientset) Kubernetes() kubernetes.Interface {
+	return f.kube
+}
+
+func (f *fakeClientset) Ferry() ferryversioned.Interface {
+	return f.ferry
+}
+
+func (f *fakeClientset) MCS() mcsversioned.Interface {
+	return nil
+}
+
+func registration(key, state string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "hub1" + consts.RegistrationSecretSuffix,
+			Namespace: consts.FerryNamespace,
+			Labels: map[string]string{
+				consts.LabelRegistrationKey: state,
+			},
+		},
+		Type: registrationType,
+		Data: map[string][]byte{
+			registrationAuthorizedKey: []byte(key),
+		},
+	}
+}
+
+// replacedRegistration is the registration that replaced the denied registration of the denied key
+func replacedRegistration(key, state, deniedKey string) *corev1.Secret {
+	secret := registration(key, state)
+	secret.Data[registrationDeniedAuthorizedKey] = []byte(deniedKey)
+	return secret
+}
+
+func TestControllerCreate(t *testing.T) {
+	tests := []struct {
+		name             string
+		registration     *corev1.Secret
+		key              string
+		wantCodes        []int
+		deniedKey        string
+		wantHub          bool
+		wantRegistration string
+	}{
+		{
+			name:             "new",
+			key:              "key1",
+			wantCodes:        []int{http.StatusAccepted},
+			wantRegistration: consts.LabelRegistrationPendingValue,
+		},
+		{
+			name:             "pending",
+			registration:     registration("key1", consts.LabelRegistrationPendingValue),
+			key:              "key1",
+			wantCodes:        []int{http.StatusAccepted},
+			wantRegistration: consts.LabelRegistrationPendingValue,
+		},
+		{
+			name:         "approved",
+			registration: registration("key1", consts.LabelRegistrationApprovedValue),
+			key:          "key1",
+			wantCodes:    []int{http.StatusOK},
+			wantHub:      true,
+		},
+		{
+			name:             "approved for another requester",
+			registration:     registration("key1", consts.LabelRegistrationApprovedValue),
+			key:              "key2",
+			wantCodes:        []int{http.StatusConflict},
+			wantRegistration: consts.LabelRegistrationApprovedValue,
+		},
+		{
+			name:             "denied",
+			registration:     registration("key1", consts.LabelRegistrationDeniedValue),
+			key:              "key1",
+			wantCodes:        []int{http.StatusForbidden},
+			wantRegistration: consts.LabelRegistrationDeniedValue,
+		},
+		{
+			name:             "denied and requested again",
+			registration:     registration("key1", consts.LabelRegistrationDeniedValue),
+			key:              "key1",
+			wantCodes:        []int{http.StatusForbidden, http.StatusForbidden},
+			wantRegistration: consts.LabelRegistrationDeniedValue,
+		},
+		{
+			name:             "denied and replaced by another requester",
+			registration:     registration("key1", consts.LabelRegistrationDeniedValue),
+			key:              "key2",
+			wantCodes:        []int{http.StatusAccepted},
+			wantRegistration: consts.LabelRegistrationPendingValue,
+		},
+		{
+			name:             "denied and requested again after replaced by another requester",
+			registration:     registration("key1", consts.LabelRegistrationDeniedValue),
+			key:              "key2",
+			wantCodes:        []int{http.StatusAccepted},
+			deniedKey:        "key1",
+			wantRegistration: consts.LabelRegistrationPendingValue,
+		},
+		{
+			name:             "denied and requested again after the replacement is approved",
+			registration:     replacedRegistration("key2", consts.LabelRegistrationApprovedValue, "key1"),
+			key:              "key3",
+			wantCodes:        []int{http.StatusConflict},
+			deniedKey:        "key1",
+			wantRegistration: consts.LabelRegistrationApprovedValue,
+		},
+		{
+			name:             "denied twice and replaced by another requester",
+			registration:     replacedRegistration("key2", consts.LabelRegistrationDeniedValue, "key1"),
+			key:              "key3",
+			wantCodes:        []int{http.StatusAccepted},
+			deniedKey:        "key2",
+			wantRegistration: consts.LabelRegistrationPendingValue,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kube := fake.NewSimpleClientset()
+			if tt.registration != nil {
+				kube = fake.NewSimpleClientset(tt.registration)
+			}
+			clientset := &fakeClientset{
+				kube:  kube,
+				ferry: ferryfake.NewSimpleClientset(),
+			}
+			c := &Controller{
+				GetBindPort: func(ctx context.Context) (int32, error) {
+					return 10000, nil
+				},
+				TunnelAddress:   "10.0.0.1:31000",
+				RequireApproval: true,
+				Clientset:       clientset,
+				Logger:          logr.Discard(),
+			}
+
+			request := func(key string) *httptest.ResponseRecorder {
+				body, _ := json.Marshal(models.JoinHub{
+					HubName:       "hub1",
+					AuthorizedKey: key,
+					Token:         "token",
+				})
+				r := httptest.NewRequest(http.MethodPost, "/hubs/hub1", strings.NewReader(string(body)))
+				rw := httptest.NewRecorder()
+				c.ServeHTTP(rw, r)
+				return rw
+			}
+			for i, want := range tt.wantCodes {
+				rw := request(tt.key)
+				if rw.Code != want {
+					t.Fatalf("request %d: got code %d, want %d: %s", i, rw.Code, want, rw.Body.String())
+				}
+			}
+			// The denied requester is still denied after the registration is replaced
+			if tt.deniedKey != "" {
+				rw := request(tt.deniedKey)
+				if rw.Code != http.StatusForbidden {
+					t.Fatalf("denied request: got code %d, want %d: %s", rw.Code, http.StatusForbidden, rw.Body.String())
+				}
+			}
+
+			ctx := context.Background()
+			_, err := clientset.ferry.TrafficV1alpha2().Hubs(consts.FerryNamespace).Get(ctx, "hub1", metav1.GetOptions{})
+			if gotHub := err == nil; gotHub != tt.wantHub {
+				t.Errorf("got hub %v, want %v: %v", gotHub, tt.wantHub, err)
+			}
+
+			got, err := c.getRegistration(ctx, "hub1")
+			if err != nil && !errors.IsNotFound(err) {
+				t.Fatal(err)
+			}
+			var gotRegistration string
+			if got != nil {
+				gotRegistration = got.Labels[consts.LabelRegistrationKey]
+				if string(got.Data[registrationAuthorizedKey]) != tt.key && tt.wantCodes[0] != http.StatusConflict {
+					t.Errorf("got registration key %q, want %q", got.Data[registrationAuthorizedKey], tt.key)
+				}
+			}
+			if gotRegistration != tt.wantRegistration {
+				t.Errorf("got registration %q, want %q", gotRegistration, tt.wantRegistration)
+			}
+		})
+	}
+}
@@ -25,16 +25,17 @@ import (
 	rest "k8s.io/client-go/rest"
 )
 
-func Serve(mux *http.ServeMux, logger logr.Logger, config *rest.Config, address string, getBindPort func(ctx context.Context) (int32, error)) error {
+func Serve(mux *http.ServeMux, logger logr.Logger, config *rest.Config, address string, requireApproval bool, getBindPort func(ctx context.Context) (int32, error)) error {
 	clientset, err := client.NewForConfig(config)
 	if err != nil {
 		return err
 	}
 	c := &Controller{
-		Clientset:     clientset,
-		TunnelAddress: address,
-		Logger:        logger,
-		GetBindPort:   getBindPort,
+		Clientset:       clientset,
+		TunnelAddress:   address,
+		RequireApproval: requireApproval,
+		Logger:          logger,
+		GetBindPort:     getBindPort,
 	}
 	mux.Handle("/hubs/", c)
 	return nil
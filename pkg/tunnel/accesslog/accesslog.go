@@ -0,0 +1,123 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package accesslog
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Entry is the access log of a connection
+type Entry struct {
+	Time time.Time `json:"time"`
+	// Tunnel is the name of the tunnel that the connection goes through
+	Tunnel string `json:"tunnel,omitempty"`
+	// Source is the address of the client
+	Source string `json:"source"`
+	// User is the ssh user that is authenticated, it is the name of the hub
+	User string `json:"user,omitempty"`
+	// PeerHub is the hub that the connection goes to or comes from
+	PeerHub     string `json:"peerHub,omitempty"`
+	Destination string `json:"destination"`
+	// BytesSent is the bytes from the source to the destination
+	BytesSent int64 `json:"bytesSent"`
+	// BytesReceived is the bytes from the destination to the source
+	BytesReceived int64  `json:"bytesReceived"`
+	Duration      string `json:"duration"`
+	Error         string `json:"error,omitempty"`
+}
+
+// Logger writes the access logs in JSON lines
+type Logger struct {
+	mut    sync.Mutex
+	enc    *json.Encoder
+	sample float64
+}
+
+// NewLogger returns a new Logger, the sample is the ratio of the connections to log in [0, 1]
+func NewLogger(w io.Writer, sample float64) *Logger {
+	return &Logger{
+		enc:    json.NewEncoder(w),
+		sample: sample,
+	}
+}
+
+// Open opens the file for the access logs, "-" or "stdout" is the stdout
+func Open(path string) (io.Writer, error) {
+	switch path {
+	case "-", "stdout":
+		return os.Stdout, nil
+	case "stderr":
+		return os.Stderr, nil
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+}
+
+// Sampled returns true if the connection should be logged
+func (l *Logger) Sampled() bool {
+	if l == nil || l.sample <= 0 {
+		return false
+	}
+	return l.sample >= 1 || rand.Float64() < l.sample
+}
+
+// Log writes the entry
+func (l *Logger) Log(e Entry) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	l.enc.Encode(e)
+}
+
+// Conn counts the bytes of the connection
+type Conn struct {
+	io.ReadWriteCloser
+	read    int64
+	written int64
+}
+
+func NewConn(conn io.ReadWriteCloser) *Conn {
+	return &Conn{
+		ReadWriteCloser: conn,
+	}
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	atomic.AddInt64(&c.read, int64(n))
+	return n, err
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	atomic.AddInt64(&c.written, int64(n))
+	return n, err
+}
+
+// BytesRead returns the bytes read from the connection
+func (c *Conn) BytesRead() int64 {
+	return atomic.LoadInt64(&c.read)
+}
+
+// BytesWritten returns the bytes written to the connection
+func (c *Conn) BytesWritten() int64 {
+	return atomic.LoadInt64(&c.written)
+}
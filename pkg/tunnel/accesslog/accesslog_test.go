@@ -0,0 +1,100 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestLoggerSampled(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample float64
+		want   bool
+	}{
+		{
+			name:   "disabled",
+			sample: 0,
+			want:   false,
+		},
+		{
+			name:   "all",
+			sample: 1,
+			want:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLogger(io.Discard, tt.sample)
+			if got := l.Sampled(); got != tt.want {
+				t.Errorf("Sampled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	var l *Logger
+	if l.Sampled() {
+		t.Errorf("nil logger should not be sampled")
+	}
+}
+
+func TestLoggedConn(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	l := NewLogger(buf, 1)
+
+	c1, c2 := net.Pipe()
+	conn := newLoggedConn(l, c1, Entry{
+		Source:      "10.0.0.1:1234",
+		User:        "cluster-1",
+		PeerHub:     "cluster-1",
+		Destination: "10.0.0.2:80",
+	}, false)
+
+	go func() {
+		b := make([]byte, 5)
+		io.ReadFull(c2, b)
+		c2.Write([]byte("hi"))
+	}()
+	conn.Write([]byte("hello"))
+	io.ReadFull(conn, make([]byte, 2))
+	conn.Close()
+	conn.Close()
+
+	var entries []Entry
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var e Entry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	e := entries[0]
+	if e.BytesSent != 5 || e.BytesReceived != 2 {
+		t.Errorf("got sent %d received %d, want sent 5 received 2", e.BytesSent, e.BytesReceived)
+	}
+	if e.User != "cluster-1" || e.Destination != "10.0.0.2:80" || e.Source != "10.0.0.1:1234" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+}
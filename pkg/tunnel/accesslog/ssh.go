@@ -0,0 +1,178 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package accesslog
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/wzshiming/sshd"
+	"github.com/wzshiming/sshd/directstreamlocal"
+	"github.com/wzshiming/sshd/directtcp"
+	"github.com/wzshiming/sshd/streamlocalforward"
+	"github.com/wzshiming/sshd/tcpforward"
+	"golang.org/x/crypto/ssh"
+)
+
+// RegisterSSH replaces the forwarding handlers of the ssh server with the ones that log the connections,
+// the user of the ssh connection is the name of the hub that is authenticated.
+// It should be called before the ssh server is served.
+func RegisterSSH(l *Logger) {
+	directTCP := &directtcp.DirectTCP{}
+	sshd.RegistryHandleChannel("direct-tcpip", func(ctx context.Context, newChan ssh.NewChannel, serverConn *sshd.ServerConn) {
+		directTCP.Handle(ctx, newChan, l.wrapServerConn(serverConn))
+	})
+	directStreamLocal := &directstreamlocal.DirectStreamLocal{}
+	sshd.RegistryHandleChannel("direct-streamlocal@openssh.com", func(ctx context.Context, newChan ssh.NewChannel, serverConn *sshd.ServerConn) {
+		directStreamLocal.Handle(ctx, newChan, l.wrapServerConn(serverConn))
+	})
+	tcpForward := &tcpforward.TCPForward{}
+	sshd.RegistryHandleRequest("tcpip-forward", func(ctx context.Context, req *ssh.Request, serverConn *sshd.ServerConn) {
+		tcpForward.Forward(ctx, req, l.wrapServerConn(serverConn))
+	})
+	sshd.RegistryHandleRequest("cancel-tcpip-forward", tcpForward.Cancel)
+	streamLocalForward := &streamlocalforward.StreamLocalForward{}
+	sshd.RegistryHandleRequest("streamlocal-forward@openssh.com", func(ctx context.Context, req *ssh.Request, serverConn *sshd.ServerConn) {
+		streamLocalForward.Forward(ctx, req, l.wrapServerConn(serverConn))
+	})
+	sshd.RegistryHandleRequest("cancel-streamlocal-forward@openssh.com", streamLocalForward.Cancel)
+}
+
+func (l *Logger) wrapServerConn(serverConn *sshd.ServerConn) *sshd.ServerConn {
+	conn := *serverConn
+	user := serverConn.User()
+	source := serverConn.RemoteAddr().String()
+
+	proxyDial := serverConn.ProxyDial
+	if proxyDial == nil {
+		var dialer net.Dialer
+		proxyDial = dialer.DialContext
+	}
+	conn.ProxyDial = func(ctx context.Context, network, address string) (net.Conn, error) {
+		entry := Entry{
+			Source:      source,
+			User:        user,
+			PeerHub:     user,
+			Destination: address,
+		}
+		c, err := proxyDial(ctx, network, address)
+		if err != nil {
+			if l.Sampled() {
+				entry.Time = time.Now()
+				entry.Duration = "0s"
+				entry.Error = err.Error()
+				l.Log(entry)
+			}
+			return nil, err
+		}
+		if !l.Sampled() {
+			return c, nil
+		}
+		return newLoggedConn(l, c, entry, false), nil
+	}
+
+	proxyListen := serverConn.ProxyListen
+	if proxyListen == nil {
+		var listenConfig net.ListenConfig
+		proxyListen = listenConfig.Listen
+	}
+	conn.ProxyListen = func(ctx context.Context, network, address string) (net.Listener, error) {
+		listener, err := proxyListen(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		return &loggedListener{
+			Listener: listener,
+			logger:   l,
+			user:     user,
+			address:  address,
+		}, nil
+	}
+	return &conn
+}
+
+type loggedListener struct {
+	net.Listener
+	logger  *Logger
+	user    string
+	address string
+}
+
+func (l *loggedListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil || !l.logger.Sampled() {
+		return c, err
+	}
+	entry := Entry{
+		Source:      c.RemoteAddr().String(),
+		User:        l.user,
+		PeerHub:     l.user,
+		Destination: l.address,
+	}
+	return newLoggedConn(l.logger, c, entry, true), nil
+}
+
+// loggedConn logs the entry when it is closed
+type loggedConn struct {
+	net.Conn
+	counter *Conn
+	logger  *Logger
+	entry   Entry
+	start   time.Time
+	// accepted is true if the connection comes from the source, otherwise it goes to the destination
+	accepted bool
+	once     sync.Once
+}
+
+func newLoggedConn(l *Logger, c net.Conn, entry Entry, accepted bool) *loggedConn {
+	return &loggedConn{
+		Conn:     c,
+		counter:  NewConn(c),
+		logger:   l,
+		entry:    entry,
+		start:    time.Now(),
+		accepted: accepted,
+	}
+}
+
+func (c *loggedConn) Read(p []byte) (int, error) {
+	return c.counter.Read(p)
+}
+
+func (c *loggedConn) Write(p []byte) (int, error) {
+	return c.counter.Write(p)
+}
+
+func (c *loggedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(func() {
+		entry := c.entry
+		entry.Time = c.start
+		entry.Duration = time.Since(c.start).String()
+		if c.accepted {
+			entry.BytesSent = c.counter.BytesRead()
+			entry.BytesReceived = c.counter.BytesWritten()
+		} else {
+			entry.BytesSent = c.counter.BytesWritten()
+			entry.BytesReceived = c.counter.BytesRead()
+		}
+		c.logger.Log(entry)
+	})
+	return err
+}
@@ -18,7 +18,9 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"reflect"
+	"sort"
 	"sync"
 	"time"
 
@@ -38,11 +40,15 @@ import (
 type DiscoveryController struct {
 	mut           sync.Mutex
 	ctx           context.Context
-	ips           []string
+	endpoints     []discovery.Endpoint
 	namespace     string
 	labelSelector string
-	cache         map[objref.ObjectRef]map[string][]discovery.MappingPort
+	endpointSlice bool
+	cache         map[objref.ObjectRef]map[string]discovery.Service
 	cacheDiscover []objref.KMetadata
+	// local is the ready endpoints of each EndpointSlice of the local services
+	local         map[objref.ObjectRef]map[string]int
+	localInformer cache.SharedIndexInformer
 	clientset     client.Interface
 	logger        logr.Logger
 	try           *trybuffer.TryBuffer
@@ -53,12 +59,16 @@ type DiscoveryControllerConfig struct {
 	LabelSelector string
 	Logger        logr.Logger
 	Clientset     client.Interface
+	// EndpointSlice uses EndpointSlices instead of Endpoints for the imported services
+	EndpointSlice bool
 }
 
 func NewDiscoveryController(conf *DiscoveryControllerConfig) *DiscoveryController {
 	return &DiscoveryController{
-		cache:         map[objref.ObjectRef]map[string][]discovery.MappingPort{},
+		cache:         map[objref.ObjectRef]map[string]discovery.Service{},
+		local:         map[objref.ObjectRef]map[string]int{},
 		labelSelector: conf.LabelSelector,
+		endpointSlice: conf.EndpointSlice,
 		namespace:     conf.Namespace,
 		clientset:     conf.Clientset,
 		logger:        conf.Logger,
@@ -129,17 +139,17 @@ func (s *DiscoveryController) onDelete(obj interface{}) {
 	s.Del(cm)
 }
 
-func (s *DiscoveryController) UpdateIPs(ips []string) {
+func (s *DiscoveryController) UpdateEndpoints(endpoints []discovery.Endpoint) {
 	s.mut.Lock()
 	defer s.mut.Unlock()
-	s.logger.Info("update ips",
-		"old", s.ips,
-		"ips", ips,
+	s.logger.Info("update endpoints",
+		"old", s.endpoints,
+		"endpoints", endpoints,
 	)
-	if len(ips) == 0 || reflect.DeepEqual(s.ips, ips) {
+	if len(endpoints) == 0 || reflect.DeepEqual(s.endpoints, endpoints) {
 		return
 	}
-	s.ips = ips
+	s.endpoints = endpoints
 	s.sync()
 }
 
@@ -150,7 +160,7 @@ func (s *DiscoveryController) Add(cm *corev1.ConfigMap) {
 		return
 	}
 
-	s.add(cm.Name, data.ImportServiceNamespace, data.ImportServiceName, data.Ports)
+	s.add(cm.Name, data)
 	s.try.Try()
 }
 
@@ -164,17 +174,17 @@ func (s *DiscoveryController) Del(cm *corev1.ConfigMap) {
 	s.try.Try()
 }
 
-func (s *DiscoveryController) add(export string, namespace, name string, ports []discovery.MappingPort) {
+func (s *DiscoveryController) add(export string, data discovery.Service) {
 	svc := objref.ObjectRef{
-		Name:      name,
-		Namespace: namespace,
+		Name:      data.ImportServiceName,
+		Namespace: data.ImportServiceNamespace,
 	}
 
 	if s.cache[svc] == nil {
-		s.cache[svc] = map[string][]discovery.MappingPort{}
+		s.cache[svc] = map[string]discovery.Service{}
 	}
 
-	s.cache[svc][export] = ports
+	s.cache[svc][export] = data
 }
 
 func (s *DiscoveryController) delete(export string, namespace, name string) {
@@ -195,19 +205,60 @@ var labelsConfigMap = map[string]string{
 }
 
 func (s *DiscoveryController) sync() {
-	if len(s.ips) == 0 {
+	if len(s.endpoints) == 0 {
 		return
 	}
+	ips := make([]string, 0, len(s.endpoints))
+	for _, ep := range s.endpoints {
+		ips = append(ips, ep.IP)
+	}
 	resources := []objref.KMetadata{}
+	headless := map[objref.ObjectRef]map[string]discovery.Service{}
 	for obj, item := range s.cache {
+		if len(item) == 0 {
+			continue
+		}
+		if isHeadless(item) {
+			headless[obj] = item
+			continue
+		}
 		meta := metav1.ObjectMeta{
 			Name:      obj.Name,
 			Namespace: obj.Namespace,
 			Labels:    labelsConfigMap,
 		}
-		if len(item) > 0 {
-			resources = append(resources, discovery.BuildServiceDiscovery(meta, s.ips, item)...)
+		if isFallback(item) {
+			// The tunnel is added to the local service only if it has no ready endpoints
+			s.watchLocal()
+			if !s.localInformer.HasSynced() || s.localReady(obj) {
+				continue
+			}
+			resources = append(resources, s.buildFallbackDiscovery(meta, item)...)
+			continue
+		}
+		if isSplit(item) {
+			// The service points to the split ports of the tunnel instead of the peer ports of the export hubs
+			ports, rules, err := buildSplit(s.namespace, obj, item)
+			if err != nil {
+				s.logger.Error(err, "failed to build split",
+					"service", obj,
+				)
+				continue
+			}
+			resources = append(resources, rules)
+			item = map[string]discovery.Service{
+				rules.Name: {
+					ExportHubName: "split",
+					Ports:         ports,
+					NotReady:      isNotReady(item),
+				},
+			}
 		}
+		resources = append(resources, s.buildServiceDiscovery(meta, ips, item)...)
+	}
+
+	for obj, item := range headless {
+		resources = append(resources, s.buildHeadlessServiceDiscovery(obj, ips, item)...)
 	}
 
 	deleted := diffobjs.ShouldDeleted(s.cacheDiscover, resources)
@@ -228,3 +279,141 @@ func (s *DiscoveryController) sync() {
 		}
 	}
 }
+
+func (s *DiscoveryController) buildServiceDiscovery(meta metav1.ObjectMeta, ips []string, item map[string]discovery.Service) []objref.KMetadata {
+	if s.endpointSlice {
+		exports := make([]string, 0, len(item))
+		for export := range item {
+			exports = append(exports, export)
+		}
+		sort.Strings(exports)
+		exportPorts := map[string][]discovery.MappingPort{}
+		// The hub is not ready only if none of its exports is ready
+		readyHubs := map[string]bool{}
+		for _, export := range exports {
+			data := item[export]
+			exportPorts[data.ExportHubName] = append(exportPorts[data.ExportHubName], data.Ports...)
+			readyHubs[data.ExportHubName] = readyHubs[data.ExportHubName] || !data.NotReady
+		}
+		notReady := map[string]bool{}
+		for hub, ready := range readyHubs {
+			notReady[hub] = !ready
+		}
+		return discovery.BuildServiceDiscoveryWithEndpointSlices(meta, s.endpoints, exportPorts, notReady)
+	}
+	mappingPorts := map[string][]discovery.MappingPort{}
+	notReady := map[string]bool{}
+	for export, data := range item {
+		mappingPorts[export] = data.Ports
+		notReady[export] = data.NotReady
+	}
+	return discovery.BuildServiceDiscovery(meta, ips, mappingPorts, notReady)
+}
+
+// buildHeadlessServiceDiscovery builds a service for each pod that proxies to the pod through the tunnel,
+// and a headless service that resolves the hostname of the pod to the cluster IP of the pod service,
+// the pods of the export hub that has no ready endpoints are not ready.
+func (s *DiscoveryController) buildHeadlessServiceDiscovery(obj objref.ObjectRef, ips []string, item map[string]discovery.Service) []objref.KMetadata {
+	exports := make([]string, 0, len(item))
+	for export := range item {
+		exports = append(exports, export)
+	}
+	sort.Strings(exports)
+	// The ready exports come first, so the pod of the same hostname is served by the ready hub
+	sort.SliceStable(exports, func(i, j int) bool {
+		return !item[exports[i]].NotReady && item[exports[j]].NotReady
+	})
+
+	resources := []objref.KMetadata{}
+	ports := []discovery.MappingPort{}
+	pods := []discovery.PodAddress{}
+	uniq := map[string]struct{}{}
+	for _, export := range exports {
+		data := item[export]
+		if len(ports) == 0 {
+			ports = data.Ports
+		}
+		for _, pod := range data.Pods {
+			if _, ok := uniq[pod.Hostname]; ok {
+				continue
+			}
+			uniq[pod.Hostname] = struct{}{}
+
+			meta := metav1.ObjectMeta{
+				Name:      discovery.PodServiceName(obj.Name, pod.Hostname),
+				Namespace: obj.Namespace,
+				Labels:    labelsConfigMap,
+			}
+			podResources := s.buildServiceDiscovery(meta, ips, map[string]discovery.Service{
+				export: {
+					ExportHubName: data.ExportHubName,
+					Ports:         pod.Ports,
+					NotReady:      data.NotReady,
+				},
+			})
+			resources = append(resources, podResources...)
+
+			// The cluster IP is allocated on creation, so the pod service is applied before the headless service
+			clusterIP, err := s.applyAndGetClusterIP(podResources)
+			if err != nil {
+				s.logger.Error(err, "failed to get cluster ip",
+					"service", objref.KRef(meta.Namespace, meta.Name),
+				)
+				continue
+			}
+			pods = append(pods, discovery.PodAddress{
+				Hostname: pod.Hostname,
+				IP:       clusterIP,
+				NotReady: data.NotReady,
+			})
+		}
+	}
+
+	meta := metav1.ObjectMeta{
+		Name:      obj.Name,
+		Namespace: obj.Namespace,
+		Labels:    labelsConfigMap,
+	}
+	return append(resources, discovery.BuildHeadlessServiceDiscovery(meta, pods, ports, s.endpointSlice)...)
+}
+
+func (s *DiscoveryController) applyAndGetClusterIP(resources []objref.KMetadata) (string, error) {
+	for _, r := range resources {
+		err := client.Apply(s.ctx, s.logger, s.clientset, r)
+		if err != nil {
+			return "", err
+		}
+	}
+	svc := resources[0]
+	ori, err := s.clientset.
+		Kubernetes().
+		CoreV1().
+		Services(svc.GetNamespace()).
+		Get(s.ctx, svc.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	if ori.Spec.ClusterIP == "" || ori.Spec.ClusterIP == corev1.ClusterIPNone {
+		return "", fmt.Errorf("service %s has no cluster ip", objref.KObj(ori))
+	}
+	return ori.Spec.ClusterIP, nil
+}
+
+// isNotReady returns true if none of the exports has ready endpoints
+func isNotReady(item map[string]discovery.Service) bool {
+	for _, data := range item {
+		if !data.NotReady {
+			return false
+		}
+	}
+	return true
+}
+
+func isHeadless(item map[string]discovery.Service) bool {
+	for _, data := range item {
+		if data.Headless {
+			return true
+		}
+	}
+	return false
+}
@@ -25,71 +25,109 @@ import (
 	"time"
 
 	"github.com/ferryproxy/ferry/pkg/client"
+	"github.com/ferryproxy/ferry/pkg/router/discovery"
 	"github.com/ferryproxy/ferry/pkg/utils/trybuffer"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
 )
 
 type EndpointWatcher struct {
-	mut       sync.Mutex
-	lastIPs   []string
-	try       *trybuffer.TryBuffer
-	name      string
-	namespace string
-	clientset client.Interface
-	syncFunc  func(ips []string)
+	mut           sync.Mutex
+	lastEndpoints []discovery.Endpoint
+	try           *trybuffer.TryBuffer
+	name          string
+	namespace     string
+	endpointSlice bool
+	clientset     client.Interface
+	syncFunc      func(endpoints []discovery.Endpoint)
 }
 
 type EndpointWatcherConfig struct {
 	Name      string
 	Namespace string
 	Clientset client.Interface
-	SyncFunc  func(ips []string)
+	// EndpointSlice watches the EndpointSlices of the service instead of the Endpoints
+	EndpointSlice bool
+	SyncFunc      func(endpoints []discovery.Endpoint)
 }
 
 func NewEndpointWatcher(conf *EndpointWatcherConfig) *EndpointWatcher {
 	return &EndpointWatcher{
-		name:      conf.Name,
-		namespace: conf.Namespace,
-		clientset: conf.Clientset,
-		syncFunc:  conf.SyncFunc,
+		name:          conf.Name,
+		namespace:     conf.Namespace,
+		clientset:     conf.Clientset,
+		endpointSlice: conf.EndpointSlice,
+		syncFunc:      conf.SyncFunc,
 	}
 }
 
 func (e *EndpointWatcher) Run(ctx context.Context) error {
-	fieldSelector := fmt.Sprintf("metadata.name=%s", e.name)
-	watch, err := e.clientset.
-		Kubernetes().
-		CoreV1().
-		Endpoints(e.namespace).
-		Watch(ctx, metav1.ListOptions{
-			FieldSelector: fieldSelector,
-		})
+	var (
+		w   watch.Interface
+		err error
+	)
+	if e.endpointSlice {
+		w, err = e.clientset.
+			Kubernetes().
+			DiscoveryV1().
+			EndpointSlices(e.namespace).
+			Watch(ctx, metav1.ListOptions{
+				LabelSelector: fmt.Sprintf("%s=%s", discoveryv1.LabelServiceName, e.name),
+			})
+	} else {
+		w, err = e.clientset.
+			Kubernetes().
+			CoreV1().
+			Endpoints(e.namespace).
+			Watch(ctx, metav1.ListOptions{
+				FieldSelector: fmt.Sprintf("metadata.name=%s", e.name),
+			})
+	}
 	if err != nil {
 		return fmt.Errorf("failed to watch service: %w", err)
 	}
 
 	e.try = trybuffer.NewTryBuffer(e.sync, time.Second/10)
 
+	// The endpoints of each EndpointSlice, a service may have more than one slice
+	slices := map[string][]discovery.Endpoint{}
 	for {
 		select {
 		case <-ctx.Done():
 			e.try.Close()
 			return nil
-		case event, ok := <-watch.ResultChan():
+		case event, ok := <-w.ResultChan():
 			if !ok {
 				return nil
 			}
-			ep := event.Object.(*corev1.Endpoints)
-			ips := getIPs(ep)
-			if len(ips) == 0 {
+			var endpoints []discovery.Endpoint
+			switch obj := event.Object.(type) {
+			case *corev1.Endpoints:
+				endpoints = getEndpoints(obj)
+			case *discoveryv1.EndpointSlice:
+				if event.Type == watch.Deleted {
+					delete(slices, obj.Name)
+				} else {
+					slices[obj.Name] = getEndpointsFromSlice(obj)
+				}
+				for _, eps := range slices {
+					endpoints = append(endpoints, eps...)
+				}
+			default:
+				continue
+			}
+			if len(endpoints) == 0 {
 				continue
 			}
-			sort.Strings(ips)
+			sort.Slice(endpoints, func(i, j int) bool {
+				return endpoints[i].IP < endpoints[j].IP
+			})
 
 			e.mut.Lock()
-			if !reflect.DeepEqual(e.lastIPs, ips) {
-				e.lastIPs = ips
+			if !reflect.DeepEqual(e.lastEndpoints, endpoints) {
+				e.lastEndpoints = endpoints
 				e.try.Try()
 			}
 			e.mut.Unlock()
@@ -100,15 +138,43 @@ func (e *EndpointWatcher) Run(ctx context.Context) error {
 func (e *EndpointWatcher) sync() {
 	e.mut.Lock()
 	defer e.mut.Unlock()
-	e.syncFunc(e.lastIPs)
+	e.syncFunc(e.lastEndpoints)
 }
 
-func getIPs(e *corev1.Endpoints) []string {
-	var ips []string
+func getEndpoints(e *corev1.Endpoints) []discovery.Endpoint {
+	var endpoints []discovery.Endpoint
 	for _, subset := range e.Subsets {
 		for _, address := range subset.Addresses {
-			ips = append(ips, address.IP)
+			ep := discovery.Endpoint{
+				IP: address.IP,
+			}
+			if address.NodeName != nil {
+				ep.NodeName = *address.NodeName
+			}
+			endpoints = append(endpoints, ep)
+		}
+	}
+	return endpoints
+}
+
+func getEndpointsFromSlice(s *discoveryv1.EndpointSlice) []discovery.Endpoint {
+	var endpoints []discovery.Endpoint
+	for _, endpoint := range s.Endpoints {
+		if endpoint.Conditions.Ready != nil && !*endpoint.Conditions.Ready {
+			continue
+		}
+		for _, address := range endpoint.Addresses {
+			ep := discovery.Endpoint{
+				IP: address,
+			}
+			if endpoint.NodeName != nil {
+				ep.NodeName = *endpoint.NodeName
+			}
+			if endpoint.Zone != nil {
+				ep.Zone = *endpoint.Zone
+			}
+			endpoints = append(endpoints, ep)
 		}
 	}
-	return ips
+	return endpoints
 }
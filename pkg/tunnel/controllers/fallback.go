@@ -0,0 +1,144 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"github.com/ferryproxy/ferry/pkg/router/discovery"
+	"github.com/ferryproxy/ferry/pkg/utils/objref"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// localManagedBy is the manager of the EndpointSlices of the local services
+const localManagedBy = "endpointslice-controller.k8s.io"
+
+// isFallback returns true if the import is the fallback of the local service
+func isFallback(item map[string]discovery.Service) bool {
+	for _, data := range item {
+		if data.Fallback {
+			return true
+		}
+	}
+	return false
+}
+
+// watchLocal starts to watch the ready endpoints of the local services, only once the first fallback is imported
+func (s *DiscoveryController) watchLocal() {
+	if s.localInformer != nil {
+		return
+	}
+	informer := informers.NewSharedInformerFactoryWithOptions(s.clientset.Kubernetes(), 0,
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = discoveryv1.LabelManagedBy + "=" + localManagedBy
+		}),
+	).Discovery().V1().EndpointSlices().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: s.onLocalUpdate,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			s.onLocalUpdate(newObj)
+		},
+		DeleteFunc: s.onLocalDelete,
+	})
+	s.localInformer = informer
+	go informer.Run(s.ctx.Done())
+	go func() {
+		if cache.WaitForCacheSync(s.ctx.Done(), informer.HasSynced) {
+			s.try.Try()
+		}
+	}()
+}
+
+func (s *DiscoveryController) onLocalUpdate(obj interface{}) {
+	slice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return
+	}
+	svc := objref.ObjectRef{
+		Name:      slice.Labels[discoveryv1.LabelServiceName],
+		Namespace: slice.Namespace,
+	}
+	ready := len(getEndpointsFromSlice(slice))
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.local[svc] == nil {
+		s.local[svc] = map[string]int{}
+	}
+	if s.local[svc][slice.Name] == ready {
+		return
+	}
+	s.local[svc][slice.Name] = ready
+	if s.cache[svc] != nil {
+		s.try.Try()
+	}
+}
+
+func (s *DiscoveryController) onLocalDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	slice, ok := obj.(*discoveryv1.EndpointSlice)
+	if !ok {
+		return
+	}
+	svc := objref.ObjectRef{
+		Name:      slice.Labels[discoveryv1.LabelServiceName],
+		Namespace: slice.Namespace,
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	delete(s.local[svc], slice.Name)
+	if len(s.local[svc]) == 0 {
+		delete(s.local, svc)
+	}
+	if s.cache[svc] != nil {
+		s.try.Try()
+	}
+}
+
+// localReady returns true if the local service has ready endpoints
+func (s *DiscoveryController) localReady(svc objref.ObjectRef) bool {
+	for _, ready := range s.local[svc] {
+		if ready != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// buildFallbackDiscovery adds the tunnel to the local service through the ready exports with the highest priority
+func (s *DiscoveryController) buildFallbackDiscovery(meta metav1.ObjectMeta, item map[string]discovery.Service) []objref.KMetadata {
+	priority := -1
+	for _, data := range item {
+		if data.Fallback && !data.NotReady && (priority == -1 || data.Priority < priority) {
+			priority = data.Priority
+		}
+	}
+	if priority == -1 {
+		return nil
+	}
+	exportPorts := map[string][]discovery.MappingPort{}
+	for _, data := range item {
+		if data.Fallback && !data.NotReady && data.Priority == priority {
+			exportPorts[data.ExportHubName] = append(exportPorts[data.ExportHubName], data.Ports...)
+		}
+	}
+	return discovery.BuildFallbackEndpointSlices(meta, s.endpoints, exportPorts)
+}
@@ -0,0 +1,162 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	ferryversioned "github.com/ferryproxy/client-go/generated/clientset/versioned"
+	"github.com/ferryproxy/ferry/pkg/router/discovery"
+	"github.com/ferryproxy/ferry/pkg/utils/trybuffer"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	mcsversioned "sigs.k8s.io/mcs-api/pkg/client/clientset/versioned"
+)
+
+type fakeClientset struct {
+	kube *fake.Clientset
+}
+
+func (f *fakeClientset) Kubernetes() kubernetes.Interface {
+	return f.kube
+}
+
+func (f *fakeClientset) Ferry() ferryversioned.Interface {
+	return nil
+}
+
+func (f *fakeClientset) MCS() mcsversioned.Interface {
+	return nil
+}
+
+func localSlice(ready bool) *discoveryv1.EndpointSlice {
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "svc1-abcde",
+			Namespace: "test",
+			Labels: map[string]string{
+				discoveryv1.LabelServiceName: "svc1",
+				discoveryv1.LabelManagedBy:   localManagedBy,
+			},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints: []discoveryv1.Endpoint{
+			{
+				Addresses:  []string{"10.1.0.1"},
+				Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+			},
+		},
+	}
+}
+
+func TestDiscoveryControllerFallback(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	kube := fake.NewSimpleClientset(localSlice(true))
+	s := NewDiscoveryController(&DiscoveryControllerConfig{
+		Namespace:     "ferry-tunnel-system",
+		Logger:        logr.Discard(),
+		Clientset:     &fakeClientset{kube: kube},
+		EndpointSlice: true,
+	})
+	s.ctx = ctx
+	s.endpoints = []discovery.Endpoint{{IP: "10.0.0.1"}}
+	s.try = trybuffer.NewTryBuffer(func() {
+		s.mut.Lock()
+		defer s.mut.Unlock()
+		s.sync()
+	}, time.Second/100)
+
+	data, err := discovery.Service{
+		ExportHubName:          "remote",
+		ExportServiceName:      "svc1",
+		ExportServiceNamespace: "test",
+		ImportServiceName:      "svc1",
+		ImportServiceNamespace: "test",
+		Ports: []discovery.MappingPort{
+			{Name: "http", Protocol: "TCP", Port: 80, TargetPort: 10001},
+		},
+		Fallback: true,
+	}.ToMap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mut.Lock()
+	s.Add(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "svc1-service", Namespace: "ferry-tunnel-system"},
+		Data:       data,
+	})
+	s.mut.Unlock()
+
+	// fallback returns the names of the EndpointSlices that the tunnel adds to the local service
+	fallback := func() []string {
+		list, err := kube.DiscoveryV1().EndpointSlices("test").List(ctx, metav1.ListOptions{
+			LabelSelector: discoveryv1.LabelManagedBy + "!=" + localManagedBy,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, item := range list.Items {
+			names = append(names, item.Name)
+		}
+		return names
+	}
+	// wait returns true once the fallback is added or removed as wanted
+	wait := func(want bool) bool {
+		for i := 0; i != 100; i++ {
+			if got := len(fallback()) != 0; got == want {
+				return true
+			}
+			time.Sleep(time.Second / 20)
+		}
+		return false
+	}
+
+	if !wait(false) {
+		t.Fatalf("fallback %v is added while the local service is ready", fallback())
+	}
+	if _, err := kube.CoreV1().Services("test").Get(ctx, "svc1", metav1.GetOptions{}); err == nil {
+		t.Errorf("the local service is managed by the fallback")
+	}
+
+	_, err = kube.DiscoveryV1().EndpointSlices("test").Update(ctx, localSlice(false), metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wait(true) {
+		t.Fatalf("fallback is not added after the local service is not ready")
+	}
+	if got := fallback(); len(got) != 1 || got[0] != "svc1-remote" {
+		t.Errorf("fallback = %v, want [svc1-remote]", got)
+	}
+
+	_, err = kube.DiscoveryV1().EndpointSlices("test").Update(ctx, localSlice(true), metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wait(false) {
+		t.Fatalf("fallback %v is not removed after the local service is ready again", fallback())
+	}
+}
@@ -22,20 +22,23 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"sync"
-	"syscall"
 	"time"
 
 	"github.com/ferryproxy/ferry/pkg/client"
 	"github.com/ferryproxy/ferry/pkg/consts"
+	"github.com/ferryproxy/ferry/pkg/tunnel/accesslog"
+	"github.com/ferryproxy/ferry/pkg/tunnel/status"
+	"github.com/ferryproxy/ferry/pkg/tunnel/worker"
 	"github.com/ferryproxy/ferry/pkg/utils/trybuffer"
 	"github.com/go-logr/logr"
 )
 
+// RuntimeController runs the tunnel in process, and applies the chains of the rules to it
 type RuntimeController struct {
-	cmd           *exec.Cmd
+	ctx           context.Context
+	runtime       *worker.Runtime
 	chains        []json.RawMessage
 	try           *trybuffer.TryBuffer
 	mut           sync.Mutex
@@ -43,6 +46,12 @@ type RuntimeController struct {
 	labelSelector string
 	logger        logr.Logger
 	clientset     client.Interface
+	drainTimeout  time.Duration
+	hubLimit      *worker.Limit
+	accessLog     *accesslog.Logger
+	statusMut     sync.Mutex
+	status        *status.Status
+	stopped       bool
 }
 
 type RuntimeControllerConfig struct {
@@ -50,6 +59,9 @@ type RuntimeControllerConfig struct {
 	LabelSelector string
 	Logger        logr.Logger
 	Clientset     client.Interface
+	DrainTimeout  time.Duration
+	HubLimit      *worker.Limit
+	AccessLog     *accesslog.Logger
 }
 
 func NewRuntimeController(conf *RuntimeControllerConfig) *RuntimeController {
@@ -58,18 +70,53 @@ func NewRuntimeController(conf *RuntimeControllerConfig) *RuntimeController {
 		labelSelector: conf.LabelSelector,
 		logger:        conf.Logger,
 		clientset:     conf.Clientset,
+		drainTimeout:  conf.DrainTimeout,
+		hubLimit:      conf.HubLimit,
+		accessLog:     conf.AccessLog,
 	}
 }
 
-func (r *RuntimeController) Run(ctx context.Context) error {
-	_, err := os.Stat(consts.TunnelRulesConfigPath)
-	if err != nil {
-		err = atomicWrite(consts.TunnelRulesConfigPath, []byte(`{}`), 0644)
-		if err != nil {
-			r.logger.Error(err, "failed to write config file")
-			return err
-		}
+// Status returns the outcome of the last reload of the tunnel
+func (r *RuntimeController) Status() (status.Status, bool) {
+	r.statusMut.Lock()
+	defer r.statusMut.Unlock()
+	if r.status == nil {
+		return status.Status{}, false
+	}
+	return *r.status, true
+}
+
+// Health returns the status of the tunnel, and an error if the tunnel is not running
+func (r *RuntimeController) Health() (interface{}, error) {
+	r.statusMut.Lock()
+	defer r.statusMut.Unlock()
+	var err error
+	if r.stopped {
+		err = fmt.Errorf("tunnel is not running")
+	}
+	if r.status == nil {
+		return nil, err
 	}
+	s := *r.status
+	if r.runtime != nil {
+		s.OpenCircuits = r.runtime.OpenCircuits()
+		s.UnhealthyHops = r.runtime.UnhealthyHops()
+	}
+	return s, err
+}
+
+func (r *RuntimeController) Run(ctx context.Context) error {
+	r.ctx = ctx
+	runtime := worker.NewRuntime(&worker.RuntimeConfig{
+		Logger:       r.logger.WithName("tunnel"),
+		DrainTimeout: r.drainTimeout,
+		HubLimit:     r.hubLimit,
+		AccessLog:    r.accessLog,
+	})
+	// The health reads the runtime while it is starting
+	r.statusMut.Lock()
+	r.runtime = runtime
+	r.statusMut.Unlock()
 
 	r.try = trybuffer.NewTryBuffer(func() {
 		err := r.reload()
@@ -78,23 +125,15 @@ func (r *RuntimeController) Run(ctx context.Context) error {
 		}
 	}, time.Second/10)
 
-	go r.watch(ctx)
-
-	go func() {
-		<-ctx.Done()
-		r.cmd.Process.Signal(syscall.SIGTERM)
-	}()
-
 	r.logger.Info("Start ferry tunnel")
-	for ctx.Err() == nil {
-		err := r.runtime(ctx)
-		if err != nil {
-			r.logger.Error(err, "tunnel exited")
-		}
-		time.Sleep(5 * time.Second)
-	}
+	r.watch(ctx)
 
 	r.try.Close()
+	r.runtime.Close()
+
+	r.statusMut.Lock()
+	r.stopped = true
+	r.statusMut.Unlock()
 	return nil
 }
 
@@ -122,7 +161,11 @@ func (r *RuntimeController) watch(ctx context.Context) {
 		if err != nil {
 			r.logger.Error(err, "failed to watch")
 		}
-		time.Sleep(backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
 		backoff <<= 1
 		if backoff > time.Minute {
 			backoff = time.Minute
@@ -130,21 +173,14 @@ func (r *RuntimeController) watch(ctx context.Context) {
 	}
 }
 
-func (r *RuntimeController) runtime(ctx context.Context) error {
-	cmd := exec.CommandContext(ctx, "ferry-tunnel", "-c", consts.TunnelRulesConfigPath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
-	r.mut.Lock()
-	r.cmd = cmd
-	r.mut.Unlock()
-	return cmd.Run()
-}
-
 func (r *RuntimeController) reload() error {
 	r.mut.Lock()
 	defer r.mut.Unlock()
 
+	if r.ctx.Err() != nil {
+		return nil
+	}
+
 	tunnelConfig, err := json.Marshal(struct {
 		Chains []json.RawMessage `json:"chains"`
 	}{
@@ -157,17 +193,46 @@ func (r *RuntimeController) reload() error {
 		"config", r,
 	)
 
+	// The config is only written for debugging
 	err = atomicWrite(consts.TunnelRulesConfigPath, tunnelConfig, 0644)
 	if err != nil {
-		return fmt.Errorf("failed to write tunnel config: %w", err)
+		r.logger.Error(err, "failed to write tunnel config")
 	}
 
-	if r.cmd != nil {
-		err = r.cmd.Process.Signal(syscall.SIGHUP)
+	tasks := make([]worker.Chain, 0, len(r.chains))
+	failed := []status.Failed{}
+	for _, raw := range r.chains {
+		var task worker.Chain
+		err := json.Unmarshal(raw, &task)
+		if err == nil {
+			err = task.Verification()
+		}
 		if err != nil {
-			return fmt.Errorf("failed to emit signal to tunnel: %w", err)
+			failed = append(failed, status.Failed{Chain: string(raw), Error: err.Error()})
+			continue
 		}
+		tasks = append(tasks, task)
+	}
+
+	s := r.runtime.Apply(r.ctx, tasks)
+	s.Checksum = status.Checksum(tunnelConfig)
+	s.Failed = append(failed, s.Failed...)
+
+	r.statusMut.Lock()
+	r.status = &s
+	r.statusMut.Unlock()
+
+	for _, f := range s.Failed {
+		r.logger.Error(fmt.Errorf("%s", f.Error), "failed to start chain",
+			"chain", f.Chain,
+		)
 	}
+	r.logger.Info("Tunnel reloaded",
+		"reload", s.Reload,
+		"live", s.Live,
+		"failed", len(s.Failed),
+		"draining", s.Draining,
+	)
 	return nil
 }
 
@@ -0,0 +1,230 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ferryproxy/ferry/pkg/consts"
+	"github.com/ferryproxy/ferry/pkg/router"
+	"github.com/ferryproxy/ferry/pkg/router/discovery"
+	"github.com/ferryproxy/ferry/pkg/utils/objref"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var labelsSplit = map[string]string{
+	consts.LabelGeneratedKey: consts.LabelGeneratedTunnelValue,
+	consts.TunnelConfigKey:   consts.TunnelConfigRulesValue,
+}
+
+// isSplit returns true if the import is split between the export hubs
+func isSplit(item map[string]discovery.Service) bool {
+	for _, data := range item {
+		for _, port := range data.Ports {
+			if port.SplitPort != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// buildSplit returns the ports of the import that point to the split ports,
+// and the rules that split the connections of the split ports to the peer ports of the export hubs by the weights,
+// or route the HTTP requests of them by the HTTP rules, or route the TLS connections of them by the server names.
+func buildSplit(namespace string, obj objref.ObjectRef, item map[string]discovery.Service) ([]discovery.MappingPort, *corev1.ConfigMap, error) {
+	exports := make([]string, 0, len(item))
+	for export := range item {
+		exports = append(exports, export)
+	}
+	sort.Strings(exports)
+
+	// The exports are split evenly if no weight is declared, e.g. only the HTTP rules are declared
+	weighted := false
+	var httpRules []discovery.HTTPRule
+	for _, export := range exports {
+		data := item[export]
+		if data.Weight != 0 {
+			weighted = true
+		}
+		if httpRules == nil {
+			httpRules = data.HTTPRules
+		}
+	}
+	var declared router.TrafficSplit
+	if weighted {
+		declared = router.TrafficSplit{}
+	}
+	ready := map[string]bool{}
+	for _, export := range exports {
+		data := item[export]
+		if declared != nil {
+			declared[export] = data.Weight
+		}
+		ready[export] = !data.NotReady
+	}
+	weights := router.EffectiveSplit(declared, ready)
+
+	type split struct {
+		port      discovery.MappingPort
+		addresses []string
+		weights   []int
+		exports   []discovery.Service
+	}
+	splits := map[int32]*split{}
+	for _, export := range exports {
+		data := item[export]
+		for _, port := range data.Ports {
+			if port.SplitPort == 0 {
+				continue
+			}
+			s := splits[port.SplitPort]
+			if s == nil {
+				s = &split{
+					port: discovery.MappingPort{
+						Name:        port.Name,
+						Protocol:    port.Protocol,
+						AppProtocol: port.AppProtocol,
+						Port:        port.Port,
+						TargetPort:  port.SplitPort,
+					},
+				}
+				splits[port.SplitPort] = s
+			}
+			s.addresses = append(s.addresses, fmt.Sprintf("127.0.0.1:%d", port.TargetPort))
+			s.weights = append(s.weights, weights[export])
+			s.exports = append(s.exports, data)
+		}
+	}
+
+	splitPorts := make([]int32, 0, len(splits))
+	for splitPort := range splits {
+		splitPorts = append(splitPorts, splitPort)
+	}
+	sort.Slice(splitPorts, func(i, j int) bool {
+		return splitPorts[i] < splitPorts[j]
+	})
+
+	name := fmt.Sprintf("%s-%s-split", obj.Name, obj.Namespace)
+	ports := make([]discovery.MappingPort, 0, len(splitPorts))
+	chains := make([]router.Chain, 0, len(splitPorts))
+	for _, splitPort := range splitPorts {
+		s := splits[splitPort]
+		ports = append(ports, s.port)
+		// The HTTP rules only apply to the HTTP ports, the others are split by the weights
+		var httpRoute *router.HTTPRoute
+		if s.port.IsHTTP() {
+			httpRoute = buildHTTPRoute(httpRules, s.exports)
+		}
+		chains = append(chains, router.Chain{
+			Name:    fmt.Sprintf("%s-%d", name, splitPort),
+			Bind:    []string{fmt.Sprintf(":%d", splitPort)},
+			Proxy:   []string{strings.Join(s.addresses, "|")},
+			Weights: s.weights,
+			HTTP:    httpRoute,
+			SNI:     buildSNIRoute(s.exports),
+		})
+	}
+
+	data, err := json.Marshal(chains)
+	if err != nil {
+		return nil, nil, err
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    labelsSplit,
+		},
+		Data: map[string]string{
+			consts.TunnelRulesKey: string(data),
+		},
+	}
+	return ports, configMap, nil
+}
+
+// buildHTTPRoute returns the route of the HTTP requests to the indexes of the exports, nil if there is no rule
+func buildHTTPRoute(rules []discovery.HTTPRule, exports []discovery.Service) *router.HTTPRoute {
+	if len(rules) == 0 {
+		return nil
+	}
+	route := &router.HTTPRoute{}
+	selected := map[int]struct{}{}
+	for i, rule := range rules {
+		name := rule.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		backends := []int{}
+		for j, export := range exports {
+			if rule.Selects(export) {
+				backends = append(backends, j)
+				selected[j] = struct{}{}
+			}
+		}
+		route.Rules = append(route.Rules, router.HTTPRouteRule{
+			Name:       name,
+			Host:       rule.Host,
+			PathPrefix: rule.PathPrefix,
+			Headers:    rule.Headers,
+			Backends:   backends,
+		})
+	}
+	for j := range exports {
+		if _, ok := selected[j]; !ok {
+			route.Default = append(route.Default, j)
+		}
+	}
+	if len(route.Default) == 0 {
+		for j := range exports {
+			route.Default = append(route.Default, j)
+		}
+	}
+	return route
+}
+
+// buildSNIRoute returns the route of the TLS connections to the indexes of the exports, nil if no export has the server names
+func buildSNIRoute(exports []discovery.Service) *router.SNIRoute {
+	route := &router.SNIRoute{}
+	rules := map[string]int{}
+	for j, export := range exports {
+		if len(export.ServerNames) == 0 {
+			route.Default = append(route.Default, j)
+			continue
+		}
+		for _, name := range export.ServerNames {
+			i, ok := rules[name]
+			if !ok {
+				i = len(route.Rules)
+				rules[name] = i
+				route.Rules = append(route.Rules, router.SNIRouteRule{
+					ServerName: name,
+				})
+			}
+			route.Rules[i].Backends = append(route.Rules[i].Backends, j)
+		}
+	}
+	if len(route.Rules) == 0 {
+		return nil
+	}
+	return route
+}
@@ -0,0 +1,188 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+var defaultRegistry = NewRegistry()
+
+// Handler returns the handler that exposes the metrics of the default registry in the Prometheus text format
+func Handler() http.Handler {
+	return defaultRegistry
+}
+
+// NewCounter returns a new counter in the default registry
+func NewCounter(name, help string, labels ...string) *Counter {
+	return defaultRegistry.NewCounter(name, help, labels...)
+}
+
+// NewGauge returns a new gauge in the default registry
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return defaultRegistry.NewGauge(name, help, labels...)
+}
+
+// Registry is a set of metrics
+type Registry struct {
+	mut     sync.Mutex
+	metrics []*metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// NewCounter returns a new counter in the registry
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register("counter", name, help, labels)}
+}
+
+// NewGauge returns a new gauge in the registry
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register("gauge", name, help, labels)}
+}
+
+func (r *Registry) register(kind, name, help string, labels []string) *metric {
+	m := &metric{
+		kind:   kind,
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: map[string]*int64{},
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+func (r *Registry) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteTo(rw)
+}
+
+// WriteTo writes the metrics in the Prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mut.Lock()
+	metrics := make([]*metric, len(r.metrics))
+	copy(metrics, r.metrics)
+	r.mut.Unlock()
+
+	var buf strings.Builder
+	for _, m := range metrics {
+		m.writeTo(&buf)
+	}
+	n, err := io.WriteString(w, buf.String())
+	return int64(n), err
+}
+
+type metric struct {
+	kind   string
+	name   string
+	help   string
+	labels []string
+
+	mut    sync.RWMutex
+	values map[string]*int64
+}
+
+func (m *metric) value(values []string) *int64 {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s: got %d label values, want %d", m.name, len(values), len(m.labels)))
+	}
+	key := strings.Join(values, "\xff")
+	m.mut.RLock()
+	v, ok := m.values[key]
+	m.mut.RUnlock()
+	if ok {
+		return v
+	}
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	v, ok = m.values[key]
+	if !ok {
+		v = new(int64)
+		m.values[key] = v
+	}
+	return v
+}
+
+func (m *metric) writeTo(buf *strings.Builder) {
+	m.mut.RLock()
+	defer m.mut.RUnlock()
+	if len(m.values) == 0 {
+		return
+	}
+	fmt.Fprintf(buf, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", m.name, m.kind)
+	keys := make([]string, 0, len(m.values))
+	for key := range m.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		buf.WriteString(m.name)
+		if len(m.labels) != 0 {
+			buf.WriteString("{")
+			for i, value := range strings.Split(key, "\xff") {
+				if i != 0 {
+					buf.WriteString(",")
+				}
+				fmt.Fprintf(buf, "%s=%q", m.labels[i], value)
+			}
+			buf.WriteString("}")
+		}
+		fmt.Fprintf(buf, " %d\n", atomic.LoadInt64(m.values[key]))
+	}
+}
+
+// Counter is a metric that only increases
+type Counter struct {
+	m *metric
+}
+
+// Inc increases the counter of the label values by 1
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add increases the counter of the label values
+func (c *Counter) Add(delta int64, values ...string) {
+	atomic.AddInt64(c.m.value(values), delta)
+}
+
+// Gauge is a metric that can go up and down
+type Gauge struct {
+	m *metric
+}
+
+// Set sets the gauge of the label values
+func (g *Gauge) Set(v int64, values ...string) {
+	atomic.StoreInt64(g.m.value(values), v)
+}
+
+// Add adds the delta to the gauge of the label values
+func (g *Gauge) Add(delta int64, values ...string) {
+	atomic.AddInt64(g.m.value(values), delta)
+}
@@ -0,0 +1,51 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "The test counter.", "route")
+	g := r.NewGauge("test_connections", "The test gauge.")
+	r.NewCounter("test_unused_total", "The unused counter.")
+
+	c.Inc("b")
+	c.Add(2, "a")
+	g.Set(3)
+	g.Add(-1)
+
+	var buf strings.Builder
+	_, err := r.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP test_total The test counter.
+# TYPE test_total counter
+test_total{route="a"} 2
+test_total{route="b"} 1
+# HELP test_connections The test gauge.
+# TYPE test_connections gauge
+test_connections 2
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,248 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pool
+
+import (
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/wzshiming/bridge"
+	"github.com/wzshiming/bridge/protocols/local"
+)
+
+var defaultPool = NewPool()
+
+// Bridge returns a bridger that shares the dialers in the default pool
+func Bridge(bridger bridge.Bridger) bridge.Bridger {
+	return defaultPool.Bridge(bridger)
+}
+
+// Keys returns the keys of the shared hops that the dialers go through in the default pool
+func Keys(dialers ...interface{}) []string {
+	return defaultPool.Keys(dialers...)
+}
+
+// Retain closes the dialers of the hops that are not in the keys in the default pool
+func Retain(keys []string) {
+	defaultPool.Retain(keys)
+}
+
+// Unhealthy returns the errors of the hops that are failing to connect in the default pool
+func Unhealthy() map[string]error {
+	return defaultPool.Unhealthy()
+}
+
+// Pool shares the dialers of the same hop, so the chains to the same hub reuse the connections,
+// the hop is identified by its address and the hops before it.
+type Pool struct {
+	mut     sync.Mutex
+	dialers map[string]bridge.Dialer
+}
+
+// NewPool returns a new Pool
+func NewPool() *Pool {
+	return &Pool{
+		dialers: map[string]bridge.Dialer{},
+	}
+}
+
+// Bridge returns a bridger that shares the dialers created by the bridger
+func (p *Pool) Bridge(bridger bridge.Bridger) bridge.Bridger {
+	return bridge.BridgeFunc(func(dialer bridge.Dialer, address string) (bridge.Dialer, error) {
+		parent, ok := keyOf(dialer)
+		if !ok {
+			// The previous hop is not shared, so neither is this one
+			return bridger.Bridge(dialer, address)
+		}
+		key := address
+		if parent != "" {
+			key = parent + "|" + address
+		}
+
+		p.mut.Lock()
+		defer p.mut.Unlock()
+		if d, ok := p.dialers[key]; ok {
+			return d, nil
+		}
+		d, err := bridger.Bridge(dialer, address)
+		if err != nil {
+			return nil, err
+		}
+		d = newSharedDialer(d, key)
+		p.dialers[key] = d
+		return d, nil
+	})
+}
+
+// Keys returns the keys of the shared hops that the dialers go through, including the hops before them
+func (p *Pool) Keys(dialers ...interface{}) []string {
+	uniq := map[string]struct{}{}
+	for _, d := range dialers {
+		k, ok := d.(keyer)
+		if !ok {
+			continue
+		}
+		hops := strings.Split(k.poolKey(), "|")
+		for i := range hops {
+			uniq[strings.Join(hops[:i+1], "|")] = struct{}{}
+		}
+	}
+	keys := make([]string, 0, len(uniq))
+	for key := range uniq {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Retain closes the dialers of the hops that are not in the keys,
+// so the connections to the hubs that are no longer used by any chain are released
+func (p *Pool) Retain(keys []string) {
+	retain := map[string]struct{}{}
+	for _, key := range keys {
+		retain[key] = struct{}{}
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	for key, d := range p.dialers {
+		if _, ok := retain[key]; ok {
+			continue
+		}
+		delete(p.dialers, key)
+		if c, ok := d.(io.Closer); ok {
+			c.Close()
+		}
+	}
+}
+
+// Unhealthy returns the errors of the hops that are failing to connect, keyed by the hops without the passwords
+// the hops are checked outside the lock of the pool, so a hop that is connecting does not block the other hops.
+func (p *Pool) Unhealthy() map[string]error {
+	p.mut.Lock()
+	hops := make(map[string]healthy, len(p.dialers))
+	for key, d := range p.dialers {
+		if h, ok := d.(healthy); ok {
+			hops[key] = h
+		}
+	}
+	p.mut.Unlock()
+
+	out := map[string]error{}
+	for key, h := range hops {
+		if err := h.Healthy(); err != nil {
+			out[redact(key)] = err
+		}
+	}
+	return out
+}
+
+func redact(key string) string {
+	hops := strings.Split(key, "|")
+	for i, hop := range hops {
+		uri, err := url.Parse(hop)
+		if err == nil {
+			hops[i] = uri.Redacted()
+		}
+	}
+	return strings.Join(hops, "|")
+}
+
+type healthy interface {
+	Healthy() error
+}
+
+func keyOf(dialer bridge.Dialer) (string, bool) {
+	switch d := dialer.(type) {
+	case nil:
+		return "", true
+	case *local.Local:
+		return "", true
+	case keyer:
+		return d.poolKey(), true
+	}
+	return "", false
+}
+
+type keyer interface {
+	poolKey() string
+}
+
+func newSharedDialer(d bridge.Dialer, key string) bridge.Dialer {
+	if l, ok := d.(bridge.ListenConfig); ok {
+		return &sharedListenDialer{
+			Dialer:       d,
+			ListenConfig: l,
+			key:          key,
+		}
+	}
+	return &sharedDialer{
+		Dialer: d,
+		key:    key,
+	}
+}
+
+type sharedDialer struct {
+	bridge.Dialer
+	key string
+}
+
+func (s *sharedDialer) poolKey() string {
+	return s.key
+}
+
+func (s *sharedDialer) Healthy() error {
+	return healthOf(s.Dialer)
+}
+
+func (s *sharedDialer) Close() error {
+	return closeOf(s.Dialer)
+}
+
+type sharedListenDialer struct {
+	bridge.Dialer
+	bridge.ListenConfig
+	key string
+}
+
+func (s *sharedListenDialer) poolKey() string {
+	return s.key
+}
+
+func (s *sharedListenDialer) Healthy() error {
+	return healthOf(s.Dialer)
+}
+
+func (s *sharedListenDialer) Close() error {
+	return closeOf(s.Dialer)
+}
+
+func healthOf(d bridge.Dialer) error {
+	if h, ok := d.(healthy); ok {
+		return h.Healthy()
+	}
+	return nil
+}
+
+func closeOf(d bridge.Dialer) error {
+	if c, ok := d.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,477 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pool
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/wzshiming/bridge"
+	"github.com/wzshiming/bridge/protocols/local"
+	"golang.org/x/crypto/ssh"
+)
+
+type fakeDialer struct {
+	address string
+	closed  bool
+}
+
+func (f *fakeDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f *fakeDialer) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestPoolBridge(t *testing.T) {
+	count := 0
+	p := NewPool()
+	b := p.Bridge(bridge.BridgeFunc(func(dialer bridge.Dialer, address string) (bridge.Dialer, error) {
+		count++
+		return &fakeDialer{address: address}, nil
+	}))
+
+	hop1, _ := b.Bridge(local.LOCAL, "ssh://a")
+	hop2, _ := b.Bridge(nil, "ssh://a")
+	if hop1 != hop2 {
+		t.Errorf("the first hop with the same address should be shared")
+	}
+	via1, _ := b.Bridge(hop1, "ssh://b")
+	via2, _ := b.Bridge(hop2, "ssh://b")
+	if via1 != via2 {
+		t.Errorf("the hop with the same previous hops should be shared")
+	}
+	direct, _ := b.Bridge(nil, "ssh://b")
+	if direct == via1 {
+		t.Errorf("the hop with different previous hops should not be shared")
+	}
+	if count != 3 {
+		t.Errorf("got %d dialers, want 3", count)
+	}
+
+	unknown1, _ := b.Bridge(&fakeDialer{}, "ssh://c")
+	unknown2, _ := b.Bridge(&fakeDialer{}, "ssh://c")
+	if unknown1 == unknown2 {
+		t.Errorf("the hop after an unknown dialer should not be shared")
+	}
+}
+
+func TestPoolRetain(t *testing.T) {
+	dialers := map[string]*fakeDialer{}
+	p := NewPool()
+	b := p.Bridge(bridge.BridgeFunc(func(dialer bridge.Dialer, address string) (bridge.Dialer, error) {
+		d := &fakeDialer{address: address}
+		dialers[address] = d
+		return d, nil
+	}))
+
+	hop, _ := b.Bridge(nil, "ssh://a")
+	via, _ := b.Bridge(hop, "ssh://b")
+	b.Bridge(nil, "ssh://c")
+
+	keys := p.Keys(via, &fakeDialer{})
+	want := []string{"ssh://a", "ssh://a|ssh://b"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Fatalf("got keys %v, want %v", keys, want)
+	}
+
+	p.Retain(keys)
+	if dialers["ssh://a"].closed || dialers["ssh://b"].closed {
+		t.Errorf("the retained hops should not be closed")
+	}
+	if !dialers["ssh://c"].closed {
+		t.Errorf("the hop that is not retained should be closed")
+	}
+	again, _ := b.Bridge(hop, "ssh://b")
+	if again != via {
+		t.Errorf("the retained hop should still be shared")
+	}
+	if c, _ := b.Bridge(nil, "ssh://c"); c == nil || dialers["ssh://c"].closed {
+		t.Errorf("the evicted hop should be created again")
+	}
+}
+
+func TestPoolUnhealthy(t *testing.T) {
+	p := NewPool()
+	b := p.Bridge(bridge.BridgeFunc(func(dialer bridge.Dialer, address string) (bridge.Dialer, error) {
+		return newSSHDialer(&fakeSSHClienter{err: errors.New("connection refused")}), nil
+	}))
+	d, _ := b.Bridge(nil, "ssh://u:p@a")
+	b.Bridge(nil, "ssh://b")
+	if len(p.Unhealthy()) != 0 {
+		t.Fatalf("the hops should be healthy before connecting")
+	}
+
+	d.DialContext(context.Background(), "tcp", "target:80")
+	unhealthy := p.Unhealthy()
+	if len(unhealthy) != 1 || unhealthy["ssh://u:xxxxx@a"] == nil {
+		t.Errorf("got %v, want the hop with the redacted password", unhealthy)
+	}
+}
+
+func TestSSHReload(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+	first := &fakeSSHClienter{err: errors.New("unable to authenticate")}
+	d := newSSHDialer(first)
+	d.now = func() time.Time { return now }
+	var reloaded []*fakeSSHClienter
+	d.reload = func() (sshClienter, error) {
+		f := &fakeSSHClienter{err: errors.New("connection refused")}
+		reloaded = append(reloaded, f)
+		return f, nil
+	}
+
+	d.DialContext(ctx, "tcp", "target:80")
+	if first.dials != 1 || len(reloaded) != 0 {
+		t.Fatalf("the first connecting should use the dialer as is")
+	}
+	now = now.Add(maxBackoff)
+	d.DialContext(ctx, "tcp", "target:80")
+	if first.dials != 1 || len(reloaded) != 1 || reloaded[0].dials != 1 {
+		t.Fatalf("the reconnecting should reload the dialer, got %d reloads", len(reloaded))
+	}
+}
+
+type fakeSSHClienter struct {
+	dials  int
+	closes int
+	err    error
+}
+
+func (f *fakeSSHClienter) SSHClient(ctx context.Context) (*ssh.Client, error) {
+	f.dials++
+	return nil, f.err
+}
+
+func (f *fakeSSHClienter) Close() error {
+	f.closes++
+	return nil
+}
+
+func TestSSHBackoff(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+	fake := &fakeSSHClienter{err: errors.New("connection refused")}
+	d := newSSHDialer(fake)
+	d.now = func() time.Time { return now }
+
+	steps := []struct {
+		after     time.Duration
+		wantDials int
+	}{
+		{0, 1},
+		{minBackoff / 2, 1},
+		{minBackoff / 2, 2},
+		{minBackoff, 2},
+		{minBackoff, 3},
+	}
+	for i, step := range steps {
+		now = now.Add(step.after)
+		_, err := d.DialContext(ctx, "tcp", "target:80")
+		if err == nil {
+			t.Fatalf("step %d: want error", i)
+		}
+		if fake.dials != step.wantDials {
+			t.Fatalf("step %d: got %d dials, want %d", i, fake.dials, step.wantDials)
+		}
+	}
+	if d.Healthy() == nil {
+		t.Errorf("should be unhealthy while backing off")
+	}
+}
+
+type countListener struct {
+	net.Listener
+	accepts int32
+}
+
+func (c *countListener) Accept() (net.Conn, error) {
+	conn, err := c.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&c.accepts, 1)
+	}
+	return conn, err
+}
+
+func TestSSHShared(t *testing.T) {
+	ctx := context.Background()
+	echo := startEcho(t)
+	address, counter := startSSHServer(t)
+
+	b := NewPool().Bridge(bridge.BridgeFunc(SSH))
+	for i := 0; i != 3; i++ {
+		dialer, err := b.Bridge(local.LOCAL, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := dialer.DialContext(ctx, "tcp", echo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = conn.Write([]byte("ping"))
+		if err == nil {
+			_, err = io.ReadFull(conn, make([]byte, 4))
+		}
+		conn.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if accepts := atomic.LoadInt32(&counter.accepts); accepts != 1 {
+		t.Errorf("got %d ssh connections, want 1", accepts)
+	}
+}
+
+type blockingSSHClienter struct {
+	started chan struct{}
+	release chan struct{}
+	dials   int32
+}
+
+func (b *blockingSSHClienter) SSHClient(ctx context.Context) (*ssh.Client, error) {
+	if atomic.AddInt32(&b.dials, 1) == 1 {
+		close(b.started)
+	}
+	<-b.release
+	return nil, errors.New("connection refused")
+}
+
+func (b *blockingSSHClienter) Close() error {
+	return nil
+}
+
+func TestSSHConnectingNotLocked(t *testing.T) {
+	ctx := context.Background()
+	fake := &blockingSSHClienter{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	p := NewPool()
+	b := p.Bridge(bridge.BridgeFunc(func(dialer bridge.Dialer, address string) (bridge.Dialer, error) {
+		return newSSHDialer(fake), nil
+	}))
+	d, _ := b.Bridge(nil, "ssh://a")
+
+	errs := make(chan error, 2)
+	for i := 0; i != 2; i++ {
+		go func() {
+			_, err := d.DialContext(ctx, "tcp", "target:80")
+			errs <- err
+		}()
+	}
+	<-fake.started
+
+	done := make(chan struct{})
+	go func() {
+		p.Unhealthy()
+		p.Retain([]string{"ssh://a"})
+		b.Bridge(nil, "ssh://b")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the pool is blocked by the connecting hop")
+	}
+
+	close(fake.release)
+	for i := 0; i != 2; i++ {
+		if err := <-errs; err == nil {
+			t.Errorf("want error")
+		}
+	}
+	if dials := atomic.LoadInt32(&fake.dials); dials != 1 {
+		t.Errorf("got %d dials, want the callers to share 1", dials)
+	}
+}
+
+func TestSSHHandshakeTimeout(t *testing.T) {
+	timeout := handshakeTimeout
+	handshakeTimeout = 100 * time.Millisecond
+	defer func() {
+		handshakeTimeout = timeout
+	}()
+
+	// The peer accepts the connection but never answers the handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	d, err := SSH(nil, "ssh://u:p@"+listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		_, err := d.DialContext(context.Background(), "tcp", "target:80")
+		errs <- err
+	}()
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Errorf("want error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handshake is not bounded")
+	}
+}
+
+func startEcho(t *testing.T) string {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		echo.Close()
+	})
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return echo.Addr().String()
+}
+
+// startSSHServer starts a ssh server that only serves direct-tcpip, and returns its address with the password
+func startSSHServer(t *testing.T) (string, *countListener) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() != "u" || string(password) != "p" {
+				return nil, errors.New("invalid password")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	counter := &countListener{Listener: listener}
+	go func() {
+		for {
+			conn, err := counter.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config)
+		}
+	}()
+	return fmt.Sprintf("ssh://u:p@%s", listener.Addr()), counter
+}
+
+func serveSSH(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChan := range chans {
+		if newChan.ChannelType() != "direct-tcpip" {
+			newChan.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		var target struct {
+			Host     string
+			Port     uint32
+			OrigHost string
+			OrigPort uint32
+		}
+		err := ssh.Unmarshal(newChan.ExtraData(), &target)
+		if err != nil {
+			newChan.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		upstream, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+		if err != nil {
+			newChan.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		ch, chReqs, err := newChan.Accept()
+		if err != nil {
+			upstream.Close()
+			continue
+		}
+		go ssh.DiscardRequests(chReqs)
+		go func() {
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				io.Copy(upstream, ch)
+				upstream.Close()
+			}()
+			go func() {
+				defer wg.Done()
+				io.Copy(ch, upstream)
+				ch.Close()
+			}()
+			wg.Wait()
+		}()
+	}
+}
@@ -0,0 +1,323 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/wzshiming/bridge"
+	"github.com/wzshiming/sshproxy"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	keepAliveInterval = 30 * time.Second
+	minBackoff        = time.Second
+	maxBackoff        = time.Minute
+)
+
+// handshakeTimeout bounds the dialing and the handshake of the connecting
+var handshakeTimeout = 30 * time.Second
+
+// SSH ssh://[username:password@]{address}[?identity_file=path/to/file]
+// It is like the ssh of the bridge, but a failed dial only closes the connection when the connection is broken,
+// as the connection is shared by all chains to the hub. The connection is kept alive,
+// and the reconnecting is backed off after it fails.
+// The identity file is read again on each reconnecting, so that the rotated identity is picked up.
+func SSH(dialer bridge.Dialer, address string) (bridge.Dialer, error) {
+	reload := func() (sshClienter, error) {
+		d, err := sshproxy.NewDialer(address)
+		if err != nil {
+			return nil, err
+		}
+		h := &handshakeDialer{Dialer: d}
+		proxyDial := (&net.Dialer{}).DialContext
+		if dialer != nil {
+			proxyDial = dialer.DialContext
+		}
+		d.ProxyDial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			conn, err := proxyDial(ctx, network, address)
+			if err != nil {
+				return nil, err
+			}
+			h.conn = conn
+			h.timer = time.AfterFunc(handshakeTimeout, func() {
+				conn.Close()
+			})
+			return conn, nil
+		}
+		return h, nil
+	}
+	d, err := reload()
+	if err != nil {
+		return nil, err
+	}
+	s := newSSHDialer(d)
+	s.reload = reload
+	return s, nil
+}
+
+type sshClienter interface {
+	SSHClient(ctx context.Context) (*ssh.Client, error)
+	Close() error
+}
+
+// handshakeDialer closes the connection if the ssh handshake is not done in the handshake timeout,
+// as the handshake does not follow the context and the peer may never answer it.
+type handshakeDialer struct {
+	*sshproxy.Dialer
+	conn  net.Conn
+	timer *time.Timer
+}
+
+func (h *handshakeDialer) SSHClient(ctx context.Context) (*ssh.Client, error) {
+	cli, err := h.Dialer.SSHClient(ctx)
+	conn, timer := h.conn, h.timer
+	h.conn, h.timer = nil, nil
+	if timer == nil {
+		return cli, err
+	}
+	if !timer.Stop() {
+		h.Dialer.Close()
+		return nil, fmt.Errorf("ssh handshake not done in %s", handshakeTimeout)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return cli, nil
+}
+
+type sshDialer struct {
+	dialer sshClienter
+	now    func() time.Time
+	// reload returns the dialer with the config read again, the dialer is always reused if reload is nil
+	reload func() (sshClienter, error)
+	// used is true once the dialer has tried to connect, so the next connecting reloads it
+	used bool
+
+	mut sync.Mutex
+	cli *ssh.Client
+	// connecting is closed when the running connecting is done, the other callers wait for it instead of connecting again
+	connecting chan struct{}
+	// closed is increased by Close, so the connection of the connecting that is running over a Close is dropped
+	closed  int
+	backoff time.Duration
+	retryAt time.Time
+	lastErr error
+}
+
+func newSSHDialer(dialer sshClienter) *sshDialer {
+	return &sshDialer{
+		dialer: dialer,
+		now:    time.Now,
+	}
+}
+
+// Healthy returns the error of the last connecting if it is backing off
+func (s *sshDialer) Healthy() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.backoff != 0 {
+		return s.lastErr
+	}
+	return nil
+}
+
+// client returns the connection, the connecting runs outside the lock,
+// so that a peer that never answers only blocks the callers of its own hop.
+func (s *sshDialer) client(ctx context.Context) (*ssh.Client, error) {
+	s.mut.Lock()
+	for {
+		if s.cli != nil {
+			cli := s.cli
+			s.mut.Unlock()
+			return cli, nil
+		}
+		if s.backoff != 0 && s.now().Before(s.retryAt) {
+			err := fmt.Errorf("backing off until %s: %w", s.retryAt.Format(time.RFC3339), s.lastErr)
+			s.mut.Unlock()
+			return nil, err
+		}
+		if s.connecting == nil {
+			break
+		}
+		connecting := s.connecting
+		s.mut.Unlock()
+		select {
+		case <-connecting:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		s.mut.Lock()
+	}
+
+	connecting := make(chan struct{})
+	s.connecting = connecting
+	closed := s.closed
+	dialer, err := s.nextDialer()
+	s.mut.Unlock()
+
+	var cli *ssh.Client
+	if err == nil {
+		cli, err = connect(dialer)
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.connecting = nil
+	close(connecting)
+	if err == nil && closed != s.closed {
+		dialer.Close()
+		err = errors.New("ssh dialer closed while connecting")
+	}
+	if err != nil {
+		s.backoff *= 2
+		if s.backoff < minBackoff {
+			s.backoff = minBackoff
+		} else if s.backoff > maxBackoff {
+			s.backoff = maxBackoff
+		}
+		s.retryAt = s.now().Add(s.backoff)
+		s.lastErr = err
+		return nil, err
+	}
+	s.backoff = 0
+	s.lastErr = nil
+	s.cli = cli
+	go s.keepAlive(cli)
+	return cli, nil
+}
+
+// nextDialer returns the dialer to connect with, which is reloaded if it has been used or failed
+func (s *sshDialer) nextDialer() (sshClienter, error) {
+	if s.used && s.reload != nil {
+		d, err := s.reload()
+		if err != nil {
+			return nil, err
+		}
+		s.dialer = d
+	}
+	s.used = true
+	return s.dialer, nil
+}
+
+// connect connects with the dialer, it is not bound to the context of the caller,
+// as the connection is shared by the other callers
+func connect(dialer sshClienter) (*ssh.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	cli, err := dialer.SSHClient(ctx)
+	if err != nil {
+		dialer.Close()
+		return nil, err
+	}
+	return cli, nil
+}
+
+// Close closes the connection, the next dial reconnects
+func (s *sshDialer) Close() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.closed++
+	s.cli = nil
+	if s.connecting != nil {
+		// The running connecting closes the dialer once it is done
+		return nil
+	}
+	return s.dialer.Close()
+}
+
+// broken closes the connection so that the next dial reconnects
+func (s *sshDialer) broken(cli *ssh.Client) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.cli != cli {
+		return
+	}
+	s.cli = nil
+	s.dialer.Close()
+}
+
+func (s *sshDialer) keepAlive(cli *ssh.Client) {
+	done := make(chan struct{})
+	go func() {
+		cli.Wait()
+		close(done)
+	}()
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			s.broken(cli)
+			return
+		case <-ticker.C:
+			_, _, err := cli.SendRequest("keepalive@openssh.com", true, nil)
+			if err != nil {
+				s.broken(cli)
+				return
+			}
+		}
+	}
+}
+
+func (s *sshDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return retry(s, ctx, func(cli *ssh.Client) (net.Conn, error) {
+		return cli.Dial(network, address)
+	})
+}
+
+func (s *sshDialer) Listen(ctx context.Context, network, address string) (net.Listener, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err == nil && host == "" {
+		address = net.JoinHostPort("0.0.0.0", port)
+	}
+	return retry(s, ctx, func(cli *ssh.Client) (net.Listener, error) {
+		return cli.Listen(network, address)
+	})
+}
+
+func retry[T any](s *sshDialer, ctx context.Context, fun func(cli *ssh.Client) (T, error)) (T, error) {
+	var t T
+	for i := 0; ; i++ {
+		cli, err := s.client(ctx)
+		if err != nil {
+			return t, err
+		}
+		t, err = fun(cli)
+		if err == nil || i != 0 || isRejected(err) {
+			return t, err
+		}
+		// The connection is broken, reconnect once
+		s.broken(cli)
+	}
+}
+
+// isRejected returns true if the peer rejected the request, the connection is still healthy
+func isRejected(err error) bool {
+	var openErr *ssh.OpenChannelError
+	if errors.As(err, &openErr) {
+		return true
+	}
+	return err.Error() == "ssh: tcpip-forward request denied by peer"
+}
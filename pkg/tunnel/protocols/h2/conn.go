@@ -0,0 +1,126 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package h2
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// clientConn is the stream of the CONNECT request, the deadlines are set on the HTTP/2 connection which has only this stream
+type clientConn struct {
+	net.Conn
+	cc     *http2.ClientConn
+	body   io.ReadCloser
+	writer *io.PipeWriter
+}
+
+func (c *clientConn) Read(p []byte) (int, error) {
+	return c.body.Read(p)
+}
+
+func (c *clientConn) Write(p []byte) (int, error) {
+	return c.writer.Write(p)
+}
+
+func (c *clientConn) Close() error {
+	c.writer.Close()
+	c.body.Close()
+	return c.cc.Close()
+}
+
+// serverConn is the stream of the CONNECT request served by the handler
+type serverConn struct {
+	body       io.ReadCloser
+	writer     *io.PipeWriter
+	controller *http.ResponseController
+	localAddr  net.Addr
+	remoteAddr net.Addr
+
+	mut      sync.Mutex
+	finished bool
+}
+
+func (c *serverConn) Read(p []byte) (int, error) {
+	return c.body.Read(p)
+}
+
+func (c *serverConn) Write(p []byte) (int, error) {
+	return c.writer.Write(p)
+}
+
+func (c *serverConn) Close() error {
+	c.writer.Close()
+	return c.body.Close()
+}
+
+// finish is called when the handler returns, the controller is unusable after it
+func (c *serverConn) finish() {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.finished = true
+}
+
+func (c *serverConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *serverConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *serverConn) SetDeadline(t time.Time) error {
+	err := c.SetReadDeadline(t)
+	if err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *serverConn) SetReadDeadline(t time.Time) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if c.finished {
+		return net.ErrClosed
+	}
+	return c.controller.SetReadDeadline(t)
+}
+
+func (c *serverConn) SetWriteDeadline(t time.Time) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if c.finished {
+		return net.ErrClosed
+	}
+	return c.controller.SetWriteDeadline(t)
+}
+
+// addr is the address of the request
+type addr string
+
+func (a addr) Network() string {
+	return "tcp"
+}
+
+func (a addr) String() string {
+	return string(a)
+}
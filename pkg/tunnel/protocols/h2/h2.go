@@ -0,0 +1,240 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package h2
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/ferryproxy/ferry/pkg/tunnel/protocols/mtls"
+	"github.com/wzshiming/bridge"
+	"github.com/wzshiming/bridge/protocols/local"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// H2 h2://[host:port]?dir=[dir]&server_name=[hub]&insecure=[bool] or h2c://[host:port]
+// As a dialer it carries the connection in a stream of the HTTP/2 CONNECT to the dialed address, each dial has its own
+// HTTP/2 connection, the HTTP proxy is traversed by the http:// before it in the chain.
+// The h2 verifies the server by the system roots, or by the CA in the dir if it is set, then the certificate of the dir
+// is presented as well and the server name is the hub, the h2c speaks the HTTP/2 in cleartext with the prior knowledge.
+// It has no listener, the HTTP/2 CONNECT is served by the gateway of the WebSocket, see ConfigureServer.
+func H2(dialer bridge.Dialer, address string) (bridge.Dialer, error) {
+	if dialer == nil {
+		dialer = local.LOCAL
+	}
+	uri, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	query := uri.Query()
+	insecure, _ := strconv.ParseBool(query.Get("insecure"))
+	return &h2Dialer{
+		dialer:     dialer,
+		cleartext:  uri.Scheme == "h2c",
+		insecure:   insecure,
+		dir:        query.Get("dir"),
+		serverName: query.Get("server_name"),
+	}, nil
+}
+
+type h2Dialer struct {
+	dialer     bridge.Dialer
+	cleartext  bool
+	insecure   bool
+	dir        string
+	serverName string
+}
+
+// clientConfig returns the TLS config of the h2 to the host
+func (h *h2Dialer) clientConfig(host string) (*tls.Config, error) {
+	serverName := h.serverName
+	if serverName == "" {
+		serverName = host
+	}
+	if h.dir == "" {
+		return &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: h.insecure,
+			NextProtos:         []string{http2.NextProtoTLS},
+			MinVersion:         tls.VersionTLS12,
+		}, nil
+	}
+	return mtls.ClientConfig(h.dir, serverName, http2.NextProtoTLS)
+}
+
+func (h *h2Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := h.dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	// The ctx only bounds the handshake, the stream lives until the conn is closed
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	c, err := h.connect(ctx, conn, address)
+	close(stop)
+	<-stopped
+	if err == nil && ctx.Err() != nil {
+		c.Close()
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// connect opens the stream of the CONNECT on the conn
+func (h *h2Dialer) connect(ctx context.Context, conn net.Conn, address string) (*clientConn, error) {
+	if !h.cleartext {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			host = address
+		}
+		config, err := h.clientConfig(host)
+		if err != nil {
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, config)
+		err = tlsConn.HandshakeContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if proto := tlsConn.ConnectionState().NegotiatedProtocol; proto != http2.NextProtoTLS {
+			return nil, fmt.Errorf("the server of %s does not support the HTTP/2, negotiated %q", address, proto)
+		}
+		conn = tlsConn
+	}
+
+	cc, err := (&http2.Transport{}).NewClientConn(conn)
+	if err != nil {
+		return nil, err
+	}
+	reader, writer := io.Pipe()
+	req := &http.Request{
+		Method:        http.MethodConnect,
+		URL:           &url.URL{Host: address},
+		Host:          address,
+		Header:        http.Header{},
+		Body:          reader,
+		ContentLength: -1,
+	}
+	resp, err := cc.RoundTrip(req)
+	if err != nil {
+		writer.Close()
+		cc.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		writer.Close()
+		cc.Close()
+		return nil, fmt.Errorf("connect to %s: %s", address, resp.Status)
+	}
+	return &clientConn{
+		Conn:   conn,
+		cc:     cc,
+		body:   resp.Body,
+		writer: writer,
+	}, nil
+}
+
+// ConfigureServer makes the server accept the HTTP/2 CONNECT requests as the connections passed to the accept,
+// over the TLS negotiated by the ALPN or in cleartext with the prior knowledge, the other requests are served by the handler of the server.
+// The authority of the requests is ignored, the connections go where the listener of the server forwards.
+func ConfigureServer(server *http.Server, accept func(net.Conn) bool) error {
+	h2Server := &http2.Server{}
+	err := http2.ConfigureServer(server, h2Server)
+	if err != nil {
+		return err
+	}
+	next := server.Handler
+	server.Handler = h2c.NewHandler(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect || r.ProtoMajor != 2 {
+			next.ServeHTTP(rw, r)
+			return
+		}
+		serveConnect(rw, r, accept)
+	}), h2Server)
+	return nil
+}
+
+// serveConnect passes the stream of the CONNECT request to the accept and copies the conn to the stream until it's closed
+func serveConnect(rw http.ResponseWriter, r *http.Request, accept func(net.Conn) bool) {
+	controller := http.NewResponseController(rw)
+	rw.WriteHeader(http.StatusOK)
+	err := controller.Flush()
+	if err != nil {
+		return
+	}
+
+	reader, writer := io.Pipe()
+	conn := &serverConn{
+		body:       r.Body,
+		writer:     writer,
+		controller: controller,
+		remoteAddr: addr(r.RemoteAddr),
+	}
+	if localAddr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		conn.localAddr = localAddr
+	} else {
+		conn.localAddr = addr("")
+	}
+	defer conn.finish()
+	if !accept(conn) {
+		return
+	}
+
+	// The handler must not return before the copy ends, the ResponseWriter is unusable after that
+	go func() {
+		<-r.Context().Done()
+		reader.CloseWithError(net.ErrClosed)
+	}()
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := reader.Read(buf)
+		if n > 0 {
+			_, werr := rw.Write(buf[:n])
+			if werr == nil {
+				werr = controller.Flush()
+			}
+			if werr != nil {
+				reader.CloseWithError(werr)
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package h2
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ferryproxy/ferry/pkg/utils/certs"
+	"github.com/wzshiming/bridge"
+	"github.com/wzshiming/bridge/protocols/connect"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func writeCert(t *testing.T, caCert, caKey []byte, name string) string {
+	dir := t.TempDir()
+	cert, key, err := certs.IssueCert(caCert, caKey, name, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, data := range map[string][]byte{
+		corev1.ServiceAccountRootCAKey: caCert,
+		corev1.TLSCertKey:              cert,
+		corev1.TLSPrivateKeyKey:        key,
+	} {
+		err = os.WriteFile(filepath.Join(dir, file), data, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestH2(t *testing.T) {
+	caCert, caKey, err := certs.NewCA("ferry", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exportDir := writeCert(t, caCert, caKey, "export")
+	importDir := writeCert(t, caCert, caKey, "import")
+
+	tests := []struct {
+		name    string
+		dir     string
+		dial    string
+		proxy   bool
+		wantErr bool
+	}{
+		{
+			name: "h2c",
+			dial: "h2c://",
+		},
+		{
+			name: "h2",
+			dir:  exportDir,
+			dial: "h2://?server_name=export&dir=" + importDir,
+		},
+		{
+			name:  "h2 through the http proxy",
+			dir:   exportDir,
+			dial:  "h2://?server_name=export&dir=" + importDir,
+			proxy: true,
+		},
+		{
+			name:    "h2 with the wrong hub",
+			dir:     exportDir,
+			dial:    "h2://?server_name=other&dir=" + importDir,
+			wantErr: true,
+		},
+		{
+			name:    "h2 with the system roots",
+			dir:     exportDir,
+			dial:    "h2://",
+			wantErr: true,
+		},
+		{
+			name: "h2 insecure",
+			dir:  exportDir,
+			dial: "h2://?insecure=true",
+		},
+		{
+			name:    "h2 to the cleartext server",
+			dial:    "h2://?insecure=true",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := echo(t, tt.dir, tt.dial, tt.proxy)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("echo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// echo dials the echo server that serves the HTTP/2 CONNECT, with the TLS of the dir if it is set
+func echo(t *testing.T, dir, dial string, proxy bool) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	if dir != "" {
+		cert, err := tls.LoadX509KeyPair(filepath.Join(dir, corev1.TLSCertKey), filepath.Join(dir, corev1.TLSPrivateKeyKey))
+		if err != nil {
+			return err
+		}
+		listener = tls.NewListener(listener, &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"h2", "http/1.1"},
+		})
+	}
+	server := &http.Server{
+		Handler: http.NotFoundHandler(),
+	}
+	err = ConfigureServer(server, func(conn net.Conn) bool {
+		go func() {
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}()
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	var dialer bridge.Dialer
+	if proxy {
+		proxyAddress, err := startProxy(t)
+		if err != nil {
+			return err
+		}
+		dialer, err = connect.CONNECT(nil, "http://"+proxyAddress)
+		if err != nil {
+			return err
+		}
+	}
+	d, err := H2(dialer, dial)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := d.DialContext(ctx, "tcp", listener.Addr().String())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The stream outlives the ctx of the dial
+	cancel()
+	for _, want := range []string{"SSH-2.0-ferry\r\n", "after the dial"} {
+		_, err = conn.Write([]byte(want))
+		if err != nil {
+			return err
+		}
+		got := make([]byte, len(want))
+		_, err = io.ReadFull(conn, got)
+		if err != nil {
+			return err
+		}
+		if string(got) != want {
+			return fmt.Errorf("got %q, want %q", got, want)
+		}
+	}
+	return nil
+}
+
+// startProxy starts the HTTP proxy that tunnels with the HTTP/1.1 CONNECT
+func startProxy(t *testing.T) (string, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodConnect {
+				http.Error(rw, "only CONNECT", http.StatusMethodNotAllowed)
+				return
+			}
+			target, err := net.Dial("tcp", r.Host)
+			if err != nil {
+				http.Error(rw, err.Error(), http.StatusBadGateway)
+				return
+			}
+			rw.WriteHeader(http.StatusOK)
+			conn, _, err := http.NewResponseController(rw).Hijack()
+			if err != nil {
+				target.Close()
+				return
+			}
+			go func() {
+				defer target.Close()
+				io.Copy(target, conn)
+			}()
+			defer conn.Close()
+			io.Copy(conn, target)
+		}),
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() {
+		server.Close()
+	})
+	return listener.Addr().String(), nil
+}
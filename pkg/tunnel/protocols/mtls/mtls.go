@@ -0,0 +1,238 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mtls
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ferryproxy/ferry/pkg/consts"
+	"github.com/ferryproxy/ferry/pkg/tunnel/protocols/stream"
+	"github.com/go-logr/logr"
+	"github.com/wzshiming/bridge"
+	"github.com/wzshiming/bridge/protocols/local"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// MTLS mtls://[host:port]?server_name=[hub]&dir=[dir]
+// It dials and listens through the gateway of the hub, each connection is a TLS connection whose ALPN selects the op,
+// and the gateway authorizes it by the hub in the client certificate against the AllowList of the hub.
+// Both sides authenticate each other with the certificates issued by the CA of the control plane,
+// the files are loaded from the dir on each connection so that the renewed certificates take effect immediately.
+func MTLS(dialer bridge.Dialer, address string) (bridge.Dialer, error) {
+	if dialer == nil {
+		dialer = local.LOCAL
+	}
+	uri, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if uri.Host == "" {
+		return nil, fmt.Errorf("no gateway address of %q", address)
+	}
+	query := uri.Query()
+	dir := query.Get("dir")
+	if dir == "" {
+		dir = consts.TunnelTLSDir
+	}
+	serverName := query.Get("server_name")
+	if serverName == "" {
+		serverName = uri.Hostname()
+	}
+	m := &mTLS{
+		dialer:     dialer,
+		address:    uri.Host,
+		dir:        dir,
+		serverName: serverName,
+	}
+	m.client = stream.NewClient(m.open)
+	return m, nil
+}
+
+type mTLS struct {
+	dialer     bridge.Dialer
+	address    string
+	dir        string
+	serverName string
+	client     *stream.Client
+}
+
+func (m *mTLS) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return m.client.DialContext(ctx, network, address)
+}
+
+func (m *mTLS) Listen(ctx context.Context, network, address string) (net.Listener, error) {
+	return m.client.Listen(ctx, network, address)
+}
+
+// open opens a TLS connection to the gateway for the op
+func (m *mTLS) open(ctx context.Context, op string) (net.Conn, error) {
+	alpn := stream.ALPN(op)
+	conf, err := ClientConfig(m.dir, m.serverName, alpn)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := m.dialer.DialContext(ctx, "tcp", m.address)
+	if err != nil {
+		return nil, err
+	}
+	tc := tls.Client(conn, conf)
+	err = tc.HandshakeContext(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if tc.ConnectionState().NegotiatedProtocol != alpn {
+		tc.Close()
+		return nil, fmt.Errorf("unexpected protocol %q of %s", tc.ConnectionState().NegotiatedProtocol, m.address)
+	}
+	return tc, nil
+}
+
+// Server is the gateway of the mtls, it is served by the chain like
+// {"bind": ["mtls://[host:port]?dir=[dir]&home_dir=[home_dir]&permissions_file_name=[permissions_file_name]"], "proxy": ["-"]}
+type Server struct {
+	address string
+	dir     string
+	streams *stream.Server
+}
+
+// NewServer returns the gateway of the address
+func NewServer(logger logr.Logger, address string) (*Server, error) {
+	uri, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	query := uri.Query()
+	dir := query.Get("dir")
+	if dir == "" {
+		dir = consts.TunnelTLSDir
+	}
+	return &Server{
+		address: uri.Host,
+		dir:     dir,
+		streams: stream.NewServer(logger, query.Get("home_dir"), query.Get("permissions_file_name")),
+	}, nil
+}
+
+// ListenAndServe listens on the address and serves until the ctx is done, ready is called when the listener is bound or failed
+func (s *Server) ListenAndServe(ctx context.Context, ready func(error)) error {
+	var listenConfig net.ListenConfig
+	listener, err := listenConfig.Listen(ctx, "tcp", s.address)
+	ready(err)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve serves the listener until the ctx is done
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+	conf := ServerConfig(s.dir, stream.ALPNs()...)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go s.serveConn(ctx, tls.Server(conn, conf))
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, conn *tls.Conn) {
+	hctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	err := conn.HandshakeContext(hctx)
+	cancel()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	state := conn.ConnectionState()
+	// The clients that do not negotiate the op are rejected
+	op, ok := stream.OpOf(state.NegotiatedProtocol)
+	if !ok || len(state.PeerCertificates) == 0 {
+		conn.Close()
+		return
+	}
+	s.streams.ServeConn(ctx, conn, state.PeerCertificates[0].Subject.CommonName, op)
+}
+
+// ClientConfig returns the TLS config that authenticates with the certificate in the dir
+func ClientConfig(dir, serverName string, protos ...string) (*tls.Config, error) {
+	cert, pool, err := loadFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		ServerName:   serverName,
+		NextProtos:   protos,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ServerConfig returns the TLS config that requires the client certificate signed by the CA in the dir,
+// the files are loaded on each handshake.
+func ServerConfig(dir string, protos ...string) *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool, err := loadFiles(dir)
+			if err != nil {
+				return nil, err
+			}
+			return &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				ClientCAs:    pool,
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				NextProtos:   protos,
+				MinVersion:   tls.VersionTLS12,
+			}, nil
+		},
+		NextProtos: protos,
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+func loadFiles(dir string) (tls.Certificate, *x509.CertPool, error) {
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, corev1.TLSCertKey), filepath.Join(dir, corev1.TLSPrivateKeyKey))
+	if err != nil {
+		return cert, nil, err
+	}
+	ca, err := os.ReadFile(filepath.Join(dir, corev1.ServiceAccountRootCAKey))
+	if err != nil {
+		return cert, nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return cert, nil, fmt.Errorf("no CA found in %s", dir)
+	}
+	return cert, pool, nil
+}
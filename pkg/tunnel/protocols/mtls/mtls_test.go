@@ -0,0 +1,231 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mtls
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ferryproxy/ferry/pkg/utils/certs"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func writeCert(t *testing.T, caCert, caKey []byte, name string) string {
+	dir := t.TempDir()
+	cert, key, err := certs.IssueCert(caCert, caKey, name, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, data := range map[string][]byte{
+		corev1.ServiceAccountRootCAKey: caCert,
+		corev1.TLSCertKey:              cert,
+		corev1.TLSPrivateKeyKey:        key,
+	} {
+		err = os.WriteFile(filepath.Join(dir, file), data, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// writePermissions allows the hub to dial and listen on the addresses
+func writePermissions(t *testing.T, hub string, dials, listens []string) string {
+	homeDir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(homeDir, hub, ".ssh"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	perm, _ := json.Marshal(map[string]interface{}{
+		"direct-tcpip":  map[string]interface{}{"allows": dials},
+		"tcpip-forward": map[string]interface{}{"allows": listens},
+	})
+	err = os.WriteFile(filepath.Join(homeDir, hub, ".ssh", "permissions.json"), perm, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return homeDir
+}
+
+func echoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+func ping(conn net.Conn) error {
+	_, err := conn.Write([]byte("ping"))
+	if err != nil {
+		return err
+	}
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	return err
+}
+
+func TestMTLS(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	caCert, caKey, err := certs.NewCA("ferry", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCACert, otherCAKey, err := certs.NewCA("other", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	echo := echoServer(t)
+	homeDir := writePermissions(t, "import", []string{echo.Addr().String()}, []string{"127.0.0.1:0"})
+	server, err := NewServer(logr.Discard(), "mtls://?dir="+writeCert(t, caCert, caKey, "export")+
+		"&home_dir="+homeDir+"&permissions_file_name=permissions.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ctx, listener)
+	gateway := listener.Addr().String()
+
+	tests := []struct {
+		name    string
+		address string
+		dial    string
+		wantErr bool
+	}{
+		{
+			name:    "same CA",
+			address: "mtls://" + gateway + "?server_name=export&dir=" + writeCert(t, caCert, caKey, "import"),
+			dial:    echo.Addr().String(),
+		},
+		{
+			name:    "not allowed address",
+			address: "mtls://" + gateway + "?server_name=export&dir=" + writeCert(t, caCert, caKey, "import"),
+			dial:    gateway,
+			wantErr: true,
+		},
+		{
+			name:    "not allowed hub",
+			address: "mtls://" + gateway + "?server_name=export&dir=" + writeCert(t, caCert, caKey, "other"),
+			dial:    echo.Addr().String(),
+			wantErr: true,
+		},
+		{
+			name:    "wrong server name",
+			address: "mtls://" + gateway + "?server_name=other&dir=" + writeCert(t, caCert, caKey, "import"),
+			dial:    echo.Addr().String(),
+			wantErr: true,
+		},
+		{
+			name:    "other CA",
+			address: "mtls://" + gateway + "?server_name=export&dir=" + writeCert(t, otherCACert, otherCAKey, "import"),
+			dial:    echo.Addr().String(),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := MTLS(nil, tt.address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, err := client.DialContext(ctx, "tcp", tt.dial)
+			if err == nil {
+				defer conn.Close()
+				err = ping(conn)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("without ALPN", func(t *testing.T) {
+		conf, err := ClientConfig(writeCert(t, caCert, caKey, "import"), "export")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := tls.Dial("tcp", gateway, conf)
+		if err == nil {
+			defer conn.Close()
+			// A valid dial request that is served if the op is not required to be negotiated
+			req, _ := json.Marshal(map[string]string{"op": "dial", "network": "tcp", "address": echo.Addr().String()})
+			_, err = conn.Write(append([]byte{0, byte(len(req))}, req...))
+			if err == nil {
+				_, err = conn.Read(make([]byte, 1))
+			}
+		}
+		if err == nil {
+			t.Fatal("want the connection without ALPN to be rejected")
+		}
+	})
+
+	t.Run("listen", func(t *testing.T) {
+		client, err := MTLS(nil, "mtls://"+gateway+"?server_name=export&dir="+writeCert(t, caCert, caKey, "import"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		listener, err := client.(interface {
+			Listen(ctx context.Context, network, address string) (net.Listener, error)
+		}).Listen(ctx, "tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}()
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		err = ping(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
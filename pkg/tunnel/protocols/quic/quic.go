@@ -0,0 +1,254 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ferryproxy/ferry/pkg/consts"
+	"github.com/ferryproxy/ferry/pkg/tunnel/protocols/mtls"
+	"github.com/ferryproxy/ferry/pkg/tunnel/protocols/stream"
+	"github.com/go-logr/logr"
+	"github.com/quic-go/quic-go"
+	"github.com/wzshiming/bridge"
+	"github.com/wzshiming/bridge/protocols/local"
+)
+
+var quicConfig = &quic.Config{
+	Allow0RTT:       true,
+	KeepAlivePeriod: 15 * time.Second,
+	MaxIdleTimeout:  time.Minute,
+}
+
+// QUIC quic://[host:port]?server_name=[hub]&dir=[dir]
+// It dials and listens through the gateway of the hub, each connection is a stream of the QUIC connection
+// that is shared by the chains to the same gateway, so the connections do not block each other,
+// and the QUIC connection is resumed with 0-RTT.
+// It is authenticated with the same certificates as mtls, and can only be the first hop as it is over UDP.
+func QUIC(dialer bridge.Dialer, address string) (bridge.Dialer, error) {
+	if dialer != nil && dialer != bridge.Dialer(local.LOCAL) {
+		return nil, fmt.Errorf("quic can only be dialed directly")
+	}
+	uri, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if uri.Host == "" {
+		return nil, fmt.Errorf("no gateway address of %q", address)
+	}
+	query := uri.Query()
+	dir := query.Get("dir")
+	if dir == "" {
+		dir = consts.TunnelTLSDir
+	}
+	serverName := query.Get("server_name")
+	if serverName == "" {
+		serverName = uri.Hostname()
+	}
+	q := &quicBridge{
+		address:    uri.Host,
+		dir:        dir,
+		serverName: serverName,
+		sessions:   tls.NewLRUClientSessionCache(0),
+	}
+	q.client = stream.NewClient(q.open)
+	return q, nil
+}
+
+type quicBridge struct {
+	address    string
+	dir        string
+	serverName string
+	client     *stream.Client
+
+	mut      sync.Mutex
+	conn     quic.EarlyConnection
+	sessions tls.ClientSessionCache
+}
+
+func (q *quicBridge) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return q.client.DialContext(ctx, network, address)
+}
+
+func (q *quicBridge) Listen(ctx context.Context, network, address string) (net.Listener, error) {
+	return q.client.Listen(ctx, network, address)
+}
+
+// open opens a stream on the QUIC connection to the gateway, the op is carried by the stream itself
+func (q *quicBridge) open(ctx context.Context, op string) (net.Conn, error) {
+	conn, err := q.getConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		// The connection is broken, dial a new one
+		q.dropConn(conn)
+		conn, err = q.getConn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		s, err = conn.OpenStreamSync(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newConn(conn, s), nil
+}
+
+func (q *quicBridge) getConn(ctx context.Context) (quic.EarlyConnection, error) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	if q.conn != nil && q.conn.Context().Err() == nil {
+		return q.conn, nil
+	}
+
+	conf, err := mtls.ClientConfig(q.dir, q.serverName, consts.TunnelTLSALPN)
+	if err != nil {
+		return nil, err
+	}
+	conf.ClientSessionCache = q.sessions
+	conn, err := quic.DialAddrEarly(ctx, q.address, conf, quicConfig)
+	if err != nil {
+		return nil, err
+	}
+	q.conn = conn
+	return conn, nil
+}
+
+func (q *quicBridge) dropConn(conn quic.EarlyConnection) {
+	q.mut.Lock()
+	defer q.mut.Unlock()
+	if q.conn == conn {
+		q.conn = nil
+	}
+	conn.CloseWithError(0, "")
+}
+
+// Server is the gateway of the quic, it is served by the chain like
+// {"bind": ["quic://[host:port]?dir=[dir]&home_dir=[home_dir]&permissions_file_name=[permissions_file_name]"], "proxy": ["-"]}
+type Server struct {
+	address string
+	dir     string
+	streams *stream.Server
+}
+
+// NewServer returns the gateway of the address
+func NewServer(logger logr.Logger, address string) (*Server, error) {
+	uri, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	query := uri.Query()
+	dir := query.Get("dir")
+	if dir == "" {
+		dir = consts.TunnelTLSDir
+	}
+	return &Server{
+		address: uri.Host,
+		dir:     dir,
+		streams: stream.NewServer(logger, query.Get("home_dir"), query.Get("permissions_file_name")),
+	}, nil
+}
+
+// ListenAndServe listens on the address and serves until the ctx is done, ready is called when the listener is bound or failed
+func (s *Server) ListenAndServe(ctx context.Context, ready func(error)) error {
+	conn, err := net.ListenPacket("udp", s.address)
+	ready(err)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return s.Serve(ctx, conn)
+}
+
+// Serve serves the packet conn until the ctx is done
+func (s *Server) Serve(ctx context.Context, pc net.PacketConn) error {
+	listener, err := quic.ListenEarly(pc, mtls.ServerConfig(s.dir, consts.TunnelTLSALPN), quicConfig)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	for {
+		conn, err := listener.Accept(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, quic.ErrServerClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.serveConn(ctx, conn)
+	}
+}
+
+func (s *Server) serveConn(ctx context.Context, conn quic.EarlyConnection) {
+	// The hub in the client certificate is only known after the handshake, even for the streams of 0-RTT
+	select {
+	case <-conn.HandshakeComplete():
+	case <-conn.Context().Done():
+		return
+	case <-ctx.Done():
+		conn.CloseWithError(0, "")
+		return
+	}
+	state := conn.ConnectionState().TLS
+	if state.NegotiatedProtocol != consts.TunnelTLSALPN || len(state.PeerCertificates) == 0 {
+		conn.CloseWithError(0, "unexpected protocol")
+		return
+	}
+	user := state.PeerCertificates[0].Subject.CommonName
+	for {
+		st, err := conn.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		go s.streams.ServeConn(ctx, newConn(conn, st), user, "")
+	}
+}
+
+// conn is a net.Conn of the QUIC stream
+type conn struct {
+	quic.Stream
+	conn quic.Connection
+}
+
+func newConn(c quic.Connection, stream quic.Stream) net.Conn {
+	return &conn{
+		Stream: stream,
+		conn:   c,
+	}
+}
+
+func (c *conn) Close() error {
+	c.Stream.CancelRead(0)
+	return c.Stream.Close()
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// handshakeTimeout is the timeout of the request and the response of a stream
+const handshakeTimeout = 10 * time.Second
+
+// Opener opens a stream to the gateway for the op
+type Opener func(ctx context.Context, op string) (net.Conn, error)
+
+// Client dials and listens through the gateway, each connection is a stream opened by the opener
+type Client struct {
+	open Opener
+}
+
+// NewClient returns the client of the opener
+func NewClient(open Opener) *Client {
+	return &Client{
+		open: open,
+	}
+}
+
+// DialContext dials the address from the gateway
+func (c *Client) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, _, err := c.roundTrip(ctx, request{
+		Op:      OpDial,
+		Network: network,
+		Address: address,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Listen listens on the address of the gateway
+func (c *Client) Listen(ctx context.Context, network, address string) (net.Listener, error) {
+	conn, resp, err := c.roundTrip(ctx, request{
+		Op:      OpListen,
+		Network: network,
+		Address: address,
+	})
+	if err != nil {
+		return nil, err
+	}
+	l := &listener{
+		client: c,
+		ctrl:   conn,
+		addr:   addr{network: network, address: resp.Address},
+		conns:  make(chan net.Conn),
+		done:   make(chan struct{}),
+	}
+	go l.run()
+	return l, nil
+}
+
+func (c *Client) roundTrip(ctx context.Context, req request) (net.Conn, *response, error) {
+	conn, err := c.open(ctx, req.Op)
+	if err != nil {
+		return nil, nil, err
+	}
+	deadline := time.Now().Add(handshakeTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+	err = writeFrame(conn, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	var resp response
+	err = readFrame(conn, &resp)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if resp.Error != "" {
+		conn.Close()
+		return nil, nil, fmt.Errorf("%s %s %s: %s", req.Op, req.Network, req.Address, resp.Error)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, &resp, nil
+}
+
+// listener receives the ids of the accepted connections from the listen stream,
+// and picks up each of them with an accept stream
+type listener struct {
+	client *Client
+	ctrl   net.Conn
+	addr   addr
+	conns  chan net.Conn
+	err    error
+	done   chan struct{}
+	once   sync.Once
+}
+
+func (l *listener) run() {
+	for {
+		var resp response
+		err := readFrame(l.ctrl, &resp)
+		if err != nil {
+			l.closeWithErr(err)
+			return
+		}
+		go l.pickup(resp.ID)
+	}
+}
+
+func (l *listener) pickup(id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	conn, _, err := l.client.roundTrip(ctx, request{
+		Op: OpAccept,
+		ID: id,
+	})
+	if err != nil {
+		return
+	}
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+func (l *listener) Close() error {
+	l.closeWithErr(net.ErrClosed)
+	return l.ctrl.Close()
+}
+
+func (l *listener) closeWithErr(err error) {
+	l.once.Do(func() {
+		if errors.Is(err, net.ErrClosed) {
+			err = net.ErrClosed
+		}
+		l.err = err
+		close(l.done)
+	})
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.addr
+}
+
+type addr struct {
+	network string
+	address string
+}
+
+func (a addr) Network() string {
+	return a.network
+}
+
+func (a addr) String() string {
+	return a.address
+}
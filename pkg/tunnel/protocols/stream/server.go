@@ -0,0 +1,288 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stream
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/wzshiming/sshd"
+	"github.com/wzshiming/sshproxy/permissions"
+)
+
+// Server serves the streams of the hubs, the requests are authorized with the same permissions file as the sshd of the gateway,
+// i.e. [homeDir]/[hub]/.ssh/[permissionsFileName], so the AllowList of the hub applies to all the transports.
+type Server struct {
+	logger              logr.Logger
+	homeDir             string
+	permissionsFileName string
+	dialer              net.Dialer
+	listenConfig        net.ListenConfig
+
+	mut         sync.Mutex
+	permissions map[string]sshd.Permissions
+	pending     map[string]*pending
+}
+
+// pending is the accepted connection that waits for the accept stream
+type pending struct {
+	user  string
+	conn  net.Conn
+	timer *time.Timer
+}
+
+// NewServer returns the server that authorizes the hubs by the permissions files in the homeDir
+func NewServer(logger logr.Logger, homeDir, permissionsFileName string) *Server {
+	return &Server{
+		logger:              logger,
+		homeDir:             homeDir,
+		permissionsFileName: permissionsFileName,
+		permissions:         map[string]sshd.Permissions{},
+		pending:             map[string]*pending{},
+	}
+}
+
+// ServeConn serves the stream of the user which is the hub authenticated by the transport,
+// op is the op negotiated by the transport, or empty if the stream can carry any op.
+func (s *Server) ServeConn(ctx context.Context, conn net.Conn, user, op string) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	var req request
+	err := readFrame(conn, &req)
+	if err != nil {
+		s.logger.Error(err, "Read request", "user", user, "remote", conn.RemoteAddr())
+		return
+	}
+	if op != "" && req.Op != op {
+		s.reply(conn, fmt.Errorf("op %q is not negotiated as %q", req.Op, op))
+		return
+	}
+
+	switch req.Op {
+	case OpDial:
+		s.serveDial(ctx, conn, user, req)
+	case OpListen:
+		s.serveListen(ctx, conn, user, req)
+	case OpAccept:
+		s.serveAccept(ctx, conn, user, req)
+	default:
+		s.reply(conn, fmt.Errorf("unsupported op %q", req.Op))
+	}
+}
+
+func (s *Server) serveDial(ctx context.Context, conn net.Conn, user string, req request) {
+	name, err := permissionName(req.Op, req.Network)
+	if err == nil {
+		err = s.authorize(user, name, req.Address)
+	}
+	if err != nil {
+		s.reply(conn, err)
+		return
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	target, err := s.dialer.DialContext(dialCtx, req.Network, req.Address)
+	cancel()
+	if err != nil {
+		s.reply(conn, err)
+		return
+	}
+	defer target.Close()
+	if !s.reply(conn, nil) {
+		return
+	}
+	_ = tunnel(ctx, conn, target)
+}
+
+func (s *Server) serveListen(ctx context.Context, conn net.Conn, user string, req request) {
+	name, err := permissionName(req.Op, req.Network)
+	if err == nil {
+		err = s.authorize(user, name, req.Address)
+	}
+	if err != nil {
+		s.reply(conn, err)
+		return
+	}
+	listener, err := s.listenConfig.Listen(ctx, req.Network, req.Address)
+	if err != nil {
+		s.reply(conn, err)
+		return
+	}
+	defer listener.Close()
+	if !s.reply(conn, nil, listener.Addr().String()) {
+		return
+	}
+
+	// The listener is closed when the client closes the listen stream
+	go func() {
+		_, _ = io.Copy(io.Discard, conn)
+		listener.Close()
+	}()
+	for {
+		c, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.logger.Error(err, "Accept", "user", user, "listen", req.Address)
+			}
+			return
+		}
+		id := s.park(user, c)
+		err = writeFrame(conn, response{ID: id})
+		if err != nil {
+			s.take(user, id)
+			c.Close()
+			return
+		}
+	}
+}
+
+func (s *Server) serveAccept(ctx context.Context, conn net.Conn, user string, req request) {
+	c := s.take(user, req.ID)
+	if c == nil {
+		s.reply(conn, fmt.Errorf("unknown id %q", req.ID))
+		return
+	}
+	defer c.Close()
+	if !s.reply(conn, nil) {
+		return
+	}
+	_ = tunnel(ctx, conn, c)
+}
+
+// reply writes the response of the request and clears the deadline of the handshake
+func (s *Server) reply(conn net.Conn, err error, address ...string) bool {
+	var resp response
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	if len(address) != 0 {
+		resp.Address = address[0]
+	}
+	werr := writeFrame(conn, resp)
+	if werr != nil {
+		return false
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return err == nil
+}
+
+func (s *Server) authorize(user, name, args string) error {
+	if user == "" || user == "." || user == ".." || strings.ContainsAny(user, `/\`) {
+		return fmt.Errorf("invalid user %q", user)
+	}
+	if s.permissionsFileName == "" {
+		return fmt.Errorf("no permissions for %q", user)
+	}
+	s.mut.Lock()
+	perm, ok := s.permissions[user]
+	if !ok {
+		perm = permissions.NewPermissionsFromFile(path.Join(s.homeDir, user, ".ssh", s.permissionsFileName), 0)
+		s.permissions[user] = perm
+	}
+	s.mut.Unlock()
+	if !perm.Allow(name, args) {
+		return fmt.Errorf("%s %s is denied for %q", name, args, user)
+	}
+	return nil
+}
+
+// permissionName returns the name of the permission of the op, they are the same as the requests of the ssh
+func permissionName(op, network string) (string, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		if op == OpListen {
+			return "tcpip-forward", nil
+		}
+		return "direct-tcpip", nil
+	case "unix":
+		if op == OpListen {
+			return "streamlocal-forward", nil
+		}
+		return "direct-streamlocal", nil
+	}
+	return "", fmt.Errorf("unsupported network %q", network)
+}
+
+// park keeps the accepted connection until it is picked up by the accept stream of the user
+func (s *Server) park(user string, conn net.Conn) string {
+	var buf [16]byte
+	_, _ = rand.Read(buf[:])
+	id := hex.EncodeToString(buf[:])
+	p := &pending{
+		user: user,
+		conn: conn,
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.pending[id] = p
+	p.timer = time.AfterFunc(handshakeTimeout, func() {
+		if c := s.take(user, id); c != nil {
+			c.Close()
+		}
+	})
+	return id
+}
+
+func (s *Server) take(user, id string) net.Conn {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	p := s.pending[id]
+	if p == nil || p.user != user {
+		return nil
+	}
+	delete(s.pending, id)
+	p.timer.Stop()
+	return p.conn
+}
+
+// tunnel copies the data between the connections until one of them is done
+func tunnel(ctx context.Context, c1, c2 io.ReadWriteCloser) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, errs[0] = io.Copy(c1, c2)
+		cancel()
+	}()
+	go func() {
+		defer wg.Done()
+		_, errs[1] = io.Copy(c2, c1)
+		cancel()
+	}()
+	<-ctx.Done()
+	c1.Close()
+	c2.Close()
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			return err
+		}
+	}
+	return nil
+}
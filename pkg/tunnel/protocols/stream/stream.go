@@ -0,0 +1,107 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package stream is the protocol of the hops to the gateways of the hubs over the mtls and the quic,
+// each connection through the hop is a stream of the transport, so that the connections do not block each other.
+//
+// A stream starts with a request frame and a response frame, a frame is the JSON with a 2 bytes length prefix,
+// then the stream is the connection itself.
+// The listen stream stays open for the notifications of the accepted connections,
+// which are picked up by the accept streams with their ids.
+package stream
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// The ops of the streams
+const (
+	OpDial   = "dial"
+	OpListen = "listen"
+	OpAccept = "accept"
+)
+
+const alpnPrefix = "ferry-"
+
+// ALPN returns the application protocol of the op, so that the transports can negotiate the op in the handshake
+func ALPN(op string) string {
+	return alpnPrefix + op
+}
+
+// ALPNs returns the application protocols of all the ops
+func ALPNs() []string {
+	return []string{ALPN(OpDial), ALPN(OpListen), ALPN(OpAccept)}
+}
+
+// OpOf returns the op of the application protocol
+func OpOf(alpn string) (string, bool) {
+	if !strings.HasPrefix(alpn, alpnPrefix) {
+		return "", false
+	}
+	switch op := alpn[len(alpnPrefix):]; op {
+	case OpDial, OpListen, OpAccept:
+		return op, true
+	}
+	return "", false
+}
+
+type request struct {
+	Op      string `json:"op"`
+	Network string `json:"network,omitempty"`
+	Address string `json:"address,omitempty"`
+	ID      string `json:"id,omitempty"`
+}
+
+type response struct {
+	Error   string `json:"error,omitempty"`
+	Address string `json:"address,omitempty"`
+	ID      string `json:"id,omitempty"`
+}
+
+const maxFrameSize = 1<<16 - 1
+
+func writeFrame(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxFrameSize {
+		return fmt.Errorf("frame too large: %d", len(data))
+	}
+	buf := make([]byte, 2+len(data))
+	binary.BigEndian.PutUint16(buf, uint16(len(data)))
+	copy(buf[2:], data)
+	_, err = w.Write(buf)
+	return err
+}
+
+func readFrame(r io.Reader, v interface{}) error {
+	var size [2]byte
+	_, err := io.ReadFull(r, size[:])
+	if err != nil {
+		return err
+	}
+	data := make([]byte, binary.BigEndian.Uint16(size[:]))
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
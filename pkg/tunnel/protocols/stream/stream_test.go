@@ -0,0 +1,192 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+func echoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+func ping(conn net.Conn) error {
+	_, err := conn.Write([]byte("ping"))
+	if err != nil {
+		return err
+	}
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	return err
+}
+
+func newTestClient(ctx context.Context, server *Server, user string) *Client {
+	return NewClient(func(_ context.Context, op string) (net.Conn, error) {
+		c1, c2 := net.Pipe()
+		go server.ServeConn(ctx, c2, user, op)
+		return c1, nil
+	})
+}
+
+func TestStream(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	echo := echoServer(t)
+	homeDir := t.TempDir()
+	err := os.MkdirAll(filepath.Join(homeDir, "import", ".ssh"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	perm, _ := json.Marshal(map[string]interface{}{
+		"direct-tcpip":  map[string]interface{}{"allows": []string{echo.Addr().String()}},
+		"tcpip-forward": map[string]interface{}{"allows": []string{"127.0.0.1:0"}},
+	})
+	err = os.WriteFile(filepath.Join(homeDir, "import", ".ssh", "permissions.json"), perm, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer(logr.Discard(), homeDir, "permissions.json")
+
+	t.Run("dial", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			user    string
+			address string
+			wantErr bool
+		}{
+			{
+				name:    "allowed",
+				user:    "import",
+				address: echo.Addr().String(),
+			},
+			{
+				name:    "not allowed address",
+				user:    "import",
+				address: "127.0.0.1:1",
+				wantErr: true,
+			},
+			{
+				name:    "other hub",
+				user:    "other",
+				address: echo.Addr().String(),
+				wantErr: true,
+			},
+			{
+				name:    "invalid hub",
+				user:    "../import",
+				address: echo.Addr().String(),
+				wantErr: true,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				conn, err := newTestClient(ctx, server, tt.user).DialContext(ctx, "tcp", tt.address)
+				if err == nil {
+					defer conn.Close()
+					err = ping(conn)
+				}
+				if (err != nil) != tt.wantErr {
+					t.Errorf("got error %v, wantErr %v", err, tt.wantErr)
+				}
+			})
+		}
+	})
+
+	t.Run("listen", func(t *testing.T) {
+		_, err := newTestClient(ctx, server, "import").Listen(ctx, "tcp", "127.0.0.1:1")
+		if err == nil {
+			t.Fatal("want error of the not allowed address")
+		}
+
+		listener, err := newTestClient(ctx, server, "import").Listen(ctx, "tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		go func() {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			io.Copy(conn, conn)
+		}()
+
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		err = ping(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("accept of other hub", func(t *testing.T) {
+		c1, c2 := net.Pipe()
+		defer c1.Close()
+		id := server.park("import", c2)
+		_, _, err := newTestClient(ctx, server, "other").roundTrip(ctx, request{Op: OpAccept, ID: id})
+		if err == nil {
+			t.Fatal("want error of the id of other hub")
+		}
+		if server.take("import", id) == nil {
+			t.Fatal("the connection should still be pending")
+		}
+	})
+}
+
+func TestOpOf(t *testing.T) {
+	for _, op := range []string{OpDial, OpListen, OpAccept} {
+		got, ok := OpOf(ALPN(op))
+		if !ok || got != op {
+			t.Errorf("OpOf(ALPN(%q)) = %q, %v", op, got, ok)
+		}
+	}
+	for _, alpn := range []string{"", "ferry", "ferry-other", "h2"} {
+		if _, ok := OpOf(alpn); ok {
+			t.Errorf("OpOf(%q) should not be ok", alpn)
+		}
+	}
+}
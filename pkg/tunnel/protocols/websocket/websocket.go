@@ -0,0 +1,239 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package websocket
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ferryproxy/ferry/pkg/consts"
+	"github.com/ferryproxy/ferry/pkg/tunnel/protocols/h2"
+	"github.com/ferryproxy/ferry/pkg/tunnel/protocols/mtls"
+	"github.com/gorilla/websocket"
+	"github.com/wzshiming/bridge"
+	"github.com/wzshiming/bridge/protocols/local"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const handshakeTimeout = 10 * time.Second
+
+// WebSocket ws://[host:port]/path or wss://[host:port]/path?dir=[dir]&server_name=[hub]&insecure=[bool]
+// As a dialer it carries the connection over the WebSocket to the dialed address, the HTTP proxy is traversed by
+// the http:// before it in the chain, which tunnels with the HTTP/1.1 CONNECT, see the h2:// for the HTTP/2 CONNECT.
+// The wss verifies the server by the system roots, or by the CA in the dir if it is set, then the certificate of the dir
+// is presented as well and the server name is the hub.
+// As a listener it accepts WebSocket connections on the listened address, and the wss terminates the TLS with the certificate
+// in the dir, which is the tunnel certificate by default, use the ws instead if the TLS is terminated before it, e.g. by an ingress.
+// The listener accepts the HTTP/2 CONNECT of the h2:// and the h2c:// on the same address as well.
+func WebSocket(dialer bridge.Dialer, address string) (bridge.Dialer, error) {
+	if dialer == nil {
+		dialer = local.LOCAL
+	}
+	uri, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if uri.Path == "" {
+		uri.Path = "/"
+	}
+	query := uri.Query()
+	insecure, _ := strconv.ParseBool(query.Get("insecure"))
+	return &webSocket{
+		dialer:     dialer,
+		uri:        uri,
+		insecure:   insecure,
+		dir:        query.Get("dir"),
+		serverName: query.Get("server_name"),
+	}, nil
+}
+
+type webSocket struct {
+	dialer     bridge.Dialer
+	uri        *url.URL
+	insecure   bool
+	dir        string
+	serverName string
+}
+
+// clientConfig returns the TLS config of the wss to the host
+func (w *webSocket) clientConfig(host string) (*tls.Config, error) {
+	serverName := w.serverName
+	if serverName == "" {
+		serverName = host
+	}
+	if w.dir == "" {
+		return &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: w.insecure,
+			MinVersion:         tls.VersionTLS12,
+		}, nil
+	}
+	return mtls.ClientConfig(w.dir, serverName)
+}
+
+// serverConfig returns the TLS config that terminates the wss, the certificate is loaded on each handshake
+func (w *webSocket) serverConfig() *tls.Config {
+	dir := w.dir
+	if dir == "" {
+		dir = consts.TunnelTLSDir
+	}
+	return &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(filepath.Join(dir, corev1.TLSCertKey), filepath.Join(dir, corev1.TLSPrivateKeyKey))
+			if err != nil {
+				return nil, err
+			}
+			return &cert, nil
+		},
+		NextProtos: []string{"h2", "http/1.1"},
+		MinVersion: tls.VersionTLS12,
+	}
+}
+
+func (w *webSocket) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	uri := *w.uri
+	uri.Host = address
+	uri.RawQuery = ""
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	d := websocket.Dialer{
+		NetDialContext:   w.dialer.DialContext,
+		HandshakeTimeout: handshakeTimeout,
+	}
+	if uri.Scheme == "wss" {
+		d.TLSClientConfig, err = w.clientConfig(host)
+		if err != nil {
+			return nil, err
+		}
+	}
+	conn, _, err := d.DialContext(ctx, uri.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewConn(conn), nil
+}
+
+func (w *webSocket) Listen(ctx context.Context, network, address string) (net.Listener, error) {
+	var listenConfig bridge.ListenConfig = local.LOCAL
+	if l, ok := w.dialer.(bridge.ListenConfig); ok {
+		listenConfig = l
+	}
+	listener, err := listenConfig.Listen(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	if w.uri.Scheme == "wss" {
+		listener = tls.NewListener(listener, w.serverConfig())
+	}
+	return NewListener(listener, w.uri.Path)
+}
+
+// NewListener accepts the WebSocket connections on the path of the listener and the HTTP/2 CONNECT
+func NewListener(listener net.Listener, path string) (net.Listener, error) {
+	l := &wsListener{
+		listener: listener,
+		conns:    make(chan net.Conn),
+		done:     make(chan struct{}),
+	}
+	upgrader := websocket.Upgrader{
+		HandshakeTimeout: handshakeTimeout,
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(rw http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(rw, r, nil)
+		if err != nil {
+			return
+		}
+		if !l.accept(NewConn(conn)) {
+			conn.Close()
+		}
+	})
+	l.server = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: handshakeTimeout,
+	}
+	err := h2.ConfigureServer(l.server, l.accept)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		err := l.server.Serve(listener)
+		l.closeWithErr(err)
+	}()
+	return l, nil
+}
+
+type wsListener struct {
+	listener net.Listener
+	server   *http.Server
+	conns    chan net.Conn
+	done     chan struct{}
+	once     sync.Once
+	err      error
+}
+
+// accept passes the conn to the Accept, it returns false if the listener is closed
+func (l *wsListener) accept(conn net.Conn) bool {
+	select {
+	case l.conns <- conn:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+func (l *wsListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, l.err
+	}
+}
+
+func (l *wsListener) Close() error {
+	l.closeWithErr(net.ErrClosed)
+	return l.server.Close()
+}
+
+func (l *wsListener) closeWithErr(err error) {
+	l.once.Do(func() {
+		if err == nil || errors.Is(err, http.ErrServerClosed) {
+			err = net.ErrClosed
+		}
+		l.err = err
+		close(l.done)
+	})
+}
+
+func (l *wsListener) Addr() net.Addr {
+	return l.listener.Addr()
+}
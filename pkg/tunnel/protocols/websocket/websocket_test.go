@@ -0,0 +1,170 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ferryproxy/ferry/pkg/tunnel/protocols/h2"
+	"github.com/ferryproxy/ferry/pkg/utils/certs"
+	"github.com/wzshiming/bridge"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func writeCert(t *testing.T, caCert, caKey []byte, name string) string {
+	dir := t.TempDir()
+	cert, key, err := certs.IssueCert(caCert, caKey, name, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, data := range map[string][]byte{
+		corev1.ServiceAccountRootCAKey: caCert,
+		corev1.TLSCertKey:              cert,
+		corev1.TLSPrivateKeyKey:        key,
+	} {
+		err = os.WriteFile(filepath.Join(dir, file), data, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestWebSocket(t *testing.T) {
+	caCert, caKey, err := certs.NewCA("ferry", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exportDir := writeCert(t, caCert, caKey, "export")
+	importDir := writeCert(t, caCert, caKey, "import")
+
+	tests := []struct {
+		name    string
+		listen  string
+		dial    string
+		wantErr bool
+	}{
+		{
+			name:   "ws",
+			listen: "ws:///ferry",
+			dial:   "ws:///ferry",
+		},
+		{
+			name:   "wss",
+			listen: "wss:///ferry?dir=" + exportDir,
+			dial:   "wss:///ferry?server_name=export&dir=" + importDir,
+		},
+		{
+			name:    "wss with the wrong hub",
+			listen:  "wss:///ferry?dir=" + exportDir,
+			dial:    "wss:///ferry?server_name=other&dir=" + importDir,
+			wantErr: true,
+		},
+		{
+			name:    "wss with the system roots",
+			listen:  "wss:///ferry?dir=" + exportDir,
+			dial:    "wss:///ferry",
+			wantErr: true,
+		},
+		{
+			name:   "wss insecure",
+			listen: "wss:///ferry?dir=" + exportDir,
+			dial:   "wss:///ferry?insecure=true",
+		},
+		{
+			name:   "h2c on the ws",
+			listen: "ws:///ferry",
+			dial:   "h2c://",
+		},
+		{
+			name:   "h2 on the wss",
+			listen: "wss:///ferry?dir=" + exportDir,
+			dial:   "h2://?server_name=export&dir=" + importDir,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := echo(tt.listen, tt.dial)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("echo() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// echo dials the echo server listened on the listen through the dial
+func echo(listen, dial string) error {
+	ctx := context.Background()
+	l, err := WebSocket(nil, listen)
+	if err != nil {
+		return err
+	}
+	listener, err := l.(interface {
+		Listen(ctx context.Context, network, address string) (net.Listener, error)
+	}).Listen(ctx, "tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	var d bridge.Dialer
+	if strings.HasPrefix(dial, "h2") {
+		d, err = h2.H2(nil, dial)
+	} else {
+		d, err = WebSocket(nil, dial)
+	}
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+
+	conn, err := d.DialContext(ctx, "tcp", listener.Addr().String())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	want := "SSH-2.0-ferry\r\n"
+	_, err = conn.Write([]byte(want))
+	if err != nil {
+		return err
+	}
+	got := make([]byte, len(want))
+	_, err = io.ReadFull(conn, got)
+	if err != nil {
+		return err
+	}
+	if string(got) != want {
+		return fmt.Errorf("got %q, want %q", got, want)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package status
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// Status is the outcome of the last reload of the tunnel, it is written by the tunnel and read by the runtime controller
+type Status struct {
+	// Checksum is the checksum of the config that was loaded
+	Checksum string `json:"checksum"`
+	// Reload is the count of the reloads
+	Reload int `json:"reload"`
+	// Live is the number of the chains whose listeners are bound
+	Live int `json:"live"`
+	// Failed is the chains that failed to bind
+	Failed []Failed `json:"failed,omitempty"`
+	// Draining is the number of the removed chains that are still draining
+	Draining int `json:"draining"`
+	// OpenCircuits is the chains whose circuit breaker is open, it is only reported by the health
+	OpenCircuits []string `json:"openCircuits,omitempty"`
+	// UnhealthyHops is the shared hops to the hubs that are failing to connect, it is only reported by the health
+	UnhealthyHops []UnhealthyHop `json:"unhealthyHops,omitempty"`
+}
+
+// UnhealthyHop is the shared hop that is failing to connect
+type UnhealthyHop struct {
+	Hop   string `json:"hop"`
+	Error string `json:"error"`
+}
+
+// Failed is the chain that failed to bind
+type Failed struct {
+	Chain string `json:"chain"`
+	Error string `json:"error"`
+}
+
+// Checksum returns the checksum of the config files
+func Checksum(data ...[]byte) string {
+	h := sha256.New()
+	for _, d := range data {
+		h.Write(d)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Write writes the status to the file
+func Write(path string, s Status) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(filepath.Dir(path), ".tmp-"+filepath.Base(path))
+	err = os.WriteFile(tmp, data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Read reads the status from the file
+func Read(path string) (Status, error) {
+	var s Status
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(data, &s)
+	return s, err
+}
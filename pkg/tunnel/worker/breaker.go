@@ -0,0 +1,205 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ferryproxy/ferry/pkg/tunnel/metrics"
+)
+
+var (
+	breakerRejectedConnections = metrics.NewCounter(
+		"ferry_tunnel_circuit_breaker_rejected_connections_total",
+		"The number of the connections rejected by the circuit breaker.",
+		"tunnel", "reason",
+	)
+	breakerEjections = metrics.NewCounter(
+		"ferry_tunnel_circuit_breaker_ejections_total",
+		"The number of the times that the circuit breaker is opened by the consecutive dial failures.",
+		"tunnel",
+	)
+	breakerOpen = metrics.NewGauge(
+		"ferry_tunnel_circuit_breaker_open",
+		"Whether the circuit breaker is open.",
+		"tunnel",
+	)
+	breakerPendingDials = metrics.NewGauge(
+		"ferry_tunnel_circuit_breaker_pending_dials",
+		"The number of the dials in progress counted by the circuit breaker.",
+		"tunnel",
+	)
+)
+
+const defaultEjectionTime = 30 * time.Second
+
+// CircuitBreaker fails the connections fast instead of dialing when the destination is overloaded or unreachable
+type CircuitBreaker struct {
+	// MaxPendingDials is the max number of the dials in progress, unlimited if zero
+	MaxPendingDials int64 `json:"maxPendingDials,omitempty"`
+	// ConsecutiveFailures is the number of the consecutive dial failures that opens the breaker, never opens if zero
+	ConsecutiveFailures int64 `json:"consecutiveFailures,omitempty"`
+	// EjectionTime is how long the breaker stays open, 30s if empty
+	EjectionTime string `json:"ejectionTime,omitempty"`
+}
+
+type breaker struct {
+	name string
+
+	mut          sync.Mutex
+	conf         CircuitBreaker
+	ejectionTime time.Duration
+	pending      int64
+	failures     int64
+	openUntil    time.Time
+}
+
+func newBreaker(name string, conf CircuitBreaker) *breaker {
+	b := &breaker{
+		name: name,
+	}
+	b.update(conf)
+	return b
+}
+
+func (b *breaker) update(conf CircuitBreaker) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.conf = conf
+	b.ejectionTime = defaultEjectionTime
+	if conf.EjectionTime != "" {
+		d, err := time.ParseDuration(conf.EjectionTime)
+		if err == nil && d > 0 {
+			b.ejectionTime = d
+		}
+	}
+}
+
+// allow counts a dial, and returns an error if the breaker is open or the max pending dials is reached
+func (b *breaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	if b.isOpen(time.Now()) {
+		breakerRejectedConnections.Inc(b.name, "open")
+		return fmt.Errorf("circuit breaker is open")
+	}
+	if b.conf.MaxPendingDials > 0 && b.pending >= b.conf.MaxPendingDials {
+		breakerRejectedConnections.Inc(b.name, "pending")
+		return fmt.Errorf("too many pending dials")
+	}
+	b.pending++
+	breakerPendingDials.Set(b.pending, b.name)
+	return nil
+}
+
+// done uncounts the dial, and opens the breaker if the dial failures reach the threshold
+func (b *breaker) done(err error) {
+	if b == nil {
+		return
+	}
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	b.pending--
+	breakerPendingDials.Set(b.pending, b.name)
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.conf.ConsecutiveFailures > 0 && b.failures >= b.conf.ConsecutiveFailures {
+		b.failures = 0
+		b.openUntil = time.Now().Add(b.ejectionTime)
+		breakerEjections.Inc(b.name)
+		breakerOpen.Set(1, b.name)
+	}
+}
+
+// isOpen returns true if the breaker is still open, the breaker is closed again after the ejection time
+func (b *breaker) isOpen(now time.Time) bool {
+	if b.openUntil.IsZero() {
+		return false
+	}
+	if now.Before(b.openUntil) {
+		return true
+	}
+	b.openUntil = time.Time{}
+	breakerOpen.Set(0, b.name)
+	return false
+}
+
+// breakerSet is the breakers of the chains by the name, the state is kept across the reloads
+type breakerSet struct {
+	mut      sync.Mutex
+	breakers map[string]*breaker
+}
+
+func (s *breakerSet) get(name string, conf CircuitBreaker) *breaker {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.breakers == nil {
+		s.breakers = map[string]*breaker{}
+	}
+	b, ok := s.breakers[name]
+	if !ok {
+		b = newBreaker(name, conf)
+		s.breakers[name] = b
+		return b
+	}
+	b.update(conf)
+	return b
+}
+
+// retain removes the breakers of the chains that are not in the tasks
+func (s *breakerSet) retain(tasks []Chain) {
+	names := map[string]struct{}{}
+	for _, task := range tasks {
+		if task.CircuitBreaker != nil {
+			names[task.Name] = struct{}{}
+		}
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for name := range s.breakers {
+		if _, ok := names[name]; !ok {
+			delete(s.breakers, name)
+			breakerOpen.Set(0, name)
+		}
+	}
+}
+
+// open returns the names of the chains whose breaker is open
+func (s *breakerSet) open() []string {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	now := time.Now()
+	var names []string
+	for name, b := range s.breakers {
+		b.mut.Lock()
+		if b.isOpen(now) {
+			names = append(names, name)
+		}
+		b.mut.Unlock()
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBreakerMaxPendingDials(t *testing.T) {
+	b := newBreaker("route", CircuitBreaker{MaxPendingDials: 1})
+
+	if err := b.allow(); err != nil {
+		t.Fatalf("the first dial should be allowed: %v", err)
+	}
+	if err := b.allow(); err == nil {
+		t.Fatal("the second dial should be rejected while the first is pending")
+	}
+	b.done(nil)
+	if err := b.allow(); err != nil {
+		t.Fatalf("the dial should be allowed after the first is done: %v", err)
+	}
+}
+
+func TestBreakerConsecutiveFailures(t *testing.T) {
+	var set breakerSet
+	b := set.get("route", CircuitBreaker{ConsecutiveFailures: 2, EjectionTime: "100ms"})
+	errDial := errors.New("dial failed")
+
+	for _, err := range []error{errDial, nil, errDial} {
+		if err := b.allow(); err != nil {
+			t.Fatalf("the dial should be allowed: %v", err)
+		}
+		b.done(err)
+	}
+	if open := set.open(); len(open) != 0 {
+		t.Fatalf("the failures are not consecutive, got open %v", open)
+	}
+
+	if err := b.allow(); err != nil {
+		t.Fatalf("the dial should be allowed: %v", err)
+	}
+	b.done(errDial)
+	if err := b.allow(); err == nil {
+		t.Fatal("the dial should be rejected while the breaker is open")
+	}
+	if open := set.open(); !reflect.DeepEqual(open, []string{"route"}) {
+		t.Fatalf("got open %v, want [route]", open)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if err := b.allow(); err != nil {
+		t.Fatalf("the dial should be allowed after the ejection time: %v", err)
+	}
+	b.done(nil)
+	if open := set.open(); len(open) != 0 {
+		t.Fatalf("got open %v after the ejection time", open)
+	}
+
+	set.retain(nil)
+	if len(set.breakers) != 0 {
+		t.Errorf("the breakers of the removed chains should be removed, got %v", set.breakers)
+	}
+}
+
+func TestBreakerNil(t *testing.T) {
+	var b *breaker
+	if err := b.allow(); err != nil {
+		t.Fatalf("the nil breaker should allow all dials: %v", err)
+	}
+	b.done(errors.New("dial failed"))
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"encoding/json"
+
+	"github.com/wzshiming/bridge/config"
+)
+
+// Chain is the chain of the bridge with the options of ferry
+type Chain struct {
+	config.Chain
+	// Name is the name of the tunnel that the chain belongs to
+	Name string `json:"name,omitempty"`
+	// Limit is the limit of the route that the chain belongs to
+	Limit *Limit `json:"limit,omitempty"`
+	// Mirror duplicates the connections accepted by the chain
+	Mirror *Mirror `json:"mirror,omitempty"`
+	// CircuitBreaker fails the connections fast when the dials fail, the chains with the same name share the breaker
+	CircuitBreaker *CircuitBreaker `json:"circuitBreaker,omitempty"`
+	// Weights is the weights of the addresses of the first proxy, the addresses are picked randomly if it is empty
+	Weights []int `json:"weights,omitempty"`
+	// HTTP routes the HTTP requests to the addresses of the first proxy instead of forwarding the connections
+	HTTP *HTTPRoute `json:"http,omitempty"`
+	// SNI routes the TLS connections to the addresses of the first proxy by the server names, it is ignored if HTTP is set
+	SNI *SNIRoute `json:"sni,omitempty"`
+	// TLS encrypts the connections to the first proxy
+	TLS *TLSOrigination `json:"tls,omitempty"`
+}
+
+// Unique returns the key of the chain that contains the options
+func (c Chain) Unique() string {
+	d, err := json.Marshal(c)
+	if err != nil {
+		return ""
+	}
+	return string(d)
+}
+
+// Config is the config of the chains
+type Config struct {
+	Chains []Chain `json:"chains"`
+}
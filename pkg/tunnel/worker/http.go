@@ -0,0 +1,287 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ferryproxy/ferry/pkg/tunnel/metrics"
+)
+
+var (
+	httpRequests = metrics.NewCounter(
+		"ferry_tunnel_http_requests_total",
+		"The number of the HTTP requests routed by the rules of the tunnel.",
+		"tunnel", "rule", "code",
+	)
+	httpRequestMilliseconds = metrics.NewCounter(
+		"ferry_tunnel_http_request_duration_milliseconds_total",
+		"The total milliseconds until the response headers of the HTTP requests routed by the rules of the tunnel.",
+		"tunnel", "rule",
+	)
+)
+
+// HTTPRoute routes the HTTP requests of the chain to the addresses of the first proxy by the rules
+type HTTPRoute struct {
+	Rules []HTTPRouteRule `json:"rules,omitempty"`
+	// Default is the indexes of the addresses for the requests that match no rule
+	Default []int `json:"default,omitempty"`
+}
+
+// HTTPRouteRule matches the requests by the host, path prefix and headers, the empty fields match any
+type HTTPRouteRule struct {
+	Name       string            `json:"name"`
+	Host       string            `json:"host,omitempty"`
+	PathPrefix string            `json:"pathPrefix,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	// Backends is the indexes of the addresses that the requests are routed to
+	Backends []int `json:"backends"`
+}
+
+// match returns the name of the first rule that matches the request and its backends
+func (r *HTTPRoute) match(req *http.Request) (string, []int) {
+	for _, rule := range r.Rules {
+		if rule.matches(req) {
+			return rule.Name, rule.Backends
+		}
+	}
+	return "default", r.Default
+}
+
+func (r HTTPRouteRule) matches(req *http.Request) bool {
+	if r.Host != "" {
+		host := req.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !strings.EqualFold(host, r.Host) {
+			return false
+		}
+	}
+	if r.PathPrefix != "" && !hasPathPrefix(req.URL.Path, r.PathPrefix) {
+		return false
+	}
+	for key, value := range r.Headers {
+		if req.Header.Get(key) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// hasPathPrefix returns true if the path is the prefix or under it, e.g. "/api/v2" matches "/api/v2/users" but not "/api/v20"
+func hasPathPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// httpRouter serves the HTTP/1.x requests of the connections and proxies them to the addresses,
+// the upgrades such as WebSocket are proxied as is.
+type httpRouter struct {
+	name       string
+	route      *HTTPRoute
+	weights    []int
+	transports []*http.Transport
+	proxies    []*httputil.ReverseProxy
+}
+
+func newHTTPRouter(name string, route *HTTPRoute, addresses []string, weights []int, dial func(ctx context.Context, network, address string) (net.Conn, error)) (*httpRouter, error) {
+	h := &httpRouter{
+		name:  name,
+		route: route,
+	}
+	if len(weights) == len(addresses) {
+		h.weights = weights
+	}
+	for _, address := range addresses {
+		network, addr, ok := splitSchemeAddr(address)
+		if !ok {
+			return nil, fmt.Errorf("unsupported protocol format %q", address)
+		}
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dial(ctx, network, addr)
+			},
+			MaxIdleConnsPerHost:   16,
+			IdleConnTimeout:       90 * time.Second,
+			ExpectContinueTimeout: time.Second,
+			DisableCompression:    true,
+		}
+		h.transports = append(h.transports, transport)
+		h.proxies = append(h.proxies, &httputil.ReverseProxy{
+			Rewrite: func(r *httputil.ProxyRequest) {
+				r.Out.URL.Scheme = "http"
+				r.Out.URL.Host = r.In.Host
+				// Appends the client to the X-Forwarded-For of the inbound request
+				r.Out.Header["X-Forwarded-For"] = r.In.Header["X-Forwarded-For"]
+				r.SetXForwarded()
+			},
+			Transport: transport,
+			ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+				http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+			},
+		})
+	}
+	return h, nil
+}
+
+// serve serves the requests of the connection until it is closed
+func (h *httpRouter) serve(ctx context.Context, conn net.Conn) error {
+	l := newConnListener(conn)
+	srv := &http.Server{
+		Handler: h,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			srv.Close()
+		case <-l.done:
+		}
+	}()
+	err := srv.Serve(l)
+	if errors.Is(err, net.ErrClosed) || errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+func (h *httpRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rw := &statusWriter{ResponseWriter: w, start: time.Now()}
+	rule, backends := h.route.match(r)
+	i, ok := pickBackend(backends, h.weights)
+	if !ok {
+		http.Error(rw, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	} else {
+		h.proxies[i].ServeHTTP(rw, r)
+	}
+	rw.WriteHeader(http.StatusOK)
+	httpRequests.Inc(h.name, rule, strconv.Itoa(rw.code))
+	httpRequestMilliseconds.Add(rw.elapsed.Milliseconds(), h.name, rule)
+}
+
+func (h *httpRouter) close() {
+	for _, t := range h.transports {
+		t.CloseIdleConnections()
+	}
+}
+
+// statusWriter records the status code of the response and the time until its headers
+type statusWriter struct {
+	http.ResponseWriter
+	start   time.Time
+	code    int
+	elapsed time.Duration
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+		w.elapsed = time.Since(w.start)
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying writer for the http.ResponseController, which flushes and hijacks the upgrades
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// connListener is the listener that accepts only the connection, and is closed once the connection is closed
+type connListener struct {
+	conns chan net.Conn
+	addr  net.Addr
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	l := &connListener{
+		conns: make(chan net.Conn, 1),
+		addr:  conn.LocalAddr(),
+		done:  make(chan struct{}),
+	}
+	l.conns <- &closeConn{Conn: conn, close: l.Close}
+	return l
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
+
+// closeConn calls the close after the connection is closed
+type closeConn struct {
+	net.Conn
+	close func() error
+}
+
+func (c *closeConn) Close() error {
+	err := c.Conn.Close()
+	c.close()
+	return err
+}
+
+// wrappedConn is the connection that reads and writes through the wrapped counters and limiters
+type wrappedConn struct {
+	net.Conn
+	rw io.ReadWriteCloser
+}
+
+func (c *wrappedConn) Read(p []byte) (int, error) {
+	return c.rw.Read(p)
+}
+
+func (c *wrappedConn) Write(p []byte) (int, error) {
+	return c.rw.Write(p)
+}
+
+func (c *wrappedConn) Close() error {
+	return c.rw.Close()
+}
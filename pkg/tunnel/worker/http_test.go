@@ -0,0 +1,276 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPRouteMatch(t *testing.T) {
+	route := &HTTPRoute{
+		Rules: []HTTPRouteRule{
+			{Name: "canary", Headers: map[string]string{"X-Canary": "true"}, Backends: []int{2}},
+			{Name: "api", Host: "example.com", PathPrefix: "/api/", Backends: []int{1}},
+		},
+		Default: []int{0},
+	}
+	tests := []struct {
+		name     string
+		host     string
+		path     string
+		header   http.Header
+		wantRule string
+		wantBack []int
+	}{
+		{
+			name:     "default",
+			host:     "example.com",
+			path:     "/",
+			wantRule: "default",
+			wantBack: []int{0},
+		},
+		{
+			name:     "host and path",
+			host:     "Example.com:8080",
+			path:     "/api/users",
+			wantRule: "api",
+			wantBack: []int{1},
+		},
+		{
+			name:     "path prefix on segment",
+			host:     "example.com",
+			path:     "/apis",
+			wantRule: "default",
+			wantBack: []int{0},
+		},
+		{
+			name:     "other host",
+			host:     "other.com",
+			path:     "/api",
+			wantRule: "default",
+			wantBack: []int{0},
+		},
+		{
+			name:     "header first",
+			host:     "example.com",
+			path:     "/api",
+			header:   http.Header{"X-Canary": {"true"}},
+			wantRule: "canary",
+			wantBack: []int{2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Host = tt.host
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			rule, backends := route.match(req)
+			if rule != tt.wantRule || len(backends) != len(tt.wantBack) || backends[0] != tt.wantBack[0] {
+				t.Errorf("match() = %v, %v, want %v, %v", rule, backends, tt.wantRule, tt.wantBack)
+			}
+		})
+	}
+}
+
+func TestHTTPRouterServe(t *testing.T) {
+	backend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name)
+		}))
+	}
+	a := backend("a")
+	defer a.Close()
+	b := backend("b")
+	defer b.Close()
+
+	var dialer net.Dialer
+	h, err := newHTTPRouter("test", &HTTPRoute{
+		Rules: []HTTPRouteRule{
+			{Name: "b", PathPrefix: "/b", Backends: []int{1}},
+			{Name: "none", PathPrefix: "/none"},
+		},
+		Default: []int{0},
+	}, []string{
+		a.Listener.Addr().String(),
+		b.Listener.Addr().String(),
+	}, nil, dialer.DialContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.close()
+
+	listener := serveHTTPRouter(t, h)
+	defer listener.Close()
+
+	client := &http.Client{}
+	tests := []struct {
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{path: "/", wantCode: http.StatusOK, wantBody: "a"},
+		{path: "/b/c", wantCode: http.StatusOK, wantBody: "b"},
+		{path: "/none", wantCode: http.StatusNotFound, wantBody: "Not Found\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			resp, err := client.Get("http://" + listener.Addr().String() + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := io.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantCode || !strings.EqualFold(string(body), tt.wantBody) {
+				t.Errorf("GET %s = %d %q, want %d %q", tt.path, resp.StatusCode, body, tt.wantCode, tt.wantBody)
+			}
+		})
+	}
+}
+
+func serveHTTPRouter(t *testing.T, h *httpRouter) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				h.serve(context.Background(), conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestHTTPRouterProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/upgrade":
+			if r.Header.Get("Upgrade") != "echo" {
+				http.Error(w, "no upgrade", http.StatusBadRequest)
+				return
+			}
+			conn, brw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+			brw.Flush()
+			io.Copy(conn, brw)
+		case "/body":
+			io.Copy(w, r.Body)
+		default:
+			fmt.Fprintf(w, "%s|%s|%s", r.Header.Get("X-Forwarded-For"), r.Header.Get("X-Hop"), r.Header.Get("X-Keep"))
+		}
+	}))
+	defer backend.Close()
+
+	var dialer net.Dialer
+	h, err := newHTTPRouter("test", &HTTPRoute{
+		Default: []int{0},
+	}, []string{
+		backend.Listener.Addr().String(),
+	}, nil, dialer.DialContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.close()
+
+	listener := serveHTTPRouter(t, h)
+	defer listener.Close()
+	address := listener.Addr().String()
+
+	t.Run("headers", func(t *testing.T) {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+address+"/", nil)
+		req.Header.Set("X-Forwarded-For", "10.0.0.1")
+		req.Header.Set("Connection", "X-Hop")
+		req.Header.Set("X-Hop", "dropped")
+		req.Header.Set("X-Keep", "kept")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		want := "10.0.0.1, 127.0.0.1||kept"
+		if string(body) != want {
+			t.Errorf("got %q, want %q", body, want)
+		}
+	})
+
+	t.Run("expect continue", func(t *testing.T) {
+		client := &http.Client{
+			Transport: &http.Transport{ExpectContinueTimeout: time.Minute},
+			Timeout:   10 * time.Second,
+		}
+		req, _ := http.NewRequest(http.MethodPost, "http://"+address+"/body", strings.NewReader("hello"))
+		req.Header.Set("Expect", "100-continue")
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if string(body) != "hello" {
+			t.Errorf("got %q, want %q", body, "hello")
+		}
+	})
+
+	t.Run("upgrade", func(t *testing.T) {
+		conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		io.WriteString(conn, "GET /upgrade HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("got %d, want %d", resp.StatusCode, http.StatusSwitchingProtocols)
+		}
+		io.WriteString(conn, "ping")
+		buf := make([]byte, 4)
+		_, err = io.ReadFull(br, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != "ping" {
+			t.Errorf("got %q, want %q", buf, "ping")
+		}
+	})
+}
@@ -0,0 +1,236 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ferryproxy/ferry/pkg/tunnel/metrics"
+	"golang.org/x/time/rate"
+)
+
+var (
+	limitRejectedConnections = metrics.NewCounter(
+		"ferry_tunnel_limit_rejected_connections_total",
+		"The number of the connections rejected by the max connections of the limit.",
+		"limit",
+	)
+	limitThrottled = metrics.NewCounter(
+		"ferry_tunnel_limit_throttled_total",
+		"The number of the times that the transfer is throttled by the bandwidth of the limit.",
+		"limit",
+	)
+	limitConnections = metrics.NewGauge(
+		"ferry_tunnel_limit_connections",
+		"The number of the connections counted by the limit.",
+		"limit",
+	)
+)
+
+// Limit is the limit of the bandwidth and the connections, the chains with the same name share the limit
+type Limit struct {
+	Name string `json:"name"`
+	// BytesPerSecond is the bandwidth in both directions, unlimited if zero
+	BytesPerSecond int64 `json:"bytesPerSecond,omitempty"`
+	// Burst is the bytes that can be transferred at once, same as BytesPerSecond if zero
+	Burst int64 `json:"burst,omitempty"`
+	// MaxConnections is the max number of the concurrent connections, unlimited if zero
+	MaxConnections int64 `json:"maxConnections,omitempty"`
+}
+
+type limiter struct {
+	name     string
+	mut      sync.RWMutex
+	rate     *rate.Limiter
+	maxConns int64
+	conns    int64
+}
+
+func newLimiter(limit Limit) *limiter {
+	l := &limiter{
+		name: limit.Name,
+	}
+	l.update(limit)
+	return l
+}
+
+func (l *limiter) update(limit Limit) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	atomic.StoreInt64(&l.maxConns, limit.MaxConnections)
+	if limit.BytesPerSecond <= 0 {
+		l.rate = nil
+		return
+	}
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = limit.BytesPerSecond
+	}
+	if l.rate == nil {
+		l.rate = rate.NewLimiter(rate.Limit(limit.BytesPerSecond), int(burst))
+	} else {
+		l.rate.SetLimit(rate.Limit(limit.BytesPerSecond))
+		l.rate.SetBurst(int(burst))
+	}
+}
+
+// acquire counts a connection, and returns false if the max connections is reached
+func (l *limiter) acquire() bool {
+	conns := atomic.AddInt64(&l.conns, 1)
+	if max := atomic.LoadInt64(&l.maxConns); max > 0 && conns > max {
+		atomic.AddInt64(&l.conns, -1)
+		limitRejectedConnections.Inc(l.name)
+		return false
+	}
+	limitConnections.Add(1, l.name)
+	return true
+}
+
+func (l *limiter) release() {
+	atomic.AddInt64(&l.conns, -1)
+	limitConnections.Add(-1, l.name)
+}
+
+// wait waits for the bandwidth to transfer n bytes
+func (l *limiter) wait(ctx context.Context, n int) error {
+	l.mut.RLock()
+	lim := l.rate
+	l.mut.RUnlock()
+	if lim == nil {
+		return nil
+	}
+	for n > 0 {
+		// The n may be greater than the burst if the burst is reduced
+		m := n
+		if burst := lim.Burst(); m > burst {
+			m = burst
+		}
+		n -= m
+		r := lim.ReserveN(time.Now(), m)
+		if !r.OK() {
+			return nil
+		}
+		delay := r.Delay()
+		if delay == 0 {
+			continue
+		}
+		limitThrottled.Inc(l.name)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			r.Cancel()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return nil
+}
+
+// chunk returns the max bytes to read at once
+func (l *limiter) chunk(size int) int {
+	l.mut.RLock()
+	defer l.mut.RUnlock()
+	if l.rate != nil && l.rate.Burst() < size {
+		return l.rate.Burst()
+	}
+	return size
+}
+
+type limiters []*limiter
+
+func (ls limiters) acquire() bool {
+	for i, l := range ls {
+		if !l.acquire() {
+			ls[:i].release()
+			return false
+		}
+	}
+	return true
+}
+
+func (ls limiters) release() {
+	for _, l := range ls {
+		l.release()
+	}
+}
+
+// limitedConn limits the reads of the connection by the limiters
+type limitedConn struct {
+	io.ReadWriteCloser
+	ctx      context.Context
+	limiters limiters
+}
+
+func (c *limitedConn) Read(p []byte) (int, error) {
+	for _, l := range c.limiters {
+		p = p[:l.chunk(len(p))]
+	}
+	n, err := c.ReadWriteCloser.Read(p)
+	if n > 0 {
+		for _, l := range c.limiters {
+			if werr := l.wait(c.ctx, n); werr != nil {
+				return n, werr
+			}
+		}
+	}
+	return n, err
+}
+
+// limiterSet is the limiters shared by the chains
+type limiterSet struct {
+	mut      sync.Mutex
+	limiters map[string]*limiter
+}
+
+func (s *limiterSet) get(limit Limit) *limiter {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.limiters == nil {
+		s.limiters = map[string]*limiter{}
+	}
+	l, ok := s.limiters[limit.Name]
+	if !ok {
+		l = newLimiter(limit)
+		s.limiters[limit.Name] = l
+		return l
+	}
+	l.update(limit)
+	return l
+}
+
+// retain removes the limiters of the routes that are not in the tasks,
+// the draining chains keep the limiters they hold
+func (s *limiterSet) retain(tasks []Chain) {
+	names := map[string]struct{}{}
+	for _, task := range tasks {
+		if task.Limit != nil {
+			names[task.Limit.Name] = struct{}{}
+		}
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	for name := range s.limiters {
+		if _, ok := names[name]; !ok {
+			delete(s.limiters, name)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+type nopCloser struct {
+	io.ReadWriter
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func TestLimiterMaxConnections(t *testing.T) {
+	route := newLimiter(Limit{Name: "route", MaxConnections: 2})
+	hub := newLimiter(Limit{Name: "hub", MaxConnections: 1})
+
+	if !(limiters{route}).acquire() {
+		t.Fatal("the first connection of the route should be accepted")
+	}
+	if !(limiters{route, hub}).acquire() {
+		t.Fatal("the second connection of the route should be accepted")
+	}
+	if (limiters{route}).acquire() {
+		t.Fatal("the third connection of the route should be rejected")
+	}
+	(limiters{route}).release()
+	if (limiters{route, hub}).acquire() {
+		t.Fatal("the second connection of the hub should be rejected")
+	}
+	if route.conns != 1 {
+		t.Errorf("the rejected connection should not be counted, got %d", route.conns)
+	}
+}
+
+func TestLimiterBandwidth(t *testing.T) {
+	l := newLimiter(Limit{Name: "route", BytesPerSecond: 10 << 10, Burst: 1 << 10})
+	conn := &limitedConn{
+		ReadWriteCloser: nopCloser{bytes.NewBuffer(make([]byte, 5<<10))},
+		ctx:             context.Background(),
+		limiters:        limiters{l},
+	}
+	start := time.Now()
+	n, err := io.Copy(io.Discard, conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 5<<10 {
+		t.Fatalf("got %d bytes, want %d", n, 5<<10)
+	}
+	// The first 1KiB is the burst, the rest 4KiB takes 0.4s
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("transfer is not throttled, took %s", elapsed)
+	}
+}
+
+func TestLimiterSetRetain(t *testing.T) {
+	set := limiterSet{}
+	kept := set.get(Limit{Name: "kept", MaxConnections: 1})
+	set.get(Limit{Name: "removed", MaxConnections: 1})
+	set.retain([]Chain{
+		{Name: "kept-80", Limit: &Limit{Name: "kept", MaxConnections: 1}},
+		{Name: "other-80"},
+	})
+	if len(set.limiters) != 1 {
+		t.Fatalf("got %d limiters, want 1", len(set.limiters))
+	}
+	if set.get(Limit{Name: "kept", MaxConnections: 1}) != kept {
+		t.Error("the limiter of the kept route should be reused")
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+
+	"github.com/ferryproxy/ferry/pkg/tunnel/metrics"
+	"github.com/wzshiming/bridge"
+)
+
+var (
+	mirrorConnections = metrics.NewCounter(
+		"ferry_tunnel_mirror_connections_total",
+		"The number of the connections mirrored, the result is mirrored or failed.",
+		"tunnel", "result",
+	)
+	mirrorBytes = metrics.NewCounter(
+		"ferry_tunnel_mirror_bytes_total",
+		"The bytes that are duplicated to the mirror.",
+		"tunnel",
+	)
+	mirrorDroppedBytes = metrics.NewCounter(
+		"ferry_tunnel_mirror_dropped_bytes_total",
+		"The bytes that are not duplicated to the mirror because it is too slow.",
+		"tunnel",
+	)
+)
+
+// Mirror duplicates the connections of the chain to the address, the responses of the mirror are discarded
+type Mirror struct {
+	Address string `json:"address"`
+	Percent int    `json:"percent"`
+}
+
+func (m *Mirror) sampled() bool {
+	return m != nil && m.Percent > 0 && (m.Percent >= 100 || rand.Intn(100) < m.Percent)
+}
+
+// mirrorConn duplicates the data read from the connection to the mirror,
+// the mirror never blocks the connection, the data is dropped if the mirror is too slow.
+type mirrorConn struct {
+	io.ReadWriteCloser
+	tunnel string
+
+	mut    sync.Mutex
+	ch     chan []byte
+	closed bool
+}
+
+// newMirrorConn dials the mirror in the background, so that the connection is not delayed by the mirror
+func newMirrorConn(ctx context.Context, dialer bridge.Dialer, mirror *Mirror, tunnel string, conn io.ReadWriteCloser) *mirrorConn {
+	c := &mirrorConn{
+		ReadWriteCloser: conn,
+		tunnel:          tunnel,
+		ch:              make(chan []byte, 64),
+	}
+	go c.run(ctx, dialer, mirror.Address)
+	return c
+}
+
+func (c *mirrorConn) run(ctx context.Context, dialer bridge.Dialer, addr string) {
+	var m net.Conn
+	network, address, ok := splitSchemeAddr(addr)
+	if ok {
+		var err error
+		m, err = dialer.DialContext(ctx, network, address)
+		if err != nil {
+			m = nil
+		}
+	}
+	if m == nil {
+		mirrorConnections.Inc(c.tunnel, "failed")
+		for b := range c.ch {
+			mirrorDroppedBytes.Add(int64(len(b)), c.tunnel)
+		}
+		return
+	}
+	mirrorConnections.Inc(c.tunnel, "mirrored")
+	defer m.Close()
+	go io.Copy(io.Discard, m)
+	for b := range c.ch {
+		_, err := m.Write(b)
+		if err != nil {
+			mirrorDroppedBytes.Add(int64(len(b)), c.tunnel)
+			continue
+		}
+		mirrorBytes.Add(int64(len(b)), c.tunnel)
+	}
+}
+
+func (c *mirrorConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	if n > 0 {
+		c.mut.Lock()
+		if !c.closed {
+			select {
+			case c.ch <- append([]byte(nil), p[:n]...):
+			default:
+				mirrorDroppedBytes.Add(int64(n), c.tunnel)
+			}
+		}
+		c.mut.Unlock()
+	}
+	return n, err
+}
+
+func (c *mirrorConn) Close() error {
+	c.mut.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.ch)
+	}
+	c.mut.Unlock()
+	return c.ReadWriteCloser.Close()
+}
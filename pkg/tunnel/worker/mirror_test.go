@@ -0,0 +1,82 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/wzshiming/bridge/protocols/local"
+)
+
+func TestMirrorConn(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	mirrored := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("the response is discarded"))
+		data, _ := io.ReadAll(conn)
+		mirrored <- data
+	}()
+
+	client, server := net.Pipe()
+	conn := newMirrorConn(context.Background(), local.LOCAL, &Mirror{Address: listener.Addr().String(), Percent: 100}, "test", server)
+
+	go func() {
+		client.Write([]byte("hello"))
+		client.Close()
+	}()
+	data, _ := io.ReadAll(conn)
+	conn.Close()
+	if string(data) != "hello" {
+		t.Fatalf("got %q, want %q", data, "hello")
+	}
+
+	select {
+	case data := <-mirrored:
+		if string(data) != "hello" {
+			t.Errorf("mirror got %q, want %q", data, "hello")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the mirror did not receive the data")
+	}
+}
+
+func TestMirrorSampled(t *testing.T) {
+	var m *Mirror
+	if m.sampled() {
+		t.Errorf("nil mirror should not be sampled")
+	}
+	if (&Mirror{Percent: 0}).sampled() {
+		t.Errorf("0 percent should not be sampled")
+	}
+	if !(&Mirror{Percent: 100}).sampled() {
+		t.Errorf("100 percent should be sampled")
+	}
+}
@@ -0,0 +1,296 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ferryproxy/ferry/pkg/tunnel/accesslog"
+	"github.com/ferryproxy/ferry/pkg/tunnel/pool"
+	"github.com/ferryproxy/ferry/pkg/tunnel/status"
+	"github.com/go-logr/logr"
+	"github.com/wzshiming/bridge/chain"
+)
+
+// Runtime runs the chains, the changes of the chains are applied gracefully,
+// the removed chains stop accepting and their connections are drained until the drain timeout.
+type Runtime struct {
+	log          logr.Logger
+	dump         bool
+	drainTimeout time.Duration
+	readyTimeout time.Duration
+
+	hubLimiter *limiter
+	limiters   limiterSet
+	breakers   breakerSet
+	accessLog  *accesslog.Logger
+
+	mut      sync.Mutex
+	working  map[string]*running
+	stopping map[*running]struct{}
+	wg       sync.WaitGroup
+	reload   int
+	draining int64
+}
+
+type RuntimeConfig struct {
+	Logger       logr.Logger
+	Dump         bool
+	DrainTimeout time.Duration
+	ReadyTimeout time.Duration
+	// HubLimit is the limit shared by all chains of the hub, it only covers the connections served by the chains,
+	// the connections that the peer hubs forward through the ssh server of the hub are not limited by it
+	HubLimit *Limit
+	// AccessLog logs the connections of the chains and the ssh server, it is disabled if nil
+	AccessLog *accesslog.Logger
+}
+
+func NewRuntime(conf *RuntimeConfig) *Runtime {
+	readyTimeout := conf.ReadyTimeout
+	if readyTimeout == 0 {
+		readyTimeout = 10 * time.Second
+	}
+	var hubLimiter *limiter
+	if conf.HubLimit != nil {
+		hubLimiter = newLimiter(*conf.HubLimit)
+	}
+	if conf.AccessLog != nil {
+		accesslog.RegisterSSH(conf.AccessLog)
+	}
+	return &Runtime{
+		hubLimiter:   hubLimiter,
+		accessLog:    conf.AccessLog,
+		log:          conf.Logger,
+		dump:         conf.Dump,
+		drainTimeout: conf.DrainTimeout,
+		readyTimeout: readyTimeout,
+		working:      map[string]*running{},
+		stopping:     map[*running]struct{}{},
+	}
+}
+
+type running struct {
+	name   string
+	server *server
+	cancel context.CancelFunc
+	ready  chan struct{}
+	err    error
+}
+
+// Apply replaces the running chains with the tasks, and returns when the new chains are ready
+func (r *Runtime) Apply(ctx context.Context, tasks []Chain) status.Status {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.reload++
+	log := r.log.WithValues("reload_count", r.reload)
+	r.breakers.retain(tasks)
+	r.limiters.retain(tasks)
+
+	working := map[string]*running{}
+	started := []*running{}
+	startedTasks := []Chain{}
+	for _, task := range tasks {
+		uniq := task.Unique()
+		if _, ok := working[uniq]; ok {
+			continue
+		}
+		if run, ok := r.working[uniq]; ok {
+			working[uniq] = run
+			continue
+		}
+		working[uniq] = &running{
+			name:  chain.ShowChainWithConfig(task.Chain),
+			ready: make(chan struct{}),
+		}
+		started = append(started, working[uniq])
+		startedTasks = append(startedTasks, task)
+	}
+
+	// Stop accepting on the removed chains first, so that the new chains can bind the same address,
+	// the listeners are closed when stop returns and the connections are drained in the background
+	for uniq, run := range r.working {
+		if _, ok := working[uniq]; !ok {
+			r.stop(log, run)
+		}
+	}
+	r.working = working
+
+	for i, run := range started {
+		r.start(ctx, log, startedTasks[i], run)
+	}
+	s := r.wait(log, started)
+	r.retainHops(log)
+	return s
+}
+
+// retainHops closes the shared hops that are used by neither the working nor the draining chains,
+// it is skipped if the hops of any chain are not known
+func (r *Runtime) retainHops(log logr.Logger) {
+	keys := []string{}
+	runs := make([]*running, 0, len(r.working)+len(r.stopping))
+	for _, run := range r.working {
+		runs = append(runs, run)
+	}
+	for run := range r.stopping {
+		runs = append(runs, run)
+	}
+	for _, run := range runs {
+		hops, ok := run.server.Hops()
+		if !ok {
+			return
+		}
+		keys = append(keys, hops...)
+	}
+	log.V(1).Info("Retain hops", "hops", keys)
+	pool.Retain(keys)
+}
+
+// UnhealthyHops returns the shared hops to the hubs that are failing to connect
+func (r *Runtime) UnhealthyHops() []status.UnhealthyHop {
+	unhealthy := pool.Unhealthy()
+	hops := make([]status.UnhealthyHop, 0, len(unhealthy))
+	for hop, err := range unhealthy {
+		hops = append(hops, status.UnhealthyHop{Hop: hop, Error: err.Error()})
+	}
+	sort.Slice(hops, func(i, j int) bool {
+		return hops[i].Hop < hops[j].Hop
+	})
+	return hops
+}
+
+func (r *Runtime) start(ctx context.Context, log logr.Logger, task Chain, run *running) {
+	ctx, cancel := context.WithCancel(ctx)
+	run.cancel = cancel
+	var ls limiters
+	if task.Limit != nil {
+		ls = append(ls, r.limiters.get(*task.Limit))
+	}
+	if r.hubLimiter != nil {
+		ls = append(ls, r.hubLimiter)
+	}
+	var b *breaker
+	if task.CircuitBreaker != nil {
+		b = r.breakers.get(task.Name, *task.CircuitBreaker)
+	}
+	run.server = newServer(log, task, r.dump, ls, b, r.accessLog)
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		log.Info(run.name)
+		var once sync.Once
+		ready := func(err error) {
+			once.Do(func() {
+				run.err = err
+				close(run.ready)
+			})
+		}
+		for ctx.Err() == nil {
+			err := run.server.Serve(ctx, ready)
+			if err != nil {
+				log.Error(err, "Serve", "chain", run.name)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+		ready(ctx.Err())
+	}()
+}
+
+func (r *Runtime) stop(log logr.Logger, run *running) {
+	run.cancel()
+	run.server.StopAccepting()
+	atomic.AddInt64(&r.draining, 1)
+	r.stopping[run] = struct{}{}
+	r.wg.Add(1)
+	go func() {
+		defer func() {
+			r.mut.Lock()
+			delete(r.stopping, run)
+			r.retainHops(log)
+			r.mut.Unlock()
+			atomic.AddInt64(&r.draining, -1)
+			r.wg.Done()
+		}()
+		closed := run.server.Drain(r.drainTimeout)
+		if closed != 0 {
+			log.Info("Closed the connections after draining", "chain", run.name, "count", closed)
+		} else {
+			log.Info("Drained", "chain", run.name)
+		}
+	}()
+}
+
+func (r *Runtime) wait(log logr.Logger, started []*running) status.Status {
+	timeout := time.NewTimer(r.readyTimeout)
+	defer timeout.Stop()
+	s := status.Status{
+		Reload: r.reload,
+	}
+	// All chains share the deadline, once it has passed the others are checked without waiting
+	expired := false
+	for _, run := range started {
+		ready := false
+		if !expired {
+			select {
+			case <-run.ready:
+				ready = true
+			case <-timeout.C:
+				expired = true
+			}
+		}
+		if !ready {
+			select {
+			case <-run.ready:
+				ready = true
+			default:
+			}
+		}
+		if !ready {
+			s.Failed = append(s.Failed, status.Failed{Chain: run.name, Error: fmt.Sprintf("not ready in %s", r.readyTimeout)})
+		} else if run.err != nil {
+			s.Failed = append(s.Failed, status.Failed{Chain: run.name, Error: run.err.Error()})
+		}
+	}
+	s.Live = len(r.working) - len(s.Failed)
+	s.Draining = int(atomic.LoadInt64(&r.draining))
+	log.Info("Reloaded", "live", s.Live, "failed", len(s.Failed), "draining", s.Draining)
+	return s
+}
+
+// OpenCircuits returns the names of the chains whose circuit breaker is open
+func (r *Runtime) OpenCircuits() []string {
+	return r.breakers.open()
+}
+
+// Close stops all chains and waits for them to be drained
+func (r *Runtime) Close() {
+	r.mut.Lock()
+	log := r.log.WithValues("reload_count", r.reload)
+	for _, run := range r.working {
+		r.stop(log, run)
+	}
+	r.working = map[string]*running{}
+	r.mut.Unlock()
+	r.wg.Wait()
+}
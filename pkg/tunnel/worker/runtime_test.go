@@ -0,0 +1,193 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/wzshiming/bridge/config"
+)
+
+func echoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func ping(conn net.Conn) error {
+	_, err := conn.Write([]byte("ping"))
+	if err != nil {
+		return err
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	return err
+}
+
+func TestRuntimeDrain(t *testing.T) {
+	ctx := context.Background()
+	target := echoServer(t)
+	bind := freeAddress(t)
+	task := Chain{
+		Chain: config.Chain{
+			Bind:  []config.Node{{LB: []string{bind}}},
+			Proxy: []config.Node{{LB: []string{target}}},
+		},
+	}
+
+	r := NewRuntime(&RuntimeConfig{
+		Logger:       logr.Discard(),
+		DrainTimeout: time.Second / 2,
+	})
+	defer r.Close()
+
+	s := r.Apply(ctx, []Chain{task})
+	if s.Live != 1 || len(s.Failed) != 0 {
+		t.Fatalf("got status %+v, want 1 live chain", s)
+	}
+
+	conn, err := net.Dial("tcp", bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = ping(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s = r.Apply(ctx, nil)
+	if s.Live != 0 || s.Draining != 1 {
+		t.Fatalf("got status %+v, want 1 draining chain", s)
+	}
+
+	_, err = net.Dial("tcp", bind)
+	if err == nil {
+		t.Errorf("the removed chain should stop accepting")
+	}
+
+	err = ping(conn)
+	if err != nil {
+		t.Errorf("the connection should be alive while draining: %v", err)
+	}
+
+	time.Sleep(time.Second)
+	err = ping(conn)
+	if err == nil {
+		t.Errorf("the connection should be closed after draining")
+	}
+}
+
+func TestRuntimeFailed(t *testing.T) {
+	ctx := context.Background()
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer occupied.Close()
+
+	r := NewRuntime(&RuntimeConfig{
+		Logger: logr.Discard(),
+	})
+	defer r.Close()
+
+	s := r.Apply(ctx, []Chain{
+		{
+			Chain: config.Chain{
+				Bind:  []config.Node{{LB: []string{occupied.Addr().String()}}},
+				Proxy: []config.Node{{LB: []string{echoServer(t)}}},
+			},
+		},
+	})
+	if s.Live != 0 || len(s.Failed) != 1 {
+		t.Fatalf("got status %+v, want 1 failed chain", s)
+	}
+}
+
+func TestRuntimeRebind(t *testing.T) {
+	ctx := context.Background()
+	bind := freeAddress(t)
+	r := NewRuntime(&RuntimeConfig{
+		Logger:       logr.Discard(),
+		DrainTimeout: time.Second,
+	})
+	defer r.Close()
+
+	// The chains differ in the proxy only, so each apply replaces the chain on the same address
+	for i := 0; i != 5; i++ {
+		s := r.Apply(ctx, []Chain{
+			{
+				Chain: config.Chain{
+					Bind:  []config.Node{{LB: []string{bind}}},
+					Proxy: []config.Node{{LB: []string{echoServer(t)}}},
+				},
+			},
+		})
+		if s.Live != 1 || len(s.Failed) != 0 {
+			t.Fatalf("apply %d: got status %+v, want the chain to bind the address of the removed one", i, s)
+		}
+	}
+}
+
+func TestRuntimeWait(t *testing.T) {
+	notReady := &running{name: "not ready", ready: make(chan struct{})}
+	late := &running{name: "late", ready: make(chan struct{})}
+	ready := &running{name: "ready", ready: make(chan struct{})}
+	close(ready.ready)
+	r := NewRuntime(&RuntimeConfig{
+		Logger:       logr.Discard(),
+		ReadyTimeout: time.Second / 5,
+	})
+	go func() {
+		time.Sleep(time.Second / 10)
+		close(late.ready)
+	}()
+
+	s := r.wait(logr.Discard(), []*running{notReady, late, ready})
+	if len(s.Failed) != 1 || s.Failed[0].Chain != "not ready" {
+		t.Errorf("got failed %+v, want only the chain that is not ready", s.Failed)
+	}
+}
@@ -0,0 +1,571 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ferryproxy/ferry/pkg/tunnel/accesslog"
+	"github.com/ferryproxy/ferry/pkg/tunnel/pool"
+	"github.com/go-logr/logr"
+	"github.com/wzshiming/bridge"
+	"github.com/wzshiming/bridge/chain"
+	"github.com/wzshiming/bridge/config"
+	"github.com/wzshiming/bridge/protocols/local"
+)
+
+// server serves a chain and tracks its connections, so that it can be drained when the chain is removed
+type server struct {
+	log      logr.Logger
+	task     Chain
+	dump     bool
+	limiters limiters
+	breaker  *breaker
+	access   *accesslog.Logger
+	// http routes the requests of the connections when the chain has the HTTP rules
+	http *httpRouter
+	// user and peerHub are the identity of the hub in the access logs
+	user    string
+	peerHub string
+
+	// connCtx is canceled to close the connections that are still alive after draining
+	connCtx    context.Context
+	connCancel context.CancelFunc
+	conns      sync.WaitGroup
+	active     int64
+	// closed is set by Drain under mut, so that no connection is tracked after the conns are waited
+	mut    sync.Mutex
+	closed bool
+	// stop is closed by StopAccepting, serving is the Serve calls that are running
+	stop    chan struct{}
+	stopped bool
+	serving sync.WaitGroup
+	// hops is the keys of the shared hops that the chain goes through,
+	// opaque is true if the chain is served by the bridge so its hops are not known
+	hops   []string
+	opaque bool
+}
+
+func newServer(log logr.Logger, task Chain, dump bool, limiters limiters, breaker *breaker, access *accesslog.Logger) *server {
+	connCtx, connCancel := context.WithCancel(context.Background())
+	user, peerHub := hubIdentity(task)
+	return &server{
+		log:        log,
+		task:       task,
+		dump:       dump,
+		limiters:   limiters,
+		breaker:    breaker,
+		access:     access,
+		user:       user,
+		peerHub:    peerHub,
+		connCtx:    connCtx,
+		connCancel: connCancel,
+		stop:       make(chan struct{}),
+	}
+}
+
+// Serve accepts the connections until the ctx is done or StopAccepting is called,
+// ready is called when the listeners are bound or failed
+func (s *server) Serve(ctx context.Context, ready func(error)) error {
+	s.mut.Lock()
+	if s.stopped {
+		s.mut.Unlock()
+		err := errors.New("stopped accepting")
+		ready(err)
+		return err
+	}
+	s.serving.Add(1)
+	s.mut.Unlock()
+	defer s.serving.Done()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return s.serve(ctx, ready)
+}
+
+// StopAccepting stops accepting and returns once the listeners are closed,
+// so that the address can be bound again at once, the accepted connections are left to Drain
+func (s *server) StopAccepting() {
+	s.mut.Lock()
+	if !s.stopped {
+		s.stopped = true
+		close(s.stop)
+	}
+	s.mut.Unlock()
+	s.serving.Wait()
+}
+
+func (s *server) serve(ctx context.Context, ready func(error)) error {
+	// The gateways of the hops and the proxy and the stdio are served by themselves, their connections are not tracked
+	if newGateway := s.gateway(); newGateway != nil {
+		gw, err := newGateway(s.log, s.task.Bind[0].LB[0])
+		if err != nil {
+			ready(err)
+			return err
+		}
+		return gw.ListenAndServe(ctx, ready)
+	}
+	if s.dump || len(s.task.Bind) == 0 || len(s.task.Proxy[0].LB) == 0 || s.task.Proxy[0].LB[0] == "-" {
+		s.mut.Lock()
+		s.opaque = len(s.task.Bind) > 1 || len(s.task.Proxy) > 1
+		s.mut.Unlock()
+		ready(nil)
+		return chain.NewBridge(s.log, s.dump).BridgeWithConfig(ctx, s.task.Chain)
+	}
+
+	dialer, listenConfig, mirrorDialer, err := s.build()
+	if err != nil {
+		ready(err)
+		return err
+	}
+	s.mut.Lock()
+	s.hops = pool.Keys(dialer, listenConfig, mirrorDialer)
+	s.mut.Unlock()
+	if s.task.HTTP != nil {
+		s.http, err = newHTTPRouter(s.task.Name, s.task.HTTP, s.task.Proxy[0].LB, s.task.Weights,
+			func(ctx context.Context, network, address string) (net.Conn, error) {
+				return s.dial(ctx, dialer, network, address)
+			})
+		if err != nil {
+			ready(err)
+			return err
+		}
+		defer s.http.close()
+	}
+
+	listens := s.task.Bind[0].LB
+	listeners := make([]net.Listener, 0, len(listens))
+	for _, l := range listens {
+		listener, err := listen(ctx, listenConfig, l)
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			ready(err)
+			return err
+		}
+		listeners = append(listeners, listener)
+	}
+	ready(nil)
+
+	var wg sync.WaitGroup
+	wg.Add(len(listeners))
+	for i, listener := range listeners {
+		go func(l string, listener net.Listener) {
+			defer wg.Done()
+			s.accept(ctx, listenConfig, dialer, mirrorDialer, l, listener)
+		}(listens[i], listener)
+	}
+	<-ctx.Done()
+	for _, listener := range listeners {
+		listener.Close()
+	}
+	wg.Wait()
+	return nil
+}
+
+// gateway returns the constructor of the gateway if the chain is like {"bind": ["mtls://:31444?..."], "proxy": ["-"]}
+func (s *server) gateway() func(logr.Logger, string) (gateway, error) {
+	if s.dump || len(s.task.Bind) != 1 || len(s.task.Bind[0].LB) != 1 ||
+		len(s.task.Proxy) != 1 || len(s.task.Proxy[0].LB) != 1 || s.task.Proxy[0].LB[0] != "-" {
+		return nil
+	}
+	uri, err := url.Parse(s.task.Bind[0].LB[0])
+	if err != nil {
+		return nil
+	}
+	return gateways[uri.Scheme]
+}
+
+// build returns the dialer of the proxy, the listener of the bind,
+// and the dialer of the mirror which dials by the hops of the bind so that it is on the side of the listener.
+func (s *server) build() (bridge.Dialer, bridge.ListenConfig, bridge.Dialer, error) {
+	var (
+		dialer       bridge.Dialer       = local.LOCAL
+		listenConfig bridge.ListenConfig = local.LOCAL
+		mirrorDialer bridge.Dialer       = local.LOCAL
+	)
+	if dials := s.task.Proxy[1:]; len(dials) != 0 {
+		d, err := chain.Default.BridgeChainWithConfig(local.LOCAL, dials...)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		dialer = d
+	}
+	if listens := s.task.Bind[1:]; len(listens) != 0 {
+		d, err := chain.Default.BridgeChainWithConfig(local.LOCAL, listens...)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		l, ok := d.(bridge.ListenConfig)
+		if !ok || l == nil {
+			return nil, nil, nil, fmt.Errorf("the last proxy could not listen")
+		}
+		listenConfig = l
+		mirrorDialer = d
+	}
+	return dialer, listenConfig, mirrorDialer, nil
+}
+
+func (s *server) accept(ctx context.Context, listenConfig bridge.ListenConfig, dialer, mirrorDialer bridge.Dialer, l string, listener net.Listener) {
+	backoff := time.Second / 10
+	for ctx.Err() == nil {
+		raw, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.log.Error(err, "Accept", "listen", l)
+			listener.Close()
+			for ctx.Err() == nil {
+				backoff <<= 1
+				if backoff > 30*time.Second {
+					backoff = 30 * time.Second
+				}
+				s.log.Info("Relisten", "listen", l, "backoff", backoff)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+				listener, err = listen(ctx, listenConfig, l)
+				if err == nil {
+					break
+				}
+				s.log.Error(err, "Relisten", "listen", l)
+			}
+			if ctx.Err() != nil {
+				if listener != nil {
+					listener.Close()
+				}
+				return
+			}
+			go func(listener net.Listener) {
+				<-ctx.Done()
+				listener.Close()
+			}(listener)
+			continue
+		}
+		backoff = time.Second / 10
+		if !s.track() {
+			raw.Close()
+			continue
+		}
+		s.log.V(1).Info("Connect", "remote_address", raw.RemoteAddr().String())
+		go func() {
+			defer func() {
+				atomic.AddInt64(&s.active, -1)
+				s.conns.Done()
+			}()
+			err := s.step(dialer, mirrorDialer, raw)
+			if err != nil {
+				s.log.V(1).Info("Disconnect", "remote_address", raw.RemoteAddr().String(), "err", err)
+			}
+		}()
+	}
+}
+
+func (s *server) step(dialer, mirrorDialer bridge.Dialer, raw net.Conn) (err error) {
+	defer raw.Close()
+	var (
+		address string
+		counter *accesslog.Conn
+		// inbound is true if the counter counts the accepted connection instead of the dialed one
+		inbound bool
+	)
+	if s.access.Sampled() {
+		start := time.Now()
+		defer func() {
+			entry := accesslog.Entry{
+				Time:        start,
+				Tunnel:      s.task.Name,
+				Source:      raw.RemoteAddr().String(),
+				User:        s.user,
+				PeerHub:     s.peerHub,
+				Destination: address,
+				Duration:    time.Since(start).String(),
+			}
+			if counter != nil && inbound {
+				entry.BytesSent = counter.BytesRead()
+				entry.BytesReceived = counter.BytesWritten()
+			} else if counter != nil {
+				entry.BytesSent = counter.BytesWritten()
+				entry.BytesReceived = counter.BytesRead()
+			}
+			if err != nil {
+				entry.Error = err.Error()
+			}
+			s.access.Log(entry)
+		}()
+	}
+
+	if s.http != nil {
+		if !s.limiters.acquire() {
+			return fmt.Errorf("too many connections")
+		}
+		defer s.limiters.release()
+		address = "http"
+		var c io.ReadWriteCloser = raw
+		if s.access != nil {
+			counter = accesslog.NewConn(c)
+			inbound = true
+			c = counter
+		}
+		if len(s.limiters) != 0 {
+			c = &limitedConn{ReadWriteCloser: c, ctx: s.connCtx, limiters: s.limiters}
+		}
+		return s.http.serve(s.connCtx, &wrappedConn{Conn: raw, rw: c})
+	}
+
+	var replay io.Reader
+	var dial string
+	if s.task.SNI != nil {
+		serverName, r, err := peekServerName(raw)
+		if err != nil {
+			return err
+		}
+		rule, backends := s.task.SNI.match(serverName)
+		sniConnections.Inc(s.task.Name, rule)
+		weights := s.task.Weights
+		if len(weights) != len(s.task.Proxy[0].LB) {
+			weights = nil
+		}
+		i, ok := pickBackend(backends, weights)
+		if !ok {
+			return fmt.Errorf("no backend for server name %q", serverName)
+		}
+		dial = s.task.Proxy[0].LB[i]
+		replay = r
+	} else {
+		dial = pickWeighted(s.task.Proxy[0].LB, s.task.Weights)
+	}
+	network, address, ok := splitSchemeAddr(dial)
+	if !ok {
+		return fmt.Errorf("unsupported protocol format %q", dial)
+	}
+	if !s.limiters.acquire() {
+		return fmt.Errorf("too many connections")
+	}
+	defer s.limiters.release()
+
+	conn, err := s.dial(s.connCtx, dialer, network, address)
+	if err != nil {
+		return err
+	}
+	var c1, c2 io.ReadWriteCloser = conn, raw
+	if replay != nil {
+		c2 = &replayConn{ReadWriteCloser: c2, r: replay}
+	}
+	if s.task.Mirror.sampled() {
+		mirror := newMirrorConn(s.connCtx, mirrorDialer, s.task.Mirror, s.task.Name, c2)
+		defer mirror.Close()
+		c2 = mirror
+	}
+	if s.access != nil {
+		counter = accesslog.NewConn(c1)
+		c1 = counter
+	}
+	if len(s.limiters) != 0 {
+		c1 = &limitedConn{ReadWriteCloser: c1, ctx: s.connCtx, limiters: s.limiters}
+		c2 = &limitedConn{ReadWriteCloser: c2, ctx: s.connCtx, limiters: s.limiters}
+	}
+	return tunnel(s.connCtx, c1, c2)
+}
+
+// tunnel copies between the connections until one direction ends or the ctx is done,
+// and it waits for both directions to return after closing the connections.
+func tunnel(ctx context.Context, c1, c2 io.ReadWriteCloser) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	var errs [2]error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, errs[0] = io.CopyBuffer(c1, c2, make([]byte, 32*1024))
+		cancel()
+	}()
+	go func() {
+		defer wg.Done()
+		_, errs[1] = io.CopyBuffer(c2, c1, make([]byte, 32*1024))
+		cancel()
+	}()
+	<-ctx.Done()
+	c1.Close()
+	c2.Close()
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			return err
+		}
+	}
+	return nil
+}
+
+// dial dials the address through the circuit breaker, and originates TLS on the connection if the chain has it
+func (s *server) dial(ctx context.Context, dialer bridge.Dialer, network, address string) (net.Conn, error) {
+	err := s.breaker.allow()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err == nil && s.task.TLS != nil {
+		conn, err = originateTLS(ctx, conn, s.task.TLS)
+	}
+	s.breaker.done(err)
+	return conn, err
+}
+
+// hubIdentity returns the hub that the chain authenticates as and the hub it goes to over ssh, mtls or quic,
+// the hub of mtls and quic is in the certificate so it is not known by the chain
+func hubIdentity(task Chain) (user, peerHub string) {
+	var hops []config.Node
+	if len(task.Proxy) > 1 {
+		hops = append(hops, task.Proxy[1:]...)
+	}
+	if len(task.Bind) > 1 {
+		hops = append(hops, task.Bind[1:]...)
+	}
+	for _, hop := range hops {
+		for _, lb := range hop.LB {
+			if !strings.HasPrefix(lb, "ssh://") && !strings.HasPrefix(lb, "mtls://") && !strings.HasPrefix(lb, "quic://") {
+				continue
+			}
+			uri, err := url.Parse(lb)
+			if err != nil {
+				continue
+			}
+			if uri.Scheme == "mtls" || uri.Scheme == "quic" {
+				return "", uri.Query().Get("server_name")
+			}
+			if uri.User != nil {
+				user = uri.User.Username()
+			}
+			return user, uri.Query().Get("target_hub")
+		}
+	}
+	return "", ""
+}
+
+// Hops returns the keys of the shared hops that the chain goes through, ok is false if they are not known
+func (s *server) Hops() (hops []string, ok bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.hops, !s.opaque
+}
+
+// Active returns the number of the connections that are alive
+func (s *server) Active() int64 {
+	return atomic.LoadInt64(&s.active)
+}
+
+// track tracks the accepted connection, false if the server is draining and the connection should be closed
+func (s *server) track() bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.closed {
+		return false
+	}
+	s.conns.Add(1)
+	atomic.AddInt64(&s.active, 1)
+	return true
+}
+
+// Drain waits for the connections to finish, and closes them after the timeout,
+// it should be called after StopAccepting, the connections accepted after it is called are closed at once.
+func (s *server) Drain(timeout time.Duration) (closed int64) {
+	defer s.connCancel()
+	s.mut.Lock()
+	s.closed = true
+	s.mut.Unlock()
+	done := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return 0
+	case <-timer.C:
+		return s.Active()
+	}
+}
+
+func listen(ctx context.Context, listenConfig bridge.ListenConfig, l string) (net.Listener, error) {
+	network, address, ok := splitSchemeAddr(l)
+	if !ok {
+		return nil, fmt.Errorf("unsupported protocol format %q", l)
+	}
+	return listenConfig.Listen(ctx, network, address)
+}
+
+// splitSchemeAddr splits the address into the network and the address in the same way as the bridge
+func splitSchemeAddr(addr string) (string, string, bool) {
+	// scheme:
+	if strings.HasSuffix(addr, ":") {
+		return addr[:len(addr)-1], "", true
+	}
+	// :port
+	if strings.HasPrefix(addr, ":") {
+		return "tcp", addr, true
+	}
+	// ./path/to/socks
+	if strings.HasPrefix(addr, "./") || strings.HasPrefix(addr, "/") {
+		return "unix", addr, true
+	}
+
+	u, _ := url.Parse(addr)
+	if u != nil && u.Scheme != "" {
+		// scheme://host
+		if u.Opaque == "" {
+			if u.Host != "" {
+				return u.Scheme, u.Host, true
+			}
+			if u.Path != "" {
+				return u.Scheme, u.Path, true
+			}
+		}
+
+		// host:port
+		if strings.Contains(u.Scheme, ".") {
+			return "tcp", net.JoinHostPort(u.Scheme, strings.TrimSpace(u.Opaque)), true
+		}
+	}
+
+	if strings.Contains(addr, ":") {
+		return "tcp", addr, true
+	}
+	return "", "", false
+}
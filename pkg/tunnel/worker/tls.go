@@ -0,0 +1,165 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/ferryproxy/ferry/pkg/tunnel/metrics"
+)
+
+var sniConnections = metrics.NewCounter(
+	"ferry_tunnel_sni_connections_total",
+	"The number of the TLS connections routed by the server names of the tunnel.",
+	"tunnel", "rule",
+)
+
+// tlsHandshakeTimeout is the timeout of the TLS handshake and of reading the server name
+const tlsHandshakeTimeout = 10 * time.Second
+
+// TLSOrigination encrypts the connections to the first proxy of the chain with TLS
+type TLSOrigination struct {
+	ServerName         string `json:"serverName,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+	// CA is the PEM bundle that verifies the certificate instead of the system roots
+	CA string `json:"ca,omitempty"`
+}
+
+// originateTLS does the TLS handshake on the connection, the connection is closed if it fails
+func originateTLS(ctx context.Context, conn net.Conn, o *TLSOrigination) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+	defer cancel()
+	config := &tls.Config{
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if o.CA != "" {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM([]byte(o.CA)) {
+			conn.Close()
+			return nil, errors.New("no certificate in the CA bundle")
+		}
+	}
+	tlsConn := tls.Client(conn, config)
+	err := tlsConn.HandshakeContext(ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// SNIRoute routes the TLS connections of the chain to the addresses of the first proxy by the server names
+type SNIRoute struct {
+	Rules []SNIRouteRule `json:"rules,omitempty"`
+	// Default is the indexes of the addresses for the connections that match no rule
+	Default []int `json:"default,omitempty"`
+}
+
+// SNIRouteRule matches the server name, or the subdomains if it is the wildcard, e.g. "*.example.com"
+type SNIRouteRule struct {
+	ServerName string `json:"serverName"`
+	// Backends is the indexes of the addresses that the connections are routed to
+	Backends []int `json:"backends"`
+}
+
+// match returns the rule that matches the server name and its backends, the exact name is preferred to the wildcards,
+// and the longer wildcard is preferred to the shorter one
+func (r *SNIRoute) match(serverName string) (string, []int) {
+	serverName = strings.ToLower(strings.TrimSuffix(serverName, "."))
+	matched := -1
+	for i, rule := range r.Rules {
+		if rule.ServerName == serverName {
+			return rule.ServerName, rule.Backends
+		}
+		suffix, ok := strings.CutPrefix(rule.ServerName, "*")
+		if !ok || !strings.HasSuffix(serverName, suffix) || strings.Contains(strings.TrimSuffix(serverName, suffix), ".") {
+			continue
+		}
+		if matched == -1 || len(rule.ServerName) > len(r.Rules[matched].ServerName) {
+			matched = i
+		}
+	}
+	if matched != -1 {
+		return r.Rules[matched].ServerName, r.Rules[matched].Backends
+	}
+	return "default", r.Default
+}
+
+var errServerNamePeeked = errors.New("server name peeked")
+
+// peekServerName reads the server name of the TLS client hello from the connection,
+// and returns the reader that reads the client hello again before the rest of the connection.
+func peekServerName(conn net.Conn) (string, io.Reader, error) {
+	var buf bytes.Buffer
+	var serverName string
+	err := conn.SetReadDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err != nil {
+		return "", nil, err
+	}
+	err = tls.Server(readOnlyConn{Conn: conn, r: io.TeeReader(conn, &buf)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errServerNamePeeked
+		},
+	}).Handshake()
+	if !errors.Is(err, errServerNamePeeked) {
+		return "", nil, err
+	}
+	err = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return "", nil, err
+	}
+	return serverName, io.MultiReader(&buf, conn), nil
+}
+
+// readOnlyConn is the connection that the TLS server reads the client hello from without answering it
+type readOnlyConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c readOnlyConn) Write(p []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func (c readOnlyConn) Close() error {
+	return nil
+}
+
+// replayConn reads the peeked bytes before the rest of the connection
+type replayConn struct {
+	io.ReadWriteCloser
+	r io.Reader
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSNIRouteMatch(t *testing.T) {
+	route := &SNIRoute{
+		Rules: []SNIRouteRule{
+			{ServerName: "*.example.com", Backends: []int{1}},
+			{ServerName: "*.api.example.com", Backends: []int{2}},
+			{ServerName: "www.example.com", Backends: []int{3}},
+		},
+		Default: []int{0},
+	}
+	tests := []struct {
+		serverName string
+		wantRule   string
+		wantBack   int
+	}{
+		{serverName: "", wantRule: "default", wantBack: 0},
+		{serverName: "example.com", wantRule: "default", wantBack: 0},
+		{serverName: "a.example.com", wantRule: "*.example.com", wantBack: 1},
+		{serverName: "A.Example.com.", wantRule: "*.example.com", wantBack: 1},
+		{serverName: "a.b.example.com", wantRule: "default", wantBack: 0},
+		{serverName: "v2.api.example.com", wantRule: "*.api.example.com", wantBack: 2},
+		{serverName: "www.example.com", wantRule: "www.example.com", wantBack: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			rule, backends := route.match(tt.serverName)
+			if rule != tt.wantRule || len(backends) != 1 || backends[0] != tt.wantBack {
+				t.Errorf("match() = %v, %v, want %v, [%v]", rule, backends, tt.wantRule, tt.wantBack)
+			}
+		})
+	}
+}
+
+func TestPeekServerName(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	go func() {
+		tls.Client(client, &tls.Config{ServerName: "api.example.com", InsecureSkipVerify: true}).Handshake()
+	}()
+
+	serverName, replay, err := peekServerName(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverName != "api.example.com" {
+		t.Errorf("peekServerName() = %q, want %q", serverName, "api.example.com")
+	}
+
+	// The client hello is read again, so that the connection can be passed through
+	header := make([]byte, 5)
+	_, err = io.ReadFull(replay, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header[0] != 0x16 {
+		t.Errorf("replay starts with %#x, want the handshake record", header[0])
+	}
+}
+
+func TestOriginateTLS(t *testing.T) {
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+	ca := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw}))
+
+	tests := []struct {
+		name    string
+		tls     *TLSOrigination
+		wantErr bool
+	}{
+		{
+			name: "ca",
+			tls:  &TLSOrigination{ServerName: "example.com", CA: ca},
+		},
+		{
+			name:    "invalid ca",
+			tls:     &TLSOrigination{ServerName: "example.com", CA: "invalid"},
+			wantErr: true,
+		},
+		{
+			name: "skip verify",
+			tls:  &TLSOrigination{ServerName: "example.com", InsecureSkipVerify: true},
+		},
+		{
+			name:    "unknown authority",
+			tls:     &TLSOrigination{ServerName: "example.com"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", backend.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, err = originateTLS(context.Background(), conn, tt.tls)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("originateTLS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				conn.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"math/rand"
+)
+
+// pickWeighted picks one of the addresses randomly in proportion to the weights,
+// the addresses are picked with the same chance if the weights do not match them or are all zero,
+// which is only generated if none of the exports is ready, see router.EffectiveSplit
+func pickWeighted(addresses []string, weights []int) string {
+	if len(addresses) == 1 {
+		return addresses[0]
+	}
+	total := 0
+	if len(weights) == len(addresses) {
+		for _, weight := range weights {
+			total += weight
+		}
+	}
+	if total <= 0 {
+		return addresses[rand.Int()%len(addresses)]
+	}
+	n := rand.Intn(total)
+	for i, weight := range weights {
+		if n < weight {
+			return addresses[i]
+		}
+		n -= weight
+	}
+	return addresses[len(addresses)-1]
+}
+
+// pickBackend picks one of the backends, which are the indexes of the addresses, randomly in proportion to the weights
+func pickBackend(backends []int, weights []int) (int, bool) {
+	if len(backends) == 0 {
+		return 0, false
+	}
+	total := 0
+	if weights != nil {
+		for _, i := range backends {
+			total += weights[i]
+		}
+	}
+	if total <= 0 {
+		return backends[rand.Int()%len(backends)], true
+	}
+	n := rand.Intn(total)
+	for _, i := range backends {
+		if n < weights[i] {
+			return i, true
+		}
+		n -= weights[i]
+	}
+	return backends[len(backends)-1], true
+}
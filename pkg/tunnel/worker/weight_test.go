@@ -0,0 +1,69 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package worker
+
+import (
+	"testing"
+)
+
+func TestPickWeighted(t *testing.T) {
+	tests := []struct {
+		name      string
+		addresses []string
+		weights   []int
+		want      map[string]bool
+	}{
+		{
+			name:      "single",
+			addresses: []string{"a"},
+			want:      map[string]bool{"a": true},
+		},
+		{
+			name:      "no weights",
+			addresses: []string{"a", "b"},
+			want:      map[string]bool{"a": true, "b": true},
+		},
+		{
+			name:      "zero weight",
+			addresses: []string{"a", "b"},
+			weights:   []int{0, 10},
+			want:      map[string]bool{"b": true},
+		},
+		{
+			name:      "all zero",
+			addresses: []string{"a", "b"},
+			weights:   []int{0, 0},
+			want:      map[string]bool{"a": true, "b": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[string]bool{}
+			for i := 0; i != 1000; i++ {
+				got[pickWeighted(tt.addresses, tt.weights)] = true
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("pickWeighted() = %v, want %v", got, tt.want)
+			}
+			for k := range got {
+				if !tt.want[k] {
+					t.Fatalf("pickWeighted() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
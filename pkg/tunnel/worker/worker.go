@@ -21,14 +21,15 @@ import (
 	"sync"
 
 	"github.com/go-logr/logr"
+	"github.com/wzshiming/bridge"
 	"github.com/wzshiming/bridge/chain"
 	"github.com/wzshiming/bridge/config"
+	"github.com/wzshiming/bridge/protocols/connect"
+	"github.com/wzshiming/bridge/protocols/socks4"
+	"github.com/wzshiming/bridge/protocols/socks5"
 
 	_ "github.com/wzshiming/bridge/protocols/command"
-	_ "github.com/wzshiming/bridge/protocols/connect"
 	_ "github.com/wzshiming/bridge/protocols/netcat"
-	_ "github.com/wzshiming/bridge/protocols/socks4"
-	_ "github.com/wzshiming/bridge/protocols/socks5"
 	_ "github.com/wzshiming/bridge/protocols/ssh"
 	_ "github.com/wzshiming/bridge/protocols/tls"
 
@@ -38,8 +39,50 @@ import (
 	_ "github.com/wzshiming/anyproxy/proxies/socks4"
 	_ "github.com/wzshiming/anyproxy/proxies/socks5"
 	_ "github.com/wzshiming/anyproxy/proxies/sshproxy"
+
+	"github.com/ferryproxy/ferry/pkg/tunnel/pool"
+	"github.com/ferryproxy/ferry/pkg/tunnel/protocols/h2"
+	"github.com/ferryproxy/ferry/pkg/tunnel/protocols/mtls"
+	"github.com/ferryproxy/ferry/pkg/tunnel/protocols/quic"
+	"github.com/ferryproxy/ferry/pkg/tunnel/protocols/websocket"
 )
 
+// The hops to the hubs are shared by all chains, so the chains to the same hub use one ssh connection
+func init() {
+	for name, bridger := range map[string]bridge.BridgeFunc{
+		"ssh":     pool.SSH,
+		"http":    connect.CONNECT,
+		"https":   connect.CONNECT,
+		"socks4":  socks4.SOCKS4,
+		"socks4a": socks4.SOCKS4,
+		"socks5":  socks5.SOCKS5,
+		"socks5h": socks5.SOCKS5,
+		"ws":      websocket.WebSocket,
+		"wss":     websocket.WebSocket,
+		"h2":      h2.H2,
+		"h2c":     h2.H2,
+		"mtls":    mtls.MTLS,
+		"quic":    quic.QUIC,
+	} {
+		chain.Default.Register(name, pool.Bridge(bridger))
+	}
+}
+
+// gateway serves the hops to this hub
+type gateway interface {
+	ListenAndServe(ctx context.Context, ready func(error)) error
+}
+
+// gateways serve the hops that are not carried by the ssh, the sshd is served by the anyproxy
+var gateways = map[string]func(log logr.Logger, address string) (gateway, error){
+	"mtls": func(log logr.Logger, address string) (gateway, error) {
+		return mtls.NewServer(log, address)
+	},
+	"quic": func(log logr.Logger, address string) (gateway, error) {
+		return quic.NewServer(log, address)
+	},
+}
+
 func run(ctx context.Context, log logr.Logger, tasks []config.Chain, dump bool) {
 	var wg sync.WaitGroup
 	wg.Add(len(tasks))
@@ -21,18 +21,20 @@ package worker
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	"os"
 	"os/signal"
-	"sync"
 	"syscall"
 	"time"
 
-	"github.com/go-logr/logr"
-	"github.com/wzshiming/bridge/chain"
-	"github.com/wzshiming/bridge/config"
+	"github.com/ferryproxy/ferry/pkg/tunnel/status"
 )
 
-func RunWithReload(ctx context.Context, log logr.Logger, configs []string, dump bool) {
+// RunWithReload runs the chains of the configs, and reloads them on SIGHUP,
+// the outcome of each reload is written to the statusPath if it is not empty.
+func RunWithReload(ctx context.Context, conf *RuntimeConfig, configs []string, statusPath string) {
+	log := conf.Logger
 	signalCh := make(chan os.Signal, 2)
 	signal.Notify(signalCh, syscall.SIGHUP)
 	reloadCn := make(chan struct{}, 1)
@@ -45,11 +47,9 @@ func RunWithReload(ctx context.Context, log logr.Logger, configs []string, dump
 		}
 	}()
 
-	wg := sync.WaitGroup{}
-	defer wg.Wait()
-	var lastWorking = map[string]func(){}
-	var cleanups []func()
-	count := 1
+	runtime := NewRuntime(conf)
+	defer runtime.Close()
+
 	reloadCn <- struct{}{}
 	for {
 		select {
@@ -57,69 +57,56 @@ func RunWithReload(ctx context.Context, log logr.Logger, configs []string, dump
 			return
 		case <-reloadCn:
 		}
-		log := log.WithValues("reload_count", count)
-		tasks, err := config.LoadConfig(configs...)
+		checksum, tasks, err := loadConfig(configs)
 		if err != nil {
 			for {
 				log.Error(err, "LoadConfig")
 				log.Info("Try reload again after 1 second")
-				time.Sleep(time.Second)
-				tasks, err = config.LoadConfig(configs...)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second):
+				}
+				checksum, tasks, err = loadConfig(configs)
 				if err == nil {
 					break
 				}
 			}
 		}
-		working := map[string]func(){}
-		for _, task := range tasks {
-			uniq := task.Unique()
 
-			cleanup := lastWorking[uniq]
-			if cleanup != nil {
-				working[uniq] = cleanup
-				continue
+		s := runtime.Apply(ctx, tasks)
+		if statusPath != "" {
+			s.Checksum = checksum
+			err = status.Write(statusPath, s)
+			if err != nil {
+				log.Error(err, "WriteStatus")
 			}
-
-			ctx, cancel := context.WithCancel(ctx)
-			working[uniq] = cancel
-			wg.Add(1)
-			go func(ctx context.Context, task config.Chain) {
-				defer wg.Done()
-				log.Info(chain.ShowChainWithConfig(task))
-				for ctx.Err() == nil {
-					b := chain.NewBridge(log, dump)
-					err := b.BridgeWithConfig(ctx, task)
-					if err != nil {
-						log.Error(err, "BridgeWithConfig")
-					}
-					time.Sleep(time.Second)
-				}
-			}(ctx, task)
 		}
+	}
+}
 
-		for uniq := range lastWorking {
-			if _, ok := working[uniq]; !ok {
-				cancel := lastWorking[uniq]
-				if cancel != nil {
-					cleanups = append(cleanups, cancel)
-				}
-			}
+// loadConfig loads the chains like the bridge, and returns the checksum of the configs
+func loadConfig(configs []string) (string, []Chain, error) {
+	tasks := []Chain{}
+	data := make([][]byte, 0, len(configs))
+	for _, c := range configs {
+		d, err := os.ReadFile(c)
+		if err != nil {
+			return "", nil, err
 		}
-		lastWorking = working
-
-		// TODO: wait for all task is working
-		select {
-		case <-ctx.Done():
-			return
-		case <-time.After(time.Second):
+		data = append(data, d)
+		conf := Config{}
+		err = json.Unmarshal(d, &conf)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %w", c, err)
 		}
-
-		if len(cleanups) > 0 {
-			for _, cleanup := range cleanups {
-				cleanup()
+		for _, ch := range conf.Chains {
+			err := ch.Verification()
+			if err != nil {
+				return "", nil, fmt.Errorf("%s: %w", c, err)
 			}
-			cleanups = cleanups[:0]
+			tasks = append(tasks, ch)
 		}
-		count++
 	}
+	return status.Checksum(data...), tasks, nil
 }
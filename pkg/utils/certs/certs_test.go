@@ -0,0 +1,61 @@
+/*
+Copyright 2022 FerryProxy Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certs
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestIssueCert(t *testing.T) {
+	caCert, caKey, err := NewCA("ferry", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _, err := IssueCert(caCert, caKey, "cluster-1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		t.Fatal("failed to append ca")
+	}
+	c, err := Parse(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, usage := range []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth} {
+		_, err = c.Verify(x509.VerifyOptions{
+			DNSName:   "cluster-1",
+			Roots:     pool,
+			KeyUsages: []x509.ExtKeyUsage{usage},
+		})
+		if err != nil {
+			t.Errorf("verify %v: %v", usage, err)
+		}
+	}
+
+	notBefore, notAfter, err := Validity(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := notAfter.Sub(notBefore); got < time.Hour || got > time.Hour+time.Minute {
+		t.Errorf("lifetime = %v, want about 1h", got)
+	}
+}
@@ -18,6 +18,8 @@ package env
 
 import (
 	"os"
+	"strconv"
+	"time"
 )
 
 func GetEnv(key, fallback string) string {
@@ -26,3 +28,23 @@ func GetEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+func GetEnvBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		b, err := strconv.ParseBool(value)
+		if err == nil {
+			return b
+		}
+	}
+	return fallback
+}
+
+func GetEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		d, err := time.ParseDuration(value)
+		if err == nil {
+			return d
+		}
+	}
+	return fallback
+}